@@ -0,0 +1,94 @@
+package backend
+
+import "fmt"
+
+// Action is something a key mapping can run. The key that completed the
+// mapping is passed along for actions like inserting a rune.
+type Action func(editor *Editor, key KeyStroke)
+
+var actions = map[string]Action{}
+
+func registerAction(name string, action Action) {
+	actions[name] = action
+}
+
+func (editor *Editor) RunAction(name string, key KeyStroke) {
+	action, ok := actions[name]
+	if !ok {
+		editor.ReportError(fmt.Errorf("unknown action %q", name))
+		return
+	}
+	action(editor, key)
+}
+
+func IsAction(name string) bool {
+	_, ok := actions[name]
+	return ok
+}
+
+func init() {
+	registerAction("quit", func(editor *Editor, key KeyStroke) {
+		editor.Quit = true
+	})
+	registerAction("normal_mode", func(editor *Editor, key KeyStroke) {
+		editor.ToNormal()
+	})
+	registerAction("insert", func(editor *Editor, key KeyStroke) {
+		editor.ToInsert(false)
+	})
+	registerAction("insert_after", func(editor *Editor, key KeyStroke) {
+		editor.ToInsert(true)
+	})
+	registerAction("command_mode", func(editor *Editor, key KeyStroke) {
+		editor.ToCommand()
+	})
+
+	registerAction("cursor_down", func(editor *Editor, key KeyStroke) {
+		if next := editor.nextLine(editor.Cursor.Row); next < len(editor.Content.lines()) {
+			editor.moveToRow(next)
+		}
+	})
+	registerAction("cursor_up", func(editor *Editor, key KeyStroke) {
+		editor.moveToRow(max(0, editor.prevLine(editor.Cursor.Row)))
+	})
+	registerAction("cursor_left", func(editor *Editor, key KeyStroke) {
+		editor.ShiftCursor(0, -1, false, false)
+	})
+	registerAction("cursor_right", func(editor *Editor, key KeyStroke) {
+		editor.ShiftCursor(0, 1, false, false)
+	})
+
+	registerAction("newline", func(editor *Editor, key KeyStroke) {
+		if editor.acceptCompletion() {
+			return
+		}
+		editor.InsertNewline()
+	})
+	registerAction("backspace", func(editor *Editor, key KeyStroke) {
+		if editor.replaceSelectedPlaceholder() {
+			return
+		}
+		editor.Backspace()
+	})
+
+	bindDefault(Normal, "q", "quit")
+	bindDefault(Normal, "a", "insert_after")
+	bindDefault(Normal, "i", "insert")
+	bindDefault(Normal, ":", "command_mode")
+	bindDefault(Normal, "j", "cursor_down")
+	bindDefault(Normal, "k", "cursor_up")
+	bindDefault(Normal, "h", "cursor_left")
+	bindDefault(Normal, "l", "cursor_right")
+
+	for _, mode := range []EditorMode{Normal, Insert} {
+		bindDefault(mode, "<Down>", "cursor_down")
+		bindDefault(mode, "<Up>", "cursor_up")
+		bindDefault(mode, "<Left>", "cursor_left")
+		bindDefault(mode, "<Right>", "cursor_right")
+	}
+
+	bindDefault(Insert, "<Esc>", "normal_mode")
+	bindDefault(Insert, "<CR>", "newline")
+	bindDefault(Insert, "<BS>", "backspace")
+	bindDefault(Insert, "<C-h>", "backspace")
+}
@@ -0,0 +1,97 @@
+package backend
+
+import "sync"
+
+// asyncQueue collects work from other goroutines, like language server
+// replies, until the loop that owns the content can run it.
+type asyncQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	wake    func()
+}
+
+// async returns the content's queue. It has to be first called from the loop
+// that owns the content, before any goroutine is handed the queue.
+func (content *Content) async() *asyncQueue {
+	if content.queue == nil {
+		content.queue = &asyncQueue{}
+	}
+	return content.queue
+}
+
+// async returns the editor's own queue, for replies that touch the editor
+// rather than a content. They run on whichever loop has the editor by then,
+// which behind the server need not be the one that owns the content they
+// are about. Like a content's queue it has to be first called from the loop.
+func (editor *Editor) async() *asyncQueue {
+	if editor.queue == nil {
+		editor.queue = &asyncQueue{wake: editor.wake}
+	}
+	return editor.queue
+}
+
+// post queues fn and wakes the loop. Safe to call from any goroutine.
+func (queue *asyncQueue) post(fn func()) {
+	queue.mu.Lock()
+	queue.pending = append(queue.pending, fn)
+	wake := queue.wake
+	queue.mu.Unlock()
+
+	if wake != nil {
+		wake()
+	}
+}
+
+// SetWake sets how the frontend is told that RunPending has work to do. wake
+// is called from other goroutines and should only poke the event loop. Files
+// the editor opens later are woken the same way, unless their content
+// already has a wake of its own.
+func (editor *Editor) SetWake(wake func()) {
+	editor.wake = wake
+	queue := editor.async()
+	queue.mu.Lock()
+	queue.wake = wake
+	queue.mu.Unlock()
+
+	if editor.Content.queue == nil || editor.Content.queue.wake == nil {
+		editor.Content.SetWake(wake)
+	}
+}
+
+// SetWake sets the wake for work queued on this content.
+func (content *Content) SetWake(wake func()) {
+	queue := content.async()
+	queue.mu.Lock()
+	queue.wake = wake
+	queue.mu.Unlock()
+}
+
+// run runs the work posted so far and reports whether there was any.
+func (queue *asyncQueue) run() bool {
+	queue.mu.Lock()
+	pending := queue.pending
+	queue.pending = nil
+	queue.mu.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+	return len(pending) > 0
+}
+
+// RunPending runs work queued by background goroutines. Frontends call it
+// from their event loop after being woken.
+func (editor *Editor) RunPending() {
+	ran := false
+	if editor.queue != nil && editor.queue.run() {
+		ran = true
+	}
+	for _, content := range editor.ownedContents() {
+		if content.queue != nil && content.queue.run() {
+			ran = true
+		}
+	}
+	if ran {
+		editor.ScrollToCursor()
+	}
+}
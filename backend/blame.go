@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blame is who last changed each line, shown in a column left of the text
+// that scrolls with it. The editor has one window, so the column stands in
+// for vim's scroll-locked split: being part of the window it cannot scroll
+// away from the text, folds and wrapped lines included. Lines not committed
+// yet are blamed on the buffer as it is, so the column stays right while the
+// file is edited.
+type Blame struct {
+	// one entry per line of the file, and the width of the column
+	Lines []string
+	Width int
+
+	content *Content
+	version int
+	running bool
+}
+
+// the widest the blame column gets
+const maxBlameWidth = 40
+
+// BlameWidth is the width of the blame column, nothing while there is none.
+func (editor *Editor) BlameWidth() int {
+	if editor.Blame == nil {
+		return 0
+	}
+	return min(editor.Blame.Width, editor.ScreenWidth/3)
+}
+
+// parseBlame reads the output of git blame --line-porcelain into a short
+// description of each line's commit.
+func parseBlame(out string) []string {
+	lines := []string{}
+	hash, author, date := "", "", ""
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			if strings.Trim(hash, "0") == "" {
+				lines = append(lines, "Not committed yet")
+			} else {
+				lines = append(lines, fmt.Sprintf("%.8s %s %s", hash, date, author))
+			}
+			hash = ""
+		case hash == "":
+			hash, _, _ = strings.Cut(line, " ")
+		case strings.HasPrefix(line, "author "):
+			author = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-time "):
+			seconds, _ := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64)
+			date = time.Unix(seconds, 0).Format(time.DateOnly)
+		}
+	}
+	return lines
+}
+
+// toggleBlame shows the blame column, or hides it when it is showing.
+func (editor *Editor) toggleBlame() error {
+	if editor.Blame != nil {
+		editor.Blame = nil
+		return nil
+	}
+	if editor.Diff != nil {
+		return fmt.Errorf("blame is not available in diff mode")
+	}
+	if editor.Content.scratch {
+		return fmt.Errorf("%s is not a file", editor.FileName)
+	}
+	editor.Blame = &Blame{content: editor.Content, version: -1}
+	editor.ScrollToCursor()
+	return nil
+}
+
+// updateBlame asks git again once the buffer has changed, or another file is
+// shown. git runs in the background and one run at a time, the next starts
+// when it is done if there were edits in the meantime.
+func (editor *Editor) updateBlame() {
+	blame := editor.Blame
+	if blame == nil {
+		return
+	}
+	if blame.content != editor.Content {
+		if editor.Content.scratch {
+			editor.Blame = nil
+			return
+		}
+		blame.content, blame.version, blame.Lines = editor.Content, -1, nil
+	}
+	if blame.running || blame.version == blame.content.Version {
+		return
+	}
+
+	content, version := blame.content, blame.content.Version
+	cmd := exec.Command("git", "-C", filepath.Dir(editor.FilePath),
+		"blame", "--line-porcelain", "--contents", "-", "--", filepath.Base(editor.FilePath))
+	cmd.Stdin = bytes.NewReader(editor.encodeFile(content.calculateContent()))
+	blame.running = true
+
+	queue := content.async()
+	go func() {
+		out, err := cmd.Output()
+		queue.post(func() { editor.blameDone(blame, content, version, string(out), err) })
+	}()
+}
+
+// blameDone takes in what git blame said about version of content.
+func (editor *Editor) blameDone(blame *Blame, content *Content, version int, out string, err error) {
+	blame.running = false
+	if editor.Blame != blame || blame.content != content {
+		return
+	}
+	if err != nil {
+		editor.Blame = nil
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%s", firstLine(strings.TrimSpace(string(exitErr.Stderr))))
+		}
+		editor.ReportError(fmt.Errorf("git blame: %w", err))
+		return
+	}
+
+	blame.Lines, blame.version = parseBlame(out), version
+	blame.Width = 0
+	for _, line := range blame.Lines {
+		blame.Width = max(blame.Width, len([]rune(line))+2)
+	}
+	blame.Width = min(blame.Width, maxBlameWidth)
+}
+
+func init() {
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.toggleBlame()
+	}, "Gblame")
+}
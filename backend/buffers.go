@@ -0,0 +1,419 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// buffer is what other editors can see of an open content: its path and the
+// text as of the owner's last event. Contents are only touched by the loop
+// that owns them, so other loops read these copies instead.
+type buffer struct {
+	path    string
+	text    []rune
+	version int
+}
+
+var (
+	buffersMu sync.Mutex
+	buffers   = map[*Content]*buffer{}
+)
+
+// publishBuffer records the content's text for other editors when it has
+// changed since the last time.
+func (editor *Editor) publishBuffer() {
+	content := editor.Content
+
+	buffersMu.Lock()
+	defer buffersMu.Unlock()
+
+	current, ok := buffers[content]
+	if ok && current.version == content.Version && current.path == editor.FilePath {
+		return
+	}
+	buffers[content] = &buffer{
+		path:    editor.FilePath,
+		text:    content.calculateContent(),
+		version: content.Version,
+	}
+}
+
+// Unpublish takes the content out of what other editors can see, once no
+// editor has it open. The next editor to show it publishes it again.
+func (content *Content) Unpublish() {
+	buffersMu.Lock()
+	defer buffersMu.Unlock()
+
+	delete(buffers, content)
+}
+
+// otherBuffers is every published buffer except the editor's own.
+func (editor *Editor) otherBuffers() []buffer {
+	buffersMu.Lock()
+	defer buffersMu.Unlock()
+
+	others := []buffer{}
+	for content, buffer := range buffers {
+		if content != editor.Content {
+			others = append(others, *buffer)
+		}
+	}
+	slices.SortFunc(others, func(a, b buffer) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return others
+}
+
+// bufferOpen reports whether some editor has published a buffer of path.
+func bufferOpen(path string) bool {
+	buffersMu.Lock()
+	defer buffersMu.Unlock()
+
+	for _, buffer := range buffers {
+		if samePath(buffer.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenBuffer is a file the editor has open but is not showing, with where
+// the editor was in it.
+type hiddenBuffer struct {
+	content *Content
+	path    string
+	cursor  int
+	topLine int
+	folds   []Fold
+}
+
+// SetOpener hands opening files to the frontend, which calls SwitchContent
+// once it has the content. The server uses this to move a client to the
+// session that already has the file.
+func (editor *Editor) SetOpener(opener func(path string)) {
+	editor.opener = opener
+}
+
+// OpenFile shows path in the editor, keeping the current file open in the
+// background.
+func (editor *Editor) OpenFile(path string) error {
+	path = filepath.Clean(path)
+	if path == editor.FilePath {
+		return nil
+	}
+	if path == outputPath {
+		editor.showOutput()
+		return nil
+	}
+	if editor.opener != nil {
+		editor.opener(path)
+		return nil
+	}
+
+	var content *Content
+	if i := slices.IndexFunc(editor.hidden, func(buffer hiddenBuffer) bool {
+		return buffer.path == path
+	}); i != -1 {
+		content = editor.hidden[i].content
+	}
+	if content == nil {
+		loaded, err := LoadContent(path)
+		if err != nil {
+			return err
+		}
+		content = loaded
+	}
+
+	editor.SwitchContent(content, path)
+	return nil
+}
+
+// reload reads the file again, changing only what differs from the buffer
+// so that the cursor and marks stay put.
+func (editor *Editor) reload() error {
+	if editor.Content.scratch {
+		return fmt.Errorf("%s is not a file", editor.FileName)
+	}
+	loaded, err := LoadContent(editor.FilePath)
+	if err != nil {
+		return err
+	}
+	edits := editor.Content.SetText(loaded.calculateContent())
+	editor.moveCursorTo(shiftIndex(editor.Cursor.Index, edits))
+	editor.Content.SavedVersion = editor.Content.Version
+	editor.Content.git = nil
+	editor.inform(fmt.Sprintf("%q reloaded, %d changes", editor.FileName, len(edits)))
+	return nil
+}
+
+// jumpTarget is a position in a file that is being opened.
+type jumpTarget struct {
+	path     string
+	row, col int
+}
+
+// openAt shows path with the cursor at row and col.
+func (editor *Editor) openAt(path string, row int, col int) error {
+	if samePath(path, editor.FilePath) {
+		editor.moveToPosition(row, col)
+		return nil
+	}
+	editor.jump = &jumpTarget{path: path, row: row, col: col}
+	if err := editor.OpenFile(path); err != nil {
+		editor.jump = nil
+		return err
+	}
+	return nil
+}
+
+// moveToPosition puts the cursor at row and col, kept inside the content.
+func (editor *Editor) moveToPosition(row int, col int) {
+	lines := editor.Content.lines()
+	row = max(0, min(row, len(lines)-1))
+	editor.moveCursorTo(editor.Content.lineStart(row) + max(0, min(col, len(lines[row]))))
+}
+
+// samePath reports whether two paths name the same file.
+func samePath(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// LeaveContent lets go of what the editor hooked into the content it shows,
+// before it shows another one or goes away. Behind an opener the content
+// belongs to another loop, which calls this before handing the editor on.
+func (editor *Editor) LeaveContent() {
+	editor.endSnippet()
+	editor.untrackFolds(editor.Content)
+}
+
+// SwitchContent shows content, which holds the file at path, in place of the
+// current file. Where the editor was in a file is remembered for when it
+// comes back to it.
+func (editor *Editor) SwitchContent(content *Content, path string) {
+	if editor.Diff != nil && !slices.Contains(editor.Diff.contents[:], content) {
+		editor.closeDiff()
+	}
+	editor.LeaveContent()
+	editor.closeCompletion()
+	editor.Popup = nil
+
+	restore := hiddenBuffer{}
+	editor.hidden = slices.DeleteFunc(editor.hidden, func(buffer hiddenBuffer) bool {
+		if buffer.path == path {
+			restore = buffer
+			return true
+		}
+		return false
+	})
+	editor.hidden = slices.Insert(editor.hidden, 0, hiddenBuffer{
+		content: editor.Content,
+		path:    editor.FilePath,
+		cursor:  editor.Cursor.Index,
+		topLine: editor.TopLine,
+		folds:   editor.Folds,
+	})
+
+	editor.Content = content
+	editor.FilePath, editor.FileName = path, filepath.Base(path)
+	editor.refreshTabs()
+	editor.TopLine, editor.Folds = restore.topLine, restore.folds
+	editor.foldMethod, editor.foldsVersion = "", 0
+	if len(editor.Folds) > 0 {
+		editor.trackFolds()
+	}
+	editor.moveCursorTo(restore.cursor)
+	if jump := editor.jump; jump != nil && samePath(jump.path, path) {
+		editor.moveToPosition(jump.row, jump.col)
+	}
+	editor.jump = nil
+
+	if editor.wake != nil && (content.queue == nil || content.queue.wake == nil) {
+		content.SetWake(editor.wake)
+	}
+
+	if !content.setUp {
+		config, err := LoadConfig(DefaultConfigPath())
+		editor.applyFiletype()
+		if err == nil {
+			err = editor.setupBuffer(config)
+		}
+		if err != nil {
+			editor.ReportError(err)
+		}
+	}
+	if !content.scratch {
+		recordRecentFile(path)
+	}
+	editor.followExplorer()
+}
+
+// ownedContents is every content this editor's loop looks after. Behind an
+// opener the hidden files belong to other loops, but the output buffer is
+// always the editor's own.
+func (editor *Editor) ownedContents() []*Content {
+	contents := []*Content{editor.Content}
+	if editor.opener == nil {
+		for _, buffer := range editor.hidden {
+			contents = append(contents, buffer.content)
+		}
+	}
+	if editor.output != nil && !slices.Contains(contents, editor.output) {
+		contents = append(contents, editor.output)
+	}
+	return contents
+}
+
+// ownedContent is the content of path among the editor's own, nil when it
+// does not have the file open or another loop owns it.
+func (editor *Editor) ownedContent(path string) *Content {
+	if samePath(path, editor.FilePath) {
+		return editor.Content
+	}
+	if editor.opener != nil {
+		return nil
+	}
+	for _, buffer := range editor.hidden {
+		if samePath(path, buffer.path) {
+			return buffer.content
+		}
+	}
+	return nil
+}
+
+// refreshTabs names the hidden files for the tabline.
+func (editor *Editor) refreshTabs() {
+	editor.Tabs = []string{}
+	for _, buffer := range editor.hidden {
+		editor.Tabs = append(editor.Tabs, filepath.Base(buffer.path))
+	}
+}
+
+// BufferPaths lists the files open in the editor, the shown one first and
+// then the most recently shown.
+func (editor *Editor) BufferPaths() []string {
+	paths := []string{editor.FilePath}
+	for _, buffer := range editor.hidden {
+		paths = append(paths, buffer.path)
+	}
+	return paths
+}
+
+const maxRecentFiles = 100
+
+var recentFilesMu sync.Mutex
+
+// recentFilesPath is where recently opened files are remembered between
+// runs.
+func recentFilesPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "text-editor", "recent")
+}
+
+// RecentFiles lists files opened before, most recent first.
+func RecentFiles() []string {
+	recentFilesMu.Lock()
+	defer recentFilesMu.Unlock()
+	return readRecentFiles()
+}
+
+func readRecentFiles() []string {
+	data, err := os.ReadFile(recentFilesPath())
+	if err != nil {
+		return nil
+	}
+	return slices.DeleteFunc(strings.Split(string(data), "\n"), func(line string) bool {
+		return line == ""
+	})
+}
+
+// recordRecentFile moves path to the front of the recent files. Failing to
+// write the list is not worth bothering anyone about.
+func recordRecentFile(path string) {
+	abs, err := filepath.Abs(path)
+	if err != nil || recentFilesPath() == "" {
+		return
+	}
+
+	recentFilesMu.Lock()
+	defer recentFilesMu.Unlock()
+
+	recent := slices.DeleteFunc(readRecentFiles(), func(other string) bool {
+		return other == abs
+	})
+	recent = slices.Insert(recent, 0, abs)
+	recent = recent[:min(len(recent), maxRecentFiles)]
+
+	os.MkdirAll(filepath.Dir(recentFilesPath()), 0755)
+	os.WriteFile(recentFilesPath(), []byte(strings.Join(recent, "\n")+"\n"), 0644)
+}
+
+// SaveAll writes every file the editor has open, the shown one last, and
+// returns the errors for any that could not be written.
+func (editor *Editor) SaveAll() error {
+	errs := []error{}
+	if editor.opener == nil {
+		shown, path := editor.Content, editor.FilePath
+		for _, buffer := range slices.Clone(editor.hidden) {
+			if buffer.content.scratch {
+				continue
+			}
+			editor.SwitchContent(buffer.content, buffer.path)
+			errs = append(errs, editor.SaveContent())
+		}
+		if editor.Content != shown {
+			editor.SwitchContent(shown, path)
+		}
+	}
+	errs = append(errs, editor.SaveContent())
+	return errors.Join(errs...)
+}
+
+// RenamedPath is where path is after from, a file or a directory, was moved
+// to to, and whether the move affected it.
+func RenamedPath(path string, from string, to string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path, false
+	}
+	fromAbs, err := filepath.Abs(from)
+	if err != nil {
+		return path, false
+	}
+	if abs == fromAbs {
+		return to, true
+	}
+	if rest, ok := strings.CutPrefix(abs, fromAbs+string(filepath.Separator)); ok {
+		return filepath.Join(to, rest), true
+	}
+	return path, false
+}
+
+// SetRenamer is told when the editor moves a file, so that the frontend can
+// let other editors that have it open know.
+func (editor *Editor) SetRenamer(renamer func(from string, to string)) {
+	editor.renamer = renamer
+}
+
+// RenameBuffers points open files at their new path after from was moved to
+// to.
+func (editor *Editor) RenameBuffers(from string, to string) {
+	editor.FilePath, _ = RenamedPath(editor.FilePath, from, to)
+	editor.FileName = filepath.Base(editor.FilePath)
+	for i := range editor.hidden {
+		editor.hidden[i].path, _ = RenamedPath(editor.hidden[i].path, from, to)
+	}
+	editor.refreshTabs()
+	if editor.Explorer != nil {
+		editor.buildExplorer()
+	}
+}
@@ -0,0 +1,358 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ExCommand is a ":" command. args is everything after the command name with
+// surrounding whitespace removed.
+type ExCommand func(editor *Editor, bang bool, args string) error
+
+var exCommands = map[string]ExCommand{}
+
+func registerExCommand(command ExCommand, names ...string) {
+	for _, name := range names {
+		exCommands[name] = command
+	}
+}
+
+// RangeCommand is a ":" command that works on lines, first to last counting
+// from 0. Without a range it gets the cursor's line.
+type RangeCommand func(editor *Editor, first int, last int, bang bool, args string) error
+
+var rangeCommands = map[string]RangeCommand{}
+
+func registerRangeCommand(command RangeCommand, names ...string) {
+	for _, name := range names {
+		rangeCommands[name] = command
+	}
+}
+
+func (editor *Editor) ToCommand() {
+	editor.Mode = Command
+	editor.CommandLine = []rune{}
+	editor.clearMessage()
+}
+
+// ExecuteCommand runs a command line as if it had been typed after ":".
+func (editor *Editor) ExecuteCommand(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	first, last, line, hasRange, err := editor.parseRange(line)
+	if err != nil {
+		return err
+	}
+	// a range on its own goes to its last line
+	if line == "" {
+		start := editor.Content.lineStart(last)
+		editor.moveCursorTo(start + len(leadingWhitespace(editor.Content.lines()[last])))
+		return nil
+	}
+
+	nameEnd := strings.IndexFunc(line, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if nameEnd == -1 {
+		nameEnd = len(line)
+	}
+	if nameEnd == 0 {
+		nameEnd = 1
+	}
+
+	name, rest := line[:nameEnd], line[nameEnd:]
+	bang := name != "!" && strings.HasPrefix(rest, "!")
+	if bang {
+		rest = rest[1:]
+	}
+
+	// a name that is also a plain command, like !, only works on lines when
+	// given a range
+	if command, ok := rangeCommands[name]; ok && (hasRange || exCommands[name] == nil) {
+		return command(editor, first, last, bang, strings.TrimSpace(rest))
+	}
+	if hasRange {
+		return fmt.Errorf("no range allowed: %s", line)
+	}
+
+	command, ok := exCommands[name]
+	if !ok {
+		return fmt.Errorf("not an editor command: %s", line)
+	}
+
+	return command(editor, bang, strings.TrimSpace(rest))
+}
+
+// parseRange reads the lines a command works on from the front of line: %
+// for every line, or one or two addresses separated by a comma. It returns
+// the rest of the line and whether there was a range at all.
+func (editor *Editor) parseRange(line string) (int, int, string, bool, error) {
+	row := editor.Cursor.Row
+	if rest, ok := strings.CutPrefix(line, "%"); ok {
+		return 0, len(editor.Content.lines()) - 1, rest, true, nil
+	}
+
+	first, rest, ok, err := editor.parseAddress(line)
+	if err != nil || !ok {
+		return row, row, line, false, err
+	}
+	last := first
+	if after, ok := strings.CutPrefix(rest, ","); ok {
+		if last, rest, ok, err = editor.parseAddress(after); err != nil {
+			return row, row, line, false, err
+		} else if !ok {
+			return row, row, line, false, fmt.Errorf("missing address after ,")
+		}
+	}
+
+	lines := len(editor.Content.lines())
+	if first >= lines || last >= lines {
+		return row, row, line, false, fmt.Errorf("invalid range")
+	}
+	return min(first, last), max(first, last), rest, true, nil
+}
+
+// parseAddress reads one line address: . for the cursor's line, $ for the
+// last, a line number, or 'x for mark x, then any +n or -n offsets.
+func (editor *Editor) parseAddress(line string) (int, string, bool, error) {
+	row, rest := editor.Cursor.Row, line
+	switch {
+	case strings.HasPrefix(line, "."):
+		rest = line[1:]
+	case strings.HasPrefix(line, "$"):
+		row, rest = len(editor.Content.lines())-1, line[1:]
+	case strings.HasPrefix(line, "'") && len(line) > 1:
+		mark, ok := editor.Content.marks[rune(line[1])]
+		if !ok {
+			return 0, line, false, fmt.Errorf("mark not set: %c", line[1])
+		}
+		row, _ = editor.Content.position(mark.Index)
+		rest = line[2:]
+	case len(line) > 0 && unicode.IsDigit(rune(line[0])):
+		digits := strings.IndexFunc(line, func(r rune) bool { return !unicode.IsDigit(r) })
+		if digits == -1 {
+			digits = len(line)
+		}
+		number, _ := strconv.Atoi(line[:digits])
+		row, rest = number-1, line[digits:]
+	case !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-"):
+		return row, line, false, nil
+	}
+
+	for len(rest) > 0 && (rest[0] == '+' || rest[0] == '-') {
+		sign := 1
+		if rest[0] == '-' {
+			sign = -1
+		}
+		rest = rest[1:]
+		digits := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) })
+		if digits == -1 {
+			digits = len(rest)
+		}
+		offset := 1
+		if digits > 0 {
+			offset, _ = strconv.Atoi(rest[:digits])
+		}
+		row, rest = row+sign*offset, rest[digits:]
+	}
+	if row < 0 {
+		return 0, line, false, fmt.Errorf("invalid range")
+	}
+	return row, rest, true, nil
+}
+
+func (editor *Editor) submitCommand() {
+	line := string(editor.CommandLine)
+	if accept := editor.promptAccept; accept != nil {
+		editor.cancelCommand()
+		if err := accept(line); err != nil {
+			editor.ReportError(err)
+		}
+		return
+	}
+
+	editor.CommandLine = []rune{}
+	editor.Mode = Normal
+	editor.remember(line)
+
+	if err := editor.ExecuteCommand(line); err != nil {
+		editor.ReportError(err)
+	}
+}
+
+// remember adds a command line to the history, moving it to the end if it
+// was already there.
+func (editor *Editor) remember(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	editor.history = slices.DeleteFunc(editor.history, func(old string) bool {
+		return old == line
+	})
+	editor.history = append(editor.history, line)
+}
+
+func (editor *Editor) cancelCommand() {
+	editor.CommandLine = []rune{}
+	editor.Mode = Normal
+	if editor.promptAccept != nil {
+		editor.Mode = editor.promptMode
+		editor.Prompt, editor.promptAccept = "", nil
+	}
+}
+
+// prompt asks for a line of text on the command line, starting from initial.
+// accept gets the answer once it is submitted. Either way the editor goes
+// back to the mode it was in.
+func (editor *Editor) prompt(text string, initial string, accept func(answer string) error) {
+	editor.promptMode = editor.Mode
+	editor.Prompt, editor.promptAccept = text, accept
+	editor.Mode = Command
+	editor.CommandLine = []rune(initial)
+	editor.clearMessage()
+}
+
+// confirm asks a yes or no question and runs yes if the answer is yes.
+func (editor *Editor) confirm(question string, yes func() error) {
+	editor.prompt(question+" (y/n) ", "", func(answer string) error {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+			return yes()
+		}
+		return nil
+	})
+}
+
+func mapCommand(modes []EditorMode, noremap bool) ExCommand {
+	return func(editor *Editor, bang bool, args string) error {
+		lhs, rhs, _ := strings.Cut(args, " ")
+		rhs = strings.TrimSpace(rhs)
+
+		if lhs == "" {
+			lines := []string{}
+			for _, mode := range modes {
+				lines = append(lines, editor.Keymap.Mappings(mode)...)
+			}
+			if len(lines) == 0 {
+				editor.inform("No mapping found")
+			} else {
+				editor.showListing(lines)
+			}
+			return nil
+		}
+
+		lhsKeys, err := ParseKeys(lhs)
+		if err != nil {
+			return err
+		}
+
+		if rhs == "" {
+			return fmt.Errorf("missing right hand side for %s", lhs)
+		}
+
+		rhsKeys, err := ParseKeys(rhs)
+		if err != nil {
+			return err
+		}
+
+		for _, mode := range modes {
+			editor.Keymap.Map(mode, lhsKeys, &Mapping{Keys: rhsKeys, Noremap: noremap})
+		}
+		return nil
+	}
+}
+
+func unmapCommand(modes []EditorMode) ExCommand {
+	return func(editor *Editor, bang bool, args string) error {
+		keys, err := ParseKeys(args)
+		if err != nil {
+			return err
+		}
+
+		removed := false
+		for _, mode := range modes {
+			if editor.Keymap.Unmap(mode, keys) {
+				removed = true
+			}
+		}
+		if !removed {
+			return fmt.Errorf("no such mapping: %s", args)
+		}
+		return nil
+	}
+}
+
+func init() {
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if editor.Content.scratch {
+			return fmt.Errorf("%s is not a file", editor.FileName)
+		}
+		if err := editor.SaveContent(); err != nil {
+			return err
+		}
+		editor.inform(fmt.Sprintf("%q written", editor.FileName))
+		return nil
+	}, "w", "write")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		editor.Quit = true
+		return nil
+	}, "q", "quit")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if err := editor.SaveContent(); err != nil {
+			return err
+		}
+		editor.Quit = true
+		return nil
+	}, "wq", "x", "exit")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.SaveAll()
+	}, "wa", "wall")
+
+	normal := []EditorMode{Normal}
+	insert := []EditorMode{Insert}
+	command := []EditorMode{Command}
+	operator := []EditorMode{OperatorPending}
+	visual := []EditorMode{Visual}
+
+	registerExCommand(mapCommand(normal, false), "map", "nmap", "nm")
+	registerExCommand(mapCommand(insert, false), "imap", "im")
+	registerExCommand(mapCommand(command, false), "cmap", "cm")
+	registerExCommand(mapCommand(operator, false), "omap", "om")
+	registerExCommand(mapCommand(visual, false), "vmap", "vm", "xmap", "xm")
+	registerExCommand(mapCommand(normal, true), "noremap", "no", "nnoremap", "nn")
+	registerExCommand(mapCommand(insert, true), "inoremap", "ino")
+	registerExCommand(mapCommand(command, true), "cnoremap", "cno")
+	registerExCommand(mapCommand(operator, true), "onoremap", "ono")
+	registerExCommand(mapCommand(visual, true), "vnoremap", "vn", "xnoremap", "xn")
+	registerExCommand(unmapCommand(normal), "unmap", "unm", "nunmap", "nun")
+	registerExCommand(unmapCommand(insert), "iunmap", "iu")
+	registerExCommand(unmapCommand(command), "cunmap", "cu")
+	registerExCommand(unmapCommand(operator), "ounmap", "ou")
+	registerExCommand(unmapCommand(visual), "vunmap", "vu", "xunmap", "xu")
+
+	registerAction("command_submit", func(editor *Editor, key KeyStroke) {
+		editor.submitCommand()
+	})
+	registerAction("command_cancel", func(editor *Editor, key KeyStroke) {
+		editor.cancelCommand()
+	})
+	registerAction("command_backspace", func(editor *Editor, key KeyStroke) {
+		if len(editor.CommandLine) == 0 {
+			editor.cancelCommand()
+			return
+		}
+		editor.CommandLine = editor.CommandLine[:len(editor.CommandLine)-1]
+	})
+
+	bindDefault(Command, "<CR>", "command_submit")
+	bindDefault(Command, "<Esc>", "command_cancel")
+	bindDefault(Command, "<C-c>", "command_cancel")
+	bindDefault(Command, "<BS>", "command_backspace")
+	bindDefault(Command, "<C-h>", "command_backspace")
+}
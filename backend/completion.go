@@ -0,0 +1,487 @@
+package backend
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CompletionItem is one candidate in the completion menu.
+type CompletionItem struct {
+	Label string
+	// Insert replaces the text being completed, Label when empty
+	Insert string
+	Detail string
+	// Preview is shown next to the menu while the item is selected
+	Preview string
+
+	// preview is called for Preview when it is empty, for previews that are
+	// only worth working out for the selected item
+	preview func() string
+	// apply inserts the item itself, for items that bring other edits along.
+	// start is the content index where the completed text begins.
+	apply func(editor *Editor, start int)
+
+	start  int
+	source int
+	score  int
+}
+
+// CompletionSource finds candidates for the completion menu.
+type CompletionSource interface {
+	// Start is the column in line where the text to complete begins, col is
+	// the cursor's column.
+	Start(line []rune, col int) int
+	// Complete finds candidates for prefix and passes them to deliver on the
+	// editor's loop. Sources that wait on a server deliver later.
+	Complete(editor *Editor, prefix string, deliver func(items []CompletionItem))
+}
+
+var completionSources = map[string]CompletionSource{}
+
+// RegisterCompletionSource adds a source that can be listed in the complete
+// option, and an action complete_<name> that completes from it alone.
+func RegisterCompletionSource(name string, source CompletionSource) {
+	completionSources[name] = source
+	registerAction("complete_"+name, func(editor *Editor, key KeyStroke) {
+		editor.startCompletion([]string{name}, false)
+	})
+}
+
+// completionMenu is the open completion menu. items is everything the
+// sources found, shown is what matches the text typed since.
+type completionMenu struct {
+	row        int
+	items      []CompletionItem
+	shown      []CompletionItem
+	selectLast bool
+	// sources that have not delivered yet
+	pending int
+}
+
+// startCompletion asks each source for candidates. The menu opens once
+// something has been delivered.
+func (editor *Editor) startCompletion(names []string, selectLast bool) {
+	line, lineStart := editor.currentLine()
+	col := editor.Cursor.Index - lineStart
+
+	menu := &completionMenu{row: editor.Cursor.Row, selectLast: selectLast, pending: len(names)}
+	editor.completion = menu
+	editor.Popup = nil
+
+	for order, name := range names {
+		source, ok := completionSources[name]
+		if !ok {
+			editor.warn(fmt.Sprintf("unknown completion source %q", name))
+			menu.pending -= 1
+			continue
+		}
+
+		start := source.Start(line, col)
+		prefix := string(line[start:col])
+		source.Complete(editor, prefix, func(items []CompletionItem) {
+			if editor.completion != menu {
+				return
+			}
+			for _, item := range items {
+				item.start, item.source = lineStart+start, order
+				menu.items = append(menu.items, item)
+			}
+			menu.pending -= 1
+			editor.showCompletion()
+		})
+	}
+
+	if menu.pending == 0 {
+		editor.showCompletion()
+	}
+}
+
+// showCompletion filters and ranks the candidates against what has been
+// typed since the menu opened, closing the menu once nothing matches or the
+// cursor has left the line.
+func (editor *Editor) showCompletion() {
+	menu := editor.completion
+	if menu == nil {
+		return
+	}
+	if editor.Mode != Insert || editor.Cursor.Row != menu.row {
+		editor.closeCompletion()
+		return
+	}
+
+	text := editor.Content.calculateContent()
+	cursor := editor.Cursor.Index
+	shown := []CompletionItem{}
+	for _, item := range menu.items {
+		if item.start > cursor {
+			continue
+		}
+		score, _, ok := fuzzyMatch(string(text[item.start:cursor]), item.Label)
+		if ok {
+			item.score = score
+			shown = append(shown, item)
+		}
+	}
+	slices.SortStableFunc(shown, func(a, b CompletionItem) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(a.source, b.source))
+	})
+
+	if len(shown) == 0 {
+		if menu.pending > 0 {
+			editor.Popup = nil
+			return
+		}
+		if len(menu.items) == 0 {
+			editor.inform("no completions")
+		}
+		editor.closeCompletion()
+		return
+	}
+
+	// the selection stays on the same item while the list changes
+	selected := 0
+	if menu.selectLast && editor.Popup == nil {
+		selected = len(shown) - 1
+	}
+	if editor.Popup != nil && editor.Popup.Selected >= 0 && editor.Popup.Selected < len(menu.shown) {
+		previous := menu.shown[editor.Popup.Selected]
+		if i := slices.IndexFunc(shown, func(item CompletionItem) bool {
+			return item.Label == previous.Label && item.source == previous.source
+		}); i != -1 {
+			selected = i
+		}
+	}
+	menu.shown = shown
+
+	labelWidth := 0
+	for _, item := range shown {
+		labelWidth = max(labelWidth, utf8.RuneCountInString(item.Label))
+	}
+	lines := []string{}
+	for _, item := range shown {
+		line := item.Label
+		if item.Detail != "" {
+			line = fmt.Sprintf("%-*s  %s", labelWidth, item.Label, item.Detail)
+		}
+		lines = append(lines, line)
+	}
+
+	editor.showPopup(lines, selected)
+	// line the menu up with the start of the completed text
+	editor.Popup.Col = max(0, editor.Popup.Col-(cursor-shown[0].start))
+	editor.previewCompletion()
+}
+
+func (editor *Editor) previewCompletion() {
+	menu, popup := editor.completion, editor.Popup
+	if menu == nil || popup == nil || popup.Selected < 0 {
+		return
+	}
+
+	item := menu.shown[popup.Selected]
+	preview := item.Preview
+	if preview == "" && item.preview != nil {
+		preview = item.preview()
+	}
+	editor.setPopupPreview(preview)
+}
+
+// selectCompletion moves the menu selection by offset.
+func (editor *Editor) selectCompletion(offset int) bool {
+	if editor.completion == nil || editor.Popup == nil {
+		return false
+	}
+	editor.selectPopupLine(editor.Popup.Selected + offset)
+	editor.previewCompletion()
+	editor.keepPopup = true
+	return true
+}
+
+// acceptCompletion inserts the selected item in place of the text being
+// completed.
+func (editor *Editor) acceptCompletion() bool {
+	menu, popup := editor.completion, editor.Popup
+	if menu == nil || popup == nil || popup.Selected < 0 {
+		return false
+	}
+
+	item := menu.shown[popup.Selected]
+	editor.closeCompletion()
+	if item.apply != nil {
+		item.apply(editor, item.start)
+		return true
+	}
+
+	insert := []rune(cmp.Or(item.Insert, item.Label))
+	editor.Content.replace(insert, item.start, editor.Cursor.Index)
+	editor.moveCursorTo(item.start + len(insert))
+	return true
+}
+
+func (editor *Editor) closeCompletion() {
+	if editor.completion != nil {
+		editor.completion = nil
+		editor.Popup = nil
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordStart is where the word that ends at col begins.
+func wordStart(line []rune, col int) int {
+	start := col
+	for start > 0 && isWordRune(line[start-1]) {
+		start -= 1
+	}
+	return start
+}
+
+// keywordSource completes words from the buffer, nearest to the cursor
+// first, and then from every other open buffer.
+type keywordSource struct{}
+
+func (keywordSource) Start(line []rune, col int) int {
+	return wordStart(line, col)
+}
+
+func (keywordSource) Complete(editor *Editor, prefix string, deliver func(items []CompletionItem)) {
+	type keyword struct {
+		word     string
+		index    int
+		row      int
+		distance int
+	}
+
+	seen := map[string]bool{prefix: true}
+	items := []CompletionItem{}
+	add := func(text []rune, path string, cursor int) {
+		lines := strings.Split(string(text), "\n")
+		keywords := []keyword{}
+		for i, row := 0, 0; i < len(text); {
+			if text[i] == '\n' {
+				row += 1
+			}
+			if !isWordRune(text[i]) {
+				i += 1
+				continue
+			}
+			end := i
+			for end < len(text) && isWordRune(text[end]) {
+				end += 1
+			}
+			// single letters are not worth completing
+			if end-i > 1 {
+				keywords = append(keywords, keyword{string(text[i:end]), i, row, abs(i - cursor)})
+			}
+			i = end
+		}
+		if cursor >= 0 {
+			slices.SortStableFunc(keywords, func(a, b keyword) int {
+				return cmp.Compare(a.distance, b.distance)
+			})
+		}
+
+		for _, keyword := range keywords {
+			if seen[keyword.word] {
+				continue
+			}
+			seen[keyword.word] = true
+
+			item := CompletionItem{
+				Label:   keyword.word,
+				Preview: fmt.Sprintf("%d: %s", keyword.row+1, strings.TrimSpace(lines[keyword.row])),
+			}
+			if path != "" {
+				item.Detail = filepath.Base(path)
+				item.Preview = item.Detail + ":" + item.Preview
+			}
+			items = append(items, item)
+		}
+	}
+
+	add(editor.Content.calculateContent(), "", editor.Cursor.Index)
+	for _, buffer := range editor.otherBuffers() {
+		add(buffer.text, buffer.path, -1)
+	}
+	deliver(items)
+}
+
+func abs(n int) int {
+	return max(n, -n)
+}
+
+// pathSource completes file names, relative to the working directory.
+type pathSource struct{}
+
+func (pathSource) Start(line []rune, col int) int {
+	start := col
+	for start > 0 && !unicode.IsSpace(line[start-1]) &&
+		!strings.ContainsRune("\"'`()<>[]{},;=", line[start-1]) {
+		start -= 1
+	}
+	return start
+}
+
+func (pathSource) Complete(editor *Editor, prefix string, deliver func(items []CompletionItem)) {
+	dir, base := "", prefix
+	if slash := strings.LastIndexByte(prefix, '/'); slash != -1 {
+		dir, base = prefix[:slash+1], prefix[slash+1:]
+	}
+
+	search := dir
+	if home, err := os.UserHomeDir(); err == nil && strings.HasPrefix(search, "~/") {
+		search = filepath.Join(home, search[2:])
+	}
+	if search == "" {
+		search = "."
+	}
+
+	entries, err := os.ReadDir(search)
+	if err != nil {
+		deliver(nil)
+		return
+	}
+
+	items := []CompletionItem{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		path := filepath.Join(search, name)
+		item := CompletionItem{Label: dir + name}
+		if entry.IsDir() {
+			item.Label += "/"
+		}
+		item.preview = func() string {
+			return pathPreview(path, maxPopupHeight)
+		}
+		items = append(items, item)
+	}
+	deliver(items)
+}
+
+// pathPreview is the first lines of a directory listing or of a text file.
+func pathPreview(path string, lines int) string {
+	if entries, err := os.ReadDir(path); err == nil {
+		names := []string{}
+		for _, entry := range entries[:min(len(entries), lines)] {
+			names = append(names, entry.Name())
+		}
+		return strings.Join(names, "\n")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	head := make([]byte, 4096)
+	n, _ := file.Read(head)
+	head = head[:n]
+	if slices.Contains(head, 0) || !utf8.Valid(head) {
+		return ""
+	}
+	text := strings.Split(string(head), "\n")
+	return strings.Join(text[:min(len(text), lines)], "\n")
+}
+
+// lineSource completes whole lines, from the buffer nearest the cursor
+// first and then from every other open buffer.
+type lineSource struct{}
+
+func (lineSource) Start(line []rune, col int) int {
+	return min(col, len(leadingWhitespace(line)))
+}
+
+func (lineSource) Complete(editor *Editor, prefix string, deliver func(items []CompletionItem)) {
+	seen := map[string]bool{}
+	items := []CompletionItem{}
+	add := func(lines []string, path string, cursorRow int) {
+		rows := []int{}
+		for row := range lines {
+			if row != cursorRow {
+				rows = append(rows, row)
+			}
+		}
+		if cursorRow >= 0 {
+			slices.SortStableFunc(rows, func(a, b int) int {
+				return cmp.Compare(abs(a-cursorRow), abs(b-cursorRow))
+			})
+		}
+
+		for _, row := range rows {
+			line := strings.TrimSpace(lines[row])
+			if line == "" || seen[line] {
+				continue
+			}
+			seen[line] = true
+
+			item := CompletionItem{Label: line}
+			if path != "" {
+				item.Detail = filepath.Base(path)
+			}
+			items = append(items, item)
+		}
+	}
+
+	add(strings.Split(string(editor.Content.calculateContent()), "\n"), "", editor.Cursor.Row)
+	for _, buffer := range editor.otherBuffers() {
+		add(strings.Split(string(buffer.text), "\n"), buffer.path, -1)
+	}
+	deliver(items)
+}
+
+func init() {
+	RegisterCompletionSource("keyword", keywordSource{})
+	RegisterCompletionSource("path", pathSource{})
+	RegisterCompletionSource("line", lineSource{})
+
+	registerOption(OptionDef{
+		Name: "complete", Short: "cpt", Kind: StringOption, Scope: GlobalScope,
+		Default: OptionValue{String: "keyword"},
+		validate: func(value OptionValue) error {
+			for _, name := range strings.Split(value.String, ",") {
+				if _, ok := completionSources[name]; !ok {
+					return fmt.Errorf("unknown completion source %q", name)
+				}
+			}
+			return nil
+		},
+	})
+
+	registerAction("complete_next", func(editor *Editor, key KeyStroke) {
+		if !editor.selectCompletion(1) {
+			editor.startCompletion(strings.Split(editor.OptionString("complete"), ","), false)
+		}
+	})
+	registerAction("complete_prev", func(editor *Editor, key KeyStroke) {
+		if !editor.selectCompletion(-1) {
+			editor.startCompletion(strings.Split(editor.OptionString("complete"), ","), true)
+		}
+	})
+	registerAction("complete_accept", func(editor *Editor, key KeyStroke) {
+		editor.acceptCompletion()
+	})
+	registerAction("complete_cancel", func(editor *Editor, key KeyStroke) {
+		editor.closeCompletion()
+	})
+
+	bindDefault(Insert, "<C-n>", "complete_next")
+	bindDefault(Insert, "<C-p>", "complete_prev")
+	bindDefault(Insert, "<C-y>", "complete_accept")
+	bindDefault(Insert, "<C-e>", "complete_cancel")
+	bindDefault(Insert, "<C-x><C-n>", "complete_keyword")
+	bindDefault(Insert, "<C-x><C-f>", "complete_path")
+	bindDefault(Insert, "<C-x><C-l>", "complete_line")
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	if _, _, ok := fuzzyMatch("fzb", "foo_bar"); ok {
+		t.Fatal("expected no match without a z")
+	}
+
+	_, positions, ok := fuzzyMatch("fb", "foo_bar")
+	if !ok || !slices.Equal(positions, []int{0, 4}) {
+		t.Fatalf("unexpected positions %v", positions)
+	}
+
+	boundary, _, _ := fuzzyMatch("fb", "foo_bar")
+	middle, _, _ := fuzzyMatch("fb", "fooxbar")
+	consecutive, _, _ := fuzzyMatch("fo", "foo_bar")
+	if boundary <= middle || consecutive <= boundary {
+		t.Fatalf("expected consecutive > boundary > middle, got %d %d %d", consecutive, boundary, middle)
+	}
+
+	// upper case in the pattern makes the match case sensitive
+	if _, _, ok := fuzzyMatch("FB", "foo_bar"); ok {
+		t.Fatal("expected smart case to reject lower case text")
+	}
+	if _, _, ok := fuzzyMatch("fb", "Foo_Bar"); !ok {
+		t.Fatal("expected a lower case pattern to ignore case")
+	}
+}
+
+// resetBuffers forgets buffers published by other tests.
+func resetBuffers() {
+	buffersMu.Lock()
+	buffers = map[*Content]*buffer{}
+	buffersMu.Unlock()
+}
+
+func TestKeywordCompletion(t *testing.T) {
+	resetBuffers()
+	other := newTestEditor("always\n")
+	other.FilePath = "other.go"
+	other.ScrollToCursor()
+
+	editor := newTestEditor("alpha beta alphabet\n")
+	editor.moveCursorTo(editor.Content.Length)
+	typeKeys(t, editor, "ial<C-n>")
+
+	if editor.Popup == nil {
+		t.Fatal("expected the completion menu")
+	}
+	expected := []string{"alphabet", "alpha", "always    other.go"}
+	if !slices.Equal(editor.Popup.Lines, expected) || editor.Popup.Selected != 0 {
+		t.Fatalf("expected %q, got %q", expected, editor.Popup.Lines)
+	}
+	if !slices.Equal(editor.Popup.Preview, []string{"1: alpha beta alphabet"}) {
+		t.Fatalf("unexpected preview %q", editor.Popup.Preview)
+	}
+
+	// typing narrows the menu
+	typeKeys(t, editor, "w")
+	if !slices.Equal(editor.Popup.Lines, []string{"always  other.go"}) {
+		t.Fatalf("expected only always, got %q", editor.Popup.Lines)
+	}
+	typeKeys(t, editor, "<C-y>")
+	expectContent(t, editor, "alpha beta alphabet\nalways")
+	if editor.Popup != nil || editor.Cursor.Col != 6 {
+		t.Fatalf("expected the menu closed after the word, got %+v", editor.Cursor)
+	}
+
+	// <C-p> starts at the end, and a key that matches nothing closes the menu
+	typeKeys(t, editor, "<CR>al<C-p>")
+	if editor.Popup.Selected != 2 {
+		t.Fatalf("expected the last item selected, got %d", editor.Popup.Selected)
+	}
+	typeKeys(t, editor, " ")
+	if editor.Popup != nil || editor.completion != nil {
+		t.Fatal("expected the menu to close")
+	}
+
+	// once nobody has the other file open its words are gone
+	other.Content.Unpublish()
+	if others := editor.otherBuffers(); len(others) != 0 {
+		t.Fatalf("expected no other buffers, got %d", len(others))
+	}
+}
+
+func TestPathAndLineCompletion(t *testing.T) {
+	resetBuffers()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello\nworld\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "first"), 0755)
+	os.WriteFile(filepath.Join(dir, ".hidden"), []byte{}, 0644)
+
+	editor := newTestEditor("see " + dir + "/fi")
+	editor.moveCursorTo(editor.Content.Length)
+	editor.Mode = Insert
+	typeKeys(t, editor, "<C-x><C-f>")
+
+	expected := []string{dir + "/file.txt", dir + "/first/"}
+	if editor.Popup == nil || !slices.Equal(editor.Popup.Lines, expected) {
+		t.Fatalf("expected %q, got %+v", expected, editor.Popup)
+	}
+	if !slices.Equal(editor.Popup.Preview, []string{"hello", "world", ""}) {
+		t.Fatalf("unexpected preview %q", editor.Popup.Preview)
+	}
+	typeKeys(t, editor, "<C-n><CR>")
+	expectContent(t, editor, "see "+dir+"/first/")
+
+	resetBuffers()
+	editor = newTestEditor("\tfoo(bar)\nbaz\n\tf")
+	editor.moveCursorTo(editor.Content.Length)
+	editor.Mode = Insert
+	typeKeys(t, editor, "<C-x><C-l>")
+	if editor.Popup == nil || !slices.Equal(editor.Popup.Lines, []string{"foo(bar)"}) {
+		t.Fatalf("expected one line, got %+v", editor.Popup)
+	}
+	typeKeys(t, editor, "<CR>")
+	expectContent(t, editor, "\tfoo(bar)\nbaz\n\tfoo(bar)")
+}
@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config is the parsed user config file. It understands the subset of TOML we
+// need: [section] headers, and key = value pairs where a value is a string,
+// integer, boolean or an array of those.
+type Config struct {
+	Sections map[string]map[string]any
+}
+
+func DefaultConfigPath() string {
+	if path := os.Getenv("TEXT_EDITOR_CONFIG"); path != "" {
+		return path
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "text-editor", "config.toml")
+}
+
+// LoadConfig reads the config at path. A missing file is an empty config.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) || path == "" {
+		return &Config{Sections: map[string]map[string]any{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func ParseConfig(reader io.Reader) (*Config, error) {
+	config := &Config{Sections: map[string]map[string]any{"": {}}}
+	section := ""
+
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum += 1 {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", lineNum)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := config.Sections[section]; !ok {
+				config.Sections[section] = map[string]any{}
+			}
+			continue
+		}
+
+		rawKey, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+
+		key, err := parseConfigKey(strings.TrimSpace(rawKey))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		value, err := parseConfigValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		config.Sections[section][key] = value
+	}
+
+	return config, scanner.Err()
+}
+
+// stripComment drops a trailing # comment that is not inside a string.
+func stripComment(line string) string {
+	inString := false
+	for i, r := range line {
+		switch {
+		case r == '"' && (i == 0 || line[i-1] != '\\'):
+			inString = !inString
+		case r == '#' && !inString:
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseConfigKey(key string) (string, error) {
+	if strings.HasPrefix(key, "\"") || strings.HasPrefix(key, "'") {
+		value, err := parseConfigValue(key)
+		if err != nil {
+			return "", err
+		}
+		return value.(string), nil
+	}
+	if key == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	return key, nil
+}
+
+func parseConfigValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, "\""):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return nil, fmt.Errorf("bad string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return nil, fmt.Errorf("unterminated array %s", raw)
+		}
+		values := []any{}
+		for _, item := range splitConfigArray(raw[1 : len(raw)-1]) {
+			value, err := parseConfigValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case raw == "true" || raw == "false":
+		return raw == "true", nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("bad value %s", raw)
+	}
+	return value, nil
+}
+
+func splitConfigArray(raw string) []string {
+	items := []string{}
+	inString := false
+	start := 0
+
+	for i, r := range raw {
+		switch {
+		case r == '"' && (i == 0 || raw[i-1] != '\\'):
+			inString = !inString
+		case r == ',' && !inString:
+			items = append(items, strings.TrimSpace(raw[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(raw[start:]); last != "" {
+		items = append(items, last)
+	}
+
+	return items
+}
+
+// Section returns the key value pairs of a section, or an empty map.
+func (config *Config) Section(name string) map[string]any {
+	if section, ok := config.Sections[name]; ok {
+		return section
+	}
+	return map[string]any{}
+}
+
+func (config *Config) String(section string, key string) (string, bool) {
+	value, ok := config.Section(section)[key].(string)
+	return value, ok
+}
+
+func (config *Config) Int(section string, key string) (int, bool) {
+	value, ok := config.Section(section)[key].(int)
+	return value, ok
+}
+
+func (config *Config) Strings(section string, key string) ([]string, bool) {
+	switch value := config.Section(section)[key].(type) {
+	case string:
+		return []string{value}, true
+	case []any:
+		values := []string{}
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// applyConfig loads option values and keymap overrides. Values under
+// [keymap.<mode>] name an action, anything that is not a known action is
+// treated as keys to feed.
+func (editor *Editor) applyConfig(config *Config) error {
+	if err := editor.applyOptionConfig(config); err != nil {
+		return err
+	}
+	if err := editor.applyThemeConfig(config); err != nil {
+		return err
+	}
+
+	for _, modeName := range []string{"normal", "insert", "command", "operator", "picker", "explorer", "quickfix", "visual"} {
+		mode, _ := modeFromName(modeName)
+
+		for lhs, value := range config.Section("keymap." + modeName) {
+			rhs, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("keymap.%s: %s must be a string", modeName, lhs)
+			}
+
+			keys, err := ParseKeys(lhs)
+			if err != nil {
+				return fmt.Errorf("keymap.%s: %w", modeName, err)
+			}
+
+			if IsAction(rhs) {
+				editor.Keymap.Map(mode, keys, &Mapping{Action: rhs})
+				continue
+			}
+
+			rhsKeys, err := ParseKeys(rhs)
+			if err != nil {
+				return fmt.Errorf("keymap.%s: %w", modeName, err)
+			}
+			editor.Keymap.Map(mode, keys, &Mapping{Keys: rhsKeys})
+		}
+	}
+
+	return nil
+}
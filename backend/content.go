@@ -3,6 +3,7 @@ package backend
 import (
 	"fmt"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -27,28 +28,136 @@ type Content struct {
 	Length      int
 	lastEdit    int64
 	NumPieces   int
+
+	// buffer local option values
+	Options OptionValues
+
+	// bumped on every edit so copies of the content can tell they are stale
+	Version int
+	// the Version the file was read or last written at, -1 for buffers
+	// that are not files, see Modified
+	SavedVersion int
+
+	listeners   []*func(edit Edit)
+	anchors     []*anchor
+	highlighter *highlighter
+	structure   *structureCache
+	queue       *asyncQueue
+	lsp         *lspDocument
+	marks       map[rune]*anchor
+
+	// the group of the last edit, and how many beginGroup calls are open
+	group      int
+	groupDepth int
+
+	// set once an editor has applied the file's own settings
+	setUp bool
+	// set for buffers that are not files, which are never saved
+	scratch bool
+
+	// what the language server last reported, see lsp.go
+	Diagnostics []Diagnostic
+
+	// the file as git's HEAD has it and the lines that differ, see git.go
+	git       *gitBase
+	GitSigns  map[int]Sign
+	GitBranch string
+}
+
+// Edit describes a change made by replace. Positions are in the content as it
+// was before the edit.
+type Edit struct {
+	Start    int
+	End      int
+	Removed  []rune
+	Inserted []rune
+
+	StartRow int
+	StartCol int
+	EndRow   int
+	EndCol   int
+
+	// Group is shared by edits that make up one change, like the hunks of
+	// SetText, for undo to take back together. Other edits get one each.
+	Group int
+}
+
+// OnEdit registers a function that is called after every edit. It returns
+// a function that unregisters it again.
+func (content *Content) OnEdit(listener func(edit Edit)) func() {
+	registered := &listener
+	content.listeners = append(content.listeners, registered)
+	return func() {
+		content.listeners = slices.DeleteFunc(content.listeners, func(other *func(edit Edit)) bool {
+			return other == registered
+		})
+	}
+}
+
+// Close lets go of a content that no editor will show again, once the loop
+// that owns it is done with it. Other editors stop seeing it and its
+// language server stops following it.
+func (content *Content) Close() {
+	content.Unpublish()
+	if content.lsp != nil {
+		content.lsp.close()
+	}
+}
+
+// anchor is a content index that follows the text around it as the content
+// is edited. Text inserted right at an anchor goes after it, unless after is
+// set in which case the anchor moves past the new text.
+type anchor struct {
+	Index int
+	after bool
+}
+
+func (content *Content) newAnchor(index int, after bool) *anchor {
+	anchor := &anchor{Index: index, after: after}
+	content.anchors = append(content.anchors, anchor)
+	return anchor
+}
+
+func (content *Content) dropAnchors(anchors ...*anchor) {
+	content.anchors = slices.DeleteFunc(content.anchors, func(anchor *anchor) bool {
+		return slices.Contains(anchors, anchor)
+	})
+}
+
+func (content *Content) shiftAnchors(edit Edit) {
+	delta := len(edit.Inserted) - (edit.End - edit.Start)
+	for _, anchor := range content.anchors {
+		switch {
+		case anchor.Index < edit.Start:
+		case anchor.Index > edit.End:
+			anchor.Index += delta
+		case anchor.Index == edit.Start && (edit.Start < edit.End || !anchor.after):
+			// text replaced after the anchor does not pull it along
+		case anchor.Index == edit.End || anchor.after:
+			anchor.Index = edit.Start + len(edit.Inserted)
+		default:
+			anchor.Index = edit.Start
+		}
+	}
 }
 
-func (content *Content) loadFromFile(path string) {
+func (content *Content) loadFromFile(path string, charset string) error {
 	content.lastEdit = -1
 
 	rawFileContent, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	fileContent := []rune{}
-
-	for _, r := range string(rawFileContent) {
-		fileContent = append(fileContent, r)
-	}
+	fileContent, options := decodeFile(rawFileContent, charset)
 
 	content.Original = fileContent
 	content.Add = []rune{}
+	content.Options = options
 
 	originalPiece := Piece{
 		Start:  0,
-		Length: len(fileContent) - 1,
+		Length: len(fileContent),
 		Kind:   original,
 		Next:   nil,
 	}
@@ -56,13 +165,54 @@ func (content *Content) loadFromFile(path string) {
 	content.Length = originalPiece.Length
 	content.ContentRoot = &originalPiece
 	content.NumPieces = 1
+	return nil
 }
 
 func (content *Content) undo() {
 }
 
+// beginGroup starts a change made of several edits, which lasts until the
+// matching endGroup. Groups inside groups are part of the outer one.
+func (content *Content) beginGroup() {
+	if content.groupDepth == 0 {
+		content.group += 1
+	}
+	content.groupDepth += 1
+}
+
+func (content *Content) endGroup() {
+	content.groupDepth -= 1
+}
+
+// replace swaps the runes in [start, end) for r and tells listeners about it.
+// It returns the edit it made.
+func (content *Content) replace(r []rune, start int, end int) Edit {
+	text := content.calculateContent()
+	edit := Edit{
+		Start:    start,
+		End:      end,
+		Removed:  append([]rune{}, text[start:end]...),
+		Inserted: append([]rune{}, r...),
+	}
+	if content.groupDepth == 0 {
+		content.group += 1
+	}
+	edit.Group = content.group
+	edit.StartRow, edit.StartCol = content.position(start)
+	edit.EndRow, edit.EndCol = content.position(end)
+
+	content.splice(r, start, end)
+	content.Version += 1
+	content.shiftAnchors(edit)
+
+	for _, listener := range content.listeners {
+		(*listener)(edit)
+	}
+	return edit
+}
+
 // TODO add a bunch of error cases, should return error
-func (content *Content) replace(r []rune, start int, end int) {
+func (content *Content) splice(r []rune, start int, end int) {
 	/*
 	   start, end inclusive
 
@@ -174,82 +324,50 @@ func (content *Content) replace(r []rune, start int, end int) {
 	}
 
 	// Case 5: replacing
-	// delete stuff first, recursion to insert
+	// cut [start, end) out of the pieces it overlaps, recursion to insert
 	pieceStart := 0
 	var prev *Piece = nil
-	for piece := content.ContentRoot; piece != nil; piece = piece.Next {
-
+	for piece := content.ContentRoot; piece != nil && pieceStart < end; {
 		pieceEnd := pieceStart + piece.Length
-
-		if pieceStart < start && end < pieceEnd {
-			// fmt.Println("going 1")
-			content.Length -= piece.Length
-
-			// set up right piece
-			pr := &Piece{
+		next := piece.Next
+
+		switch {
+		case pieceEnd <= start:
+			prev = piece
+		case pieceStart < start && end < pieceEnd:
+			// the range is inside the piece, split it around the range
+			piece.Next = &Piece{
 				Start:  piece.Start + end - pieceStart,
 				Length: pieceEnd - end,
 				Kind:   piece.Kind,
 				Next:   piece.Next,
 			}
-
-			// set up left piece
 			piece.Length = start - pieceStart
-			piece.Next = pr
-
-			content.Length += piece.Length + pr.Length
-			break
-		}
-
-		if pieceStart >= start && end >= pieceEnd {
-			// fmt.Println("going 2")
-			// fmt.Printf("%d %d %d %d\n", start, end, piece_start, piece_end)
-			content.Length -= piece.Length
+		case pieceStart >= start && pieceEnd <= end:
+			// the piece is inside the range
 			if prev == nil {
-				content.ContentRoot = content.ContentRoot.Next
+				content.ContentRoot = next
 			} else {
-				prev.Next = piece.Next
+				prev.Next = next
 			}
-			piece = content.ContentRoot
-			prev = nil
-
-			if pieceEnd == end {
-				break
-			}
-
-			continue
-
-		} else if pieceStart >= start && end > pieceStart && end < pieceEnd {
-			// fmt.Println("going 3")
-			content.Length -= piece.Length
-			piece.Start = piece.Start + end - start
+		case pieceStart >= start:
+			// the range ends inside the piece, keep its tail
+			piece.Start += end - pieceStart
 			piece.Length = pieceEnd - end
-
-			content.Length += piece.Length
-			if pieceStart == start {
-				break
-			}
-
-			pieceEnd = pieceStart + piece.Length
-		} else if end >= pieceEnd && start > pieceStart && start < pieceEnd {
-			// fmt.Println("going 4")
-			content.Length -= piece.Length
+			prev = piece
+		default:
+			// the range starts inside the piece, keep its head
 			piece.Length = start - pieceStart
-
-			content.Length += piece.Length
-			if pieceEnd == end {
-				break
-			}
-
-			pieceEnd = pieceStart + piece.Length
+			prev = piece
 		}
 
 		pieceStart = pieceEnd
-		prev = piece
+		piece = next
 	}
+	content.Length -= end - start
 
 	if len(r) > 0 {
-		content.replace(r, start, start)
+		content.splice(r, start, start)
 	}
 }
 
@@ -282,3 +400,54 @@ func (content *Content) calculateContent() []rune {
 
 	return finalString
 }
+
+// lines splits the content on newlines. There is always at least one line.
+func (content *Content) lines() [][]rune {
+	lines := [][]rune{}
+	text := content.calculateContent()
+
+	start := 0
+	for i, r := range text {
+		if r == '\n' {
+			lines = append(lines, text[start:i])
+			start = i + 1
+		}
+	}
+	lines = append(lines, text[start:])
+
+	return lines
+}
+
+// position converts an index into the content into a row and column.
+func (content *Content) position(index int) (int, int) {
+	row, col := 0, 0
+	for i, r := range content.calculateContent() {
+		if i == index {
+			break
+		}
+		if r == '\n' {
+			row, col = row+1, 0
+		} else {
+			col += 1
+		}
+	}
+	return row, col
+}
+
+// lineStart is the index of the first rune of row, or the content length when
+// row is past the last line.
+func (content *Content) lineStart(row int) int {
+	if row <= 0 {
+		return 0
+	}
+
+	for i, r := range content.calculateContent() {
+		if r == '\n' {
+			row -= 1
+			if row == 0 {
+				return i + 1
+			}
+		}
+	}
+	return content.Length
+}
@@ -274,3 +274,22 @@ func TestReplaceAddDelete(t *testing.T) {
 	content.printPieces()
 	fmt.Println(content.calculateContent())
 }
+
+func TestReplaceAcrossPieces(t *testing.T) {
+	content := &Content{
+		Original:    []rune("one two"),
+		Add:         []rune{},
+		ContentRoot: &Piece{0, 7, original, nil},
+		Length:      7,
+	}
+	for i, r := range " three" {
+		content.replace([]rune{r}, 7+i, 7+i)
+	}
+	content.replace([]rune("2 3"), 4, 13)
+	content.replace([]rune{}, 2, 5)
+
+	final := content.calculateContent()
+	if expected := []rune("on 3"); !runeCmp(final, expected) || content.Length != len(expected) {
+		t.Fatalf("\nFinal String: %s\nExpected String: %s", string(final), string(expected))
+	}
+}
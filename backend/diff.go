@@ -0,0 +1,180 @@
+package backend
+
+import "strings"
+
+// diffHunk is a run, a[aStart:aEnd], that becomes b[bStart:bEnd].
+type diffHunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// diff finds the fewest elements to change to turn a into b, with the linear
+// space form of Myers' algorithm, and returns the changes in order.
+func diff[T comparable](a []T, b []T) []diffHunk {
+	hunks := []diffHunk{}
+	var walk func(aStart, aEnd, bStart, bEnd int)
+	walk = func(aStart, aEnd, bStart, bEnd int) {
+		for aStart < aEnd && bStart < bEnd && a[aStart] == b[bStart] {
+			aStart, bStart = aStart+1, bStart+1
+		}
+		for aStart < aEnd && bStart < bEnd && a[aEnd-1] == b[bEnd-1] {
+			aEnd, bEnd = aEnd-1, bEnd-1
+		}
+		if aStart == aEnd || bStart == bEnd {
+			if aStart < aEnd || bStart < bEnd {
+				hunks = appendHunk(hunks, diffHunk{aStart, aEnd, bStart, bEnd})
+			}
+			return
+		}
+
+		// with the ends trimmed at least two changes are left, so both
+		// sides of the middle snake are smaller problems
+		x, y, u, v := middleSnake(a[aStart:aEnd], b[bStart:bEnd])
+		walk(aStart, aStart+x, bStart, bStart+y)
+		walk(aStart+u, aEnd, bStart+v, bEnd)
+	}
+	walk(0, len(a), 0, len(b))
+	return hunks
+}
+
+// appendHunk adds a hunk, joining it to the last one when they touch.
+func appendHunk(hunks []diffHunk, hunk diffHunk) []diffHunk {
+	if last := len(hunks) - 1; last >= 0 && hunks[last].aEnd == hunk.aStart && hunks[last].bEnd == hunk.bStart {
+		hunks[last].aEnd, hunks[last].bEnd = hunk.aEnd, hunk.bEnd
+		return hunks
+	}
+	return append(hunks, hunk)
+}
+
+// middleSnake runs the search from both ends at once until the paths meet,
+// and returns the run of equal elements, a[x:u] and b[y:v], that a shortest
+// edit script goes through halfway.
+func middleSnake[T comparable](a []T, b []T) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n+m+1)/2 + 1
+	// forward[k+limit] is how far along a the furthest path from the start
+	// on diagonal k got, backward the same from the end with both reversed
+	forward := make([]int, 2*limit+1)
+	backward := make([]int, 2*limit+1)
+
+	for d := 0; d < limit; d += 1 {
+		for k := -d; k <= d; k += 2 {
+			x := forward[limit+k-1] + 1
+			if k == -d || (k != d && forward[limit+k-1] < forward[limit+k+1]) {
+				x = forward[limit+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			forward[limit+k] = x
+
+			// the backward paths are one change behind
+			if back := delta - k; odd && back >= -(d-1) && back <= d-1 && x+backward[limit+back] >= n {
+				return startX, startY, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			x := backward[limit+k-1] + 1
+			if k == -d || (k != d && backward[limit+k-1] < backward[limit+k+1]) {
+				x = backward[limit+k+1]
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x, y = x+1, y+1
+			}
+			backward[limit+k] = x
+
+			if ahead := delta - k; !odd && ahead >= -d && ahead <= d && x+forward[limit+ahead] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+	panic("diff: the searches never met")
+}
+
+// splitLines splits text after each newline, so the lines join back into
+// the text.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// changed lines shorter than this, together, are diffed again rune by rune
+const refineLimit = 4096
+
+// SetText changes the content into text with a replace for each run that
+// differs, rather than replacing everything, so that anchors and cursors
+// outside the changes stay where they are. The replaces are grouped as one
+// change. It returns the edits it made in order, positioned in the text as
+// it was, for moving other cursors with shiftIndex.
+func (content *Content) SetText(text []rune) []Edit {
+	current := content.calculateContent()
+	old, lines := splitLines(string(current)), splitLines(string(text))
+
+	// where each old and new line starts, in runes
+	oldStarts := make([]int, len(old)+1)
+	for i, line := range old {
+		oldStarts[i+1] = oldStarts[i] + len([]rune(line))
+	}
+	newStarts := make([]int, len(lines)+1)
+	for i, line := range lines {
+		newStarts[i+1] = newStarts[i] + len([]rune(line))
+	}
+
+	// changed lines are narrowed down to the runes that changed when there
+	// are not too many of them
+	type change struct {
+		start, end int
+		text       []rune
+	}
+	changes := []change{}
+	for _, hunk := range diff(old, lines) {
+		start, end := oldStarts[hunk.aStart], oldStarts[hunk.aEnd]
+		replacement := text[newStarts[hunk.bStart]:newStarts[hunk.bEnd]]
+		if end-start+len(replacement) > refineLimit {
+			changes = append(changes, change{start, end, replacement})
+			continue
+		}
+		for _, inner := range diff(current[start:end], replacement) {
+			changes = append(changes, change{
+				start + inner.aStart, start + inner.aEnd, replacement[inner.bStart:inner.bEnd],
+			})
+		}
+	}
+
+	// from the bottom so the positions above stay right, which also leaves
+	// every edit positioned in the text as it was
+	content.beginGroup()
+	defer content.endGroup()
+	edits := make([]Edit, len(changes))
+	for i := len(changes) - 1; i >= 0; i -= 1 {
+		change := changes[i]
+		edits[i] = content.replace(append([]rune{}, change.text...), change.start, change.end)
+	}
+	return edits
+}
+
+// shiftIndex moves an index in the text from before edits, as SetText
+// returns them, to the same place after them. An index inside a change goes
+// to its start.
+func shiftIndex(index int, edits []Edit) int {
+	shifted := index
+	for _, edit := range edits {
+		switch {
+		case edit.End < index || (edit.End == index && edit.Start < edit.End):
+			shifted += len(edit.Inserted) - (edit.End - edit.Start)
+		case edit.Start < index:
+			shifted -= index - edit.Start
+		}
+	}
+	return shifted
+}
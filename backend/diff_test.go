@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := splitLines("one\ntwo\nthree\nfour\nfive")
+	b := splitLines("zero\none\nthree\nFOUR\nfive\nsix\n")
+	// five gains a newline, so it changes too
+	expected := []diffHunk{{0, 0, 0, 1}, {1, 2, 2, 2}, {3, 5, 3, 6}}
+	if hunks := diff(a, b); !slices.Equal(hunks, expected) {
+		t.Fatalf("\nGot: %v\nExpected: %v", hunks, expected)
+	}
+	if hunks := diff(a, a); len(hunks) != 0 {
+		t.Fatalf("expected no changes, got %v", hunks)
+	}
+
+	// any text can be turned into any other
+	random := rand.New(rand.NewSource(1))
+	text := func() string {
+		var text strings.Builder
+		for i := random.Intn(12); i > 0; i -= 1 {
+			text.WriteRune(rune('a' + random.Intn(3)))
+			if random.Intn(4) == 0 {
+				text.WriteRune('\n')
+			}
+		}
+		return text.String()
+	}
+	for i := 0; i < 2000; i += 1 {
+		from, to := text(), text()
+		editor := newTestEditor(from)
+		editor.Content.SetText([]rune(to))
+		if result := string(editor.Content.calculateContent()); result != to {
+			t.Fatalf("%q to %q gave %q", from, to, result)
+		}
+	}
+}
+
+func TestDiffEverythingChanged(t *testing.T) {
+	// the search only keeps its frontier, not one per change, so a long
+	// file with nothing in common is cheap
+	a, b := make([]string, 3000), make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprintf("a%d\n", i), fmt.Sprintf("b%d\n", i)
+	}
+	allocs := testing.AllocsPerRun(1, func() {
+		if hunks := diff(a, b); !slices.Equal(hunks, []diffHunk{{0, 3000, 0, 3000}}) {
+			t.Fatalf("expected one hunk, got %v", hunks)
+		}
+	})
+	if allocs > 100 {
+		t.Fatalf("expected few allocations, got %v", allocs)
+	}
+}
+
+func TestSetText(t *testing.T) {
+	editor := newTestEditor("func main() {\n\tx := 1\n\ty := 2\n}\n")
+	editor.ExecuteCommand("3")
+	typeKeys(t, editor, "lma")
+	editor.ExecuteCommand("1")
+
+	edits := editor.Content.SetText([]rune("func main() {\n\tx = 10\n\ty := 2\n}\n"))
+	expectContent(t, editor, "func main() {\n\tx = 10\n\ty := 2\n}\n")
+
+	// only the runes that changed are replaced
+	changes := []string{}
+	for _, edit := range edits {
+		changes = append(changes, string(edit.Removed)+">"+string(edit.Inserted))
+	}
+	if !slices.Equal(changes, []string{":>", ">0"}) {
+		t.Fatalf("unexpected edits %q", changes)
+	}
+	// the hunks are one change, apart from the edits around them
+	if edits[0].Group != edits[1].Group || editor.Content.replace([]rune{}, 0, 0).Group == edits[0].Group {
+		t.Fatalf("expected the edits to be grouped, got %+v", edits)
+	}
+	if mark := editor.Content.marks['a']; mark.Index != 24 {
+		t.Fatalf("expected the mark to follow its line, got %d", mark.Index)
+	}
+
+	cursors := map[int]int{0: 0, 17: 17, 18: 17, 20: 19, 21: 20, 22: 22, 24: 24}
+	for before, after := range cursors {
+		if shifted := shiftIndex(before, edits); shifted != after {
+			t.Fatalf("expected %d to move to %d, got %d", before, after, shifted)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	writeTestFile(t, path, "one\ntwo\nthree\n")
+	editor := InitializeEditor(path, 24, 80)
+	editor.ExecuteCommand("3")
+
+	writeTestFile(t, path, "zero\none\ntwo\nthree\n")
+	if err := editor.ExecuteCommand("e"); err != nil {
+		t.Fatal(err)
+	}
+	// the final newline is kept apart, see fileformat.go
+	expectContent(t, &editor, "zero\none\ntwo\nthree")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected the cursor to stay on three, got row %d", editor.Cursor.Row)
+	}
+
+	// unsaved changes are only thrown away with a bang
+	typeKeys(t, &editor, "dd")
+	if err := editor.ExecuteCommand("e"); err == nil {
+		t.Fatal("expected :e to refuse with unsaved changes")
+	}
+	expectContent(t, &editor, "zero\none\ntwo")
+	if err := editor.ExecuteCommand("e!"); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, &editor, "zero\none\ntwo\nthree")
+}
@@ -0,0 +1,485 @@
+package backend
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DiffView is diff mode: the file being edited and another one side by
+// side, lined up, with what differs marked. The editor shows whichever of
+// the two the cursor is in, the other one is kept as a hidden buffer.
+type DiffView struct {
+	// the two files, left then right
+	Paths [2]string
+	// the rows on screen for each side, always the same number of them
+	Panes [2][]DiffLine
+	// the first row on screen and the screen row of the cursor
+	Top       int
+	CursorRow int
+
+	contents [2]*Content
+	rows     []diffRow
+	// where each line of either side is in rows
+	lineRows [2][]int
+	// the versions of the contents rows was worked out from
+	versions [2]int
+}
+
+// DiffLine is a row of one side. Line is -1 for filler, the rows that stand
+// in for lines only the other side has. Kind is "added" for a line only
+// this side has and "changed" for one both have but differently, where the
+// runes from TextStart to TextEnd, content indices, are what changed.
+type DiffLine struct {
+	Line      int
+	Kind      string
+	Cells     []ViewCell
+	TextStart int
+	TextEnd   int
+}
+
+// diffRow is a line of each side that belong next to each other, or -1 on
+// the side that has no line there.
+type diffRow struct {
+	lines [2]int
+	kind  string
+}
+
+// alignLines pairs up the lines of two texts. Lines in a run that changed
+// are paired while both sides have some, the rest are put against filler.
+func alignLines(a [][]rune, b [][]rune) []diffRow {
+	sides := [2][]string{}
+	for i, lines := range [2][][]rune{a, b} {
+		for _, line := range lines {
+			sides[i] = append(sides[i], string(line))
+		}
+	}
+
+	// the lines both start and end with are left out of the diff, so an
+	// edit only costs as much as the part of the file around it
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && sides[0][prefix] == sides[1][prefix] {
+		prefix += 1
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		sides[0][len(a)-1-suffix] == sides[1][len(b)-1-suffix] {
+		suffix += 1
+	}
+
+	rows := []diffRow{}
+	same := func(from int, to int, offset int) {
+		for line := from; line < to; line += 1 {
+			rows = append(rows, diffRow{lines: [2]int{line, line + offset}})
+		}
+	}
+	same(0, prefix, 0)
+
+	nextA, nextB := prefix, prefix
+	for _, hunk := range diff(sides[0][prefix:len(a)-suffix], sides[1][prefix:len(b)-suffix]) {
+		same(nextA, prefix+hunk.aStart, nextB-nextA)
+		countA, countB := hunk.aEnd-hunk.aStart, hunk.bEnd-hunk.bStart
+		for i := range max(countA, countB) {
+			row := diffRow{lines: [2]int{-1, -1}, kind: "added"}
+			if i < countA {
+				row.lines[0] = prefix + hunk.aStart + i
+			}
+			if i < countB {
+				row.lines[1] = prefix + hunk.bStart + i
+			}
+			if i < countA && i < countB {
+				row.kind = "changed"
+			}
+			rows = append(rows, row)
+		}
+		nextA, nextB = prefix+hunk.aEnd, prefix+hunk.bEnd
+	}
+	same(nextA, len(a), nextB-nextA)
+	return rows
+}
+
+// startDiff shows path next to the current file, on the right.
+func (editor *Editor) startDiff(path string) error {
+	if editor.opener != nil {
+		return fmt.Errorf("diff mode is only available when editing locally")
+	}
+	path = filepath.Clean(path)
+	if samePath(path, editor.FilePath) {
+		return fmt.Errorf("%s is the file being edited", path)
+	}
+	if editor.Content.scratch {
+		return fmt.Errorf("%s is not a file", editor.FileName)
+	}
+
+	// opening the file sets it up like any other, then the editor goes back
+	content, current := editor.Content, editor.FilePath
+	editor.Diff = nil
+	if err := editor.OpenFile(path); err != nil {
+		return err
+	}
+	other := editor.Content
+	editor.SwitchContent(content, current)
+
+	editor.Blame = nil
+	editor.Diff = &DiffView{
+		Paths:    [2]string{current, path},
+		contents: [2]*Content{content, other},
+		versions: [2]int{-1, -1},
+	}
+	editor.ScrollToCursor()
+	return nil
+}
+
+// diffSide is which side the editor is showing.
+func (editor *Editor) diffSide() int {
+	if editor.Diff.contents[1] == editor.Content {
+		return 1
+	}
+	return 0
+}
+
+// refreshDiff lines the two sides up again when either has been edited.
+func (editor *Editor) refreshDiff() {
+	view := editor.Diff
+	if view.versions == [2]int{view.contents[0].Version, view.contents[1].Version} {
+		return
+	}
+
+	lines := [2][][]rune{view.contents[0].lines(), view.contents[1].lines()}
+	view.rows = alignLines(lines[0], lines[1])
+	for side := range 2 {
+		view.lineRows[side] = make([]int, len(lines[side]))
+	}
+	for i, row := range view.rows {
+		for side, line := range row.lines {
+			if line != -1 {
+				view.lineRows[side][line] = i
+			}
+		}
+	}
+	view.versions = [2]int{view.contents[0].Version, view.contents[1].Version}
+}
+
+// DiffPaneWidth is the width of each side, gutter included. The sides are
+// split by a one column border.
+func (editor *Editor) DiffPaneWidth() int {
+	return max(2, (editor.ScreenWidth-editor.SidebarWidth()-1)/2)
+}
+
+// scrollDiff is ScrollToCursor for diff mode. Both sides scroll together,
+// so it works in rows rather than lines, and lays out the rows on screen.
+func (editor *Editor) scrollDiff() {
+	view := editor.Diff
+	editor.refreshDiff()
+	side := editor.diffSide()
+	height := max(1, editor.TextHeight())
+
+	lines := editor.Content.lines()
+	cursorRow := view.lineRows[side][min(editor.Cursor.Row, len(lines)-1)]
+	scrolloff := min(editor.OptionInt("scrolloff"), (height-1)/2)
+	view.Top = max(0, min(view.Top, cursorRow-scrolloff))
+	if cursorRow+scrolloff >= view.Top+height {
+		view.Top = min(cursorRow+scrolloff, len(view.rows)-1) - height + 1
+	}
+	view.Top = max(0, view.Top)
+	view.CursorRow = cursorRow - view.Top
+
+	// the first line on screen, for anything that goes by TopLine
+	for row := view.Top; row < len(view.rows); row += 1 {
+		if line := view.rows[row].lines[side]; line != -1 {
+			editor.TopLine = line
+			break
+		}
+	}
+
+	width := editor.TextWidth()
+	col := editor.displayCol(lines[editor.Cursor.Row], editor.Cursor.Col)
+	if col < editor.LeftCol {
+		editor.LeftCol = col
+	}
+	if col >= editor.LeftCol+width {
+		editor.LeftCol = col - width + 1
+	}
+
+	shown := view.rows[view.Top:min(len(view.rows), view.Top+height)]
+	for s := range 2 {
+		view.Panes[s] = editor.diffPane(s, shown, width)
+	}
+}
+
+// diffPane lays out one side's rows. Syntax is highlighted as the side's own
+// buffer would have it.
+func (editor *Editor) diffPane(side int, rows []diffRow, width int) []DiffLine {
+	view := editor.Diff
+	content := view.contents[side]
+	other := view.contents[1-side]
+	lines, otherLines := content.lines(), other.lines()
+
+	// the spans come from the content's own options, so the editor looks at
+	// it for a moment
+	shown := editor.Content
+	editor.Content = content
+	first, last := len(lines), -1
+	for _, row := range rows {
+		if line := row.lines[side]; line != -1 {
+			first, last = min(first, line), max(last, line)
+		}
+	}
+	spans := editor.lineSpans(lines, max(0, first), last)
+	editor.Content = shown
+
+	starts := make([]int, len(lines)+1)
+	for i, line := range lines {
+		starts[i+1] = starts[i] + len(line) + 1
+	}
+
+	pane := []DiffLine{}
+	for _, row := range rows {
+		line := row.lines[side]
+		if line == -1 {
+			cells := []ViewCell{}
+			for range width {
+				cells = append(cells, ViewCell{Rune: '-', Index: -1, Class: "diff.filler"})
+			}
+			pane = append(pane, DiffLine{Line: -1, Kind: "filler", Cells: cells})
+			continue
+		}
+
+		start := starts[line]
+		cells := editor.displayCells(lines[line], start)
+		if line-first < len(spans) {
+			classifyCells(cells, spans[line-first], start)
+		}
+		if content == shown {
+			editor.classifySelection(cells)
+		}
+		if editor.LeftCol < len(cells) {
+			cells = cells[editor.LeftCol:]
+		} else {
+			cells = []ViewCell{}
+		}
+
+		diffLine := DiffLine{Line: line, Kind: row.kind, Cells: cells[:min(width, len(cells))]}
+		if row.kind == "changed" {
+			// the changed text is what is left between what both lines
+			// start and end with
+			text, otherText := lines[line], otherLines[row.lines[1-side]]
+			prefix := 0
+			for prefix < len(text) && prefix < len(otherText) && text[prefix] == otherText[prefix] {
+				prefix += 1
+			}
+			suffix := 0
+			for suffix < len(text)-prefix && suffix < len(otherText)-prefix &&
+				text[len(text)-1-suffix] == otherText[len(otherText)-1-suffix] {
+				suffix += 1
+			}
+			diffLine.TextStart, diffLine.TextEnd = start+prefix, start+len(text)-suffix
+		}
+		pane = append(pane, diffLine)
+	}
+	return pane
+}
+
+// switchDiffSide moves the cursor to the other side, keeping it on the same
+// row.
+func (editor *Editor) switchDiffSide() {
+	view := editor.Diff
+	side := editor.diffSide()
+	row := view.lineRows[side][editor.Cursor.Row]
+
+	other := 1 - side
+	editor.SwitchContent(view.contents[other], view.Paths[other])
+	editor.moveToPosition(editor.Diff.lineAt(other, row), 0)
+}
+
+// lineAt is the line of side at row, or the next line it has after it when
+// the row is filler there.
+func (view *DiffView) lineAt(side int, row int) int {
+	for ; row < len(view.rows); row += 1 {
+		if line := view.rows[row].lines[side]; line != -1 {
+			return line
+		}
+	}
+	return len(view.lineRows[side]) - 1
+}
+
+// jumpDiffHunk moves to the first line of the next or previous run of
+// differences.
+func (editor *Editor) jumpDiffHunk(forward bool) error {
+	view := editor.Diff
+	editor.refreshDiff()
+	side := editor.diffSide()
+	current := view.lineRows[side][editor.Cursor.Row]
+
+	starts := func(row int) bool {
+		return view.rows[row].kind != "" && (row == 0 || view.rows[row-1].kind == "")
+	}
+	step := 1
+	if !forward {
+		step = -1
+	}
+	for row := current + step; row >= 0 && row < len(view.rows); row += step {
+		if starts(row) && view.lineAt(side, row) != editor.Cursor.Row {
+			editor.moveToPosition(view.lineAt(side, row), 0)
+			return nil
+		}
+	}
+	return fmt.Errorf("no more differences")
+}
+
+// diffHunkAt is the run of rows around row that differ, first to last+1.
+// Lines this side lacks leave it on the line after them, so that row counts
+// too.
+func (view *DiffView) diffHunkAt(side int, row int) (int, int, bool) {
+	if view.rows[row].kind == "" {
+		if row == 0 || view.rows[row-1].kind == "" || view.rows[row-1].lines[side] != -1 {
+			return 0, 0, false
+		}
+		row -= 1
+	}
+	first, last := row, row+1
+	for first > 0 && view.rows[first-1].kind != "" {
+		first -= 1
+	}
+	for last < len(view.rows) && view.rows[last].kind != "" {
+		last += 1
+	}
+	return first, last, true
+}
+
+// linesIn is the lines of side that rows first to last cover, as a start
+// and end line. For rows that are all filler it is where they would go.
+func (view *DiffView) linesIn(side int, first int, last int) (int, int) {
+	start := 0
+	for _, row := range view.rows[:first] {
+		if row.lines[side] != -1 {
+			start += 1
+		}
+	}
+	end := start
+	for _, row := range view.rows[first:last] {
+		if row.lines[side] != -1 {
+			end += 1
+		}
+	}
+	return start, end
+}
+
+// diffCopy makes the run of differences under the cursor the same on both
+// sides. Obtaining copies the other side's lines here, putting copies these
+// lines there.
+func (editor *Editor) diffCopy(obtain bool) error {
+	view := editor.Diff
+	editor.refreshDiff()
+	side := editor.diffSide()
+	first, last, ok := view.diffHunkAt(side, view.lineRows[side][editor.Cursor.Row])
+	if !ok {
+		return fmt.Errorf("no difference under the cursor")
+	}
+
+	from, to := 1-side, side
+	if !obtain {
+		from, to = side, 1-side
+	}
+	fromStart, fromEnd := view.linesIn(from, first, last)
+	toStart, toEnd := view.linesIn(to, first, last)
+
+	lines := []string{}
+	for _, line := range view.contents[to].lines() {
+		lines = append(lines, string(line))
+	}
+	replacement := []string{}
+	for _, line := range view.contents[from].lines()[fromStart:fromEnd] {
+		replacement = append(replacement, string(line))
+	}
+	lines = slices.Concat(lines[:toStart], replacement, lines[toEnd:])
+
+	edits := view.contents[to].SetText([]rune(strings.Join(lines, "\n")))
+	if to == side {
+		editor.moveCursorTo(shiftIndex(editor.Cursor.Index, edits))
+	}
+	return nil
+}
+
+// closeDiff leaves diff mode. Both files stay open.
+func (editor *Editor) closeDiff() {
+	editor.Diff = nil
+}
+
+func init() {
+	diffCommand := func(run func(editor *Editor, args string) error) ExCommand {
+		return func(editor *Editor, bang bool, args string) error {
+			if editor.Diff == nil {
+				return fmt.Errorf("not in diff mode")
+			}
+			return run(editor, args)
+		}
+	}
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if args == "" {
+			return fmt.Errorf("usage: diffsplit file")
+		}
+		return editor.startDiff(args)
+	}, "diffsplit", "diffs")
+	registerExCommand(diffCommand(func(editor *Editor, args string) error {
+		editor.closeDiff()
+		return nil
+	}), "diffoff", "diffo")
+	registerExCommand(diffCommand(func(editor *Editor, args string) error {
+		return editor.diffCopy(true)
+	}), "diffget", "diffg")
+	registerExCommand(diffCommand(func(editor *Editor, args string) error {
+		return editor.diffCopy(false)
+	}), "diffput", "diffpu")
+
+	diffAction := func(run func(editor *Editor) error) Action {
+		return func(editor *Editor, key KeyStroke) {
+			if editor.Diff == nil {
+				editor.ReportError(fmt.Errorf("not in diff mode"))
+				return
+			}
+			if err := run(editor); err != nil {
+				editor.ReportError(err)
+			}
+		}
+	}
+	registerAction("diff_next", diffAction(func(editor *Editor) error {
+		return editor.jumpDiffHunk(true)
+	}))
+	registerAction("diff_prev", diffAction(func(editor *Editor) error {
+		return editor.jumpDiffHunk(false)
+	}))
+	registerAction("diff_obtain", diffAction(func(editor *Editor) error {
+		return editor.diffCopy(true)
+	}))
+	registerAction("diff_put", diffAction(func(editor *Editor) error {
+		return editor.diffCopy(false)
+	}))
+	registerAction("diff_switch", diffAction(func(editor *Editor) error {
+		editor.switchDiffSide()
+		return nil
+	}))
+	registerAction("diff_left", diffAction(func(editor *Editor) error {
+		if editor.diffSide() == 1 {
+			editor.switchDiffSide()
+		}
+		return nil
+	}))
+	registerAction("diff_right", diffAction(func(editor *Editor) error {
+		if editor.diffSide() == 0 {
+			editor.switchDiffSide()
+		}
+		return nil
+	}))
+
+	bindDefault(Normal, "]c", "diff_next")
+	bindDefault(Normal, "[c", "diff_prev")
+	bindDefault(Normal, "do", "diff_obtain")
+	bindDefault(Normal, "dp", "diff_put")
+	bindDefault(Normal, "<C-w>w", "diff_switch")
+	bindDefault(Normal, "<C-w><C-w>", "diff_switch")
+	bindDefault(Normal, "<C-w>h", "diff_left")
+	bindDefault(Normal, "<C-w>l", "diff_right")
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAlignLines(t *testing.T) {
+	split := func(text string) [][]rune {
+		lines := [][]rune{}
+		for _, line := range splitLines(text) {
+			lines = append(lines, []rune(line[:len(line)-1]))
+		}
+		return lines
+	}
+
+	rows := alignLines(split("a\nb\nc\nd\ne\n"), split("a\nB\nc\nx\ny\ne\n"))
+	expected := []diffRow{
+		{lines: [2]int{0, 0}},
+		{lines: [2]int{1, 1}, kind: "changed"},
+		{lines: [2]int{2, 2}},
+		{lines: [2]int{3, 3}, kind: "changed"},
+		{lines: [2]int{-1, 4}, kind: "added"},
+		{lines: [2]int{4, 5}},
+	}
+	if !slices.Equal(rows, expected) {
+		t.Fatalf("\nGot: %+v\nExpected: %+v", rows, expected)
+	}
+}
+
+func TestDiffMode(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	dir := t.TempDir()
+	left, right := filepath.Join(dir, "left.txt"), filepath.Join(dir, "right.txt")
+	writeTestFile(t, left, "one\ntwo\nthree\nfour\nfive\n")
+	writeTestFile(t, right, "one\ntwo\nthree\n4\nfive\nsix\n")
+
+	editor := InitializeEditor(left, 24, 80)
+	if err := editor.ExecuteCommand("diffsplit " + right); err != nil {
+		t.Fatal(err)
+	}
+	if editor.Diff == nil || editor.FilePath != left {
+		t.Fatalf("expected diff mode on %s, got %+v", left, editor.Diff)
+	}
+
+	kinds := func(side int) []string {
+		kinds := []string{}
+		for _, line := range editor.Diff.Panes[side] {
+			kinds = append(kinds, line.Kind)
+		}
+		return kinds
+	}
+	if got := kinds(0); !slices.Equal(got, []string{"", "", "", "changed", "", "filler"}) {
+		t.Fatalf("unexpected left side %q", got)
+	}
+	if got := kinds(1); !slices.Equal(got, []string{"", "", "", "changed", "", "added"}) {
+		t.Fatalf("unexpected right side %q", got)
+	}
+	if line := editor.Diff.Panes[1][3]; line.TextStart != 14 || line.TextEnd != 15 {
+		t.Fatalf("expected the whole of 4 to have changed, got %d to %d", line.TextStart, line.TextEnd)
+	}
+
+	typeKeys(t, &editor, "]c")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected ]c to go to line 3, got %+v", editor.Cursor)
+	}
+	typeKeys(t, &editor, "]c")
+	if editor.Cursor.Row != 4 {
+		t.Fatalf("expected ]c to stop above the missing line, got %+v", editor.Cursor)
+	}
+	typeKeys(t, &editor, "[c")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected [c to go back to line 3, got %+v", editor.Cursor)
+	}
+
+	// pulling the change over lines the sides up again
+	typeKeys(t, &editor, "do")
+	expectContent(t, &editor, "one\ntwo\nthree\n4\nfive")
+	if got := kinds(0); !slices.Equal(got, []string{"", "", "", "", "", "filler"}) {
+		t.Fatalf("unexpected left side after do %q", got)
+	}
+
+	// the other side is edited and the diff follows
+	typeKeys(t, &editor, "<C-w>l")
+	if editor.FilePath != right || editor.Cursor.Row != 3 {
+		t.Fatalf("expected to be on the right at line 3, got %s %+v", editor.FilePath, editor.Cursor)
+	}
+	typeKeys(t, &editor, "kkkiX<Esc>")
+	if got := kinds(1); !slices.Equal(got, []string{"changed", "", "", "", "", "added"}) {
+		t.Fatalf("unexpected right side after an edit %q", got)
+	}
+
+	// obtaining the left side's lines, none, takes the added line away
+	typeKeys(t, &editor, "jjjjjdo")
+	expectContent(t, &editor, "Xone\ntwo\nthree\n4\nfive")
+
+	// putting goes the other way
+	typeKeys(t, &editor, "kkkkkdp<C-w>h")
+	expectContent(t, &editor, "Xone\ntwo\nthree\n4\nfive")
+
+	// d on its own still deletes
+	typeKeys(t, &editor, "dd")
+	expectContent(t, &editor, "two\nthree\n4\nfive")
+
+	if err := editor.ExecuteCommand("diffoff"); err != nil || editor.Diff != nil {
+		t.Fatalf("expected diff mode to end, got %v", err)
+	}
+	if err := editor.ExecuteCommand("diffsplit " + left); err == nil {
+		t.Fatal("expected a file not to be diffed against itself")
+	}
+}
@@ -1,16 +1,31 @@
 package backend
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
-	"strconv"
+	"time"
 )
 
 type EditorMode int
 
 const (
-	Normal EditorMode = iota
-	Insert            = iota
+	Normal  EditorMode = iota
+	Insert             = iota
+	Command            = iota
+	// waiting for the motion or text object after d, c or y
+	OperatorPending = iota
+	// typing into the picker's prompt, see picker.go
+	Picking = iota
+	// moving around the file explorer, see explorer.go
+	Exploring = iota
+	// moving around the quickfix window, see quickfix.go
+	Quickfixing = iota
+	// selecting text for an operator, see visual.go
+	Visual = iota
 )
 
 type Editor struct {
@@ -24,37 +39,267 @@ type Editor struct {
 	FileName string
 
 	Mode EditorMode
+
+	// first buffer line and first display column shown in the window
+	TopLine int
+	LeftCol int
+
+	GlobalOptions OptionValues
+	WindowOptions OptionValues
+
+	CommandLine []rune
+	Quit        bool
+
+	// what the last command had to say and how serious it is, and what
+	// earlier ones said, see message.go
+	Message      string
+	MessageLevel MessageLevel
+	messages     []Message
+
+	// set while the command line is answering a question instead of taking
+	// a command, see prompt
+	Prompt       string
+	promptAccept func(answer string) error
+	promptMode   EditorMode
+
+	// hover text or a menu drawn over the text
+	Popup      *Popup
+	keepPopup  bool
+	completion *completionMenu
+
+	// the snippet being filled in and the placeholder ranges to highlight
+	snippet      *snippetSession
+	Placeholders []Placeholder
+
+	Keymap      *Keymap `json:"-"`
+	pendingKeys []KeyStroke
+	lastKeyTime time.Time
+	mouse       mouseState
+
+	// folds in this window, see fold.go
+	Folds        []Fold
+	foldsTracked map[*Content]func()
+	foldMethod   string
+	foldsVersion int
+
+	// the other end of the selection in visual mode, a content index
+	VisualStart int
+
+	// operator waiting for a text object, and the unnamed register
+	operator         string
+	register         []rune
+	registerLinewise bool
+
+	// the picker overlay and the file tree, see picker.go and explorer.go
+	Picker   *Picker
+	Explorer *Explorer
+
+	// the results of :grep or :make, see quickfix.go
+	quickfix       quickfixList
+	QuickfixWindow *QuickfixWindow
+
+	// the command :make or :! is running and the buffer it writes to, see
+	// make.go
+	job    *job
+	output *Content
+
+	// two files side by side, see diffmode.go
+	Diff *DiffView
+	// who changed each line, see blame.go
+	Blame *Blame
+
+	// how many other clients are editing the same file, set by the server
+	Collaborators int
+
+	// how everything is drawn and the colorscheme it started from, see
+	// theme.go
+	Theme       Theme
+	colorscheme string
+
+	// files open in this editor besides the one shown, most recent first,
+	// and their names for the tabline, see buffers.go
+	hidden  []hiddenBuffer
+	Tabs    []string
+	opener  func(path string)
+	renamer func(from string, to string)
+	wake    func()
+	// replies for the editor itself, see async.go
+	queue *asyncQueue
+	// where to put the cursor once a file being opened is shown
+	jump *jumpTarget
+
+	// command lines run from the command line, oldest first
+	history []string
 }
 
-func (editor *Editor) SaveContent() {
-	string := string(editor.GetContent())
-	err := os.WriteFile(editor.FilePath, []byte(string), 0644)
+func (editor *Editor) SaveContent() error {
+	if editor.Content.scratch {
+		return nil
+	}
+	// a formatter that fails is skipped, the file is saved as it is
+	if editor.OptionBool("formatonsave") {
+		if err := editor.format(); err != nil {
+			editor.warn("not formatted: " + err.Error())
+		}
+	}
+	if editor.OptionBool("trimtrailingwhitespace") {
+		editor.trimTrailingWhitespace()
+	}
+
+	err := os.WriteFile(editor.FilePath, editor.encodeFile(editor.GetContent()), 0644)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	editor.Content.SavedVersion = editor.Content.Version
+	// a commit since the file was read moves HEAD
+	editor.Content.git = nil
+
+	if editor.Content.lsp != nil {
+		editor.Content.lsp.saved()
 	}
+	return nil
 }
 
+// InitializeEditor opens the file at path. A file that does not exist yet
+// starts out empty and is made on the first write, and one that cannot be
+// read starts out empty with the error showing.
 func InitializeEditor(path string, screenHeight int, screenWidth int) Editor {
+	content, err := LoadContent(path)
+	if err != nil {
+		content = &Content{Original: []rune{}, Add: []rune{}, lastEdit: -1}
+	}
+
+	editor := InitializeEditorWithContent(content, path, screenHeight, screenWidth)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		editor.inform(fmt.Sprintf("%q [New]", editor.FileName))
+	case err != nil:
+		editor.ReportError(err)
+	}
+	return editor
+}
+
+// LoadContent reads a file into a new content.
+func LoadContent(path string) (*Content, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	// the charset has to be known before the file can be decoded, any error
+	// is reported once the editor applies the rest of the editorconfig
+	charset := ""
+	if properties, err := editorConfigFor(path); err == nil {
+		charset = properties["charset"]
+	}
+
+	content := &Content{}
+	if err := content.loadFromFile(path, charset); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// InitializeEditorWithContent sets up an editor on content that is already
+// loaded, which is how several clients end up sharing one file. Like any
+// other use of the content it has to happen on the loop that owns it.
+func InitializeEditorWithContent(
+	content *Content,
+	path string,
+	screenHeight int,
+	screenWidth int,
+) Editor {
 	fileName := filepath.Base(path)
 
 	cursor := Cursor{Index: 0, Row: 0, Col: 0}
 
-	content := Content{}
-	content.loadFromFile(path)
-
 	editor := Editor{
-		Content:      &content,
+		Content:      content,
 		Cursor:       &cursor,
 		FilePath:     path,
 		FileName:     fileName,
 		Mode:         Normal,
 		ScreenHeight: screenHeight,
 		ScreenWidth:  screenWidth,
+		Keymap:       NewKeymap(),
+		Theme:        maps.Clone(DefaultTheme),
+		colorscheme:  "default",
 	}
 
+	config, err := LoadConfig(DefaultConfigPath())
+	if err == nil {
+		err = editor.applyConfig(config)
+	}
+	if err == nil {
+		err = loadUserGrammars()
+	}
+	// the first editor on the content sets it up, later ones keep what it
+	// and :setlocal made of it
+	if !content.setUp {
+		editor.applyFiletype()
+		if err == nil {
+			err = editor.setupBuffer(config)
+		}
+	}
+	if err != nil {
+		editor.ReportError(err)
+	}
+	recordRecentFile(path)
+
 	return editor
 }
 
+// setupBuffer applies the settings that come from the file itself, once the
+// filetype is known.
+func (editor *Editor) setupBuffer(config *Config) error {
+	editor.Content.setUp = true
+
+	indentSet, err := editor.applyEditorConfig()
+	if err == nil && !indentSet {
+		err = editor.applyDetectedIndent()
+	}
+
+	// modelines are the most specific so they go last
+	if err == nil {
+		err = editor.applyModelines()
+	}
+
+	// the filetype and options are final so the right server can start
+	if err == nil {
+		err = editor.attachLanguageServer(config)
+	}
+	return err
+}
+
+func (editor *Editor) Resize(width int, height int) {
+	editor.ScreenWidth, editor.ScreenHeight = width, height
+	if editor.Picker != nil {
+		editor.refreshPicker()
+	}
+	if editor.QuickfixWindow != nil {
+		editor.QuickfixWindow.Height = max(2, min(editor.QuickfixWindow.Height, height-3))
+		editor.refreshQuickfix()
+	}
+	if editor.Explorer != nil {
+		editor.refreshExplorer()
+	}
+	editor.ScrollToCursor()
+}
+
+// TextHeight is the number of rows available for file content, the bottom two
+// rows hold the status bar and the command line. The quickfix window sits
+// between the text and the status bar, and the tabline above the text.
+func (editor *Editor) TextHeight() int {
+	height := editor.ScreenHeight - 2 - editor.TablineHeight()
+	if editor.QuickfixWindow != nil {
+		height -= editor.QuickfixWindow.Height
+	}
+	return height
+}
+
 func (editor *Editor) ShiftCursor(
 	rowOffset int,
 	colOffset int,
@@ -132,7 +377,19 @@ func (editor *Editor) InsertRune(r rune) {
 	}
 }
 
+// moveCursorTo puts the cursor at a content index.
+func (editor *Editor) moveCursorTo(index int) {
+	index = max(0, min(index, editor.Content.Length))
+
+	editor.Cursor.Index = index
+	editor.Cursor.Row, editor.Cursor.Col = editor.Content.position(index)
+}
+
 func (editor *Editor) Backspace() {
+	if editor.softBackspace() {
+		return
+	}
+
 	delIdx := editor.Cursor.Index - 1
 	if delIdx < 0 {
 		return
@@ -156,39 +413,6 @@ func (editor *Editor) GetContent() []rune {
 	return editor.Content.calculateContent()
 }
 
-func (editor *Editor) GetStatusBar() []rune {
-	row, col := editor.Cursor.Row, editor.Cursor.Col
-
-	leftContent := []rune(" ")
-	switch editor.Mode {
-	case Normal:
-		leftContent = append(leftContent, []rune("NORMAL")...)
-	case Insert:
-		leftContent = append(leftContent, []rune("INSERT")...)
-	}
-	leftContent = append(leftContent, rune(' '), rune('|'), rune(' '))
-	leftContent = append(leftContent, []rune(editor.FileName)...)
-
-	rightContent := []rune(strconv.FormatInt(int64(row), 10))
-	rightContent = append(rightContent, rune(':'))
-	rightContent = append(rightContent, []rune(strconv.FormatInt(int64(col), 10))...)
-	rightContent = append(rightContent, rune(' '))
-
-	spaceBetween := editor.ScreenWidth - len(leftContent) - len(rightContent)
-
-	if spaceBetween < 1 {
-		return []rune{}
-	}
-
-	statusLine := leftContent
-	for range spaceBetween {
-		statusLine = append(statusLine, rune(' '))
-	}
-	statusLine = append(statusLine, rightContent...)
-
-	return statusLine
-}
-
 func (editor *Editor) ToNormal() {
 	editor.ShiftCursor(0, -1, false, false)
 	editor.Mode = Normal
@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+type editorConfigFile struct {
+	dir      string
+	root     bool
+	sections []editorConfigSection
+}
+
+func parseEditorConfig(path string) (*editorConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &editorConfigFile{dir: filepath.Dir(path)}
+	var section *editorConfigSection
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum += 1 {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			pattern, err := editorConfigGlob(line[1:len(line)-1], config.dir)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+			}
+			config.sections = append(config.sections, editorConfigSection{
+				pattern:    pattern,
+				properties: map[string]string{},
+			})
+			section = &config.sections[len(config.sections)-1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNum)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		if section == nil {
+			if key == "root" {
+				config.root = value == "true"
+			}
+			continue
+		}
+		section.properties[key] = value
+	}
+
+	return config, scanner.Err()
+}
+
+// editorConfigGlob translates an editorconfig section glob into a regexp that
+// matches absolute slash separated paths.
+func editorConfigGlob(glob string, dir string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+
+	prefix := regexp.QuoteMeta(filepath.ToSlash(dir))
+	switch {
+	case strings.HasPrefix(glob, "/"):
+		builder.WriteString("^" + prefix)
+	case strings.Contains(glob, "/"):
+		builder.WriteString("^" + prefix + "/")
+	default:
+		builder.WriteString("^" + prefix + "/(?:.*/)?")
+	}
+
+	braces := 0
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i += 1 {
+		r := runes[i]
+		switch {
+		case r == '*' && i+1 < len(runes) && runes[i+1] == '*':
+			builder.WriteString(".*")
+			i += 1
+		case r == '*':
+			builder.WriteString("[^/]*")
+		case r == '?':
+			builder.WriteString("[^/]")
+		case r == '[':
+			end := slices.Index(runes[i:], ']')
+			if end == -1 {
+				builder.WriteString(`\[`)
+				continue
+			}
+			class := string(runes[i+1 : i+end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + class + "]")
+			i += end
+		case r == '{':
+			if rangeRe, length, ok := editorConfigRange(runes[i:]); ok {
+				builder.WriteString(rangeRe)
+				i += length - 1
+				continue
+			}
+			braces += 1
+			builder.WriteString("(?:")
+		case r == '}' && braces > 0:
+			braces -= 1
+			builder.WriteString(")")
+		case r == ',' && braces > 0:
+			builder.WriteString("|")
+		case r == '\\' && i+1 < len(runes):
+			i += 1
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+
+	return regexp.Compile(builder.String())
+}
+
+var editorConfigRangePattern = regexp.MustCompile(`^\{(-?\d+)\.\.(-?\d+)\}`)
+
+// editorConfigRange handles {n1..n2} which matches any integer in the range.
+func editorConfigRange(runes []rune) (string, int, bool) {
+	match := editorConfigRangePattern.FindStringSubmatch(string(runes))
+	if match == nil {
+		return "", 0, false
+	}
+
+	low, _ := strconv.Atoi(match[1])
+	high, _ := strconv.Atoi(match[2])
+	if low > high {
+		low, high = high, low
+	}
+	if high-low > 1000 {
+		return "", 0, false
+	}
+
+	numbers := []string{}
+	for n := low; n <= high; n += 1 {
+		numbers = append(numbers, strconv.Itoa(n))
+	}
+	return "(?:" + strings.Join(numbers, "|") + ")", len([]rune(match[0])), true
+}
+
+// editorConfigFor collects the editorconfig properties that apply to path,
+// closer files taking precedence over ones further up the tree.
+func editorConfigFor(path string) (map[string]string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.ToSlash(absolute)
+
+	files := []*editorConfigFile{}
+	for dir := filepath.Dir(absolute); ; dir = filepath.Dir(dir) {
+		config, err := parseEditorConfig(filepath.Join(dir, ".editorconfig"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if config != nil {
+			files = append(files, config)
+			if config.root {
+				break
+			}
+		}
+
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	properties := map[string]string{}
+	for i := len(files) - 1; i >= 0; i -= 1 {
+		for _, section := range files[i].sections {
+			if !section.pattern.MatchString(target) {
+				continue
+			}
+			for key, value := range section.properties {
+				properties[key] = value
+			}
+		}
+	}
+
+	return properties, nil
+}
+
+// applyEditorConfig sets buffer options from .editorconfig files and reports
+// whether they decided the indentation style.
+func (editor *Editor) applyEditorConfig() (bool, error) {
+	properties, err := editorConfigFor(editor.FilePath)
+	if err != nil {
+		return false, err
+	}
+
+	set := func(name string, value OptionValue) {
+		if err == nil {
+			err = editor.SetOption(name, value, setLocal)
+		}
+	}
+
+	indentSet := false
+
+	switch properties["indent_style"] {
+	case "tab":
+		set("expandtab", OptionValue{Bool: false})
+		indentSet = true
+	case "space":
+		set("expandtab", OptionValue{Bool: true})
+		indentSet = true
+	}
+
+	tabWidth, tabWidthErr := strconv.Atoi(properties["tab_width"])
+	if tabWidthErr == nil {
+		set("tabstop", OptionValue{Number: tabWidth})
+	}
+
+	switch size := properties["indent_size"]; size {
+	case "":
+	case "tab":
+		set("shiftwidth", OptionValue{Number: 0})
+		indentSet = true
+	default:
+		width, convErr := strconv.Atoi(size)
+		if convErr != nil {
+			return indentSet, fmt.Errorf("editorconfig: bad indent_size %s", size)
+		}
+		set("shiftwidth", OptionValue{Number: width})
+		if tabWidthErr != nil && properties["indent_style"] == "tab" {
+			set("tabstop", OptionValue{Number: width})
+		}
+		indentSet = true
+	}
+
+	switch properties["end_of_line"] {
+	case "lf":
+		set("fileformat", OptionValue{String: "unix"})
+	case "crlf":
+		set("fileformat", OptionValue{String: "dos"})
+	case "cr":
+		set("fileformat", OptionValue{String: "mac"})
+	}
+
+	switch properties["charset"] {
+	case "utf-8":
+		set("fileencoding", OptionValue{String: "utf-8"})
+		set("bomb", OptionValue{Bool: false})
+	case "utf-8-bom":
+		set("fileencoding", OptionValue{String: "utf-8"})
+		set("bomb", OptionValue{Bool: true})
+	case "latin1", "utf-16le", "utf-16be":
+		set("fileencoding", OptionValue{String: properties["charset"]})
+	}
+
+	switch properties["trim_trailing_whitespace"] {
+	case "true":
+		set("trimtrailingwhitespace", OptionValue{Bool: true})
+	case "false":
+		set("trimtrailingwhitespace", OptionValue{Bool: false})
+	}
+
+	switch properties["insert_final_newline"] {
+	case "true":
+		set("fixendofline", OptionValue{Bool: true})
+	case "false":
+		set("fixendofline", OptionValue{Bool: false})
+	}
+
+	if err != nil {
+		return indentSet, fmt.Errorf("editorconfig: %w", err)
+	}
+	return indentSet, nil
+}
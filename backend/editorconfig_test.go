@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectIndent(t *testing.T) {
+	split := func(text string) [][]rune {
+		lines := [][]rune{}
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, []rune(line))
+		}
+		return lines
+	}
+
+	expandtab, width, ok := detectIndent(split("a {\n  b {\n    c\n  }\n}"))
+	if !ok || !expandtab || width != 2 {
+		t.Fatalf("expected 2 spaces, got %v %d %v", expandtab, width, ok)
+	}
+
+	expandtab, _, ok = detectIndent(split("a {\n\tb\n\t\tc\n}"))
+	if !ok || expandtab {
+		t.Fatalf("expected tabs, got %v %v", expandtab, ok)
+	}
+
+	_, _, ok = detectIndent(split("no\nindentation\nhere"))
+	if ok {
+		t.Fatal("expected no guess without indentation")
+	}
+}
+
+func TestEditorConfig(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, ".editorconfig"), `
+root = true
+
+[*]
+end_of_line = crlf
+insert_final_newline = true
+trim_trailing_whitespace = true
+
+[*.{go,mod}]
+indent_style = tab
+indent_size = 4
+`)
+	writeTestFile(t, filepath.Join(dir, "sub", ".editorconfig"), `
+[lib/**.go]
+indent_style = space
+indent_size = 2
+`)
+	writeTestFile(t, filepath.Join(dir, "sub", "main.go"), "a  \n    b")
+	writeTestFile(t, filepath.Join(dir, "sub", "lib", "x", "lib.go"), "a\n")
+
+	editor := InitializeEditor(filepath.Join(dir, "sub", "main.go"), 24, 80)
+	if editor.Message != "" {
+		t.Fatal(editor.Message)
+	}
+
+	// the file is indented with spaces, editorconfig still wins
+	if editor.OptionBool("expandtab") || editor.OptionInt("shiftwidth") != 4 ||
+		editor.OptionInt("tabstop") != 4 {
+		t.Fatalf("unexpected options %+v", editor.Content.Options)
+	}
+
+	editor.SaveContent()
+	raw, _ := os.ReadFile(editor.FilePath)
+	if string(raw) != "a\r\n    b\r\n" {
+		t.Fatalf("unexpected saved file %q", string(raw))
+	}
+
+	editor = InitializeEditor(filepath.Join(dir, "sub", "lib", "x", "lib.go"), 24, 80)
+	if !editor.OptionBool("expandtab") || editor.OptionInt("shiftwidth") != 2 {
+		t.Fatalf("unexpected options %+v", editor.Content.Options)
+	}
+}
+
+func TestFileFormatRoundTrip(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	original := "\xef\xbb\xbfone\r\ntwo\r\n"
+	writeTestFile(t, path, original)
+
+	editor := InitializeEditor(path, 24, 80)
+	expectContent(t, &editor, "one\ntwo")
+	if editor.OptionString("fileformat") != "dos" || !editor.OptionBool("bomb") {
+		t.Fatalf("unexpected options %+v", editor.Content.Options)
+	}
+
+	editor.SaveContent()
+	raw, _ := os.ReadFile(path)
+	if string(raw) != original {
+		t.Fatalf("\nSaved: %q\nExpected: %q", string(raw), original)
+	}
+
+	writeTestFile(t, path, "no newline")
+	editor = InitializeEditor(path, 24, 80)
+	editor.ExecuteCommand("set nofixeol")
+	editor.SaveContent()
+	raw, _ = os.ReadFile(path)
+	if string(raw) != "no newline" {
+		t.Fatalf("expected missing final newline to be kept, got %q", string(raw))
+	}
+}
@@ -0,0 +1,543 @@
+package backend
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Explorer is the file tree shown down the left of the screen. Like the
+// picker, only the rows that fit on screen are kept in Lines, from Top.
+type Explorer struct {
+	Root  string
+	Lines []ExplorerLine
+	Top   int
+	// index of the selected entry in the whole tree
+	Selected    int
+	ShowIgnored bool
+
+	fs FileSystem
+	// gitStatus is left nil where there is no git to ask
+	gitStatus func(dir string) (map[string]string, error)
+	statuses  map[string]string
+	open      map[string]bool
+	entries   []ExplorerLine
+}
+
+// ExplorerLine is one file or directory in the tree. Path is slash separated
+// and relative to the explorer's root, Status is its git status letter, see
+// gitStatus.
+type ExplorerLine struct {
+	Path    string
+	Name    string
+	Depth   int
+	Dir     bool
+	Open    bool
+	Ignored bool
+	Status  string
+	// set on the file the editor is showing
+	Current bool
+}
+
+// newExplorer shows the tree of fsys, which holds the directory root.
+func newExplorer(root string, fsys FileSystem) *Explorer {
+	return &Explorer{Root: root, fs: fsys, open: map[string]bool{}, statuses: map[string]string{}}
+}
+
+// SidebarWidth is the number of columns the explorer takes, with the border
+// between it and the text.
+func (editor *Editor) SidebarWidth() int {
+	if editor.Explorer == nil {
+		return 0
+	}
+	return max(2, min(editor.OptionInt("explorerwidth"), editor.ScreenWidth/2))
+}
+
+// openExplorer shows the working directory's tree, or keeps the one already
+// open, and selects the current file in it.
+func (editor *Editor) openExplorer() error {
+	if editor.Explorer == nil {
+		root, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		editor.Explorer = newExplorer(root, dirFileSystem(root))
+		editor.Explorer.gitStatus = gitStatus
+	}
+	editor.Mode = Exploring
+	editor.followExplorer()
+	return nil
+}
+
+func (editor *Editor) closeExplorer() {
+	editor.Explorer = nil
+	if editor.Mode == Exploring {
+		editor.Mode = Normal
+	}
+}
+
+// relPath is path relative to the explorer's root, or "" when it is not
+// under it.
+func (explorer *Explorer) relPath(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(explorer.Root, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// osPath is where the entry at rel is on disk.
+func (explorer *Explorer) osPath(rel string) string {
+	return displayPath(filepath.Join(explorer.Root, filepath.FromSlash(rel)))
+}
+
+// followExplorer opens the directories down to the current file and selects
+// it.
+func (editor *Editor) followExplorer() {
+	explorer := editor.Explorer
+	if explorer == nil {
+		return
+	}
+	explorer.refreshStatus()
+
+	current := explorer.relPath(editor.FilePath)
+	for dir := path.Dir(current); current != "" && dir != "."; dir = path.Dir(dir) {
+		explorer.open[dir] = true
+	}
+	editor.buildExplorer()
+	editor.selectExplorerPath(current)
+}
+
+// refreshStatus asks git again. Directories take the status of what is in
+// them, or M when that is mixed.
+func (explorer *Explorer) refreshStatus() {
+	explorer.statuses = map[string]string{}
+	if explorer.gitStatus == nil {
+		return
+	}
+	statuses, err := explorer.gitStatus(explorer.Root)
+	if err != nil {
+		return
+	}
+	for file, status := range statuses {
+		explorer.statuses[file] = status
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			if current, ok := explorer.statuses[dir]; ok && current != status {
+				status = "M"
+			}
+			explorer.statuses[dir] = status
+		}
+	}
+}
+
+// buildExplorer lists the tree again from the file system, keeping the
+// selection on the same path where it still exists.
+func (editor *Editor) buildExplorer() {
+	explorer := editor.Explorer
+	selected := ""
+	if explorer.Selected < len(explorer.entries) {
+		selected = explorer.entries[explorer.Selected].Path
+	}
+
+	rules := []ignoreRule{}
+	if data, err := explorer.fs.ReadFile(".git/info/exclude"); err == nil {
+		rules = parseIgnoreRules(string(data), "")
+	}
+	current := explorer.relPath(editor.FilePath)
+	explorer.entries = []ExplorerLine{}
+	explorer.walk("", 0, rules, current)
+
+	editor.selectExplorerPath(selected)
+}
+
+func (explorer *Explorer) walk(dir string, depth int, rules []ignoreRule, current string) {
+	name := cmp.Or(dir, ".")
+	entries, err := explorer.fs.ReadDir(name)
+	if err != nil {
+		return
+	}
+	if data, err := explorer.fs.ReadFile(path.Join(name, ".gitignore")); err == nil {
+		rules = append(slices.Clip(rules), parseIgnoreRules(string(data), dir)...)
+	}
+
+	// directories first, like most file managers
+	slices.SortStableFunc(entries, func(a, b os.DirEntry) int {
+		if a.IsDir() != b.IsDir() {
+			if a.IsDir() {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		rel := path.Join(dir, entry.Name())
+		isIgnored := ignored(rules, rel, entry.IsDir())
+		if isIgnored && !explorer.ShowIgnored {
+			continue
+		}
+
+		explorer.entries = append(explorer.entries, ExplorerLine{
+			Path:    rel,
+			Name:    entry.Name(),
+			Depth:   depth,
+			Dir:     entry.IsDir(),
+			Open:    entry.IsDir() && explorer.open[rel],
+			Ignored: isIgnored,
+			Status:  explorer.statuses[rel],
+			Current: rel == current,
+		})
+		if entry.IsDir() && explorer.open[rel] {
+			explorer.walk(rel, depth+1, rules, current)
+		}
+	}
+}
+
+// selectExplorerPath selects the entry at rel, leaving the selection where
+// it was when there is no such entry.
+func (editor *Editor) selectExplorerPath(rel string) {
+	explorer := editor.Explorer
+	if i := slices.IndexFunc(explorer.entries, func(line ExplorerLine) bool {
+		return line.Path == rel
+	}); i != -1 {
+		explorer.Selected = i
+	}
+	editor.refreshExplorer()
+}
+
+// refreshExplorer scrolls the selection into view and fills in the visible
+// lines.
+func (editor *Editor) refreshExplorer() {
+	explorer := editor.Explorer
+	height := max(1, editor.TextHeight())
+
+	explorer.Selected = max(0, min(explorer.Selected, len(explorer.entries)-1))
+	if explorer.Selected < explorer.Top {
+		explorer.Top = explorer.Selected
+	}
+	if explorer.Selected >= explorer.Top+height {
+		explorer.Top = explorer.Selected - height + 1
+	}
+	explorer.Top = max(0, min(explorer.Top, len(explorer.entries)-height))
+
+	explorer.Lines = explorer.entries[explorer.Top:min(len(explorer.entries), explorer.Top+height)]
+}
+
+// cursorPosition is the screen position of the selected entry's name.
+func (explorer *Explorer) cursorPosition() (int, int) {
+	entry, _ := explorer.selectedEntry()
+	return entry.Depth*2 + 2, explorer.Selected - explorer.Top
+}
+
+func (editor *Editor) moveExplorer(offset int) {
+	editor.Explorer.Selected += offset
+	editor.refreshExplorer()
+}
+
+// selectedEntry is the entry the explorer's commands act on.
+func (explorer *Explorer) selectedEntry() (ExplorerLine, bool) {
+	if explorer.Selected >= len(explorer.entries) {
+		return ExplorerLine{}, false
+	}
+	return explorer.entries[explorer.Selected], true
+}
+
+// activateExplorer opens the selected file, or opens or closes the selected
+// directory.
+func (editor *Editor) activateExplorer() error {
+	entry, ok := editor.Explorer.selectedEntry()
+	if !ok {
+		return nil
+	}
+	if entry.Dir {
+		editor.Explorer.open[entry.Path] = !entry.Open
+		editor.buildExplorer()
+		return nil
+	}
+
+	editor.Mode = Normal
+	return editor.OpenFile(editor.Explorer.osPath(entry.Path))
+}
+
+// collapseExplorer closes the selected directory, or goes up to the one
+// holding the selected entry.
+func (editor *Editor) collapseExplorer() {
+	explorer := editor.Explorer
+	entry, ok := explorer.selectedEntry()
+	if !ok {
+		return
+	}
+	if entry.Open {
+		explorer.open[entry.Path] = false
+		editor.buildExplorer()
+		return
+	}
+	if dir := path.Dir(entry.Path); dir != "." {
+		editor.selectExplorerPath(dir)
+	}
+}
+
+// explorerDir is the directory new files go in: the selected directory, or
+// the one holding the selected file. It ends in a slash unless it is the
+// root.
+func (explorer *Explorer) explorerDir() string {
+	entry, ok := explorer.selectedEntry()
+	dir := path.Dir(entry.Path)
+	if entry.Dir {
+		dir = entry.Path
+	}
+	if !ok || dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// cleanExplorerPath checks a path typed into a prompt, which must stay
+// under the root.
+func cleanExplorerPath(name string) (string, error) {
+	clean := path.Clean(strings.TrimSpace(name))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+		return "", fmt.Errorf("not a path in the explorer: %s", name)
+	}
+	return clean, nil
+}
+
+// createInExplorer makes a file, or a directory when name ends in a slash.
+func (editor *Editor) createInExplorer(name string) error {
+	explorer := editor.Explorer
+	isDir := strings.HasSuffix(strings.TrimSpace(name), "/")
+	rel, err := cleanExplorerPath(name)
+	if err != nil {
+		return err
+	}
+	if exists(explorer.fs, rel) {
+		return fmt.Errorf("already exists: %s", rel)
+	}
+
+	if isDir {
+		err = explorer.fs.Mkdir(rel)
+	} else if err = explorer.fs.Mkdir(path.Dir(rel)); err == nil {
+		err = explorer.fs.Create(rel)
+	}
+	if err != nil {
+		return err
+	}
+	editor.revealExplorerPath(rel)
+	return nil
+}
+
+// moveInExplorer renames from to to, asking first when that would replace
+// something. Open files follow the move.
+func (editor *Editor) moveInExplorer(from string, to string) error {
+	explorer := editor.Explorer
+	rel, err := cleanExplorerPath(to)
+	if err != nil || rel == from {
+		return err
+	}
+	if strings.HasPrefix(rel, from+"/") {
+		return fmt.Errorf("can not move %s into itself", from)
+	}
+
+	move := func() error {
+		if err := explorer.fs.Mkdir(path.Dir(rel)); err != nil {
+			return err
+		}
+		if err := explorer.fs.Rename(from, rel); err != nil {
+			return err
+		}
+		editor.RenameBuffers(explorer.osPath(from), explorer.osPath(rel))
+		if editor.renamer != nil {
+			editor.renamer(explorer.osPath(from), explorer.osPath(rel))
+		}
+		if explorer.open[from] {
+			explorer.open[rel] = true
+		}
+		editor.revealExplorerPath(rel)
+		return nil
+	}
+	if exists(explorer.fs, rel) {
+		editor.confirm(fmt.Sprintf("Overwrite %s?", rel), move)
+		return nil
+	}
+	return move()
+}
+
+// deleteInExplorer removes the selected entry once the user agrees to it.
+// Files that are open stay open, so they can still be saved again.
+func (editor *Editor) deleteInExplorer() {
+	entry, ok := editor.Explorer.selectedEntry()
+	if !ok {
+		return
+	}
+	question := fmt.Sprintf("Delete %s?", entry.Path)
+	if entry.Dir {
+		question = fmt.Sprintf("Delete %s and everything in it?", entry.Path)
+	}
+	editor.confirm(question, func() error {
+		if err := editor.Explorer.fs.Remove(entry.Path); err != nil {
+			return err
+		}
+		editor.Explorer.refreshStatus()
+		editor.buildExplorer()
+		editor.inform("Deleted " + entry.Path)
+		return nil
+	})
+}
+
+// revealExplorerPath rebuilds the tree after a change and selects rel.
+func (editor *Editor) revealExplorerPath(rel string) {
+	explorer := editor.Explorer
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		explorer.open[dir] = true
+	}
+	explorer.refreshStatus()
+	editor.buildExplorer()
+	editor.selectExplorerPath(rel)
+}
+
+// explorerAction makes an action that does nothing unless the explorer is
+// open, reporting any error.
+func explorerAction(fn func(editor *Editor) error) Action {
+	return func(editor *Editor, key KeyStroke) {
+		if editor.Explorer == nil {
+			return
+		}
+		if err := fn(editor); err != nil {
+			editor.ReportError(err)
+		}
+	}
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "explorerwidth", Short: "exw", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 30}, validate: positive,
+	})
+
+	registerAction("explorer_toggle", func(editor *Editor, key KeyStroke) {
+		if editor.Explorer != nil && editor.Mode == Exploring {
+			editor.closeExplorer()
+			return
+		}
+		if err := editor.openExplorer(); err != nil {
+			editor.ReportError(err)
+		}
+	})
+	registerAction("explorer_close", func(editor *Editor, key KeyStroke) {
+		editor.closeExplorer()
+	})
+	registerAction("explorer_leave", func(editor *Editor, key KeyStroke) {
+		editor.Mode = Normal
+	})
+	registerAction("explorer_down", explorerAction(func(editor *Editor) error {
+		editor.moveExplorer(1)
+		return nil
+	}))
+	registerAction("explorer_up", explorerAction(func(editor *Editor) error {
+		editor.moveExplorer(-1)
+		return nil
+	}))
+	registerAction("explorer_first", explorerAction(func(editor *Editor) error {
+		editor.moveExplorer(-len(editor.Explorer.entries))
+		return nil
+	}))
+	registerAction("explorer_last", explorerAction(func(editor *Editor) error {
+		editor.moveExplorer(len(editor.Explorer.entries))
+		return nil
+	}))
+	registerAction("explorer_open", explorerAction((*Editor).activateExplorer))
+	registerAction("explorer_expand", explorerAction(func(editor *Editor) error {
+		if entry, ok := editor.Explorer.selectedEntry(); ok && entry.Dir && entry.Open {
+			return nil
+		}
+		return editor.activateExplorer()
+	}))
+	registerAction("explorer_collapse", explorerAction(func(editor *Editor) error {
+		editor.collapseExplorer()
+		return nil
+	}))
+	registerAction("explorer_refresh", explorerAction(func(editor *Editor) error {
+		editor.Explorer.refreshStatus()
+		editor.buildExplorer()
+		return nil
+	}))
+	registerAction("explorer_toggle_ignored", explorerAction(func(editor *Editor) error {
+		editor.Explorer.ShowIgnored = !editor.Explorer.ShowIgnored
+		editor.buildExplorer()
+		return nil
+	}))
+	registerAction("explorer_create", explorerAction(func(editor *Editor) error {
+		editor.prompt("New file (end with / for a directory): ", editor.Explorer.explorerDir(),
+			editor.createInExplorer)
+		return nil
+	}))
+	registerAction("explorer_rename", explorerAction(func(editor *Editor) error {
+		entry, ok := editor.Explorer.selectedEntry()
+		if !ok {
+			return nil
+		}
+		dir := strings.TrimSuffix(entry.Path, entry.Name)
+		editor.prompt("Rename to: ", entry.Name, func(name string) error {
+			if strings.Contains(name, "/") {
+				return fmt.Errorf("a name can not contain /, use move")
+			}
+			return editor.moveInExplorer(entry.Path, dir+name)
+		})
+		return nil
+	}))
+	registerAction("explorer_move", explorerAction(func(editor *Editor) error {
+		entry, ok := editor.Explorer.selectedEntry()
+		if !ok {
+			return nil
+		}
+		editor.prompt("Move to: ", entry.Path, func(to string) error {
+			// moving onto a directory puts the entry inside it
+			if strings.HasSuffix(to, "/") {
+				to += entry.Name
+			}
+			return editor.moveInExplorer(entry.Path, to)
+		})
+		return nil
+	}))
+	registerAction("explorer_delete", explorerAction(func(editor *Editor) error {
+		editor.deleteInExplorer()
+		return nil
+	}))
+
+	bindDefault(Normal, "<Space>e", "explorer_toggle")
+	bindDefault(Exploring, "<Space>e", "explorer_toggle")
+	bindDefault(Exploring, "q", "explorer_close")
+	bindDefault(Exploring, "<Esc>", "explorer_leave")
+	bindDefault(Exploring, "j", "explorer_down")
+	bindDefault(Exploring, "<Down>", "explorer_down")
+	bindDefault(Exploring, "k", "explorer_up")
+	bindDefault(Exploring, "<Up>", "explorer_up")
+	bindDefault(Exploring, "gg", "explorer_first")
+	bindDefault(Exploring, "G", "explorer_last")
+	bindDefault(Exploring, "<CR>", "explorer_open")
+	bindDefault(Exploring, "o", "explorer_open")
+	bindDefault(Exploring, "l", "explorer_expand")
+	bindDefault(Exploring, "h", "explorer_collapse")
+	bindDefault(Exploring, "R", "explorer_refresh")
+	bindDefault(Exploring, "I", "explorer_toggle_ignored")
+	bindDefault(Exploring, "a", "explorer_create")
+	bindDefault(Exploring, "r", "explorer_rename")
+	bindDefault(Exploring, "m", "explorer_move")
+	bindDefault(Exploring, "d", "explorer_delete")
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.openExplorer()
+	}, "Explore", "Ex")
+}
@@ -0,0 +1,258 @@
+package backend
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// memFileSystem is a FileSystem in memory for testing the explorer.
+type memFileSystem struct {
+	fstest.MapFS
+}
+
+func (fsys memFileSystem) Create(name string) error {
+	if _, ok := fsys.MapFS[name]; ok {
+		return fs.ErrExist
+	}
+	fsys.MapFS[name] = &fstest.MapFile{}
+	return nil
+}
+
+func (fsys memFileSystem) Mkdir(name string) error {
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if _, ok := fsys.MapFS[dir]; !ok {
+			fsys.MapFS[dir] = &fstest.MapFile{Mode: fs.ModeDir}
+		}
+	}
+	return nil
+}
+
+func (fsys memFileSystem) Rename(from string, to string) error {
+	for name, file := range fsys.MapFS {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			delete(fsys.MapFS, name)
+			fsys.MapFS[to+name[len(from):]] = file
+		}
+	}
+	return nil
+}
+
+func (fsys memFileSystem) Remove(name string) error {
+	for other := range fsys.MapFS {
+		if other == name || strings.HasPrefix(other, name+"/") {
+			delete(fsys.MapFS, other)
+		}
+	}
+	return nil
+}
+
+func newExplorerTestEditor(t *testing.T) (*Editor, memFileSystem) {
+	t.Helper()
+
+	fsys := memFileSystem{fstest.MapFS{
+		".gitignore":        {Data: []byte("*.log\nbuild/\n")},
+		"main.go":           {},
+		"debug.log":         {},
+		"build/out":         {},
+		"src/app/app.go":    {},
+		"src/app/app.md":    {},
+		"src/lib/lib.go":    {},
+		".git/info/exclude": {Data: []byte("secret\n")},
+		"secret":            {},
+	}}
+
+	editor := newTestEditor("")
+	root := filepath.Join(t.TempDir(), "project")
+	editor.FilePath = filepath.Join(root, "src", "app", "app.go")
+	editor.Explorer = newExplorer(root, fsys)
+	editor.Explorer.gitStatus = func(dir string) (map[string]string, error) {
+		return map[string]string{"src/app/app.go": "M", "src/app/app.md": "?"}, nil
+	}
+	editor.Mode = Exploring
+	editor.followExplorer()
+	return editor, fsys
+}
+
+// explorerTree is the whole tree, a line per entry indented by depth, with
+// the selected entry marked.
+func explorerTree(editor *Editor) string {
+	lines := []string{}
+	for i, entry := range editor.Explorer.entries {
+		line := strings.Repeat("  ", entry.Depth) + entry.Name
+		if entry.Dir {
+			line += "/"
+		}
+		if entry.Status != "" {
+			line += " " + entry.Status
+		}
+		if i == editor.Explorer.Selected {
+			line += " <"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func expectTree(t *testing.T, editor *Editor, expected string) {
+	t.Helper()
+	if tree := explorerTree(editor); tree != expected {
+		t.Fatalf("\nTree:\n%s\nExpected:\n%s", tree, expected)
+	}
+}
+
+func TestExplorerTree(t *testing.T) {
+	editor, _ := newExplorerTestEditor(t)
+
+	// the current file is revealed, directories come first and ignored
+	// files are hidden
+	expectTree(t, editor, strings.Join([]string{
+		"src/ M",
+		"  app/ M",
+		"    app.go M <",
+		"    app.md ?",
+		"  lib/",
+		".gitignore",
+		"main.go",
+	}, "\n"))
+	if !editor.Explorer.entries[2].Current {
+		t.Fatal("expected the current file to be marked")
+	}
+
+	typeKeys(t, editor, "kh")
+	expectTree(t, editor, "src/ M\n  app/ M <\n  lib/\n.gitignore\nmain.go")
+	typeKeys(t, editor, "hh")
+	expectTree(t, editor, "src/ M <\n.gitignore\nmain.go")
+
+	typeKeys(t, editor, "I")
+	expectTree(t, editor, "build/\nsrc/ M <\n.gitignore\ndebug.log\nmain.go\nsecret")
+	if editor.Explorer.entries[0].Ignored != true {
+		t.Fatal("expected build/ to be marked as ignored")
+	}
+
+	typeKeys(t, editor, "q")
+	if editor.Explorer != nil || editor.Mode != Normal || editor.SidebarWidth() != 0 {
+		t.Fatal("expected q to close the explorer")
+	}
+}
+
+func TestExplorerFileOperations(t *testing.T) {
+	editor, fsys := newExplorerTestEditor(t)
+
+	// new files go in the selected file's directory
+	typeKeys(t, editor, "anew.go<CR>")
+	if _, ok := fsys.MapFS["src/app/new.go"]; !ok || editor.Mode != Exploring {
+		t.Fatalf("expected src/app/new.go to be created, got %v", editor.Message)
+	}
+	typeKeys(t, editor, "a<Esc>")
+	if editor.Mode != Exploring || editor.Prompt != "" {
+		t.Fatal("expected escape to cancel the prompt")
+	}
+
+	typeKeys(t, editor, "agen/<CR>")
+	if file, ok := fsys.MapFS["src/app/gen"]; !ok || !file.Mode.IsDir() {
+		t.Fatal("expected a trailing slash to create a directory")
+	}
+
+	// renaming the open file keeps the buffer pointed at it
+	editor.selectExplorerPath("src/app/app.go")
+	typeKeys(t, editor, "r")
+	editor.CommandLine = []rune("main.go")
+	typeKeys(t, editor, "<CR>")
+	if _, ok := fsys.MapFS["src/app/main.go"]; !ok {
+		t.Fatal("expected app.go to be renamed")
+	}
+	if filepath.Base(editor.FilePath) != "main.go" || editor.FileName != "main.go" {
+		t.Fatalf("expected the buffer to follow the rename, got %s", editor.FilePath)
+	}
+
+	// moving onto something that exists asks first
+	typeKeys(t, editor, "m")
+	editor.CommandLine = []rune("src/app/app.md")
+	typeKeys(t, editor, "<CR>")
+	if editor.Prompt == "" {
+		t.Fatal("expected to be asked before overwriting")
+	}
+	typeKeys(t, editor, "n<CR>")
+	if _, ok := fsys.MapFS["src/app/main.go"]; !ok {
+		t.Fatal("expected no to leave the file alone")
+	}
+
+	// a trailing slash moves into a directory
+	typeKeys(t, editor, "m")
+	editor.CommandLine = []rune("src/lib/")
+	typeKeys(t, editor, "<CR>")
+	if _, ok := fsys.MapFS["src/lib/main.go"]; !ok {
+		t.Fatalf("expected main.go to move into src/lib, got %v", editor.Message)
+	}
+
+	editor.selectExplorerPath("src/app")
+	typeKeys(t, editor, "dy<CR>")
+	for name := range fsys.MapFS {
+		if strings.HasPrefix(name, "src/app") {
+			t.Fatalf("expected src/app to be deleted, found %s", name)
+		}
+	}
+	// the selection stays where the directory was
+	expectTree(t, editor, "src/ M\n  lib/ <\n    lib.go\n    main.go\n.gitignore\nmain.go")
+}
+
+func TestExplorerOpensFiles(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.txt"), "a\n")
+	writeTestFile(t, filepath.Join(root, "dir", "b.txt"), "b\n")
+
+	editor := InitializeEditor(filepath.Join(root, "a.txt"), 24, 80)
+	editor.Explorer = newExplorer(root, dirFileSystem(root))
+	editor.Mode = Exploring
+	editor.followExplorer()
+
+	typeKeys(t, &editor, "gg<CR>j<CR>")
+	if editor.Mode != Normal || filepath.Base(editor.FilePath) != "b.txt" {
+		t.Fatalf("expected dir/b.txt to open, got %s: %s", editor.FilePath, editor.Message)
+	}
+	expectContent(t, &editor, "b")
+
+	// the explorer follows files opened some other way
+	if err := editor.OpenFile(filepath.Join(root, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	selected, _ := editor.Explorer.selectedEntry()
+	if selected.Path != "a.txt" || !slices.ContainsFunc(editor.Explorer.Lines, func(line ExplorerLine) bool {
+		return line.Current && line.Path == "a.txt"
+	}) {
+		t.Fatalf("expected a.txt selected, got %+v", selected)
+	}
+
+	// below the tabline, which shows now that two files are open
+	col, row := editor.CursorScreenPosition()
+	if width := editor.SidebarWidth(); col < width || width != 30 || row != 1 {
+		t.Fatalf("expected the text cursor right of the explorer, got %d,%d", col, row)
+	}
+}
+
+func TestParseGitStatus(t *testing.T) {
+	out := " M sub/changed.go\x00A  sub/added.go\x00R  sub/new.go\x00sub/old.go\x00?? sub/dir/untracked\x00UU sub/both.go\x00 M other/file.go\x00"
+	statuses := parseGitStatus(out, "sub/")
+	expected := map[string]string{
+		"changed.go":    "M",
+		"added.go":      "A",
+		"new.go":        "R",
+		"dir/untracked": "?",
+		"both.go":       "U",
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("\nGot: %v\nExpected: %v", statuses, expected)
+	}
+	for file, status := range expected {
+		if statuses[file] != status {
+			t.Fatalf("\nGot: %v\nExpected: %v", statuses, expected)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "fileformat", Short: "ff", Kind: StringOption, Scope: BufferScope,
+		Default: OptionValue{String: "unix"}, validate: oneOf("unix", "dos", "mac"),
+	})
+	registerOption(OptionDef{
+		Name: "fileencoding", Short: "fenc", Kind: StringOption, Scope: BufferScope,
+		Default:  OptionValue{String: "utf-8"},
+		validate: oneOf("utf-8", "latin1", "utf-16le", "utf-16be"),
+	})
+	registerOption(OptionDef{
+		Name: "bomb", Kind: BoolOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "endofline", Short: "eol", Kind: BoolOption, Scope: BufferScope,
+		Default: OptionValue{Bool: true},
+	})
+	registerOption(OptionDef{
+		Name: "fixendofline", Short: "fixeol", Kind: BoolOption, Scope: BufferScope,
+		Default: OptionValue{Bool: true},
+	})
+	registerOption(OptionDef{
+		Name: "trimtrailingwhitespace", Kind: BoolOption, Scope: BufferScope,
+	})
+}
+
+func oneOf(values ...string) func(value OptionValue) error {
+	return func(value OptionValue) error {
+		for _, allowed := range values {
+			if value.String == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+// decodeFile turns the raw bytes of a file into runes with "\n" line endings.
+// The options returned describe how to write the text back out. charset is
+// the encoding to assume when the file has no byte order mark.
+func decodeFile(raw []byte, charset string) ([]rune, OptionValues) {
+	options := OptionValues{}
+	text := []rune{}
+
+	switch {
+	case bytes.HasPrefix(raw, utf8BOM):
+		options["bomb"] = OptionValue{Bool: true}
+		raw = raw[len(utf8BOM):]
+		charset = "utf-8"
+	case bytes.HasPrefix(raw, []byte{0xff, 0xfe}):
+		options["bomb"] = OptionValue{Bool: true}
+		raw = raw[2:]
+		charset = "utf-16le"
+	case bytes.HasPrefix(raw, []byte{0xfe, 0xff}):
+		options["bomb"] = OptionValue{Bool: true}
+		raw = raw[2:]
+		charset = "utf-16be"
+	}
+
+	switch charset {
+	case "latin1":
+		for _, b := range raw {
+			text = append(text, rune(b))
+		}
+	case "utf-16le", "utf-16be":
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			if charset == "utf-16le" {
+				units[i] = uint16(raw[2*i]) | uint16(raw[2*i+1])<<8
+			} else {
+				units[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+			}
+		}
+		text = utf16.Decode(units)
+	default:
+		charset = "utf-8"
+		text = []rune(string(raw))
+	}
+	options["fileencoding"] = OptionValue{String: charset}
+
+	crlf, lf, cr := 0, 0, 0
+	for i, r := range text {
+		switch {
+		case r == '\r' && i+1 < len(text) && text[i+1] == '\n':
+			crlf += 1
+		case r == '\r':
+			cr += 1
+		case r == '\n' && (i == 0 || text[i-1] != '\r'):
+			lf += 1
+		}
+	}
+
+	switch {
+	case crlf > 0 && crlf >= lf:
+		options["fileformat"] = OptionValue{String: "dos"}
+		text = []rune(strings.ReplaceAll(string(text), "\r\n", "\n"))
+	case cr > 0 && lf == 0 && crlf == 0:
+		options["fileformat"] = OptionValue{String: "mac"}
+		text = []rune(strings.ReplaceAll(string(text), "\r", "\n"))
+	default:
+		options["fileformat"] = OptionValue{String: "unix"}
+	}
+
+	// the final newline ends the last line rather than starting a new one
+	endOfLine := len(text) > 0 && text[len(text)-1] == '\n'
+	if endOfLine {
+		text = text[:len(text)-1]
+	}
+	options["endofline"] = OptionValue{Bool: endOfLine || len(text) == 0}
+
+	return text, options
+}
+
+// encodeFile is the inverse of decodeFile, writing text out the way the
+// buffer's fileformat, fileencoding, bomb and endofline options ask.
+func (editor *Editor) encodeFile(text []rune) []byte {
+	eol := "\n"
+	switch editor.OptionString("fileformat") {
+	case "dos":
+		eol = "\r\n"
+	case "mac":
+		eol = "\r"
+	}
+
+	body := strings.ReplaceAll(string(text), "\n", eol)
+	if len(text) > 0 &&
+		(editor.OptionBool("endofline") || editor.OptionBool("fixendofline")) {
+		body += eol
+	}
+
+	out := []byte{}
+	bom := editor.OptionBool("bomb")
+
+	switch editor.OptionString("fileencoding") {
+	case "latin1":
+		for _, r := range body {
+			if r > 0xff {
+				r = '?'
+			}
+			out = append(out, byte(r))
+		}
+	case "utf-16le", "utf-16be":
+		little := editor.OptionString("fileencoding") == "utf-16le"
+		units := utf16.Encode([]rune(body))
+		if bom {
+			units = append([]uint16{0xfeff}, units...)
+		}
+		for _, unit := range units {
+			if little {
+				out = append(out, byte(unit), byte(unit>>8))
+			} else {
+				out = append(out, byte(unit>>8), byte(unit))
+			}
+		}
+	default:
+		if bom {
+			out = append(out, utf8BOM...)
+		}
+		out = append(out, body...)
+	}
+
+	return out
+}
+
+// trimTrailingWhitespace removes blanks at the end of every line, keeping the
+// cursor on the same line.
+func (editor *Editor) trimTrailingWhitespace() {
+	lines := editor.Content.lines()
+	row, col := editor.Cursor.Row, editor.Cursor.Col
+
+	start := editor.Content.Length
+	for i := len(lines) - 1; i >= 0; i -= 1 {
+		start -= len(lines[i])
+		line := lines[i]
+
+		trimmed := len(strings.TrimRight(string(line), " \t"))
+		trimmed = utf8.RuneCountInString(string(line)[:trimmed])
+		if trimmed < len(line) {
+			editor.Content.replace([]rune{}, start+trimmed, start+len(line))
+			if i == row {
+				col = min(col, trimmed)
+			}
+		}
+
+		start -= 1
+	}
+
+	editor.moveCursorTo(editor.Content.lineStart(row) + col)
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DetectFiletype guesses a filetype from a file's name, falling back to the
+// interpreter named on a #! first line.
+func DetectFiletype(path string, firstLine string) string {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+
+	grammarsMu.RLock()
+	defer grammarsMu.RUnlock()
+
+	for name, grammar := range grammars {
+		if slices.Contains(grammar.Filenames, base) ||
+			(ext != "" && slices.Contains(grammar.Extensions, ext)) {
+			return name
+		}
+	}
+
+	if interpreter := shebangInterpreter(firstLine); interpreter != "" {
+		for name, grammar := range grammars {
+			if slices.Contains(grammar.Shebangs, interpreter) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// shebangInterpreter pulls the program name out of lines like "#!/bin/sh" and
+// "#!/usr/bin/env bash".
+func shebangInterpreter(line string) string {
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return ""
+	}
+
+	program := filepath.Base(fields[0])
+	if program == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return filepath.Base(field)
+			}
+		}
+		return ""
+	}
+	return program
+}
+
+// applyFiletype sets the filetype option unless something already chose one.
+func (editor *Editor) applyFiletype() {
+	if editor.OptionString("filetype") != "" {
+		return
+	}
+
+	lines := editor.Content.lines()
+	filetype := DetectFiletype(editor.FilePath, string(lines[0]))
+	if filetype != "" {
+		editor.SetOption("filetype", OptionValue{String: filetype}, setLocal)
+	}
+}
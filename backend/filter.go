@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// filterRun is a filter on its way through its command, and what the
+// command came back with.
+type filterRun struct {
+	command     string
+	content     *Content
+	version     int
+	first, last int
+	start, end  int
+
+	stdout string
+	stderr string
+	err    error
+}
+
+// filter pipes lines first to last through command and puts its output in
+// their place, as one edit. The command runs on its own goroutine for at
+// most filtertimeout milliseconds, so the editor, and anyone sharing the
+// file, carries on meanwhile.
+func (editor *Editor) filter(first int, last int, command string) error {
+	if command == "" {
+		return fmt.Errorf("usage: {range}!command")
+	}
+
+	content := editor.Content
+	lines := content.lines()
+	run := &filterRun{
+		command: command,
+		content: content,
+		version: content.Version,
+		first:   first,
+		last:    last,
+		start:   content.lineStart(first),
+		end:     content.lineStart(last) + len(lines[last]),
+	}
+	input := string(content.calculateContent()[run.start:run.end]) + "\n"
+	timeout := time.Duration(editor.OptionInt("filtertimeout")) * time.Millisecond
+
+	queue := editor.async()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdin = strings.NewReader(input)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		// anything the shell started that still holds the pipes is not
+		// waited on
+		cmd.WaitDelay = 100 * time.Millisecond
+		run.err = cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			run.err = fmt.Errorf("timed out after %s", timeout)
+		}
+		run.stdout, run.stderr = stdout.String(), stderr.String()
+
+		queue.post(func() {
+			if err := editor.finishFilter(run); err != nil {
+				editor.ReportError(err)
+			}
+		})
+	}()
+
+	editor.inform("running: " + command)
+	return nil
+}
+
+// finishFilter puts what the command wrote in place of the lines it was
+// given. The text is left alone when the command failed, or when the lines
+// may have moved since.
+func (editor *Editor) finishFilter(run *filterRun) error {
+	complaint := strings.TrimSpace(run.stderr)
+	if run.err != nil {
+		if complaint != "" {
+			return fmt.Errorf("%s: %s: %s", run.command, run.err, complaint)
+		}
+		return fmt.Errorf("%s: %s", run.command, run.err)
+	}
+	if editor.Content != run.content || run.content.Version != run.version {
+		editor.warn(fmt.Sprintf("%s: the buffer changed, filter not applied", run.command))
+		return nil
+	}
+
+	content := editor.Content
+	start, end := run.start, run.end
+	output := []rune(strings.TrimSuffix(run.stdout, "\n"))
+	if run.stdout == "" {
+		// no output takes the lines away entirely, with a newline next to
+		// them
+		if end < content.Length {
+			end += 1
+		} else if start > 0 {
+			start -= 1
+		}
+	}
+	content.replace(output, start, end)
+
+	row := min(run.first, len(content.lines())-1)
+	rowStart := content.lineStart(row)
+	editor.moveCursorTo(rowStart + len(leadingWhitespace(content.lines()[row])))
+
+	if complaint != "" {
+		editor.warn(complaint)
+	} else {
+		editor.inform(fmt.Sprintf("%d lines filtered", run.last-run.first+1))
+	}
+	return nil
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "filtertimeout", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 5000}, validate: positive,
+	})
+
+	registerRangeCommand(func(editor *Editor, first int, last int, bang bool, args string) error {
+		return editor.filter(first, last, args)
+	}, "!")
+
+	registerAction("operator_filter", func(editor *Editor, key KeyStroke) {
+		editor.startOperator("!")
+	})
+	bindDefault(Normal, "!", "operator_filter")
+	bindDefault(OperatorPending, "!", "operator_line")
+}
@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+// waitForFilter waits for the filter started last to report back.
+func waitForFilter(t *testing.T, editor *Editor) {
+	t.Helper()
+	waitFor(t, editor, "filter", func() bool { return !strings.HasPrefix(editor.Message, "running: ") })
+}
+
+func TestFilter(t *testing.T) {
+	editor := newTestEditor("c\nb\na\n  z")
+
+	if err := editor.ExecuteCommand("1,3!sort"); err != nil {
+		t.Fatal(err)
+	}
+	waitForFilter(t, editor)
+	expectContent(t, editor, "a\nb\nc\n  z")
+	if editor.Message != "3 lines filtered" {
+		t.Fatalf("unexpected message %q", editor.Message)
+	}
+
+	// !{motion} puts the range on the command line
+	editor.moveCursorTo(0)
+	typeKeys(t, editor, "!j")
+	if editor.Mode != Command || string(editor.CommandLine) != ".,.+1!" {
+		t.Fatalf("expected a filter command line, got %q", string(editor.CommandLine))
+	}
+	typeKeys(t, editor, "tr a-z A-Z<CR>")
+	waitForFilter(t, editor)
+	expectContent(t, editor, "A\nB\nc\n  z")
+
+	editor.ExecuteCommand("$")
+	typeKeys(t, editor, "!!sed s/z/y/<CR>")
+	waitForFilter(t, editor)
+	expectContent(t, editor, "A\nB\nc\n  y")
+	if editor.Cursor.Row != 3 || editor.Cursor.Col != 2 {
+		t.Fatalf("expected the cursor on the first non-blank, got %+v", editor.Cursor)
+	}
+
+	// failures leave the text alone and say why, whatever was written
+	editor.ExecuteCommand("%!echo broken >&2; exit 3")
+	waitForFilter(t, editor)
+	if editor.MessageLevel != MessageError || !strings.HasSuffix(editor.Message, "exit status 3: broken") {
+		t.Fatalf("expected the exit status and stderr, got %q", editor.Message)
+	}
+	expectContent(t, editor, "A\nB\nc\n  y")
+	editor.ExecuteCommand("1!echo partial; exit 1")
+	waitForFilter(t, editor)
+	expectContent(t, editor, "A\nB\nc\n  y")
+
+	// the editor carries on while the command runs, and the output is
+	// dropped once the text has changed under it
+	editor.ExecuteCommand("1!sleep 0.2; echo late")
+	editor.Content.replace([]rune("x"), 0, 0)
+	waitForFilter(t, editor)
+	if editor.MessageLevel != MessageWarning || !strings.Contains(editor.Message, "filter not applied") {
+		t.Fatalf("expected the late output to be dropped, got %q", editor.Message)
+	}
+	expectContent(t, editor, "xA\nB\nc\n  y")
+
+	editor.ExecuteCommand("set filtertimeout=50")
+	editor.ExecuteCommand("%!sleep 5")
+	waitForFilter(t, editor)
+	if !strings.Contains(editor.Message, "timed out") {
+		t.Fatalf("expected a timeout, got %q", editor.Message)
+	}
+
+	// stderr from a command that succeeds is a warning
+	editor.ExecuteCommand("set filtertimeout=5000")
+	editor.ExecuteCommand("1!echo kept; echo warning >&2")
+	waitForFilter(t, editor)
+	expectContent(t, editor, "kept\nB\nc\n  y")
+	if editor.MessageLevel != MessageWarning || editor.Message != "warning" {
+		t.Fatalf("expected stderr in the status line, got %q", editor.Message)
+	}
+
+	// no output removes the lines
+	editor.ExecuteCommand("2,3!true")
+	waitForFilter(t, editor)
+	expectContent(t, editor, "kept\n  y")
+}
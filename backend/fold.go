@@ -0,0 +1,444 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Fold hides the rows after StartRow through EndRow when closed. Folds nest,
+// Editor.Folds is sorted by StartRow with outer folds first.
+type Fold struct {
+	StartRow int
+	EndRow   int
+	Closed   bool
+}
+
+func sortFolds(folds []Fold) {
+	slices.SortFunc(folds, func(a Fold, b Fold) int {
+		if a.StartRow != b.StartRow {
+			return a.StartRow - b.StartRow
+		}
+		return b.EndRow - a.EndRow
+	})
+}
+
+// closedFold returns the outermost closed fold containing row, which is the
+// one drawn in its place.
+func (editor *Editor) closedFold(row int) (Fold, bool) {
+	for _, fold := range editor.Folds {
+		if fold.Closed && fold.StartRow <= row && row <= fold.EndRow {
+			return fold, true
+		}
+	}
+	return Fold{}, false
+}
+
+// nextLine is the first row of the screen line after the one row is on.
+func (editor *Editor) nextLine(row int) int {
+	if fold, ok := editor.closedFold(row); ok {
+		return fold.EndRow + 1
+	}
+	return row + 1
+}
+
+// prevLine is the first row of the screen line before the one row is on.
+func (editor *Editor) prevLine(row int) int {
+	if fold, ok := editor.closedFold(row); ok {
+		row = fold.StartRow
+	}
+	row -= 1
+	if fold, ok := editor.closedFold(row); ok {
+		return fold.StartRow
+	}
+	return row
+}
+
+// lineRange is the rows shown as one screen line with row.
+func (editor *Editor) lineRange(row int) (int, int) {
+	if fold, ok := editor.closedFold(row); ok {
+		return fold.StartRow, fold.EndRow
+	}
+	return row, row
+}
+
+// trackFolds keeps the folds in place as the content changes, until the
+// editor leaves it. The editor has to stay at the same address once this is
+// called.
+func (editor *Editor) trackFolds() {
+	content := editor.Content
+	if _, ok := editor.foldsTracked[content]; ok {
+		return
+	}
+	if editor.foldsTracked == nil {
+		editor.foldsTracked = map[*Content]func(){}
+	}
+	editor.foldsTracked[content] = content.OnEdit(editor.shiftFolds)
+}
+
+// untrackFolds stops shifting the folds with edits to content.
+func (editor *Editor) untrackFolds(content *Content) {
+	if stop, ok := editor.foldsTracked[content]; ok {
+		stop()
+		delete(editor.foldsTracked, content)
+	}
+}
+
+func (editor *Editor) shiftFolds(edit Edit) {
+	added := strings.Count(string(edit.Inserted), "\n")
+	delta := added - (edit.EndRow - edit.StartRow)
+
+	shift := func(row int) int {
+		switch {
+		case row == edit.StartRow && row == edit.EndRow && edit.StartCol == 0:
+			// lines inserted at the very start of a row push it down
+			return row + delta
+		case row <= edit.StartRow:
+			return row
+		case row > edit.EndRow:
+			return row + delta
+		}
+		// the row was deleted, keep the fold on what replaced it
+		return edit.StartRow + added
+	}
+
+	folds := []Fold{}
+	for _, fold := range editor.Folds {
+		fold.StartRow, fold.EndRow = shift(fold.StartRow), shift(fold.EndRow)
+		if fold.EndRow > fold.StartRow {
+			folds = append(folds, fold)
+		}
+	}
+	editor.Folds = folds
+}
+
+// updateFolds recomputes folds for the automatic fold methods, keeping folds
+// that were closed closed.
+func (editor *Editor) updateFolds() {
+	method := editor.OptionString("foldmethod")
+	if method == "manual" {
+		editor.foldMethod = method
+		return
+	}
+	editor.trackFolds()
+
+	if method == editor.foldMethod && editor.foldsVersion == editor.Content.Version {
+		return
+	}
+
+	var ranges []FoldRange
+	switch method {
+	case "indent":
+		ranges = editor.indentFolds()
+	case "marker":
+		ranges = editor.markerFolds()
+	case "syntax":
+		structure := editor.Structure(false)
+		if structure != nil {
+			if editor.Content.structure.version != editor.Content.Version {
+				// the parse is waiting for edits to settle, the shifted
+				// folds will do until then
+				return
+			}
+			ranges = structure.Folds()
+		}
+	}
+
+	closed := map[int]bool{}
+	for _, fold := range editor.Folds {
+		closed[fold.StartRow] = closed[fold.StartRow] || fold.Closed
+	}
+
+	folds := []Fold{}
+	for _, r := range ranges {
+		folds = append(folds, Fold{StartRow: r.StartRow, EndRow: r.EndRow, Closed: closed[r.StartRow]})
+	}
+	sortFolds(folds)
+
+	editor.Folds = folds
+	editor.foldMethod = method
+	editor.foldsVersion = editor.Content.Version
+}
+
+// indentFolds folds every run of lines indented at least one shiftwidth past
+// the line before it. Blank lines take the lower level of their neighbours.
+func (editor *Editor) indentFolds() []FoldRange {
+	lines := editor.Content.lines()
+	width := max(1, editor.shiftWidth())
+
+	levels := make([]int, len(lines))
+	for row, line := range lines {
+		levels[row] = -1
+		if strings.TrimSpace(string(line)) != "" {
+			levels[row] = editor.displayCol(line, len(leadingWhitespace(line))) / width
+		}
+	}
+	for row := range levels {
+		if levels[row] != -1 {
+			continue
+		}
+		before, after := 0, 0
+		for i := row - 1; i >= 0; i -= 1 {
+			if levels[i] != -1 {
+				before = levels[i]
+				break
+			}
+		}
+		for i := row + 1; i < len(levels); i += 1 {
+			if levels[i] != -1 {
+				after = levels[i]
+				break
+			}
+		}
+		levels[row] = min(before, after)
+	}
+
+	return levelFolds(levels)
+}
+
+// levelFolds turns per row fold levels into ranges, a fold for every run of
+// rows at or above each level.
+func levelFolds(levels []int) []FoldRange {
+	folds := []FoldRange{}
+	// starts[level-1] is where the open fold at that level began
+	starts := []int{}
+	for row := 0; row <= len(levels); row += 1 {
+		level := 0
+		if row < len(levels) {
+			level = levels[row]
+		}
+
+		for len(starts) > level {
+			start := starts[len(starts)-1]
+			starts = starts[:len(starts)-1]
+			if row-1 > start {
+				folds = append(folds, FoldRange{StartRow: start, EndRow: row - 1})
+			}
+		}
+		for len(starts) < level {
+			starts = append(starts, row)
+		}
+	}
+	return folds
+}
+
+// markerFolds folds between lines containing the start and end of
+// foldmarker, which nest.
+func (editor *Editor) markerFolds() []FoldRange {
+	open, close, ok := strings.Cut(editor.OptionString("foldmarker"), ",")
+	if !ok || open == "" || close == "" {
+		return nil
+	}
+
+	folds := []FoldRange{}
+	starts := []int{}
+	for row, line := range editor.Content.lines() {
+		text := string(line)
+		for range strings.Count(text, open) {
+			starts = append(starts, row)
+		}
+		for range strings.Count(text, close) {
+			if len(starts) == 0 {
+				break
+			}
+			start := starts[len(starts)-1]
+			starts = starts[:len(starts)-1]
+			if row > start {
+				folds = append(folds, FoldRange{StartRow: start, EndRow: row})
+			}
+		}
+	}
+	return folds
+}
+
+// createFold is zf, only manual folds can be made by hand.
+func (editor *Editor) createFold(first int, last int) {
+	if editor.OptionString("foldmethod") != "manual" {
+		editor.ReportError(fmt.Errorf("cannot create a fold with foldmethod=%s", editor.OptionString("foldmethod")))
+		return
+	}
+	if last <= first {
+		return
+	}
+
+	editor.trackFolds()
+	editor.Folds = append(editor.Folds, Fold{StartRow: first, EndRow: last, Closed: true})
+	sortFolds(editor.Folds)
+	editor.moveToRow(first)
+}
+
+// foldsAt returns the indexes of the folds containing row, outermost first.
+func (editor *Editor) foldsAt(row int) []int {
+	indexes := []int{}
+	for i, fold := range editor.Folds {
+		if fold.StartRow <= row && row <= fold.EndRow {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+func (editor *Editor) openFold() {
+	for _, i := range editor.foldsAt(editor.Cursor.Row) {
+		if editor.Folds[i].Closed {
+			editor.Folds[i].Closed = false
+			return
+		}
+	}
+}
+
+func (editor *Editor) closeFold() {
+	folds := editor.foldsAt(editor.Cursor.Row)
+	for j := len(folds) - 1; j >= 0; j -= 1 {
+		if !editor.Folds[folds[j]].Closed {
+			editor.Folds[folds[j]].Closed = true
+			editor.moveToRow(editor.Folds[folds[j]].StartRow)
+			return
+		}
+	}
+}
+
+func (editor *Editor) toggleFold() {
+	if _, ok := editor.closedFold(editor.Cursor.Row); ok {
+		editor.openFold()
+	} else {
+		editor.closeFold()
+	}
+}
+
+func (editor *Editor) setAllFolds(closed bool) {
+	for i := range editor.Folds {
+		editor.Folds[i].Closed = closed
+	}
+	if fold, ok := editor.closedFold(editor.Cursor.Row); ok {
+		editor.moveToRow(fold.StartRow)
+	}
+}
+
+func (editor *Editor) deleteFold(all bool) {
+	if editor.OptionString("foldmethod") != "manual" {
+		editor.ReportError(fmt.Errorf("cannot delete a fold with foldmethod=%s", editor.OptionString("foldmethod")))
+		return
+	}
+	if all {
+		editor.Folds = []Fold{}
+		return
+	}
+
+	folds := editor.foldsAt(editor.Cursor.Row)
+	if len(folds) > 0 {
+		editor.Folds = slices.Delete(editor.Folds, folds[len(folds)-1], folds[len(folds)-1]+1)
+	}
+}
+
+// jumpFold is zj and zk, moving to the start of the next fold or the end of
+// the previous one.
+func (editor *Editor) jumpFold(forward bool) {
+	row := editor.Cursor.Row
+	target := -1
+	for _, fold := range editor.Folds {
+		if forward && fold.StartRow > row && (target == -1 || fold.StartRow < target) {
+			if first, _ := editor.lineRange(fold.StartRow); first == fold.StartRow {
+				target = fold.StartRow
+			}
+		}
+		if !forward && fold.EndRow < row && fold.EndRow > target {
+			// an end hidden in a closed fold lands on the fold
+			target = fold.EndRow
+		}
+	}
+
+	if target != -1 {
+		editor.moveToRow(target)
+	}
+}
+
+// revealCursor keeps the cursor out of closed folds, on the row drawn for
+// the fold. Typing inside a closed fold opens it instead.
+func (editor *Editor) revealCursor() {
+	fold, ok := editor.closedFold(editor.Cursor.Row)
+	if !ok {
+		return
+	}
+
+	if editor.Mode == Insert {
+		for i := range editor.Folds {
+			if editor.Folds[i].StartRow <= editor.Cursor.Row && editor.Cursor.Row <= editor.Folds[i].EndRow {
+				editor.Folds[i].Closed = false
+			}
+		}
+		return
+	}
+	if editor.Cursor.Row != fold.StartRow {
+		editor.moveToRow(fold.StartRow)
+	}
+}
+
+// moveToRow moves the cursor to another row keeping its column if it can.
+func (editor *Editor) moveToRow(row int) {
+	editor.ShiftCursor(row-editor.Cursor.Row, 0, false, false)
+}
+
+// foldSummary is the text drawn for a closed fold.
+func (editor *Editor) foldSummary(fold Fold, line []rune) string {
+	depth := len(editor.foldsAt(fold.StartRow))
+	text := strings.TrimSpace(strings.ReplaceAll(string(line), "\t", " "))
+	return fmt.Sprintf(
+		"+-%s%3d lines: %s",
+		strings.Repeat("-", depth), fold.EndRow-fold.StartRow+1, text,
+	)
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "foldmethod", Short: "fdm", Kind: StringOption, Scope: WindowScope,
+		Default:  OptionValue{String: "manual"},
+		validate: oneOf("manual", "indent", "marker", "syntax"),
+	})
+	registerOption(OptionDef{
+		Name: "foldmarker", Short: "fmr", Kind: StringOption, Scope: WindowScope,
+		Default: OptionValue{String: "{{{,}}}"},
+	})
+
+	registerAction("operator_fold", func(editor *Editor, key KeyStroke) {
+		editor.startOperator("zf")
+	})
+	registerAction("fold_open", func(editor *Editor, key KeyStroke) {
+		editor.openFold()
+	})
+	registerAction("fold_close", func(editor *Editor, key KeyStroke) {
+		editor.closeFold()
+	})
+	registerAction("fold_toggle", func(editor *Editor, key KeyStroke) {
+		editor.toggleFold()
+	})
+	registerAction("fold_open_all", func(editor *Editor, key KeyStroke) {
+		editor.setAllFolds(false)
+	})
+	registerAction("fold_close_all", func(editor *Editor, key KeyStroke) {
+		editor.setAllFolds(true)
+	})
+	registerAction("fold_delete", func(editor *Editor, key KeyStroke) {
+		editor.deleteFold(false)
+	})
+	registerAction("fold_delete_all", func(editor *Editor, key KeyStroke) {
+		editor.deleteFold(true)
+	})
+	registerAction("fold_next", func(editor *Editor, key KeyStroke) {
+		editor.jumpFold(true)
+	})
+	registerAction("fold_prev", func(editor *Editor, key KeyStroke) {
+		editor.jumpFold(false)
+	})
+
+	bindDefault(Normal, "zf", "operator_fold")
+	bindDefault(Normal, "zo", "fold_open")
+	bindDefault(Normal, "zc", "fold_close")
+	bindDefault(Normal, "za", "fold_toggle")
+	bindDefault(Normal, "zR", "fold_open_all")
+	bindDefault(Normal, "zM", "fold_close_all")
+	bindDefault(Normal, "zd", "fold_delete")
+	bindDefault(Normal, "zE", "fold_delete_all")
+	bindDefault(Normal, "zj", "fold_next")
+	bindDefault(Normal, "zk", "fold_prev")
+}
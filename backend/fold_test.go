@@ -0,0 +1,141 @@
+package backend
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManualFolds(t *testing.T) {
+	editor := newTestEditor("a\nb\nc\nd\ne")
+	typeKeys(t, editor, "jzfj")
+
+	view := editor.View()
+	if len(view) != 4 || view[1].Folded != 2 {
+		t.Fatalf("expected b and c folded into one row, got %+v", view)
+	}
+	summary := ""
+	for _, cell := range view[1].Cells {
+		summary += string(cell.Rune)
+	}
+	if !strings.HasPrefix(summary, "+--  2 lines: b") {
+		t.Fatalf("unexpected summary %q", summary)
+	}
+
+	// j and k step over the closed fold
+	typeKeys(t, editor, "j")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected row 3, got %d", editor.Cursor.Row)
+	}
+	typeKeys(t, editor, "k")
+	if editor.Cursor.Row != 1 {
+		t.Fatalf("expected row 1, got %d", editor.Cursor.Row)
+	}
+	if _, row := editor.CursorScreenPosition(); row != 1 {
+		t.Fatalf("expected the cursor on screen row 1, got %d", row)
+	}
+
+	// lines added above the fold move it down
+	editor.moveCursorTo(0)
+	typeKeys(t, editor, "ox<Esc>")
+	if editor.Folds[0].StartRow != 2 || editor.Folds[0].EndRow != 3 {
+		t.Fatalf("expected the fold to shift, got %+v", editor.Folds)
+	}
+
+	typeKeys(t, editor, "jzo")
+	if len(editor.View()) != 6 {
+		t.Fatal("expected zo to open the fold")
+	}
+	typeKeys(t, editor, "za")
+	if len(editor.View()) != 5 {
+		t.Fatal("expected za to close the fold")
+	}
+
+	// dd on a closed fold deletes all of it
+	typeKeys(t, editor, "dd")
+	expectContent(t, editor, "a\nx\nd\ne")
+	if len(editor.Folds) != 0 {
+		t.Fatalf("expected the fold to go with its lines, got %+v", editor.Folds)
+	}
+}
+
+func TestFoldsFollowTheShownFile(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	editor := newTestEditor("a\nb\nc\nd")
+	typeKeys(t, editor, "jzfj")
+	first := editor.Content
+
+	// the folds stop listening to a file the editor leaves
+	editor.SwitchContent(newTestEditor("other").Content, "other.txt")
+	if len(first.listeners) != 0 {
+		t.Fatalf("expected no listeners left on the old file, got %d", len(first.listeners))
+	}
+
+	// and pick up again when it comes back
+	editor.SwitchContent(first, "test.txt")
+	editor.moveCursorTo(0)
+	typeKeys(t, editor, "Ox<Esc>")
+	if len(editor.Folds) != 1 || editor.Folds[0].StartRow != 2 {
+		t.Fatalf("expected the fold to shift, got %+v", editor.Folds)
+	}
+}
+
+func TestIndentFolds(t *testing.T) {
+	editor := newTestEditor("func a() {\n\tone\n\n\tif x {\n\t\ttwo\n\t\tthree\n\t}\n}\nfunc b() {\n\tfour\n}")
+	editor.ExecuteCommand("set fdm=indent sw=8")
+	editor.ScrollToCursor()
+
+	expected := []Fold{{1, 6, false}, {4, 5, false}}
+	if len(editor.Folds) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, editor.Folds)
+	}
+	for i := range expected {
+		if editor.Folds[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected, editor.Folds)
+		}
+	}
+
+	typeKeys(t, editor, "zMzj")
+	if editor.Cursor.Row != 1 {
+		t.Fatalf("expected zj to reach row 1, got %d", editor.Cursor.Row)
+	}
+	if len(editor.View()) != 6 {
+		t.Fatalf("expected the body to be folded, got %d rows", len(editor.View()))
+	}
+	typeKeys(t, editor, "jzk")
+	if editor.Cursor.Row != 1 {
+		t.Fatalf("expected zk to land on the closed fold, got %d", editor.Cursor.Row)
+	}
+
+	// closed folds stay closed when the folds are recomputed
+	editor.Content.replace([]rune("// top\n"), 0, 0)
+	editor.ScrollToCursor()
+	if fold, ok := editor.closedFold(2); !ok || fold.StartRow != 2 || fold.EndRow != 7 {
+		t.Fatalf("expected the shifted fold to stay closed, got %+v", editor.Folds)
+	}
+
+	if editor.ExecuteCommand("set fdm=outline") == nil {
+		t.Fatal("expected an error for an unknown fold method")
+	}
+}
+
+func TestMarkerAndSyntaxFolds(t *testing.T) {
+	editor := newTestEditor("a {{{\nb\nc {{{\nd\n}}}\n}}}\ne")
+	editor.ExecuteCommand("set fdm=marker")
+	editor.ScrollToCursor()
+	if len(editor.Folds) != 2 || editor.Folds[0] != (Fold{0, 5, false}) ||
+		editor.Folds[1] != (Fold{2, 4, false}) {
+		t.Fatalf("unexpected marker folds %+v", editor.Folds)
+	}
+
+	editor = newGoTestEditor(t)
+	editor.ExecuteCommand("set fdm=syntax")
+	editor.ScrollToCursor()
+	if _, ok := editor.closedFold(0); ok || len(editor.Folds) == 0 {
+		t.Fatalf("expected open syntax folds, got %+v", editor.Folds)
+	}
+	typeKeys(t, editor, "zM")
+	if len(editor.View()) != 9 {
+		t.Fatalf("expected three folded declarations, got %d rows", len(editor.View()))
+	}
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// how long a formatter gets when its config does not say
+const defaultFormatTimeout = 2 * time.Second
+
+// formatterFor reads the command that formats filetype from the
+// [format.<filetype>] section of the config, and how long it may take.
+func formatterFor(config *Config, filetype string) ([]string, time.Duration, bool) {
+	command, ok := config.Strings("format."+filetype, "command")
+	if !ok || len(command) == 0 {
+		return nil, 0, false
+	}
+	timeout := defaultFormatTimeout
+	if ms, ok := config.Int("format."+filetype, "timeout"); ok && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	return command, timeout, true
+}
+
+// format pipes the buffer through the formatter configured for its filetype
+// and applies what changed. It does nothing for filetypes without one.
+func (editor *Editor) format() error {
+	config, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return err
+	}
+	command, timeout, ok := formatterFor(config, editor.OptionString("filetype"))
+	if !ok {
+		return nil
+	}
+
+	// an argument of % is the file, for formatters that go by its name
+	args := []string{}
+	for _, arg := range command[1:] {
+		if arg == "%" {
+			arg = editor.FilePath
+		}
+		args = append(args, arg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	text := editor.Content.calculateContent()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stdin = strings.NewReader(string(text))
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = 100 * time.Millisecond
+	err = cmd.Run()
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%s: timed out after %s", command[0], timeout)
+	case err != nil:
+		if complaint := firstLine(strings.TrimSpace(stderr.String())); complaint != "" {
+			return fmt.Errorf("%s: %s", command[0], complaint)
+		}
+		return fmt.Errorf("%s: %w", command[0], err)
+	case stdout.Len() == 0 && len(text) > 0:
+		return fmt.Errorf("%s: no output", command[0])
+	}
+
+	formatted := []rune(stdout.String())
+	if string(formatted) == string(text) {
+		return nil
+	}
+	edits := editor.Content.SetText(formatted)
+	editor.moveCursorTo(shiftIndex(editor.Cursor.Index, edits))
+	return nil
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "formatonsave", Kind: BoolOption, Scope: BufferScope,
+		Default: OptionValue{Bool: true},
+	})
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.format()
+	}, "Format")
+}
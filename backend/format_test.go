@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatOnSave(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("TEXT_EDITOR_CONFIG", config)
+	writeTestFile(t, config, "[options]\nlsp = false\n[format.go]\ncommand = [\"sed\", \"s/this/that/\"]\n")
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	writeTestFile(t, path, "keep\nthis\nstay\n")
+	editor := InitializeEditor(path, 24, 80)
+
+	// the cursor and marks on lines the formatter leaves alone stay put
+	typeKeys(t, &editor, "jjlma")
+	editor.SaveContent()
+	raw, _ := os.ReadFile(path)
+	if string(raw) != "keep\nthat\nstay\n" || editor.Cursor.Row != 2 || editor.Cursor.Col != 1 {
+		t.Fatalf("saved %q with the cursor at %+v", raw, editor.Cursor)
+	}
+	if mark := editor.Content.marks['a']; mark.Index != 11 {
+		t.Fatalf("expected the mark to stay put, got %d", mark.Index)
+	}
+
+	// failures save the file as it is
+	writeTestFile(t, config, "[format.go]\ncommand = [\"sh\", \"-c\", \"echo bad input >&2; exit 2\"]\n")
+	typeKeys(t, &editor, "ix<Esc>")
+	editor.SaveContent()
+	raw, _ = os.ReadFile(path)
+	if string(raw) != "keep\nthat\nsxtay\n" || editor.Message != "not formatted: sh: bad input" {
+		t.Fatalf("saved %q with message %q", raw, editor.Message)
+	}
+
+	writeTestFile(t, config, "[format.go]\ncommand = [\"sleep\", \"5\"]\ntimeout = 50\n")
+	editor.SaveContent()
+	if editor.Message != "not formatted: sleep: timed out after 50ms" {
+		t.Fatalf("expected a timeout, got %q", editor.Message)
+	}
+
+	editor.ExecuteCommand("setlocal noformatonsave")
+	editor.Message = ""
+	editor.SaveContent()
+	if editor.Message != "" {
+		t.Fatalf("expected the formatter to be skipped, got %q", editor.Message)
+	}
+}
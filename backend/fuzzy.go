@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"strings"
+	"unicode"
+)
+
+// fuzzy match scores, in the spirit of fzf: every matched rune scores, gaps
+// cost, and matches at word boundaries or right after another match are
+// worth more
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8
+	bonusCamel        = 7
+	bonusConsecutive  = 4
+)
+
+// fuzzyMatch reports whether the runes of pattern appear in text in order,
+// with a score for how good a match it is and the rune positions that
+// matched. The match is case insensitive unless pattern has an upper case
+// letter.
+func fuzzyMatch(pattern string, text string) (int, []int, bool) {
+	if pattern == "" {
+		return 0, nil, true
+	}
+
+	caseSensitive := strings.IndexFunc(pattern, unicode.IsUpper) != -1
+	fold := func(r rune) rune {
+		if caseSensitive {
+			return r
+		}
+		return unicode.ToLower(r)
+	}
+
+	want := []rune(pattern)
+	for i, r := range want {
+		want[i] = fold(r)
+	}
+	runes := []rune(text)
+
+	// the first place the whole pattern fits, then back from its end to find
+	// the shortest window that still holds it
+	end, next := -1, 0
+	for i, r := range runes {
+		if fold(r) == want[next] {
+			next += 1
+			if next == len(want) {
+				end = i
+				break
+			}
+		}
+	}
+	if end == -1 {
+		return 0, nil, false
+	}
+
+	start, next := end, len(want)-1
+	for i := end; i >= 0; i -= 1 {
+		if fold(runes[i]) == want[next] {
+			next -= 1
+			if next < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	score, consecutive, inGap := 0, 0, false
+	positions := []int{}
+	for i := start; i <= end && len(positions) < len(want); i += 1 {
+		if fold(runes[i]) != want[len(positions)] {
+			if inGap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+			}
+			inGap, consecutive = true, 0
+			continue
+		}
+
+		bonus := boundaryBonus(runes, i)
+		if consecutive > 0 {
+			bonus = max(bonus, bonusConsecutive)
+		}
+		if len(positions) == 0 {
+			bonus *= 2
+		}
+		score += scoreMatch + bonus
+		positions = append(positions, i)
+		inGap, consecutive = false, consecutive+1
+	}
+
+	return score, positions, true
+}
+
+// boundaryBonus rewards matches that start a word, a path component or a
+// camelCase hump.
+func boundaryBonus(runes []rune, i int) int {
+	if i == 0 {
+		return bonusBoundary
+	}
+
+	prev, current := runes[i-1], runes[i]
+	switch {
+	case !isWordRune(prev) && isWordRune(current):
+		return bonusBoundary
+	case prev == '_' && current != '_':
+		return bonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(current):
+		return bonusCamel
+	case !unicode.IsDigit(prev) && unicode.IsDigit(current):
+		return bonusCamel
+	}
+	return 0
+}
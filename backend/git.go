@@ -0,0 +1,364 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gitStatus maps the changed files under dir, by slash separated path
+// relative to it, to a one letter status: M modified, A added, D deleted,
+// R renamed, U conflicted and ? untracked. Outside a repository there is
+// nothing to report.
+func gitStatus(dir string) (map[string]string, error) {
+	prefix, err := exec.Command("git", "-C", dir, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return map[string]string{}, nil
+	}
+
+	out, err := exec.Command("git", "-C", dir, "status",
+		"--porcelain=v1", "-z", "--untracked-files=all", "--", ".").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatus(string(out), strings.TrimSpace(string(prefix))), nil
+}
+
+// parseGitStatus reads porcelain v1 -z output, where paths are relative to
+// the top of the repository.
+func parseGitStatus(out string, prefix string) map[string]string {
+	statuses := map[string]string{}
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i += 1 {
+		field := fields[i]
+		if len(field) < 4 {
+			continue
+		}
+		x, y, path := field[0], field[1], field[3:]
+		// a rename is followed by the name it had before
+		if x == 'R' || x == 'C' {
+			i += 1
+		}
+
+		status := string(y)
+		switch {
+		case x == '?':
+			status = "?"
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			status = "U"
+		case y == ' ':
+			status = string(x)
+		}
+		if rel, ok := strings.CutPrefix(path, prefix); ok {
+			statuses[rel] = status
+		}
+	}
+	return statuses
+}
+
+// gitBase is a file as git last committed it, and how the content differs
+// from it.
+type gitBase struct {
+	// false outside a repository and for files git does not track
+	tracked bool
+	lines   []string
+	hunks   []diffHunk
+	version int
+	// a comparison is waiting for typing to pause, see updateGitSigns
+	pending bool
+}
+
+// how long the signs wait for edits to settle before the file is compared
+// with HEAD again
+const gitDebounce = 200 * time.Millisecond
+
+// gitShow reads a file at a revision, "HEAD" or "" for the index.
+func gitShow(path string, revision string) (string, error) {
+	out, err := exec.Command("git", "-C", filepath.Dir(path),
+		"show", revision+":./"+filepath.Base(path)).Output()
+	return string(out), err
+}
+
+// gitBranch is the branch checked out in dir, or the commit when none is,
+// and nothing outside a repository.
+func gitBranch(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	branch := strings.TrimSpace(string(out))
+	if err != nil {
+		return ""
+	}
+	if branch == "HEAD" {
+		out, _ = exec.Command("git", "-C", dir, "rev-parse", "--short", "HEAD").Output()
+		branch = strings.TrimSpace(string(out))
+	}
+	return branch
+}
+
+// gitLines splits text from git into lines the way the content holds them,
+// without the last newline or carriage returns.
+func gitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// textLines is the content's lines as strings, none for an empty content.
+func (content *Content) textLines() []string {
+	lines := []string{}
+	if content.Length == 0 {
+		return lines
+	}
+	for _, line := range content.lines() {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// gitBase reads the file as HEAD has it, the first time it is asked for and
+// again once the file is saved. It is nil for files git does not track.
+func (editor *Editor) gitBase() *gitBase {
+	content := editor.Content
+	if content.scratch {
+		return nil
+	}
+	if content.git == nil {
+		content.git = &gitBase{version: -1}
+		content.GitBranch = gitBranch(filepath.Dir(editor.FilePath))
+		if text, err := gitShow(editor.FilePath, "HEAD"); err == nil {
+			content.git.tracked = true
+			content.git.lines = gitLines(text)
+		}
+	}
+	if !content.git.tracked {
+		return nil
+	}
+	return content.git
+}
+
+// setGitHunks records how the content at version differs from HEAD.
+func (content *Content) setGitHunks(base *gitBase, hunks []diffHunk, version int) {
+	base.hunks, base.version = hunks, version
+	content.GitSigns = gitSigns(hunks)
+}
+
+// gitHunks is how the current file differs from HEAD, worked out on the spot
+// for commands that need it up to date.
+func (editor *Editor) gitHunks() []diffHunk {
+	content := editor.Content
+	base := editor.gitBase()
+	if base == nil {
+		return nil
+	}
+	if base.version != content.Version {
+		content.setGitHunks(base, diff(base.lines, content.textLines()), content.Version)
+	}
+	return base.hunks
+}
+
+// updateGitSigns marks the lines that differ from HEAD in the sign column.
+// Clients draw the signs without asking git themselves. After an edit the
+// file is compared again once edits pause for gitDebounce, off the loop, so
+// typing in a big file does not wait on it.
+func (editor *Editor) updateGitSigns() {
+	content := editor.Content
+	base := editor.gitBase()
+	switch {
+	case base == nil:
+		content.GitSigns = nil
+		return
+	case base.version == -1:
+		// a file that was just opened shows its signs right away
+		editor.gitHunks()
+		return
+	case base.version == content.Version || base.pending:
+		return
+	}
+
+	base.pending = true
+	queue := content.async()
+	time.AfterFunc(gitDebounce, func() {
+		queue.post(func() {
+			base.pending = false
+			if content.git != base {
+				return
+			}
+			lines, version := content.textLines(), content.Version
+			go func() {
+				hunks := diff(base.lines, lines)
+				queue.post(func() {
+					// edits since then compare again on their own
+					if content.git == base && content.Version == version {
+						content.setGitHunks(base, hunks, version)
+					}
+				})
+			}()
+		})
+	})
+}
+
+// gitSigns marks added lines with +, changed ones with ~ and deleted ones
+// with _ on the line above them.
+func gitSigns(hunks []diffHunk) map[int]Sign {
+	signs := map[int]Sign{}
+	for _, hunk := range hunks {
+		switch {
+		case hunk.aStart == hunk.aEnd:
+			for row := hunk.bStart; row < hunk.bEnd; row += 1 {
+				signs[row] = Sign{Text: "+", Class: "git.added", Priority: 1}
+			}
+		case hunk.bStart == hunk.bEnd:
+			row, _ := hunkRows(hunk)
+			text := "_"
+			if hunk.bStart == 0 {
+				text = "‾"
+			}
+			signs[row] = Sign{Text: text, Class: "git.deleted", Priority: 1}
+		default:
+			for row := hunk.bStart; row < hunk.bEnd; row += 1 {
+				signs[row] = Sign{Text: "~", Class: "git.modified", Priority: 1}
+			}
+			// more lines went than came, the rest were deleted
+			if hunk.aEnd-hunk.aStart > hunk.bEnd-hunk.bStart {
+				signs[hunk.bEnd-1] = Sign{Text: "~_", Class: "git.modified", Priority: 1}
+			}
+		}
+	}
+	return signs
+}
+
+// hunkRows is the rows a hunk marks. Lines that were deleted are marked on
+// the line above them, or the first line when there is none.
+func hunkRows(hunk diffHunk) (int, int) {
+	if hunk.bStart == hunk.bEnd {
+		row := max(0, hunk.bStart-1)
+		return row, row + 1
+	}
+	return hunk.bStart, hunk.bEnd
+}
+
+// hunkAt is the hunk that marks row.
+func hunkAt(hunks []diffHunk, row int) (diffHunk, bool) {
+	for _, hunk := range hunks {
+		if start, end := hunkRows(hunk); row >= start && row < end {
+			return hunk, true
+		}
+	}
+	return diffHunk{}, false
+}
+
+// jumpGitHunk moves to the first row of the next or previous hunk.
+func (editor *Editor) jumpGitHunk(forward bool) error {
+	hunks := editor.gitHunks()
+	if !forward {
+		hunks = slices.Clone(hunks)
+		slices.Reverse(hunks)
+	}
+	for _, hunk := range hunks {
+		row, _ := hunkRows(hunk)
+		if (forward && row > editor.Cursor.Row) || (!forward && row < editor.Cursor.Row) {
+			editor.moveToPosition(row, 0)
+			return nil
+		}
+	}
+	return fmt.Errorf("no more hunks")
+}
+
+// resetGitHunk puts the lines of the hunk under the cursor back the way HEAD
+// has them.
+func (editor *Editor) resetGitHunk() error {
+	hunk, ok := hunkAt(editor.gitHunks(), editor.Cursor.Row)
+	if !ok {
+		return fmt.Errorf("no hunk under the cursor")
+	}
+
+	lines := editor.Content.textLines()
+	lines = slices.Concat(lines[:hunk.bStart], editor.Content.git.lines[hunk.aStart:hunk.aEnd], lines[hunk.bEnd:])
+	edits := editor.Content.SetText([]rune(strings.Join(lines, "\n")))
+	editor.moveCursorTo(shiftIndex(editor.Cursor.Index, edits))
+	return nil
+}
+
+// stageGitHunk adds the hunk under the cursor to the index, leaving the rest
+// of the file's changes unstaged. Hunks are found against the index here, so
+// what is already staged stays staged.
+func (editor *Editor) stageGitHunk() error {
+	if editor.Content.scratch {
+		return fmt.Errorf("%s is not a file", editor.FileName)
+	}
+	dir, name := filepath.Dir(editor.FilePath), filepath.Base(editor.FilePath)
+	if _, err := exec.Command("git", "-C", dir, "rev-parse", "--git-dir").Output(); err != nil {
+		return fmt.Errorf("%s is not in a git repository", editor.FileName)
+	}
+
+	// a file git does not know yet starts out empty
+	indexed, _ := gitShow(editor.FilePath, "")
+	index, lines := gitLines(indexed), editor.Content.textLines()
+	hunk, ok := hunkAt(diff(index, lines), editor.Cursor.Row)
+	if !ok {
+		return fmt.Errorf("no unstaged hunk under the cursor")
+	}
+	staged := slices.Concat(index[:hunk.aStart], lines[hunk.bStart:hunk.bEnd], index[hunk.aEnd:])
+
+	hash := exec.Command("git", "-C", dir, "hash-object", "-w", "--stdin")
+	hash.Stdin = bytes.NewReader(editor.encodeFile([]rune(strings.Join(staged, "\n"))))
+	object, err := hash.Output()
+	if err != nil {
+		return fmt.Errorf("git hash-object: %w", err)
+	}
+
+	mode := "100644"
+	if out, err := exec.Command("git", "-C", dir, "ls-files", "-s", "--", name).Output(); err == nil {
+		if fields := strings.Fields(string(out)); len(fields) > 0 {
+			mode = fields[0]
+		}
+	}
+	info := mode + "," + strings.TrimSpace(string(object)) + "," + name
+	if out, err := exec.Command("git", "-C", dir, "update-index", "--add", "--cacheinfo", info).CombinedOutput(); err != nil {
+		return fmt.Errorf("git update-index: %s", strings.TrimSpace(string(out)))
+	}
+	editor.inform(fmt.Sprintf("staged lines %d-%d", hunk.bStart+1, max(hunk.bStart+1, hunk.bEnd)))
+	return nil
+}
+
+func init() {
+	registerSignSource(func(editor *Editor) map[int]Sign {
+		return editor.Content.GitSigns
+	})
+
+	gitAction := func(run func(editor *Editor) error) Action {
+		return func(editor *Editor, key KeyStroke) {
+			if err := run(editor); err != nil {
+				editor.ReportError(err)
+			}
+		}
+	}
+	registerAction("git_next_hunk", gitAction(func(editor *Editor) error {
+		return editor.jumpGitHunk(true)
+	}))
+	registerAction("git_prev_hunk", gitAction(func(editor *Editor) error {
+		return editor.jumpGitHunk(false)
+	}))
+	registerAction("git_stage_hunk", gitAction((*Editor).stageGitHunk))
+	registerAction("git_reset_hunk", gitAction((*Editor).resetGitHunk))
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.stageGitHunk()
+	}, "Gstage")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.resetGitHunk()
+	}, "Greset")
+
+	bindDefault(Normal, "]h", "git_next_hunk")
+	bindDefault(Normal, "[h", "git_prev_hunk")
+	bindDefault(Normal, "<Space>hs", "git_stage_hunk")
+	bindDefault(Normal, "<Space>hr", "git_reset_hunk")
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newGitRepo makes a throwaway repository with files committed in it.
+func newGitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	t.Setenv("GIT_AUTHOR_NAME", "Ada")
+	t.Setenv("GIT_AUTHOR_EMAIL", "ada@example.com")
+	t.Setenv("GIT_AUTHOR_DATE", "2024-03-01T12:00:00Z")
+	t.Setenv("GIT_COMMITTER_NAME", "Ada")
+	t.Setenv("GIT_COMMITTER_EMAIL", "ada@example.com")
+
+	dir := t.TempDir()
+	for name, text := range files {
+		writeTestFile(t, filepath.Join(dir, name), text)
+	}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}, {"commit", "-q", "-m", "first"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s", args[0], out)
+		}
+	}
+	return dir
+}
+
+func TestGitSigns(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	dir := newGitRepo(t, map[string]string{"notes.txt": "one\ntwo\nthree\nfour\nfive\n"})
+	path := filepath.Join(dir, "notes.txt")
+
+	editor := InitializeEditor(path, 24, 80)
+	editor.ScrollToCursor()
+	if len(editor.signs()) != 0 {
+		t.Fatalf("expected no signs for an unchanged file, got %+v", editor.signs())
+	}
+
+	// change two, add a line after three and delete five
+	editor.Content.SetText([]rune("one\n2\nthree\nnew\nfour"))
+	editor.ScrollToCursor()
+	if len(editor.signs()) != 0 {
+		t.Fatal("expected the signs to wait for edits to settle")
+	}
+	waitFor(t, &editor, "git signs", func() bool { return len(editor.signs()) != 0 })
+	expected := map[int]string{1: "~", 3: "+", 4: "_"}
+	signs := editor.signs()
+	for row, text := range expected {
+		if signs[row].Text != text {
+			t.Fatalf("expected %s on line %d, got %+v", text, row, signs)
+		}
+	}
+	if len(signs) != len(expected) || signs[3].Class != "git.added" {
+		t.Fatalf("unexpected signs %+v", signs)
+	}
+
+	typeKeys(t, &editor, "]h")
+	typeKeys(t, &editor, "]h")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected ]h to go to the added line, got %+v", editor.Cursor)
+	}
+	typeKeys(t, &editor, "[h")
+	if editor.Cursor.Row != 1 {
+		t.Fatalf("expected [h to go back to the changed line, got %+v", editor.Cursor)
+	}
+
+	// staging the change leaves the file and the other changes alone
+	typeKeys(t, &editor, "<Space>hs")
+	out, err := exec.Command("git", "-C", dir, "diff", "--cached").Output()
+	if err != nil || !strings.Contains(string(out), "-two\n+2\n") || strings.Contains(string(out), "new") {
+		t.Fatalf("unexpected staged changes %q: %v", out, err)
+	}
+	expectContent(t, &editor, "one\n2\nthree\nnew\nfour")
+
+	// resetting puts it back the way HEAD has it
+	typeKeys(t, &editor, "<Space>hr")
+	expectContent(t, &editor, "one\ntwo\nthree\nnew\nfour")
+	typeKeys(t, &editor, "jj<Space>hr")
+	expectContent(t, &editor, "one\ntwo\nthree\nfour")
+	typeKeys(t, &editor, "kkk")
+	if err := editor.ExecuteCommand("Greset"); err == nil {
+		t.Fatal("expected no hunk under the cursor")
+	}
+
+	editor.ExecuteCommand("Gblame")
+	waitFor(t, &editor, "blame", func() bool { return editor.Blame != nil && len(editor.Blame.Lines) == 4 })
+	if line := editor.Blame.Lines[0]; !strings.HasSuffix(line, " 2024-03-01 Ada") {
+		t.Fatalf("unexpected blame %q", line)
+	}
+	if editor.BlameWidth() != 25 || editor.TextWidth() != 80-25-editor.GutterWidth() {
+		t.Fatalf("expected the text to make room for blame, got %d", editor.BlameWidth())
+	}
+
+	// the blame follows edits
+	typeKeys(t, &editor, "Onew<Esc>")
+	waitFor(t, &editor, "blame after an edit", func() bool { return len(editor.Blame.Lines) == 5 })
+	if editor.Blame.Lines[editor.Cursor.Row] != "Not committed yet" {
+		t.Fatalf("expected the new line not to be committed, got %q", editor.Blame.Lines)
+	}
+	editor.ExecuteCommand("Gblame")
+	if editor.Blame != nil || editor.BlameWidth() != 0 {
+		t.Fatal("expected :Gblame to hide the blame")
+	}
+}
@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is one line of a .gitignore. dir is the slash separated
+// directory, relative to the walk's root, of the file it came from.
+type ignoreRule struct {
+	dir      string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// readIgnoreRules parses the .gitignore style file at file, for paths under
+// dir.
+func readIgnoreRules(file string, dir string) []ignoreRule {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	return parseIgnoreRules(string(data), dir)
+}
+
+func parseIgnoreRules(text string, dir string) []ignoreRule {
+	rules := []ignoreRule{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		// a slash anywhere but the end ties the pattern to its directory
+		if strings.Contains(line, "/") {
+			rule.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (rule ignoreRule) matches(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.dir != "" {
+		if !strings.HasPrefix(rel, rule.dir+"/") {
+			return false
+		}
+		rel = rel[len(rule.dir)+1:]
+	}
+
+	if !rule.anchored {
+		return globMatch(rule.pattern, path.Base(rel))
+	}
+	return globMatch(rule.pattern, rel)
+}
+
+// globMatch matches a slash separated path against a pattern where ** spans
+// any number of directories.
+func globMatch(pattern string, name string) bool {
+	patterns, names := strings.Split(pattern, "/"), strings.Split(name, "/")
+
+	var match func(p int, n int) bool
+	match = func(p int, n int) bool {
+		for ; p < len(patterns); p, n = p+1, n+1 {
+			if patterns[p] == "**" {
+				for skip := n; skip <= len(names); skip += 1 {
+					if match(p+1, skip) {
+						return true
+					}
+				}
+				return false
+			}
+			if n == len(names) {
+				return false
+			}
+			if ok, _ := path.Match(patterns[p], names[n]); !ok {
+				return false
+			}
+		}
+		return n == len(names)
+	}
+	return match(0, 0)
+}
+
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.matches(rel, isDir) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
+
+// maxProjectFiles bounds how much of a huge tree the file picker lists.
+const maxProjectFiles = 100000
+
+// projectFiles lists the files under root, relative to it, leaving out what
+// .gitignore files and .git/info/exclude ignore.
+func projectFiles(root string) ([]string, error) {
+	rules := readIgnoreRules(filepath.Join(root, ".git", "info", "exclude"), "")
+	files := []string{}
+
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable directories are skipped, not fatal
+			if entry != nil && entry.IsDir() && file != root {
+				return fs.SkipDir
+			}
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, file)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rules = append(rules, readIgnoreRules(filepath.Join(file, ".gitignore"), "")...)
+			return nil
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == ".git" || ignored(rules, rel, true) {
+				return fs.SkipDir
+			}
+			rules = append(rules, readIgnoreRules(filepath.Join(file, ".gitignore"), rel)...)
+			return nil
+		}
+
+		if !ignored(rules, rel, false) && entry.Type().IsRegular() {
+			files = append(files, rel)
+			if len(files) == maxProjectFiles {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return files, err
+}
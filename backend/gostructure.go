@@ -0,0 +1,375 @@
+package backend
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+)
+
+// goStructure is the StructureProvider for Go, built on go/parser.
+type goStructure struct {
+	file   *ast.File
+	tokens *token.File
+	text   []rune
+	// rune index of each byte offset that starts a rune
+	runeIndex []int
+}
+
+func (g *goStructure) Parse(text []rune) error {
+	src := string(text)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if file == nil {
+		return err
+	}
+
+	g.file = file
+	g.tokens = fset.File(file.Pos())
+	g.text = text
+	g.runeIndex = make([]int, len(src)+1)
+	index := 0
+	for offset := range src {
+		g.runeIndex[offset] = index
+		index += 1
+	}
+	g.runeIndex[len(src)] = index
+
+	return err
+}
+
+// index converts a position to a content index, positions in a partial parse
+// can point past the text so they are clamped.
+func (g *goStructure) index(pos token.Pos) int {
+	offset := int(pos) - g.tokens.Base()
+	offset = max(0, min(offset, len(g.runeIndex)-1))
+	return g.runeIndex[offset]
+}
+
+func (g *goStructure) row(pos token.Pos) int {
+	offset := int(pos) - g.tokens.Base()
+	offset = max(0, min(offset, g.tokens.Size()))
+	return g.tokens.Line(g.tokens.Pos(offset)) - 1
+}
+
+// nodeStart includes a declaration's doc comment.
+func nodeStart(node ast.Node) token.Pos {
+	switch node := node.(type) {
+	case *ast.FuncDecl:
+		if node.Doc != nil {
+			return node.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if node.Doc != nil {
+			return node.Doc.Pos()
+		}
+	case *ast.TypeSpec:
+		if node.Doc != nil {
+			return node.Doc.Pos()
+		}
+	}
+	return node.Pos()
+}
+
+func (g *goStructure) TextObject(object TextObject, index int, inner bool) (int, int, bool, bool) {
+	if g.file == nil {
+		return 0, 0, false, false
+	}
+
+	switch object {
+	case FunctionObject:
+		return g.functionObject(index, inner)
+	case ClassObject:
+		return g.classObject(index, inner)
+	case ArgumentObject:
+		return g.argumentObject(index, inner)
+	}
+	return 0, 0, false, false
+}
+
+// enclosing visits the nodes around index, outermost first.
+func (g *goStructure) enclosing(index int, visit func(node ast.Node)) {
+	ast.Inspect(g.file, func(node ast.Node) bool {
+		if node == nil {
+			return false
+		}
+		if _, isFile := node.(*ast.File); !isFile &&
+			(index < g.index(nodeStart(node)) || index >= g.index(node.End())) {
+			return false
+		}
+		visit(node)
+		return true
+	})
+}
+
+func (g *goStructure) functionObject(index int, inner bool) (int, int, bool, bool) {
+	var function ast.Node
+	var body *ast.BlockStmt
+	g.enclosing(index, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.FuncDecl:
+			function, body = node, node.Body
+		case *ast.FuncLit:
+			function, body = node, node.Body
+		}
+	})
+
+	if function == nil || body == nil {
+		return 0, 0, false, false
+	}
+	if inner {
+		return g.innerBraces(body.Lbrace, body.Rbrace)
+	}
+	return g.around(nodeStart(function), function.End())
+}
+
+func (g *goStructure) classObject(index int, inner bool) (int, int, bool, bool) {
+	var decl *ast.GenDecl
+	var spec *ast.TypeSpec
+	var fields *ast.FieldList
+	g.enclosing(index, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.GenDecl:
+			decl = node
+		case *ast.TypeSpec:
+			switch t := node.Type.(type) {
+			case *ast.StructType:
+				spec, fields = node, t.Fields
+			case *ast.InterfaceType:
+				spec, fields = node, t.Methods
+			}
+		}
+	})
+
+	if spec == nil || fields == nil {
+		return 0, 0, false, false
+	}
+	if inner {
+		return g.innerBraces(fields.Opening, fields.Closing)
+	}
+
+	// a lone type declaration goes with its type keyword
+	if decl != nil && !decl.Lparen.IsValid() {
+		return g.around(nodeStart(decl), decl.End())
+	}
+	return g.around(nodeStart(spec), spec.End())
+}
+
+func (g *goStructure) argumentObject(index int, inner bool) (int, int, bool, bool) {
+	var elements []ast.Node
+	inList := func(open token.Pos, close token.Pos) bool {
+		return open.IsValid() && g.index(open) < index && index <= g.index(close)
+	}
+	fieldNodes := func(fields *ast.FieldList) []ast.Node {
+		nodes := []ast.Node{}
+		for _, field := range fields.List {
+			nodes = append(nodes, field)
+		}
+		return nodes
+	}
+
+	g.enclosing(index, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.CallExpr:
+			if inList(node.Lparen, node.Rparen) {
+				elements = []ast.Node{}
+				for _, arg := range node.Args {
+					elements = append(elements, arg)
+				}
+			}
+		case *ast.FuncType:
+			for _, fields := range []*ast.FieldList{node.TypeParams, node.Params, node.Results} {
+				if fields != nil && inList(fields.Opening, fields.Closing) {
+					elements = fieldNodes(fields)
+				}
+			}
+		}
+	})
+
+	if len(elements) == 0 {
+		return 0, 0, false, false
+	}
+
+	// the argument under the cursor, or the one after the separator it is on
+	i := slices.IndexFunc(elements, func(element ast.Node) bool {
+		return index < g.index(element.End())
+	})
+	if i == -1 {
+		i = len(elements) - 1
+	}
+
+	start, end := g.index(elements[i].Pos()), g.index(elements[i].End())
+	if !inner {
+		if i+1 < len(elements) {
+			end = g.index(elements[i+1].Pos())
+		} else if i > 0 {
+			start = g.index(elements[i-1].End())
+		}
+	}
+	return start, end, false, true
+}
+
+// innerBraces is the text between two braces. When the braces sit on lines of
+// their own it is the whole lines between them.
+func (g *goStructure) innerBraces(open token.Pos, close token.Pos) (int, int, bool, bool) {
+	start, end := g.index(open)+1, g.index(close)
+
+	lineBreak := start
+	for lineBreak < end && (g.text[lineBreak] == ' ' || g.text[lineBreak] == '\t') {
+		lineBreak += 1
+	}
+	closeLine := end
+	for closeLine > start && (g.text[closeLine-1] == ' ' || g.text[closeLine-1] == '\t') {
+		closeLine -= 1
+	}
+
+	if lineBreak < end && g.text[lineBreak] == '\n' &&
+		closeLine > lineBreak && g.text[closeLine-1] == '\n' {
+		return lineBreak + 1, closeLine, true, true
+	}
+	return start, end, false, true
+}
+
+// around grows a node's range to whole lines when nothing else shares them.
+func (g *goStructure) around(from token.Pos, to token.Pos) (int, int, bool, bool) {
+	start, end := g.index(from), g.index(to)
+
+	lineStart := start
+	for lineStart > 0 && (g.text[lineStart-1] == ' ' || g.text[lineStart-1] == '\t') {
+		lineStart -= 1
+	}
+	lineEnd := end
+	for lineEnd < len(g.text) && (g.text[lineEnd] == ' ' || g.text[lineEnd] == '\t') {
+		lineEnd += 1
+	}
+
+	if (lineStart == 0 || g.text[lineStart-1] == '\n') &&
+		(lineEnd == len(g.text) || g.text[lineEnd] == '\n') {
+		return lineStart, min(lineEnd+1, len(g.text)), true, true
+	}
+	return start, end, false, true
+}
+
+func (g *goStructure) Folds() []FoldRange {
+	if g.file == nil {
+		return nil
+	}
+
+	folds := []FoldRange{}
+	add := func(from token.Pos, to token.Pos) {
+		fold := FoldRange{StartRow: g.row(from), EndRow: g.row(to)}
+		if fold.EndRow > fold.StartRow && !slices.Contains(folds, fold) {
+			folds = append(folds, fold)
+		}
+	}
+
+	for _, comment := range g.file.Comments {
+		add(comment.Pos(), comment.End()-1)
+	}
+
+	ast.Inspect(g.file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncDecl:
+			add(node.Pos(), node.End()-1)
+		case *ast.GenDecl:
+			if node.Lparen.IsValid() {
+				add(node.Pos(), node.Rparen)
+			}
+		case *ast.StructType:
+			add(node.Pos(), node.End()-1)
+		case *ast.InterfaceType:
+			add(node.Pos(), node.End()-1)
+		case *ast.BlockStmt:
+			add(node.Lbrace, node.Rbrace)
+		case *ast.CompositeLit:
+			add(node.Lbrace, node.Rbrace)
+		case *ast.CaseClause:
+			add(node.Pos(), node.End()-1)
+		case *ast.CommClause:
+			add(node.Pos(), node.End()-1)
+		}
+		return true
+	})
+
+	slices.SortFunc(folds, func(a FoldRange, b FoldRange) int {
+		if a.StartRow != b.StartRow {
+			return a.StartRow - b.StartRow
+		}
+		return b.EndRow - a.EndRow
+	})
+	return folds
+}
+
+func (g *goStructure) Outline() []Symbol {
+	if g.file == nil {
+		return nil
+	}
+
+	symbols := []Symbol{}
+	add := func(name *ast.Ident, label string, kind string, depth int) {
+		symbols = append(symbols, Symbol{
+			Name:  label,
+			Kind:  kind,
+			Index: g.index(name.Pos()),
+			Row:   g.row(name.Pos()),
+			Depth: depth,
+		})
+	}
+
+	for _, decl := range g.file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				receiver := types.ExprString(decl.Recv.List[0].Type)
+				add(decl.Name, "("+receiver+") "+decl.Name.Name, "method", 0)
+			} else {
+				add(decl.Name, decl.Name.Name, "func", 0)
+			}
+
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					g.outlineType(spec, add)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						add(name, name.Name, decl.Tok.String(), 0)
+					}
+				}
+			}
+		}
+	}
+
+	return symbols
+}
+
+func (g *goStructure) outlineType(
+	spec *ast.TypeSpec,
+	add func(name *ast.Ident, label string, kind string, depth int),
+) {
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		add(spec.Name, spec.Name.Name, "struct", 0)
+		for _, field := range t.Fields.List {
+			for _, name := range field.Names {
+				add(name, name.Name, "field", 1)
+			}
+		}
+	case *ast.InterfaceType:
+		add(spec.Name, spec.Name.Name, "interface", 0)
+		for _, method := range t.Methods.List {
+			for _, name := range method.Names {
+				add(name, name.Name, "method", 1)
+			}
+		}
+	default:
+		add(spec.Name, spec.Name.Name, "type", 0)
+	}
+}
+
+func init() {
+	RegisterStructureProvider("go", func() StructureProvider {
+		return &goStructure{}
+	})
+}
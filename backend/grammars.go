@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var grammars = map[string]*Grammar{}
+var grammarsMu sync.RWMutex
+
+// RegisterGrammar compiles a grammar and makes it available for its filetype,
+// replacing any grammar already registered under the same name.
+func RegisterGrammar(grammar *Grammar) error {
+	if err := grammar.compile(); err != nil {
+		return err
+	}
+
+	grammarsMu.Lock()
+	defer grammarsMu.Unlock()
+	grammars[grammar.Name] = grammar
+	return nil
+}
+
+func grammarFor(filetype string) *Grammar {
+	grammarsMu.RLock()
+	defer grammarsMu.RUnlock()
+	return grammars[filetype]
+}
+
+var loadUserGrammarsOnce sync.Once
+var userGrammarsErr error
+
+// loadUserGrammars registers every *.json grammar in the syntax directory next
+// to the config file. It only reads the directory once.
+func loadUserGrammars() error {
+	loadUserGrammarsOnce.Do(func() {
+		configPath := DefaultConfigPath()
+		if configPath == "" {
+			return
+		}
+
+		paths, err := filepath.Glob(filepath.Join(filepath.Dir(configPath), "syntax", "*.json"))
+		if err != nil {
+			userGrammarsErr = err
+			return
+		}
+
+		for _, path := range paths {
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				userGrammarsErr = err
+				return
+			}
+
+			grammar := &Grammar{}
+			if err := json.Unmarshal(raw, grammar); err != nil {
+				userGrammarsErr = fmt.Errorf("%s: %w", path, err)
+				return
+			}
+			if grammar.Name == "" {
+				grammar.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+			}
+			if err := RegisterGrammar(grammar); err != nil {
+				userGrammarsErr = fmt.Errorf("%s: %w", path, err)
+				return
+			}
+		}
+	})
+
+	return userGrammarsErr
+}
+
+const (
+	numberPattern = `\b(0[xX][0-9a-fA-F_]+|0[bB][01_]+|0[oO]?[0-7_]+|[0-9][0-9_]*(\.[0-9_]*)?([eE][-+]?[0-9]+)?i?)\b`
+)
+
+func stringContext(quote string, escapes bool) *GrammarContext {
+	rules := []Rule{}
+	if escapes {
+		rules = append(rules, Rule{Match: `\\.`, Class: "string.escape"})
+	}
+	rules = append(rules, Rule{Match: quote, Pop: true})
+	return &GrammarContext{Class: "string", Rules: rules}
+}
+
+var builtinGrammars = []*Grammar{
+	{
+		Name:       "go",
+		Extensions: []string{".go"},
+		Filenames:  []string{"go.mod", "go.work"},
+		Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{
+				{Match: `//.*$`, Class: "comment"},
+				{Match: `/\*`, Class: "comment", Push: "block_comment"},
+				{Match: `"`, Class: "string", Push: "string"},
+				{Match: "`", Class: "string", Push: "raw_string"},
+				{Match: `'(\\.|[^'\\])+'`, Class: "string"},
+				{Match: `\b(break|case|chan|const|continue|default|defer|else|fallthrough|for|func|go|goto|if|import|interface|map|package|range|return|select|struct|switch|type|var)\b`, Class: "keyword"},
+				{Match: `\b(bool|byte|complex64|complex128|error|float32|float64|int|int8|int16|int32|int64|rune|string|uint|uint8|uint16|uint32|uint64|uintptr|any|comparable)\b`, Class: "type"},
+				{Match: `\b(true|false|nil|iota)\b`, Class: "constant"},
+				{Match: `\b([A-Za-z_][A-Za-z0-9_]*)\(`, Captures: map[int]string{1: "function"}},
+				{Match: numberPattern, Class: "number"},
+			}},
+			"block_comment": {Class: "comment", Rules: []Rule{
+				{Match: `\*/`, Pop: true},
+			}},
+			"string":     stringContext(`"`, true),
+			"raw_string": stringContext("`", false),
+		},
+	},
+	{
+		Name:       "markdown",
+		Extensions: []string{".md", ".markdown", ".mkd"},
+		Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{
+				{Match: "^\\s*```", Class: "code", Push: "code_block"},
+				{Match: `^#{1,6}\s.*$`, Class: "heading"},
+				{Match: `^\s*>.*$`, Class: "comment"},
+				{Match: `^\s*([-*+]|[0-9]+\.)\s`, Class: "special"},
+				{Match: "`[^`]+`", Class: "code"},
+				{Match: `\*\*[^*]+\*\*|__[^_]+__`, Class: "strong"},
+				{Match: `\*[^*\s][^*]*\*|\b_[^_]+_\b`, Class: "emphasis"},
+				{Match: `!?\[[^\]]*\]\([^)]*\)`, Class: "link"},
+			}},
+			"code_block": {Class: "code", Rules: []Rule{
+				{Match: "^\\s*```\\s*$", Pop: true},
+			}},
+		},
+	},
+	{
+		Name:       "json",
+		Extensions: []string{".json", ".jsonc"},
+		Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{
+				{Match: `("(\\.|[^"\\])*")\s*:`, Captures: map[int]string{1: "keyword"}},
+				{Match: `"(\\.|[^"\\])*"`, Class: "string"},
+				{Match: `\b(true|false|null)\b`, Class: "constant"},
+				{Match: `-?\b[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?\b`, Class: "number"},
+				{Match: `//.*$`, Class: "comment"},
+			}},
+		},
+	},
+	{
+		Name:       "yaml",
+		Extensions: []string{".yaml", ".yml"},
+		Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{
+				{Match: `(^|\s)#.*$`, Class: "comment"},
+				{Match: `^(---|\.\.\.)\s*$`, Class: "special"},
+				{Match: `^\s*(-\s+)?([A-Za-z0-9_.\-/]+|"[^"]*"|'[^']*')\s*:(\s|$)`, Captures: map[int]string{2: "keyword"}},
+				{Match: `"(\\.|[^"\\])*"|'[^']*'`, Class: "string"},
+				{Match: `[&*][A-Za-z0-9_\-]+`, Class: "special"},
+				{Match: `\b(true|false|yes|no|on|off|null)\b|~`, Class: "constant"},
+				{Match: `\b-?[0-9]+(\.[0-9]+)?\b`, Class: "number"},
+			}},
+		},
+	},
+	{
+		Name:       "sh",
+		Extensions: []string{".sh", ".bash", ".zsh", ".ksh"},
+		Filenames:  []string{".bashrc", ".bash_profile", ".profile", ".zshrc"},
+		Shebangs:   []string{"sh", "bash", "zsh", "ksh", "dash"},
+		Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{
+				{Match: `(^|\s)#.*$`, Class: "comment"},
+				{Match: `"`, Class: "string", Push: "string"},
+				{Match: `'`, Class: "string", Push: "single_string"},
+				{Match: `\$\{[^}]*\}|\$[A-Za-z_][A-Za-z0-9_]*|\$[@#?$!*0-9-]`, Class: "special"},
+				{Match: `\b(if|then|else|elif|fi|for|while|until|do|done|case|esac|in|function|select|return|break|continue)\b`, Class: "keyword"},
+				{Match: `\b(echo|printf|read|cd|export|local|readonly|source|set|unset|shift|exit|eval|exec|test|trap)\b`, Class: "function"},
+				{Match: `\b[0-9]+\b`, Class: "number"},
+			}},
+			"string": {Class: "string", Rules: []Rule{
+				{Match: `\\.`, Class: "string.escape"},
+				{Match: `\$\{[^}]*\}|\$[A-Za-z_][A-Za-z0-9_]*|\$[@#?$!*0-9-]`, Class: "special"},
+				{Match: `"`, Pop: true},
+			}},
+			"single_string": stringContext(`'`, false),
+		},
+	},
+}
+
+func init() {
+	for _, grammar := range builtinGrammars {
+		if err := RegisterGrammar(grammar); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// grep finds the lines matching pattern in paths, searching directories
+// for files that .gitignore does not ignore. It runs ripgrep when that is
+// installed and searches in Go otherwise, with the same results either way
+// for patterns both understand. Paths that cannot be searched are reported
+// in the error, along with what was found in the others.
+func grep(pattern string, paths []string) ([]QuickfixItem, error) {
+	if rg, err := exec.LookPath("rg"); err == nil {
+		return ripgrep(rg, pattern, paths)
+	}
+	return grepFiles(pattern, paths)
+}
+
+func ripgrep(rg string, pattern string, paths []string) ([]QuickfixItem, error) {
+	args := []string{
+		"--no-config", "--line-number", "--column", "--with-filename", "--null",
+		"--no-heading", "--color=never", "--sort=path",
+		// search what grepFiles does: hidden files, and .gitignore even
+		// outside a repository
+		"--hidden", "--glob=!.git", "--no-require-git",
+		"--regexp", pattern, "--",
+	}
+	out, err := exec.Command(rg, append(args, paths...)...).Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// 1 is nothing found
+		if exitErr.ExitCode() == 1 {
+			return []QuickfixItem{}, nil
+		}
+		// anything else is an error, which need not have stopped it
+		// finding matches elsewhere
+		err = fmt.Errorf("rg: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		if len(out) == 0 {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	items := []QuickfixItem{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		// path NUL line:column:text, the column counted in bytes from 1
+		path, rest, ok := strings.Cut(line, "\x00")
+		if !ok {
+			continue
+		}
+		fields := strings.SplitN(rest, ":", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		row, _ := strconv.Atoi(fields[0])
+		col, _ := strconv.Atoi(fields[1])
+		text := strings.TrimSuffix(fields[2], "\r")
+		col = utf8.RuneCountInString(text[:max(0, min(col-1, len(text)))])
+		items = append(items, QuickfixItem{Path: filepath.Clean(path), Row: row - 1, Col: col, Text: text})
+	}
+	return items, err
+}
+
+// grepFiles is grep without ripgrep.
+func grepFiles(pattern string, paths []string) ([]QuickfixItem, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	items, errs := []QuickfixItem{}, []error{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !info.IsDir() {
+			items = append(items, grepFile(re, filepath.Clean(path))...)
+			continue
+		}
+
+		files, err := projectFiles(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, file := range files {
+			items = append(items, grepFile(re, filepath.Join(path, filepath.FromSlash(file)))...)
+		}
+	}
+	return items, errors.Join(errs...)
+}
+
+// grepFile lists the lines in file that match, at the first match. Binary
+// files are skipped as ripgrep skips them.
+func grepFile(re *regexp.Regexp, file string) []QuickfixItem {
+	data, err := os.ReadFile(file)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+		return nil
+	}
+
+	items := []QuickfixItem{}
+	for row, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if match := re.FindStringIndex(line); match != nil {
+			col := utf8.RuneCountInString(line[:match[0]])
+			items = append(items, QuickfixItem{Path: file, Row: row, Col: col, Text: line})
+		}
+	}
+	return items
+}
+
+// splitArgs splits command arguments on spaces. Quotes group words, and a
+// backslash keeps a space or quote from being special. Other backslashes
+// are kept, for patterns.
+func splitArgs(args string) ([]string, error) {
+	words, current := []string{}, []rune{}
+	inWord := false
+	var quote rune
+	runes := []rune(args)
+	for i := 0; i < len(runes); i += 1 {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current = append(current, r)
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune(` "'`, runes[i+1]):
+			current, inWord = append(current, runes[i+1]), true
+			i += 1
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words, current, inWord = append(words, string(current)), []rune{}, false
+			}
+		default:
+			current, inWord = append(current, r), true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing quote: %c", quote)
+	}
+	if inWord {
+		words = append(words, string(current))
+	}
+	return words, nil
+}
@@ -0,0 +1,334 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// contexts nested deeper than this are assumed to be a grammar bug
+const maxContextDepth = 32
+
+// Rule is one pattern of a grammar context. Class styles the whole match and
+// Captures style individual groups. After matching, Push enters a context and
+// Pop leaves the current one.
+type Rule struct {
+	Match    string         `json:"match"`
+	Class    string         `json:"class,omitempty"`
+	Captures map[int]string `json:"captures,omitempty"`
+	Push     string         `json:"push,omitempty"`
+	Pop      bool           `json:"pop,omitempty"`
+
+	re *regexp.Regexp
+	// patterns starting with ^ can only match at the start of a line
+	lineStart bool
+}
+
+// GrammarContext is a state of the highlighter. Text that no rule matches is
+// given the context's class.
+type GrammarContext struct {
+	Class string `json:"class,omitempty"`
+	Rules []Rule `json:"rules"`
+}
+
+// Grammar describes how to highlight one filetype. Highlighting starts in the
+// "main" context.
+type Grammar struct {
+	Name       string                     `json:"name"`
+	Extensions []string                   `json:"extensions,omitempty"`
+	Filenames  []string                   `json:"filenames,omitempty"`
+	Shebangs   []string                   `json:"shebangs,omitempty"`
+	Contexts   map[string]*GrammarContext `json:"contexts"`
+}
+
+func (grammar *Grammar) compile() error {
+	if _, ok := grammar.Contexts["main"]; !ok {
+		return fmt.Errorf("grammar %s: missing main context", grammar.Name)
+	}
+
+	for name, context := range grammar.Contexts {
+		for i := range context.Rules {
+			rule := &context.Rules[i]
+
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return fmt.Errorf("grammar %s, context %s: %w", grammar.Name, name, err)
+			}
+			rule.re = re
+			rule.lineStart = strings.HasPrefix(rule.Match, "^")
+
+			if rule.Push != "" {
+				if _, ok := grammar.Contexts[rule.Push]; !ok {
+					return fmt.Errorf(
+						"grammar %s, context %s: push to unknown context %s",
+						grammar.Name, name, rule.Push,
+					)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Span styles the runes in [Start, End) of a line.
+type Span struct {
+	Start int
+	End   int
+	Class string
+}
+
+// highlightState is the context stack between lines, joined with "/" so it
+// can be compared cheaply.
+type highlightState string
+
+const initialHighlightState highlightState = "main"
+
+func (state highlightState) stack() []string {
+	return strings.Split(string(state), "/")
+}
+
+func stateOf(stack []string) highlightState {
+	return highlightState(strings.Join(stack, "/"))
+}
+
+// highlightLine tokenizes one line starting in state, returning the spans and
+// the state the next line starts in.
+func (grammar *Grammar) highlightLine(line []rune, state highlightState) ([]Span, highlightState) {
+	text := string(line)
+	stack := state.stack()
+	spans := []Span{}
+
+	// spans are built on byte offsets then converted to rune columns
+	add := func(start int, end int, class string) {
+		if start >= end || class == "" {
+			return
+		}
+		spans = append(spans, Span{
+			Start: utf8.RuneCountInString(text[:start]),
+			End:   utf8.RuneCountInString(text[:end]),
+			Class: class,
+		})
+	}
+
+	pos := 0
+	for pos <= len(text) {
+		context := grammar.Contexts[stack[len(stack)-1]]
+
+		var best *Rule
+		var bestMatch []int
+		for i := range context.Rules {
+			rule := &context.Rules[i]
+			if rule.lineStart && pos > 0 {
+				continue
+			}
+
+			match := rule.re.FindStringSubmatchIndex(text[pos:])
+			if match != nil && (bestMatch == nil || match[0] < bestMatch[0]) {
+				best, bestMatch = rule, match
+			}
+		}
+
+		if best == nil {
+			add(pos, len(text), context.Class)
+			break
+		}
+
+		matchStart, matchEnd := pos+bestMatch[0], pos+bestMatch[1]
+		add(pos, matchStart, context.Class)
+
+		class := best.Class
+		if class == "" {
+			class = context.Class
+		}
+		captured := pos + bestMatch[0]
+		for group := 1; group*2 < len(bestMatch); group += 1 {
+			groupClass, ok := best.Captures[group]
+			if !ok || bestMatch[group*2] < 0 {
+				continue
+			}
+			groupStart, groupEnd := pos+bestMatch[group*2], pos+bestMatch[group*2+1]
+			if groupStart < captured {
+				continue
+			}
+			add(captured, groupStart, class)
+			add(groupStart, groupEnd, groupClass)
+			captured = groupEnd
+		}
+		add(captured, matchEnd, class)
+
+		moved := len(stack)
+		if best.Pop && len(stack) > 1 {
+			stack = stack[:len(stack)-1]
+		}
+		if best.Push != "" && len(stack) < maxContextDepth {
+			stack = append(stack, best.Push)
+		}
+
+		if matchEnd > pos {
+			pos = matchEnd
+		} else if moved == len(stack) || pos == len(text) {
+			// an empty match that changed nothing, step over a rune so we
+			// always make progress
+			if pos == len(text) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(text[pos:])
+			add(pos, pos+size, context.Class)
+			pos += size
+		}
+	}
+
+	return spans, stateOf(stack)
+}
+
+type lineHighlight struct {
+	text  string
+	start highlightState
+	end   highlightState
+	spans []Span
+}
+
+// highlighter caches highlighted lines for a content. Lines are re-highlighted
+// from the first edited line until the state at the start of a line matches
+// the cached state again.
+type highlighter struct {
+	grammar *Grammar
+	lines   []lineHighlight
+
+	// lines before valid are known to be up to date
+	valid int
+	// lines after dirtyEnd have not been edited since they were highlighted
+	dirtyEnd int
+	// content version the cache was last told about
+	version int
+}
+
+func (content *Content) highlighterFor(grammar *Grammar) *highlighter {
+	if content.highlighter == nil {
+		content.highlighter = &highlighter{version: content.Version}
+		content.OnEdit(func(edit Edit) {
+			content.highlighter.edited(edit, content.Version)
+		})
+	}
+
+	if content.highlighter.grammar != grammar {
+		content.highlighter.grammar = grammar
+		content.highlighter.lines = nil
+		content.highlighter.valid = 0
+	}
+
+	return content.highlighter
+}
+
+func (h *highlighter) edited(edit Edit, version int) {
+	removed := edit.EndRow - edit.StartRow
+	added := strings.Count(string(edit.Inserted), "\n")
+
+	if edit.StartRow < len(h.lines) {
+		end := min(len(h.lines), edit.StartRow+1+removed)
+		h.lines = slices.Replace(
+			h.lines,
+			edit.StartRow+1,
+			max(edit.StartRow+1, end),
+			make([]lineHighlight, added)...,
+		)
+		h.lines[edit.StartRow] = lineHighlight{}
+	}
+
+	if h.dirtyEnd > edit.StartRow {
+		h.dirtyEnd += added - removed
+	}
+	h.dirtyEnd = max(h.dirtyEnd, edit.StartRow+added)
+	h.valid = min(h.valid, edit.StartRow)
+
+	// edits we did not see mean nothing in the cache can be trusted
+	if h.version+1 != version {
+		h.valid = 0
+		h.dirtyEnd = len(h.lines)
+	}
+	h.version = version
+}
+
+// update makes sure lines up to and including last are highlighted.
+func (h *highlighter) update(lines [][]rune, last int, version int) {
+	if h.version != version {
+		h.valid = 0
+		h.dirtyEnd = len(lines)
+		h.version = version
+	}
+
+	if len(h.lines) > len(lines) {
+		h.lines = h.lines[:len(lines)]
+	}
+	for len(h.lines) < len(lines) {
+		h.lines = append(h.lines, lineHighlight{})
+	}
+
+	last = min(last, len(lines)-1)
+	h.valid = min(h.valid, len(lines))
+	state := initialHighlightState
+	if h.valid > 0 {
+		state = h.lines[h.valid-1].end
+	}
+
+	for i := h.valid; i <= last; i += 1 {
+		text := string(lines[i])
+		cached := &h.lines[i]
+
+		if cached.start == state && cached.text == text && cached.end != "" {
+			if i > h.dirtyEnd {
+				// the state converged on an untouched line, everything
+				// highlighted after it is still correct
+				for i+1 < len(h.lines) && h.lines[i+1].end != "" {
+					i += 1
+				}
+			}
+			state = h.lines[i].end
+			continue
+		}
+
+		spans, end := h.grammar.highlightLine(lines[i], state)
+		*cached = lineHighlight{text: text, start: state, end: end, spans: spans}
+		state = end
+	}
+
+	h.valid = max(h.valid, last+1)
+	if h.valid >= h.dirtyEnd {
+		h.dirtyEnd = -1
+	}
+}
+
+// lineSpans returns the highlight spans for the given rows, highlighting as
+// much of the content as needed.
+func (editor *Editor) lineSpans(lines [][]rune, first int, last int) [][]Span {
+	grammar := editor.grammar()
+	spans := make([][]Span, max(0, last-first+1))
+	if grammar == nil {
+		return spans
+	}
+
+	h := editor.Content.highlighterFor(grammar)
+	h.update(lines, last, editor.Content.Version)
+
+	for row := first; row <= last && row < len(h.lines); row += 1 {
+		spans[row-first] = h.lines[row].spans
+	}
+	return spans
+}
+
+func (editor *Editor) grammar() *Grammar {
+	if !editor.OptionBool("syntax") {
+		return nil
+	}
+	return grammarFor(editor.OptionString("filetype"))
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "syntax", Short: "syn", Kind: BoolOption, Scope: BufferScope,
+		Default: OptionValue{Bool: true},
+	})
+}
@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// classAt returns the class of the rune at col in row of the editor's view.
+func classAt(editor *Editor, row int, col int) string {
+	lines := editor.Content.lines()
+	spans := editor.lineSpans(lines, 0, len(lines)-1)
+	for _, span := range spans[row] {
+		if span.Start <= col && col < span.End {
+			return span.Class
+		}
+	}
+	return ""
+}
+
+func TestHighlightGo(t *testing.T) {
+	editor := newTestEditor("package main\n\nfunc f() int { return 0x1f } // done")
+	editor.SetOption("filetype", OptionValue{String: "go"}, setLocal)
+
+	expected := []struct {
+		row   int
+		col   int
+		class string
+	}{
+		{0, 0, "keyword"},
+		{0, 8, ""},
+		{2, 0, "keyword"},
+		{2, 5, "function"},
+		{2, 9, "type"},
+		{2, 15, "keyword"},
+		{2, 22, "number"},
+		{2, 30, "comment"},
+	}
+	for _, e := range expected {
+		if class := classAt(editor, e.row, e.col); class != e.class {
+			t.Errorf("row %d col %d: expected %q, got %q", e.row, e.col, e.class, class)
+		}
+	}
+
+	view := editor.View()
+	if view[0].Cells[0].Class != "keyword" {
+		t.Errorf("expected view cells to carry classes, got %+v", view[0].Cells[0])
+	}
+}
+
+func TestHighlightIncremental(t *testing.T) {
+	editor := newTestEditor("a := 1\nb := 2\nc := 3\nd := 4")
+	editor.SetOption("filetype", OptionValue{String: "go"}, setLocal)
+
+	if class := classAt(editor, 3, 0); class != "" {
+		t.Fatalf("expected plain text, got %q", class)
+	}
+
+	// opening a block comment changes the state of every following line
+	editor.Content.replace([]rune("/*"), 0, 0)
+	for row := 0; row < 4; row += 1 {
+		if class := classAt(editor, row, 3); class != "comment" {
+			t.Fatalf("row %d: expected comment, got %q", row, class)
+		}
+	}
+
+	// closing it on the second line restores the lines after it
+	second := editor.Content.lineStart(1)
+	editor.Content.replace([]rune("*/"), second, second)
+	if class := classAt(editor, 1, 0); class != "comment" {
+		t.Fatalf("expected comment before */, got %q", class)
+	}
+	if class := classAt(editor, 2, 5); class != "number" {
+		t.Fatalf("expected number after the comment closed, got %q", class)
+	}
+}
+
+func TestDetectFiletype(t *testing.T) {
+	tests := []struct {
+		path      string
+		firstLine string
+		expected  string
+	}{
+		{"main.go", "", "go"},
+		{"/x/README.md", "", "markdown"},
+		{"config.YML", "", "yaml"},
+		{"go.mod", "", "go"},
+		{"script", "#!/usr/bin/env bash", "sh"},
+		{"script", "#!/bin/sh -e", "sh"},
+		{"notes", "hello", ""},
+	}
+
+	for _, test := range tests {
+		if filetype := DetectFiletype(test.path, test.firstLine); filetype != test.expected {
+			t.Errorf("%s %q: expected %q, got %q", test.path, test.firstLine, test.expected, filetype)
+		}
+	}
+}
+
+func TestFiletypeOnLoad(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	path := filepath.Join(t.TempDir(), "run")
+	writeTestFile(t, path, "#!/bin/bash\n# vim: ft=yaml\n")
+
+	// modelines override detection
+	editor := InitializeEditor(path, 24, 80)
+	if filetype := editor.OptionString("filetype"); filetype != "yaml" {
+		t.Fatalf("expected yaml, got %q", filetype)
+	}
+}
+
+func TestGrammarCompileErrors(t *testing.T) {
+	bad := []*Grammar{
+		{Name: "nomain", Contexts: map[string]*GrammarContext{}},
+		{Name: "badre", Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{{Match: `(`}}},
+		}},
+		{Name: "badpush", Contexts: map[string]*GrammarContext{
+			"main": {Rules: []Rule{{Match: `x`, Push: "missing"}}},
+		}},
+	}
+
+	for _, grammar := range bad {
+		if err := RegisterGrammar(grammar); err == nil {
+			t.Errorf("expected an error registering %s", grammar.Name)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package backend
+
+import (
+	"slices"
+	"strings"
+)
+
+type indentRules struct {
+	// last non blank character of a line that indents the next line
+	openers string
+	// first character typed on a blank line that dedents it
+	closers string
+}
+
+var smartIndentRules = map[string]indentRules{
+	"":         {openers: "{[(", closers: "}])"},
+	"python":   {openers: ":{[(", closers: "}])"},
+	"yaml":     {openers: ":"},
+	"markdown": {},
+	"text":     {},
+}
+
+func (editor *Editor) indentRules() indentRules {
+	if rules, ok := smartIndentRules[editor.OptionString("filetype")]; ok {
+		return rules
+	}
+	return smartIndentRules[""]
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "autoindent", Short: "ai", Kind: BoolOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "smartindent", Short: "si", Kind: BoolOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "softtabstop", Short: "sts", Kind: NumberOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "detectindent", Kind: BoolOption, Scope: GlobalScope,
+		Default: OptionValue{Bool: true},
+	})
+
+	registerAction("insert_tab", func(editor *Editor, key KeyStroke) {
+		if editor.expandSnippet() || editor.jumpSnippet(1) {
+			return
+		}
+		editor.InsertTab()
+	})
+	registerAction("open_below", func(editor *Editor, key KeyStroke) {
+		editor.OpenLine(false)
+	})
+	registerAction("open_above", func(editor *Editor, key KeyStroke) {
+		editor.OpenLine(true)
+	})
+
+	bindDefault(Insert, "<Tab>", "insert_tab")
+	bindDefault(Normal, "o", "open_below")
+	bindDefault(Normal, "O", "open_above")
+}
+
+// shiftWidth is the width of one indentation level, a shiftwidth of zero
+// means use tabstop.
+func (editor *Editor) shiftWidth() int {
+	if sw := editor.OptionInt("shiftwidth"); sw > 0 {
+		return sw
+	}
+	return max(1, editor.OptionInt("tabstop"))
+}
+
+// softTabStop is the width a tab or backspace in leading whitespace moves,
+// zero when softtabstop is off and negative values mean use shiftwidth.
+func (editor *Editor) softTabStop() int {
+	sts := editor.OptionInt("softtabstop")
+	if sts < 0 {
+		return editor.shiftWidth()
+	}
+	return sts
+}
+
+func leadingWhitespace(line []rune) []rune {
+	end := 0
+	for end < len(line) && (line[end] == ' ' || line[end] == '\t') {
+		end += 1
+	}
+	return line[:end]
+}
+
+// whitespaceFill builds the whitespace that spans display columns from to to,
+// using tabs where expandtab allows it.
+func (editor *Editor) whitespaceFill(from int, to int) []rune {
+	fill := []rune{}
+	if to <= from {
+		return fill
+	}
+
+	tabstop := max(1, editor.OptionInt("tabstop"))
+	col := from
+	if !editor.OptionBool("expandtab") {
+		for next := (col/tabstop + 1) * tabstop; next <= to; next += tabstop {
+			fill = append(fill, '\t')
+			col = next
+		}
+	}
+	for ; col < to; col += 1 {
+		fill = append(fill, ' ')
+	}
+
+	return fill
+}
+
+// newlineIndent is the indent a line opened after line should get.
+func (editor *Editor) newlineIndent(line []rune) []rune {
+	if !editor.OptionBool("autoindent") && !editor.OptionBool("smartindent") {
+		return []rune{}
+	}
+
+	indent := slices.Clone(leadingWhitespace(line))
+	if !editor.OptionBool("smartindent") {
+		return indent
+	}
+
+	trimmed := strings.TrimRight(string(line), " \t")
+	if trimmed == "" {
+		return indent
+	}
+
+	last := []rune(trimmed)[len([]rune(trimmed))-1]
+	if strings.ContainsRune(editor.indentRules().openers, last) {
+		width := len(editor.displayCells(indent, 0))
+		indent = editor.whitespaceFill(0, width+editor.shiftWidth())
+	}
+
+	return indent
+}
+
+// currentLine returns the line the cursor is on and the index it starts at.
+func (editor *Editor) currentLine() ([]rune, int) {
+	lines := editor.Content.lines()
+	row := min(editor.Cursor.Row, len(lines)-1)
+	return lines[row], editor.Content.lineStart(row)
+}
+
+// InsertNewline breaks the line at the cursor, indenting the new line.
+func (editor *Editor) InsertNewline() {
+	line, start := editor.currentLine()
+	col := editor.Cursor.Index - start
+
+	indent := editor.newlineIndent(line[:col])
+
+	// whitespace left at the cursor would end up before the new indent
+	end := col
+	if len(indent) > 0 {
+		for end < len(line) && (line[end] == ' ' || line[end] == '\t') {
+			end += 1
+		}
+	}
+
+	text := append([]rune{'\n'}, indent...)
+	editor.Content.replace(text, editor.Cursor.Index, start+end)
+	editor.moveCursorTo(editor.Cursor.Index + len(text))
+}
+
+// OpenLine starts a new indented line below, or above, the cursor line and
+// enters insert mode on it.
+func (editor *Editor) OpenLine(above bool) {
+	line, start := editor.currentLine()
+
+	if above {
+		indent := slices.Clone(leadingWhitespace(line))
+		if !editor.OptionBool("autoindent") && !editor.OptionBool("smartindent") {
+			indent = []rune{}
+		}
+		editor.Content.replace(append(indent, '\n'), start, start)
+		editor.moveCursorTo(start + len(indent))
+	} else {
+		indent := editor.newlineIndent(line)
+		end := start + len(line)
+		editor.Content.replace(append([]rune{'\n'}, indent...), end, end)
+		editor.moveCursorTo(end + 1 + len(indent))
+	}
+
+	editor.Mode = Insert
+}
+
+// typeRune inserts a rune typed in insert mode. Unlike InsertRune it applies
+// smartindent, dedenting a line that starts with a closing bracket.
+func (editor *Editor) typeRune(r rune) {
+	editor.replaceSelectedPlaceholder()
+
+	if !editor.OptionBool("smartindent") ||
+		!strings.ContainsRune(editor.indentRules().closers, r) {
+		editor.InsertRune(r)
+		return
+	}
+
+	line, start := editor.currentLine()
+	col := editor.Cursor.Index - start
+	indent := leadingWhitespace(line)
+
+	if col != len(indent) || len(indent) == 0 {
+		editor.InsertRune(r)
+		return
+	}
+
+	width := len(editor.displayCells(indent, 0))
+	newWidth := max(0, (width-1)/editor.shiftWidth()*editor.shiftWidth())
+	text := append(editor.whitespaceFill(0, newWidth), r)
+
+	editor.Content.replace(text, start, start+len(indent))
+	editor.moveCursorTo(start + len(text))
+}
+
+// blanksBeforeCursor finds the run of spaces and tabs directly before the
+// cursor, returning its start index and display columns.
+func (editor *Editor) blanksBeforeCursor() (int, int, int) {
+	line, start := editor.currentLine()
+	col := editor.Cursor.Index - start
+
+	runStart := col
+	for runStart > 0 && (line[runStart-1] == ' ' || line[runStart-1] == '\t') {
+		runStart -= 1
+	}
+
+	from := len(editor.displayCells(line[:runStart], 0))
+	to := len(editor.displayCells(line[:col], 0))
+	return start + runStart, from, to
+}
+
+// InsertTab inserts a tab, or the whitespace softtabstop and expandtab ask for
+// in its place.
+func (editor *Editor) InsertTab() {
+	sts := editor.softTabStop()
+	if sts == 0 && !editor.OptionBool("expandtab") {
+		editor.InsertRune('\t')
+		return
+	}
+
+	stop := sts
+	if stop == 0 {
+		stop = max(1, editor.OptionInt("tabstop"))
+	}
+
+	runStart, from, to := editor.blanksBeforeCursor()
+	fill := editor.whitespaceFill(from, (to/stop+1)*stop)
+
+	editor.Content.replace(fill, runStart, editor.Cursor.Index)
+	editor.moveCursorTo(runStart + len(fill))
+}
+
+// softBackspace deletes back to the previous softtabstop when the cursor is
+// after whitespace. It reports false when a plain backspace should be used.
+func (editor *Editor) softBackspace() bool {
+	sts := editor.softTabStop()
+	if sts == 0 {
+		return false
+	}
+
+	runStart, from, to := editor.blanksBeforeCursor()
+	if to == from {
+		return false
+	}
+
+	target := max(from, (to-1)/sts*sts)
+	fill := editor.whitespaceFill(from, target)
+
+	editor.Content.replace(fill, runStart, editor.Cursor.Index)
+	editor.moveCursorTo(runStart + len(fill))
+	return true
+}
+
+// lines sampled when guessing the indentation of a file
+const detectIndentLines = 1000
+
+// detectIndent guesses whether lines are indented with tabs or spaces and, for
+// spaces, how many make up one level. ok is false when there is too little
+// indentation to tell.
+func detectIndent(lines [][]rune) (expandtab bool, width int, ok bool) {
+	tabs, spaces := 0, 0
+	deltas := map[int]int{}
+	previous := 0
+
+	for _, line := range lines[:min(len(lines), detectIndentLines)] {
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+
+		indent := leadingWhitespace(line)
+		if len(indent) > 0 && indent[0] == '\t' {
+			tabs += 1
+			previous = -1
+			continue
+		}
+
+		count := len(indent)
+		if count > 0 {
+			spaces += 1
+		}
+
+		// a difference of one is usually a " *" comment continuation
+		if previous >= 0 && count-previous >= 2 && count-previous <= 8 {
+			deltas[count-previous] += 1
+		}
+		previous = count
+	}
+
+	if tabs == 0 && spaces == 0 {
+		return false, 0, false
+	}
+	if tabs >= spaces {
+		return false, 0, true
+	}
+
+	for delta, seen := range deltas {
+		if seen > deltas[width] || (seen == deltas[width] && delta < width) {
+			width = delta
+		}
+	}
+	if width == 0 {
+		return false, 0, false
+	}
+	return true, width, true
+}
+
+// applyDetectedIndent sets the buffer's indentation options from the style
+// already used in the file.
+func (editor *Editor) applyDetectedIndent() error {
+	if !editor.OptionBool("detectindent") {
+		return nil
+	}
+
+	expandtab, width, ok := detectIndent(editor.Content.lines())
+	if !ok {
+		return nil
+	}
+
+	if err := editor.SetOption("expandtab", OptionValue{Bool: expandtab}, setLocal); err != nil {
+		return err
+	}
+	return editor.SetOption("shiftwidth", OptionValue{Number: width}, setLocal)
+}
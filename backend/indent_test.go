@@ -0,0 +1,67 @@
+package backend
+
+import "testing"
+
+func expectContent(t *testing.T, editor *Editor, expected string) {
+	t.Helper()
+
+	if final := string(editor.GetContent()); final != expected {
+		t.Fatalf("\nFinal String: %q\nExpected String: %q", final, expected)
+	}
+}
+
+func TestAutoindent(t *testing.T) {
+	editor := newTestEditor("\tfoo")
+	editor.ExecuteCommand("set autoindent")
+	editor.moveCursorTo(editor.Content.Length)
+
+	typeKeys(t, editor, "i<CR>bar<Esc>")
+	expectContent(t, editor, "\tfoo\n\tbar")
+
+	// off by default, as in vim
+	editor = newTestEditor("\tfoo")
+	editor.moveCursorTo(editor.Content.Length)
+	typeKeys(t, editor, "i<CR>bar<Esc>")
+	expectContent(t, editor, "\tfoo\nbar")
+}
+
+func TestSmartindent(t *testing.T) {
+	editor := newTestEditor("func main() {")
+	editor.ExecuteCommand("set si sw=4 ts=4 et")
+	editor.moveCursorTo(editor.Content.Length)
+
+	typeKeys(t, editor, "i<CR>x<CR>}<Esc>")
+	expectContent(t, editor, "func main() {\n    x\n}")
+}
+
+func TestOpenLine(t *testing.T) {
+	editor := newTestEditor("  foo")
+	editor.ExecuteCommand("set autoindent")
+
+	typeKeys(t, editor, "obar<Esc>")
+	expectContent(t, editor, "  foo\n  bar")
+
+	typeKeys(t, editor, "Obaz<Esc>")
+	expectContent(t, editor, "  foo\n  baz\n  bar")
+}
+
+func TestSoftTabStop(t *testing.T) {
+	editor := newTestEditor("")
+	editor.ExecuteCommand("set sts=4 ts=8 noet")
+
+	typeKeys(t, editor, "i<Tab>")
+	expectContent(t, editor, "    ")
+
+	typeKeys(t, editor, "<Tab>")
+	expectContent(t, editor, "\t")
+
+	typeKeys(t, editor, "<BS>")
+	expectContent(t, editor, "    ")
+
+	typeKeys(t, editor, "<BS>")
+	expectContent(t, editor, "")
+
+	editor.ExecuteCommand("set et sts=0 ts=2")
+	typeKeys(t, editor, "<Tab>x")
+	expectContent(t, editor, "  x")
+}
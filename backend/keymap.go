@@ -0,0 +1,494 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// mappings may feed keys that are themselves mapped, this bounds how deep that
+// is allowed to go before we assume the mapping is recursive
+const maxMapDepth = 100
+
+// KeyStroke is a single key press, normalized so that two presses of the same
+// key compare equal regardless of how the terminal reported them.
+type KeyStroke struct {
+	Key  tcell.Key
+	Rune rune
+	Mod  tcell.ModMask
+}
+
+func NewKeyStroke(key tcell.Key, r rune, mod tcell.ModMask) KeyStroke {
+	switch {
+	case key == tcell.KeyRune:
+		// shift is already part of the rune
+		mod &= tcell.ModAlt
+	case key < tcell.KeyRune:
+		// control characters already carry their modifier
+		r = 0
+		mod = tcell.ModNone
+	default:
+		r = 0
+	}
+
+	return KeyStroke{Key: key, Rune: r, Mod: mod}
+}
+
+var namedKeys = map[string]tcell.Key{
+	"esc":      tcell.KeyEscape,
+	"cr":       tcell.KeyEnter,
+	"enter":    tcell.KeyEnter,
+	"return":   tcell.KeyEnter,
+	"bs":       tcell.KeyBackspace2,
+	"tab":      tcell.KeyTab,
+	"s-tab":    tcell.KeyBacktab,
+	"del":      tcell.KeyDelete,
+	"insert":   tcell.KeyInsert,
+	"up":       tcell.KeyUp,
+	"down":     tcell.KeyDown,
+	"left":     tcell.KeyLeft,
+	"right":    tcell.KeyRight,
+	"home":     tcell.KeyHome,
+	"end":      tcell.KeyEnd,
+	"pageup":   tcell.KeyPgUp,
+	"pagedown": tcell.KeyPgDn,
+}
+
+var namedRunes = map[string]rune{
+	"space":  ' ',
+	"lt":     '<',
+	"bar":    '|',
+	"bslash": '\\',
+}
+
+// ParseKeys turns vim style key notation, e.g. "gg", "<C-w>j" or "<Esc>", into
+// a sequence of key strokes.
+func ParseKeys(notation string) ([]KeyStroke, error) {
+	keys := []KeyStroke{}
+
+	for len(notation) > 0 {
+		if notation[0] == '<' {
+			if end := strings.IndexByte(notation, '>'); end > 1 {
+				key, err := parseKeyName(notation[1:end])
+				if err == nil {
+					keys = append(keys, key)
+					notation = notation[end+1:]
+					continue
+				}
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(notation)
+		keys = append(keys, NewKeyStroke(tcell.KeyRune, r, tcell.ModNone))
+		notation = notation[size:]
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+
+	return keys, nil
+}
+
+func parseKeyName(name string) (KeyStroke, error) {
+	lower := strings.ToLower(name)
+
+	if key, ok := namedKeys[lower]; ok {
+		return NewKeyStroke(key, 0, tcell.ModNone), nil
+	}
+	if r, ok := namedRunes[lower]; ok {
+		return NewKeyStroke(tcell.KeyRune, r, tcell.ModNone), nil
+	}
+
+	var f int
+	if _, err := fmt.Sscanf(lower, "f%d", &f); err == nil && f >= 1 && f <= 12 {
+		return NewKeyStroke(tcell.KeyF1+tcell.Key(f-1), 0, tcell.ModNone), nil
+	}
+
+	if len(lower) > 2 && lower[1] == '-' {
+		rest := name[2:]
+		switch lower[0] {
+		case 'c':
+			if len(rest) == 1 {
+				c := strings.ToLower(rest)[0]
+				if c >= 'a' && c <= 'z' {
+					return NewKeyStroke(tcell.KeyCtrlA+tcell.Key(c-'a'), 0, tcell.ModNone), nil
+				}
+			}
+			if key, ok := namedKeys[strings.ToLower(rest)]; ok {
+				return NewKeyStroke(key, 0, tcell.ModCtrl), nil
+			}
+		case 'm', 'a':
+			if r, size := utf8.DecodeRuneInString(rest); size == len(rest) {
+				return NewKeyStroke(tcell.KeyRune, r, tcell.ModAlt), nil
+			}
+		case 's':
+			if key, ok := namedKeys[strings.ToLower(rest)]; ok {
+				return NewKeyStroke(key, 0, tcell.ModShift), nil
+			}
+		}
+	}
+
+	return KeyStroke{}, fmt.Errorf("unknown key <%s>", name)
+}
+
+// FormatKeys is the inverse of ParseKeys.
+func FormatKeys(keys []KeyStroke) string {
+	var builder strings.Builder
+
+	for _, key := range keys {
+		builder.WriteString(formatKey(key))
+	}
+
+	return builder.String()
+}
+
+func formatKey(key KeyStroke) string {
+	if key.Key == tcell.KeyRune {
+		switch {
+		case key.Mod&tcell.ModAlt != 0:
+			return "<M-" + string(key.Rune) + ">"
+		case key.Rune == ' ':
+			return "<Space>"
+		case key.Rune == '<':
+			return "<lt>"
+		}
+		return string(key.Rune)
+	}
+
+	if key.Key >= tcell.KeyCtrlA && key.Key <= tcell.KeyCtrlZ &&
+		key.Key != tcell.KeyTab && key.Key != tcell.KeyEnter &&
+		key.Key != tcell.KeyBackspace {
+		return fmt.Sprintf("<C-%c>", 'a'+rune(key.Key-tcell.KeyCtrlA))
+	}
+
+	prefix := ""
+	if key.Mod&tcell.ModCtrl != 0 {
+		prefix = "C-"
+	} else if key.Mod&tcell.ModShift != 0 {
+		prefix = "S-"
+	}
+
+	for _, name := range []string{
+		"Esc", "CR", "BS", "Tab", "S-Tab", "Del", "Insert", "Up", "Down",
+		"Left", "Right", "Home", "End", "PageUp", "PageDown",
+	} {
+		if namedKeys[strings.ToLower(name)] == key.Key {
+			return "<" + prefix + name + ">"
+		}
+	}
+
+	if key.Key >= tcell.KeyF1 && key.Key <= tcell.KeyF12 {
+		return fmt.Sprintf("<%sF%d>", prefix, key.Key-tcell.KeyF1+1)
+	}
+
+	return fmt.Sprintf("<%s%d>", prefix, key.Key)
+}
+
+// Mapping is what a key sequence resolves to: either a named action or another
+// key sequence to be fed back through the keymap.
+type Mapping struct {
+	Action  string
+	Keys    []KeyStroke
+	Noremap bool
+}
+
+func (mapping *Mapping) String() string {
+	if mapping.Action != "" {
+		return mapping.Action
+	}
+
+	if mapping.Noremap {
+		return "* " + FormatKeys(mapping.Keys)
+	}
+	return FormatKeys(mapping.Keys)
+}
+
+type keyNode struct {
+	children map[KeyStroke]*keyNode
+	mapping  *Mapping
+}
+
+func newKeyNode() *keyNode {
+	return &keyNode{children: make(map[KeyStroke]*keyNode)}
+}
+
+func (node *keyNode) insert(keys []KeyStroke, mapping *Mapping) {
+	for _, key := range keys {
+		child, ok := node.children[key]
+		if !ok {
+			child = newKeyNode()
+			node.children[key] = child
+		}
+		node = child
+	}
+	node.mapping = mapping
+}
+
+func (node *keyNode) remove(keys []KeyStroke) bool {
+	if len(keys) == 0 {
+		removed := node.mapping != nil
+		node.mapping = nil
+		return removed
+	}
+
+	child, ok := node.children[keys[0]]
+	if !ok {
+		return false
+	}
+
+	removed := child.remove(keys[1:])
+	if child.mapping == nil && len(child.children) == 0 {
+		delete(node.children, keys[0])
+	}
+	return removed
+}
+
+func (node *keyNode) walk(prefix []KeyStroke, visit func([]KeyStroke, *Mapping)) {
+	if node.mapping != nil {
+		visit(prefix, node.mapping)
+	}
+	for key, child := range node.children {
+		child.walk(append(append([]KeyStroke{}, prefix...), key), visit)
+	}
+}
+
+// Keymap maps a mode and key sequence to an action. Built in bindings live in
+// their own layer so that noremap mappings can bypass anything the user added.
+type Keymap struct {
+	defaults map[EditorMode]*keyNode
+	user     map[EditorMode]*keyNode
+}
+
+type defaultBinding struct {
+	keys   string
+	action string
+}
+
+var defaultBindings = map[EditorMode][]defaultBinding{}
+
+// bindDefault registers a built in binding, called from init in the file that
+// defines the action.
+func bindDefault(mode EditorMode, keys string, action string) {
+	defaultBindings[mode] = append(
+		defaultBindings[mode],
+		defaultBinding{keys: keys, action: action},
+	)
+}
+
+func NewKeymap() *Keymap {
+	keymap := &Keymap{
+		defaults: make(map[EditorMode]*keyNode),
+		user:     make(map[EditorMode]*keyNode),
+	}
+
+	for mode, bindings := range defaultBindings {
+		root := keymap.root(keymap.defaults, mode)
+		for _, binding := range bindings {
+			keys, err := ParseKeys(binding.keys)
+			if err != nil {
+				panic(err)
+			}
+			root.insert(keys, &Mapping{Action: binding.action})
+		}
+	}
+
+	return keymap
+}
+
+func (keymap *Keymap) root(layer map[EditorMode]*keyNode, mode EditorMode) *keyNode {
+	root, ok := layer[mode]
+	if !ok {
+		root = newKeyNode()
+		layer[mode] = root
+	}
+	return root
+}
+
+func (keymap *Keymap) Map(mode EditorMode, keys []KeyStroke, mapping *Mapping) {
+	keymap.root(keymap.user, mode).insert(keys, mapping)
+}
+
+func (keymap *Keymap) Unmap(mode EditorMode, keys []KeyStroke) bool {
+	return keymap.root(keymap.user, mode).remove(keys)
+}
+
+// Mappings lists the user mappings for a mode, formatted for display.
+func (keymap *Keymap) Mappings(mode EditorMode) []string {
+	lines := []string{}
+	keymap.root(keymap.user, mode).walk(nil, func(keys []KeyStroke, mapping *Mapping) {
+		lines = append(lines, FormatKeys(keys)+"  "+mapping.String())
+	})
+	return lines
+}
+
+// lookup finds the longest prefix of keys that has a mapping and reports
+// whether keys could still be extended into a longer mapping.
+func (keymap *Keymap) lookup(
+	mode EditorMode,
+	keys []KeyStroke,
+	remap bool,
+) (mapping *Mapping, length int, more bool) {
+	layers := []*keyNode{keymap.defaults[mode]}
+	if remap {
+		layers = append([]*keyNode{keymap.user[mode]}, layers...)
+	}
+
+	for _, node := range layers {
+		for i := 0; node != nil; i += 1 {
+			if node.mapping != nil && i > length {
+				mapping, length = node.mapping, i
+			}
+			if i == len(keys) {
+				if len(node.children) > 0 {
+					more = true
+				}
+				break
+			}
+			node = node.children[keys[i]]
+		}
+	}
+
+	return mapping, length, more
+}
+
+func modeFromName(name string) (EditorMode, bool) {
+	switch name {
+	case "normal":
+		return Normal, true
+	case "insert":
+		return Insert, true
+	case "command":
+		return Command, true
+	case "picker":
+		return Picking, true
+	case "explorer":
+		return Exploring, true
+	case "quickfix":
+		return Quickfixing, true
+	case "operator":
+		return OperatorPending, true
+	case "visual":
+		return Visual, true
+	}
+	return Normal, false
+}
+
+func (editor *Editor) timeoutLen() time.Duration {
+	return time.Duration(editor.OptionInt("timeoutlen")) * time.Millisecond
+}
+
+// HandleKey feeds a key press through the keymap. Keys that are a prefix of a
+// longer mapping are held until the sequence completes or times out.
+func (editor *Editor) HandleKey(key KeyStroke) {
+	// a long message stays up until the next key
+	if editor.dismissMessage(key) {
+		return
+	}
+
+	// popups close on the next key unless it was one that works the popup,
+	// a completion menu is filtered again by what was typed
+	popup := editor.Popup
+	editor.keepPopup = false
+
+	if len(editor.pendingKeys) > 0 &&
+		time.Since(editor.lastKeyTime) >= editor.timeoutLen() {
+		editor.pendingKeys = editor.feedKeys(editor.pendingKeys, true, true, 0)
+	}
+
+	editor.pendingKeys = append(editor.pendingKeys, key)
+	editor.lastKeyTime = time.Now()
+	editor.pendingKeys = editor.feedKeys(editor.pendingKeys, true, false, 0)
+	if popup != nil && editor.Popup == popup && !editor.keepPopup {
+		editor.showCompletion()
+		if editor.completion == nil {
+			editor.Popup = nil
+		}
+	}
+	editor.ScrollToCursor()
+}
+
+// PendingKeyTimeout reports how long until held keys should be flushed with
+// FlushPendingKeys.
+func (editor *Editor) PendingKeyTimeout() (time.Duration, bool) {
+	if len(editor.pendingKeys) == 0 {
+		return 0, false
+	}
+	return editor.timeoutLen() - time.Since(editor.lastKeyTime), true
+}
+
+// FlushPendingKeys resolves held keys once timeoutlen has passed. Calling it
+// early is a no-op so stale timers are harmless.
+func (editor *Editor) FlushPendingKeys() {
+	if len(editor.pendingKeys) == 0 ||
+		time.Since(editor.lastKeyTime) < editor.timeoutLen() {
+		return
+	}
+	editor.pendingKeys = editor.feedKeys(editor.pendingKeys, true, true, 0)
+	editor.ScrollToCursor()
+}
+
+// feedKeys runs as many keys as it can and returns the ones left waiting for
+// more input. When final is set nothing is left waiting.
+func (editor *Editor) feedKeys(
+	keys []KeyStroke,
+	remap bool,
+	final bool,
+	depth int,
+) []KeyStroke {
+	if depth > maxMapDepth {
+		editor.ReportError(fmt.Errorf("recursive mapping"))
+		return nil
+	}
+
+	for len(keys) > 0 {
+		mapping, length, more := editor.Keymap.lookup(editor.Mode, keys, remap)
+		if more && !final {
+			return keys
+		}
+
+		if mapping == nil {
+			editor.defaultKey(keys[0])
+			keys = keys[1:]
+			continue
+		}
+
+		trigger := keys[length-1]
+		keys = keys[length:]
+
+		if mapping.Action != "" {
+			editor.RunAction(mapping.Action, trigger)
+		} else {
+			editor.feedKeys(mapping.Keys, !mapping.Noremap, true, depth+1)
+		}
+	}
+
+	return nil
+}
+
+// defaultKey handles keys that no mapping claimed.
+func (editor *Editor) defaultKey(key KeyStroke) {
+	// anything that is not a text object cancels a pending operator
+	if editor.Mode == OperatorPending {
+		editor.cancelOperator()
+		return
+	}
+
+	if key.Key != tcell.KeyRune {
+		return
+	}
+
+	switch editor.Mode {
+	case Insert:
+		editor.typeRune(key.Rune)
+	case Command:
+		editor.CommandLine = append(editor.CommandLine, key.Rune)
+	case Picking:
+		if editor.Picker != nil {
+			editor.typePicker(key.Rune)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEditor(text string) *Editor {
+	content := &Content{
+		Original:    []rune(text),
+		Add:         []rune{},
+		ContentRoot: &Piece{0, len([]rune(text)), original, nil},
+		Length:      len([]rune(text)),
+	}
+	if len(text) == 0 {
+		content.ContentRoot = nil
+	}
+
+	return &Editor{
+		Content:      content,
+		Cursor:       &Cursor{},
+		FilePath:     "test.txt",
+		FileName:     "test.txt",
+		ScreenHeight: 24,
+		ScreenWidth:  80,
+		Mode:         Normal,
+		Keymap:       NewKeymap(),
+	}
+}
+
+func typeKeys(t *testing.T, editor *Editor, notation string) {
+	t.Helper()
+
+	keys, err := ParseKeys(notation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		editor.HandleKey(key)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	for _, notation := range []string{"gg", "<Esc>", "<C-w>j", "<Space>x", "<CR>", "<M-a>", "<F5>"} {
+		keys, err := ParseKeys(notation)
+		if err != nil {
+			t.Fatalf("%s: %v", notation, err)
+		}
+		if formatted := FormatKeys(keys); formatted != notation {
+			t.Fatalf("\nParsed: %s\nExpected: %s", formatted, notation)
+		}
+	}
+
+	keys, _ := ParseKeys("<nope>")
+	if len(keys) != 6 {
+		t.Fatalf("unknown names should be literal keys, got %d keys", len(keys))
+	}
+}
+
+func TestDefaultKeymap(t *testing.T) {
+	editor := newTestEditor("hey")
+
+	typeKeys(t, editor, "ia<Esc>")
+
+	expected := "ahey"
+	if final := string(editor.GetContent()); final != expected {
+		t.Fatalf("\nFinal String: %s\nExpected String: %s", final, expected)
+	}
+	if editor.Mode != Normal {
+		t.Fatalf("expected normal mode, got %d", editor.Mode)
+	}
+}
+
+func TestMultiKeyMapping(t *testing.T) {
+	editor := newTestEditor("hey")
+
+	if err := editor.ExecuteCommand("inoremap jk <Esc>"); err != nil {
+		t.Fatal(err)
+	}
+
+	typeKeys(t, editor, "ij")
+	if _, ok := editor.PendingKeyTimeout(); !ok {
+		t.Fatal("expected j to wait for the rest of the mapping")
+	}
+
+	typeKeys(t, editor, "k")
+	if editor.Mode != Normal {
+		t.Fatal("expected jk to leave insert mode")
+	}
+	if final := string(editor.GetContent()); final != "hey" {
+		t.Fatalf("mapping should not insert, got %s", final)
+	}
+
+	typeKeys(t, editor, "ijx")
+	expected := "jxhey"
+	if final := string(editor.GetContent()); final != expected {
+		t.Fatalf("\nFinal String: %s\nExpected String: %s", final, expected)
+	}
+}
+
+func TestMappingTimeout(t *testing.T) {
+	editor := newTestEditor("")
+	editor.ExecuteCommand("set timeoutlen=1")
+
+	editor.ExecuteCommand("inoremap jk <Esc>")
+	typeKeys(t, editor, "ij")
+
+	time.Sleep(2 * time.Millisecond)
+	editor.FlushPendingKeys()
+
+	if final := string(editor.GetContent()); final != "j" {
+		t.Fatalf("expected timed out j to be inserted, got %q", final)
+	}
+	if editor.Mode != Insert {
+		t.Fatal("expected to still be in insert mode")
+	}
+}
+
+func TestRecursiveMapping(t *testing.T) {
+	editor := newTestEditor("")
+
+	editor.ExecuteCommand("imap a b")
+	editor.ExecuteCommand("imap b c")
+	editor.ExecuteCommand("inoremap x a")
+
+	typeKeys(t, editor, "iax")
+
+	expected := "ca"
+	if final := string(editor.GetContent()); final != expected {
+		t.Fatalf("\nFinal String: %s\nExpected String: %s", final, expected)
+	}
+
+	editor.ExecuteCommand("imap y y")
+	typeKeys(t, editor, "y")
+	if editor.Message != "recursive mapping" {
+		t.Fatalf("expected recursive mapping error, got %q", editor.Message)
+	}
+}
+
+func TestConfigKeymap(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`
+[options]
+timeoutlen = 250 # milliseconds
+
+[keymap.normal]
+"<C-s>" = "quit"
+"Q" = ":q<CR>"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := newTestEditor("hey")
+	if err := editor.applyConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if timeout := editor.timeoutLen(); timeout != 250*time.Millisecond {
+		t.Fatalf("expected timeout 250ms, got %v", timeout)
+	}
+
+	typeKeys(t, editor, "Q")
+	if !editor.Quit {
+		t.Fatal("expected Q to run :q")
+	}
+
+	editor = newTestEditor("hey")
+	editor.applyConfig(config)
+	typeKeys(t, editor, "<C-s>")
+	if !editor.Quit {
+		t.Fatal("expected <C-s> to run the quit action")
+	}
+}
@@ -0,0 +1,945 @@
+package backend
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf16"
+
+	"github.com/bhivam/text-editor/lsp"
+)
+
+// servers started for filetypes the config has no [lsp.<filetype>] command for
+var defaultLanguageServers = map[string][]string{
+	"go": {"gopls"},
+}
+
+// LSP language ids that differ from the filetype name
+var languageIDs = map[string]string{
+	"sh": "shellscript",
+}
+
+// languageServer is a running server and the documents open in it.
+type languageServer struct {
+	client *lsp.Client
+	// by URI, guarded by languageServersMu
+	documents map[string]*lspDocument
+}
+
+var (
+	languageServersMu sync.Mutex
+	// by project root and command
+	languageServers = map[string]*languageServer{}
+)
+
+// lspDocument is a content open in a language server. Everything but the
+// server's handlers and synced runs on the loop that owns the content.
+type lspDocument struct {
+	server     *languageServer
+	content    *Content
+	uri        string
+	languageID string
+	queue      *asyncQueue
+
+	version int
+	// edits not yet sent to the server
+	changes []lsp.TextDocumentContentChangeEvent
+	// unregisters edited from the content
+	stopListening func()
+	// the content's Version the server has last been sent, read from other
+	// loops to tell whether its edits still fit
+	synced atomic.Int64
+}
+
+// Diagnostic is an error or warning a language server reported, in rows and
+// rune columns of the content.
+type Diagnostic struct {
+	StartRow int
+	StartCol int
+	EndRow   int
+	EndCol   int
+	Severity lsp.DiagnosticSeverity
+	Source   string
+	Message  string
+}
+
+var severityNames = map[lsp.DiagnosticSeverity]string{
+	lsp.SeverityError:       "error",
+	lsp.SeverityWarning:     "warning",
+	lsp.SeverityInformation: "info",
+	lsp.SeverityHint:        "hint",
+}
+
+// severityName is the name used in sign and highlight classes, servers that
+// leave the severity out mean an error.
+func (diagnostic Diagnostic) severityName() string {
+	if name, ok := severityNames[diagnostic.Severity]; ok {
+		return name
+	}
+	return "error"
+}
+
+// rank orders diagnostics with the most severe first.
+func (diagnostic Diagnostic) rank() int {
+	if diagnostic.Severity == 0 {
+		return int(lsp.SeverityError)
+	}
+	return int(diagnostic.Severity)
+}
+
+// projectRoot is the closest directory above path with a go.mod or .git,
+// or the file's own directory.
+func projectRoot(path string) string {
+	dir := filepath.Dir(path)
+	for current := dir; ; current = filepath.Dir(current) {
+		for _, marker := range []string{"go.mod", ".git"} {
+			if _, err := os.Stat(filepath.Join(current, marker)); err == nil {
+				return current
+			}
+		}
+		if filepath.Dir(current) == current {
+			return dir
+		}
+	}
+}
+
+// startLanguageServer returns the server for command in root, starting it
+// if it is not already running.
+func startLanguageServer(command []string, root string) (*languageServer, error) {
+	key := root + "\x00" + strings.Join(command, "\x00")
+
+	languageServersMu.Lock()
+	defer languageServersMu.Unlock()
+
+	if server, ok := languageServers[key]; ok {
+		return server, nil
+	}
+
+	server := &languageServer{documents: map[string]*lspDocument{}}
+	client, err := lsp.Start(command, root, lsp.Handlers{
+		Ready: func() {
+			for _, doc := range server.openDocuments() {
+				doc.queue.post(doc.sync)
+			}
+		},
+		Diagnostics: func(params lsp.PublishDiagnosticsParams) {
+			if doc := server.document(params.URI); doc != nil {
+				doc.queue.post(func() {
+					doc.content.setDiagnostics(params.Diagnostics)
+				})
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("language server %s: %w", command[0], err)
+	}
+	server.client = client
+	languageServers[key] = server
+
+	// a server that dies is started again by the next file that needs it
+	go func() {
+		<-client.Done()
+		languageServersMu.Lock()
+		if languageServers[key] == server {
+			delete(languageServers, key)
+		}
+		languageServersMu.Unlock()
+	}()
+
+	return server, nil
+}
+
+func (server *languageServer) document(uri string) *lspDocument {
+	languageServersMu.Lock()
+	defer languageServersMu.Unlock()
+	return server.documents[uri]
+}
+
+func (server *languageServer) openDocuments() []*lspDocument {
+	languageServersMu.Lock()
+	defer languageServersMu.Unlock()
+
+	docs := []*lspDocument{}
+	for _, doc := range server.documents {
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// openDocument finds the document for uri in any running server.
+func openDocument(uri string) *lspDocument {
+	languageServersMu.Lock()
+	defer languageServersMu.Unlock()
+
+	for _, server := range languageServers {
+		if doc, ok := server.documents[uri]; ok {
+			return doc
+		}
+	}
+	return nil
+}
+
+// ShutdownLanguageServers stops every running language server. Frontends call
+// it on exit.
+func ShutdownLanguageServers() {
+	languageServersMu.Lock()
+	servers := []*languageServer{}
+	for _, server := range languageServers {
+		servers = append(servers, server)
+	}
+	languageServersMu.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, server := range servers {
+		wg.Go(server.client.Shutdown)
+	}
+	wg.Wait()
+}
+
+// attachLanguageServer opens the content in the language server for its
+// filetype. Filetypes without a server, and default servers that are not
+// installed, are left alone.
+func (editor *Editor) attachLanguageServer(config *Config) error {
+	content := editor.Content
+	if content.lsp != nil || editor.FilePath == "" || !editor.OptionBool("lsp") {
+		return nil
+	}
+
+	filetype := editor.OptionString("filetype")
+	command, configured := []string(nil), false
+	if config != nil {
+		command, configured = config.Strings("lsp."+filetype, "command")
+	}
+	if !configured {
+		command = defaultLanguageServers[filetype]
+	}
+	if len(command) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		if configured {
+			return fmt.Errorf("language server %s: %w", command[0], err)
+		}
+		return nil
+	}
+
+	path, err := filepath.Abs(editor.FilePath)
+	if err != nil {
+		return err
+	}
+	server, err := startLanguageServer(command, projectRoot(path))
+	if err != nil {
+		return err
+	}
+
+	languageID := filetype
+	if id, ok := languageIDs[filetype]; ok {
+		languageID = id
+	}
+	doc := &lspDocument{
+		server:     server,
+		content:    content,
+		uri:        lsp.URIFromPath(path),
+		languageID: languageID,
+		queue:      content.async(),
+	}
+
+	languageServersMu.Lock()
+	server.documents[doc.uri] = doc
+	languageServersMu.Unlock()
+
+	content.lsp = doc
+	doc.stopListening = content.OnEdit(doc.edited)
+
+	// the client holds this back until the server is ready, but ahead of any
+	// request about the document
+	doc.version = 1
+	server.client.Notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{
+			URI:        doc.uri,
+			LanguageID: doc.languageID,
+			Version:    doc.version,
+			Text:       string(content.calculateContent()),
+		},
+	})
+	doc.synced.Store(int64(content.Version))
+	return nil
+}
+
+// sync sends the changes made since the last sync. How they are sent depends
+// on the server's capabilities, so until it is ready they wait.
+func (doc *lspDocument) sync() {
+	client := doc.server.client
+	if !client.Ready() || len(doc.changes) == 0 {
+		return
+	}
+	changes := doc.changes
+	doc.changes = nil
+
+	switch client.Capabilities().SyncKind() {
+	case lsp.SyncNone:
+		return
+	case lsp.SyncFull:
+		changes = []lsp.TextDocumentContentChangeEvent{
+			{Text: string(doc.content.calculateContent())},
+		}
+	}
+
+	doc.version += 1
+	client.Notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: doc.uri, Version: doc.version},
+		ContentChanges: changes,
+	})
+	// only once it is sent, a request made after this sees the change
+	doc.synced.Store(int64(doc.content.Version))
+}
+
+// edited turns an edit into an incremental change. The range is in the
+// document as the server last saw it, so it is worked out from the text
+// before the edit: the line up to the start is unchanged, and the end
+// follows from the removed text.
+func (doc *lspDocument) edited(edit Edit) {
+	text := doc.content.calculateContent()
+	lineStart := edit.Start - edit.StartCol
+	start := lsp.Position{Line: edit.StartRow, Character: utf16Len(text[lineStart:edit.Start])}
+
+	end := lsp.Position{Line: edit.EndRow}
+	if edit.EndRow == edit.StartRow {
+		end.Character = start.Character + utf16Len(edit.Removed)
+	} else {
+		lastLine := len(edit.Removed)
+		for lastLine > 0 && edit.Removed[lastLine-1] != '\n' {
+			lastLine -= 1
+		}
+		end.Character = utf16Len(edit.Removed[lastLine:])
+	}
+
+	doc.changes = append(doc.changes, lsp.TextDocumentContentChangeEvent{
+		Range: &lsp.Range{Start: start, End: end},
+		Text:  string(edit.Inserted),
+	})
+	doc.sync()
+}
+
+// saved tells the server the file was written.
+func (doc *lspDocument) saved() {
+	doc.sync()
+	doc.server.client.Notify("textDocument/didSave", lsp.DidSaveTextDocumentParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: doc.uri},
+	})
+}
+
+// close tells the server the file is no longer open and stops following
+// its edits.
+func (doc *lspDocument) close() {
+	doc.stopListening()
+	doc.content.lsp = nil
+
+	languageServersMu.Lock()
+	delete(doc.server.documents, doc.uri)
+	languageServersMu.Unlock()
+
+	doc.server.client.Notify("textDocument/didClose", lsp.DidCloseTextDocumentParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: doc.uri},
+	})
+}
+
+// utf16Len is the length of text in UTF-16 code units, what LSP positions
+// count.
+func utf16Len(text []rune) int {
+	length := 0
+	for _, r := range text {
+		length += max(1, utf16.RuneLen(r))
+	}
+	return length
+}
+
+// lineIndex converts between content indexes and LSP positions.
+type lineIndex struct {
+	lines  [][]rune
+	starts []int
+}
+
+func (content *Content) lineIndex() lineIndex {
+	index := lineIndex{lines: content.lines()}
+	start := 0
+	for _, line := range index.lines {
+		index.starts = append(index.starts, start)
+		start += len(line) + 1
+	}
+	return index
+}
+
+// column is the row and rune column of a position, clamped to the content.
+func (index lineIndex) column(pos lsp.Position) (int, int) {
+	if pos.Line < 0 {
+		return 0, 0
+	}
+	if pos.Line >= len(index.lines) {
+		last := len(index.lines) - 1
+		return last, len(index.lines[last])
+	}
+
+	line := index.lines[pos.Line]
+	col, units := 0, 0
+	for col < len(line) && units < pos.Character {
+		units += max(1, utf16.RuneLen(line[col]))
+		col += 1
+	}
+	return pos.Line, col
+}
+
+func (index lineIndex) offset(pos lsp.Position) int {
+	row, col := index.column(pos)
+	return index.starts[row] + col
+}
+
+func (index lineIndex) position(offset int) lsp.Position {
+	row := sort.Search(len(index.starts), func(i int) bool {
+		return index.starts[i] > offset
+	}) - 1
+	row = max(0, row)
+	col := min(offset-index.starts[row], len(index.lines[row]))
+	return lsp.Position{Line: row, Character: utf16Len(index.lines[row][:col])}
+}
+
+// setDiagnostics replaces the content's diagnostics with what the server
+// published.
+func (content *Content) setDiagnostics(published []lsp.Diagnostic) {
+	index := content.lineIndex()
+	diagnostics := []Diagnostic{}
+	for _, d := range published {
+		diagnostic := Diagnostic{Severity: d.Severity, Source: d.Source, Message: d.Message}
+		diagnostic.StartRow, diagnostic.StartCol = index.column(d.Range.Start)
+		diagnostic.EndRow, diagnostic.EndCol = index.column(d.Range.End)
+		diagnostics = append(diagnostics, diagnostic)
+	}
+
+	slices.SortStableFunc(diagnostics, func(a, b Diagnostic) int {
+		return cmp.Or(cmp.Compare(a.StartRow, b.StartRow), cmp.Compare(a.StartCol, b.StartCol))
+	})
+	content.Diagnostics = diagnostics
+}
+
+// worstDiagnostics is the most severe diagnostic on each row.
+func (editor *Editor) worstDiagnostics() map[int]Diagnostic {
+	worst := map[int]Diagnostic{}
+	for _, diagnostic := range editor.Content.Diagnostics {
+		if current, ok := worst[diagnostic.StartRow]; !ok || diagnostic.rank() < current.rank() {
+			worst[diagnostic.StartRow] = diagnostic
+		}
+	}
+	return worst
+}
+
+// jumpDiagnostic moves to the next or previous diagnostic, wrapping around
+// the file, and shows its message.
+func (editor *Editor) jumpDiagnostic(forward bool) {
+	diagnostics := editor.Content.Diagnostics
+	if len(diagnostics) == 0 {
+		editor.inform("no diagnostics")
+		return
+	}
+
+	row, col := editor.Cursor.Row, editor.Cursor.Col
+	after := func(d Diagnostic) bool {
+		return d.StartRow > row || d.StartRow == row && d.StartCol > col
+	}
+	before := func(d Diagnostic) bool {
+		return d.StartRow < row || d.StartRow == row && d.StartCol < col
+	}
+
+	target := diagnostics[0]
+	if forward {
+		if i := slices.IndexFunc(diagnostics, after); i != -1 {
+			target = diagnostics[i]
+		}
+	} else {
+		target = diagnostics[len(diagnostics)-1]
+		for _, diagnostic := range slices.Backward(diagnostics) {
+			if before(diagnostic) {
+				target = diagnostic
+				break
+			}
+		}
+	}
+
+	editor.moveCursorTo(editor.Content.lineStart(target.StartRow) + target.StartCol)
+	level := MessageInfo
+	switch lsp.DiagnosticSeverity(target.rank()) {
+	case lsp.SeverityError:
+		level = MessageError
+	case lsp.SeverityWarning:
+		level = MessageWarning
+	}
+	editor.Notify(level, firstLine(target.Message))
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// lspDocument is the open document of the editor's content.
+func (editor *Editor) lspDocument() (*lspDocument, error) {
+	doc := editor.Content.lsp
+	if doc == nil {
+		return nil, errors.New("no language server for this buffer")
+	}
+	return doc, nil
+}
+
+func (editor *Editor) lspPosition(doc *lspDocument) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: doc.uri},
+		Position:     editor.Content.lineIndex().position(editor.Cursor.Index),
+	}
+}
+
+// hover shows what the server knows about the symbol under the cursor in a
+// popup.
+func (editor *Editor) hover() error {
+	doc, err := editor.lspDocument()
+	if err != nil {
+		return err
+	}
+
+	version := doc.content.Version
+	queue := editor.async()
+	doc.server.client.Hover(editor.lspPosition(doc), func(hover *lsp.Hover, err error) {
+		queue.post(func() {
+			switch {
+			case err != nil:
+				editor.ReportError(err)
+			case editor.Content != doc.content || doc.content.Version != version:
+			case hover == nil || strings.TrimSpace(string(hover.Contents)) == "":
+				editor.inform("no hover information")
+			default:
+				editor.showPopup(hoverLines(string(hover.Contents)), -1)
+			}
+		})
+	})
+	return nil
+}
+
+// hoverLines drops markdown code fences and blank lines at the ends.
+func hoverLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		if strings.HasPrefix(line, "```") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \r"))
+	}
+
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// definition jumps to where the symbol under the cursor is defined, opening
+// its file when it is in another one.
+func (editor *Editor) definition() error {
+	doc, err := editor.lspDocument()
+	if err != nil {
+		return err
+	}
+
+	version := doc.content.Version
+	queue := editor.async()
+	doc.server.client.Definition(editor.lspPosition(doc), func(locations []lsp.Location, err error) {
+		queue.post(func() {
+			switch {
+			case err != nil:
+				editor.ReportError(err)
+			case editor.Content != doc.content || doc.content.Version != version:
+				// the cursor has moved on
+			case len(locations) == 0:
+				editor.inform("no definition found")
+			case locations[0].URI == doc.uri:
+				editor.moveCursorTo(doc.content.lineIndex().offset(locations[0].Range.Start))
+			default:
+				// the column counts UTF-16 units, which only differs from
+				// runes outside the basic plane
+				start := locations[0].Range.Start
+				if err := editor.openAt(lsp.PathFromURI(locations[0].URI), start.Line, start.Character); err != nil {
+					editor.ReportError(err)
+				}
+			}
+		})
+	})
+	return nil
+}
+
+// describeLocation is path:line:column, with the path relative to the
+// working directory when it is below it.
+func (editor *Editor) describeLocation(location lsp.Location) string {
+	path := relativePath(lsp.PathFromURI(location.URI))
+	return fmt.Sprintf("%s:%d:%d", path, location.Range.Start.Line+1, location.Range.Start.Character+1)
+}
+
+// relativePath is path relative to the working directory when it is below
+// it.
+func relativePath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if relative, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(relative, "..") {
+			return relative
+		}
+	}
+	return path
+}
+
+// references lists every use of the symbol under the cursor.
+func (editor *Editor) references() error {
+	doc, err := editor.lspDocument()
+	if err != nil {
+		return err
+	}
+
+	position := editor.lspPosition(doc)
+	params := lsp.ReferenceParams{TextDocument: position.TextDocument, Position: position.Position}
+	params.Context.IncludeDeclaration = true
+
+	queue := editor.async()
+	doc.server.client.References(params, func(locations []lsp.Location, err error) {
+		queue.post(func() {
+			if err != nil {
+				editor.ReportError(err)
+				return
+			}
+			if len(locations) == 0 {
+				editor.inform("no references")
+				return
+			}
+
+			// the lines are only shown from the file the editor is still in
+			lines := [][]rune{}
+			if editor.Content == doc.content {
+				lines = editor.Content.lines()
+			}
+			listing := []string{}
+			for _, location := range locations {
+				entry := editor.describeLocation(location)
+				if row := location.Range.Start.Line; location.URI == doc.uri && row < len(lines) {
+					entry += ": " + strings.TrimSpace(string(lines[row]))
+				}
+				listing = append(listing, entry)
+			}
+			editor.showListing(listing)
+		})
+	})
+	return nil
+}
+
+// rename renames the symbol under the cursor everywhere the server finds
+// it. Documents the server follows are changed by the loop that owns them,
+// as long as they are still what the server was last sent. Other files are
+// changed on disk, unless they are open: then only an unmodified buffer of
+// this editor's is changed, the rest are left alone.
+func (editor *Editor) rename(name string) error {
+	if name == "" {
+		return errors.New("usage: LspRename <new name>")
+	}
+	doc, err := editor.lspDocument()
+	if err != nil {
+		return err
+	}
+
+	position := editor.lspPosition(doc)
+	params := lsp.RenameParams{TextDocument: position.TextDocument, Position: position.Position, NewName: name}
+
+	versions := syncedVersions()
+	queue := editor.async()
+	doc.server.client.Rename(params, func(edit *lsp.WorkspaceEdit, err error) {
+		queue.post(func() {
+			if err != nil {
+				editor.ReportError(err)
+				return
+			}
+			// the edits are against text that is no longer there
+			if editor.Content == doc.content && int64(doc.content.Version) != versions[doc.uri] {
+				editor.warn("the buffer changed, rename not applied")
+				return
+			}
+			if edit == nil {
+				editor.inform("nothing to rename")
+				return
+			}
+
+			changes := edit.Edits()
+			skipped := []string{}
+			for uri, edits := range changes {
+				path := lsp.PathFromURI(uri)
+				version, synced := versions[uri]
+				other := openDocument(uri)
+				owned := editor.ownedContent(path)
+
+				switch {
+				case other != nil && other.content == editor.Content:
+					if !synced || int64(other.content.Version) != version {
+						skipped = append(skipped, relativePath(path))
+						break
+					}
+					cursor := editor.Cursor.Index
+					other.content.applyTextEdits(edits, -1)
+					editor.moveCursorTo(cursor)
+				case other != nil:
+					other.queue.post(func() {
+						if !synced || int64(other.content.Version) != version {
+							queue.post(func() {
+								editor.warn(fmt.Sprintf("%s changed, rename not applied there", relativePath(path)))
+							})
+							return
+						}
+						other.content.applyTextEdits(edits, -1)
+					})
+				case owned != nil && !owned.Modified():
+					// the server read the file, which the buffer still matches
+					cursor := editor.Cursor.Index
+					owned.applyTextEdits(edits, -1)
+					if owned == editor.Content {
+						editor.moveCursorTo(cursor)
+					}
+				case owned != nil || bufferOpen(path):
+					skipped = append(skipped, relativePath(path))
+				default:
+					if err := applyTextEditsToFile(path, edits); err != nil {
+						editor.ReportError(err)
+						return
+					}
+				}
+			}
+
+			message := fmt.Sprintf("renamed to %s in %d files", name, len(changes)-len(skipped))
+			if len(skipped) > 0 {
+				slices.Sort(skipped)
+				editor.warn(fmt.Sprintf("%s, not in %s: open with changes the server has not seen", message, strings.Join(skipped, ", ")))
+				return
+			}
+			editor.inform(message)
+		})
+	})
+	return nil
+}
+
+// syncedVersions is the Version of every open document as the server was
+// last sent it, by URI.
+func syncedVersions() map[string]int64 {
+	languageServersMu.Lock()
+	defer languageServersMu.Unlock()
+
+	versions := map[string]int64{}
+	for _, server := range languageServers {
+		for uri, doc := range server.documents {
+			versions[uri] = doc.synced.Load()
+		}
+	}
+	return versions
+}
+
+// applyTextEdits makes the edits of one document, given against its text
+// before any of them. It returns where the edit at track ends afterwards.
+func (content *Content) applyTextEdits(edits []lsp.TextEdit, track int) int {
+	type change struct {
+		start, end int
+		text       []rune
+		tracked    bool
+	}
+
+	index := content.lineIndex()
+	changes := []change{}
+	for i, edit := range edits {
+		changes = append(changes, change{
+			start:   index.offset(edit.Range.Start),
+			end:     index.offset(edit.Range.End),
+			text:    []rune(edit.NewText),
+			tracked: i == track,
+		})
+	}
+
+	// last first so the earlier offsets stay valid
+	slices.SortStableFunc(changes, func(a, b change) int {
+		return cmp.Compare(b.start, a.start)
+	})
+
+	end := -1
+	for _, c := range changes {
+		content.replace(c.text, c.start, c.end)
+		if c.tracked {
+			end = c.start + len(c.text)
+		} else if end != -1 {
+			end += len(c.text) - (c.end - c.start)
+		}
+	}
+	return end
+}
+
+func applyTextEditsToFile(path string, edits []lsp.TextEdit) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	text := []rune(string(raw))
+	content := &Content{
+		Original:    text,
+		Add:         []rune{},
+		ContentRoot: &Piece{Start: 0, Length: len(text), Kind: original},
+		Length:      len(text),
+		NumPieces:   1,
+		lastEdit:    -1,
+	}
+	content.applyTextEdits(edits, -1)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(string(content.calculateContent())), info.Mode())
+}
+
+// lspSource completes from the language server.
+type lspSource struct{}
+
+func (lspSource) Start(line []rune, col int) int {
+	return wordStart(line, col)
+}
+
+func (lspSource) Complete(editor *Editor, prefix string, deliver func(items []CompletionItem)) {
+	doc, err := editor.lspDocument()
+	if err != nil {
+		editor.ReportError(err)
+		deliver(nil)
+		return
+	}
+
+	queue := editor.async()
+	doc.server.client.Completion(editor.lspPosition(doc), func(items []lsp.CompletionItem, err error) {
+		queue.post(func() {
+			if err != nil {
+				editor.ReportError(err)
+				deliver(nil)
+				return
+			}
+
+			slices.SortStableFunc(items, func(a, b lsp.CompletionItem) int {
+				return cmp.Compare(cmp.Or(a.SortText, a.Label), cmp.Or(b.SortText, b.Label))
+			})
+
+			candidates := []CompletionItem{}
+			for _, item := range items {
+				preview := strings.TrimSpace(item.Detail + "\n\n" + string(item.Documentation))
+				candidates = append(candidates, CompletionItem{
+					Label:   item.Label,
+					Detail:  firstLine(item.Detail),
+					Preview: preview,
+					apply: func(editor *Editor, start int) {
+						editor.applyLSPCompletion(item, start)
+					},
+				})
+			}
+			deliver(candidates)
+		})
+	})
+}
+
+// applyLSPCompletion inserts a server's completion item, along with any
+// imports or other edits that come with it. The item's own range ends
+// wherever typing has got to since it was asked for.
+func (editor *Editor) applyLSPCompletion(item lsp.CompletionItem, start int) {
+	index := editor.Content.lineIndex()
+	main := lsp.TextEdit{
+		Range:   lsp.Range{Start: index.position(start), End: index.position(editor.Cursor.Index)},
+		NewText: cmp.Or(item.InsertText, item.Label),
+	}
+	if item.TextEdit != nil {
+		main.Range.Start = item.TextEdit.Range.Start
+		main.NewText = item.TextEdit.NewText
+	}
+
+	edits := append(slices.Clone(item.AdditionalTextEdits), main)
+	editor.moveCursorTo(editor.Content.applyTextEdits(edits, len(edits)-1))
+}
+
+func init() {
+	RegisterCompletionSource("lsp", lspSource{})
+
+	registerOption(OptionDef{
+		Name: "lsp", Kind: BoolOption, Scope: GlobalScope,
+		Default: OptionValue{Bool: true},
+	})
+
+	registerSignSource(func(editor *Editor) map[int]Sign {
+		signs := map[int]Sign{}
+		for row, diagnostic := range editor.worstDiagnostics() {
+			name := diagnostic.severityName()
+			signs[row] = Sign{
+				Text:     strings.ToUpper(name[:1]),
+				Class:    "diagnostic." + name,
+				Priority: 10 - diagnostic.rank(),
+			}
+		}
+		return signs
+	})
+	registerVirtualText(func(editor *Editor) map[int][]ViewCell {
+		text := map[int][]ViewCell{}
+		for row, diagnostic := range editor.worstDiagnostics() {
+			class := "diagnostic." + diagnostic.severityName()
+			cells := []ViewCell{}
+			for _, r := range "■ " + firstLine(diagnostic.Message) {
+				cells = append(cells, ViewCell{Rune: r, Index: -1, Class: class})
+			}
+			text[row] = cells
+		}
+		return text
+	})
+
+	lspAction := func(run func(editor *Editor) error) Action {
+		return func(editor *Editor, key KeyStroke) {
+			if err := run(editor); err != nil {
+				editor.ReportError(err)
+			}
+		}
+	}
+	registerAction("lsp_hover", lspAction((*Editor).hover))
+	registerAction("lsp_definition", lspAction((*Editor).definition))
+	registerAction("lsp_references", lspAction((*Editor).references))
+	registerAction("diagnostic_next", func(editor *Editor, key KeyStroke) {
+		editor.jumpDiagnostic(true)
+	})
+	registerAction("diagnostic_prev", func(editor *Editor, key KeyStroke) {
+		editor.jumpDiagnostic(false)
+	})
+
+	bindDefault(Normal, "K", "lsp_hover")
+	bindDefault(Normal, "gd", "lsp_definition")
+	bindDefault(Normal, "gr", "lsp_references")
+	bindDefault(Normal, "]d", "diagnostic_next")
+	bindDefault(Normal, "[d", "diagnostic_prev")
+	bindDefault(Insert, "<C-x><C-o>", "complete_lsp")
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.hover()
+	}, "LspHover")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.definition()
+	}, "LspDefinition")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.references()
+	}, "LspReferences")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.rename(args)
+	}, "LspRename")
+}
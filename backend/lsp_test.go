@@ -0,0 +1,422 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/bhivam/text-editor/lsp"
+)
+
+// The test binary doubles as a fake language server, the tests configure
+// os.Args[0] as the server for go files.
+func TestMain(m *testing.M) {
+	if os.Getenv("TEXT_EDITOR_FAKE_LSP") == "1" {
+		runFakeLanguageServer()
+		os.Exit(0)
+	}
+
+	// opened files are recorded as recent, which tests keep out of the
+	// real cache directory
+	cache, err := os.MkdirTemp("", "text-editor-cache")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_CACHE_HOME", cache)
+	code := m.Run()
+	os.RemoveAll(cache)
+	os.Exit(code)
+}
+
+// runFakeLanguageServer keeps its own copy of each document, updated from
+// incremental changes. It reports a warning for every TODO, answers hover
+// with the word and line under the cursor, treats the first occurrence of a
+// word as its definition and renames it in every go file it can see.
+func runFakeLanguageServer() {
+	documents := map[string][]string{}
+	ready := make(chan struct{})
+	published := make(chan lsp.PublishDiagnosticsParams, 100)
+	exit := make(chan struct{})
+	var conn *lsp.Conn
+
+	publish := func(uri string) {
+		diagnostics := []lsp.Diagnostic{}
+		for row, line := range documents[uri] {
+			if col := strings.Index(line, "TODO"); col != -1 {
+				start := lsp.Position{Line: row, Character: fakeUTF16Len(line[:col])}
+				end := lsp.Position{Line: row, Character: start.Character + 4}
+				diagnostics = append(diagnostics, lsp.Diagnostic{
+					Range:    lsp.Range{Start: start, End: end},
+					Severity: lsp.SeverityWarning,
+					Message:  "todo here",
+				})
+			}
+		}
+		published <- lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics}
+	}
+	// sent in order from one goroutine, the handler may run before conn is set
+	go func() {
+		<-ready
+		for params := range published {
+			conn.Notify("textDocument/publishDiagnostics", params)
+		}
+	}()
+
+	occurrences := func(uri string, word string) []lsp.Location {
+		locations := []lsp.Location{}
+		for row, line := range documents[uri] {
+			for col := 0; ; col += len(word) {
+				i := strings.Index(line[col:], word)
+				if i == -1 {
+					break
+				}
+				col += i
+				start := lsp.Position{Line: row, Character: fakeUTF16Len(line[:col])}
+				end := lsp.Position{Line: row, Character: start.Character + fakeUTF16Len(word)}
+				locations = append(locations, lsp.Location{URI: uri, Range: lsp.Range{Start: start, End: end}})
+			}
+		}
+		return locations
+	}
+
+	conn = lsp.NewConn(os.Stdin, os.Stdout, func(method string, raw json.RawMessage) (any, error) {
+		params := struct {
+			TextDocument   lsp.TextDocumentItem                 `json:"textDocument"`
+			ContentChanges []lsp.TextDocumentContentChangeEvent `json:"contentChanges"`
+			Position       lsp.Position                         `json:"position"`
+			NewName        string                               `json:"newName"`
+		}{}
+		json.Unmarshal(raw, &params)
+		uri := params.TextDocument.URI
+
+		switch method {
+		case "initialize":
+			return json.RawMessage(`{"capabilities": {
+				"textDocumentSync": {"openClose": true, "change": 2},
+				"hoverProvider": true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"renameProvider": true,
+				"completionProvider": {}
+			}}`), nil
+		case "textDocument/didOpen":
+			documents[uri] = strings.Split(params.TextDocument.Text, "\n")
+			publish(uri)
+		case "textDocument/didChange":
+			for _, change := range params.ContentChanges {
+				documents[uri] = fakeApplyChange(documents[uri], change)
+			}
+			publish(uri)
+		case "textDocument/hover":
+			word, line := fakeWordAt(documents[uri], params.Position)
+			return lsp.Hover{Contents: lsp.Markup(word + "\n```go\n" + line + "\n```")}, nil
+		case "textDocument/definition":
+			word, _ := fakeWordAt(documents[uri], params.Position)
+			return occurrences(uri, word)[0], nil
+		case "textDocument/references":
+			word, _ := fakeWordAt(documents[uri], params.Position)
+			return occurrences(uri, word), nil
+		case "textDocument/rename":
+			// go files next to the document that are not open are read from
+			// disk
+			paths, _ := filepath.Glob(filepath.Join(filepath.Dir(lsp.PathFromURI(uri)), "*.go"))
+			for _, path := range paths {
+				if other := lsp.URIFromPath(path); documents[other] == nil {
+					raw, _ := os.ReadFile(path)
+					documents[other] = strings.Split(string(raw), "\n")
+					defer delete(documents, other)
+				}
+			}
+
+			word, _ := fakeWordAt(documents[uri], params.Position)
+			changes := map[string][]lsp.TextEdit{}
+			for other := range documents {
+				for _, location := range occurrences(other, word) {
+					changes[other] = append(changes[other], lsp.TextEdit{Range: location.Range, NewText: params.NewName})
+				}
+			}
+			return lsp.WorkspaceEdit{Changes: changes}, nil
+		case "textDocument/completion":
+			return []lsp.CompletionItem{
+				{Label: "Println", Detail: "func(a ...any)", AdditionalTextEdits: []lsp.TextEdit{
+					{NewText: "// added\n"},
+				}},
+				{Label: "Printf", Detail: "func(format string, a ...any)"},
+			}, nil
+		case "exit":
+			close(exit)
+		}
+		return nil, nil
+	})
+	close(ready)
+
+	select {
+	case <-exit:
+	case <-conn.Done():
+	}
+}
+
+func fakeUTF16Len(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// fakeOffset is the byte offset in line of a UTF-16 character position.
+func fakeOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+func fakeApplyChange(lines []string, change lsp.TextDocumentContentChangeEvent) []string {
+	if change.Range == nil {
+		return strings.Split(change.Text, "\n")
+	}
+	start, end := change.Range.Start, change.Range.End
+	before := lines[start.Line][:fakeOffset(lines[start.Line], start.Character)]
+	after := lines[end.Line][fakeOffset(lines[end.Line], end.Character):]
+
+	replaced := strings.Split(before+change.Text+after, "\n")
+	return append(append(append([]string{}, lines[:start.Line]...), replaced...), lines[end.Line+1:]...)
+}
+
+func fakeWordAt(lines []string, position lsp.Position) (string, string) {
+	line := lines[position.Line]
+	runes := []rune(line)
+	col := len([]rune(line[:fakeOffset(line, position.Character)]))
+	start, end := col, col
+	for start > 0 && isWordRune(runes[start-1]) {
+		start -= 1
+	}
+	for end < len(runes) && isWordRune(runes[end]) {
+		end += 1
+	}
+	return string(runes[start:end]), line
+}
+
+// newLSPTestEditor opens text as a go file with the fake server configured.
+func newLSPTestEditor(t *testing.T, text string) *Editor {
+	t.Helper()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.toml")
+	config := fmt.Sprintf("[lsp.go]\ncommand = [%q]\n", os.Args[0])
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEXT_EDITOR_CONFIG", configPath)
+	t.Setenv("TEXT_EDITOR_FAKE_LSP", "1")
+
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	editor := InitializeEditor(path, 24, 80)
+	if editor.Message != "" {
+		t.Fatal(editor.Message)
+	}
+	t.Cleanup(ShutdownLanguageServers)
+	return &editor
+}
+
+// waitFor runs queued replies until done reports true.
+func waitFor(t *testing.T, editor *Editor, what string, done func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		editor.RunPending()
+		if done() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestLSPDiagnosticsAndSync(t *testing.T) {
+	editor := newLSPTestEditor(t, "package main\n\nfunc main() {\n\ts := \"😀\" // TODO\n}")
+	waitFor(t, editor, "diagnostics", func() bool {
+		return len(editor.Content.Diagnostics) == 1
+	})
+
+	diagnostic := editor.Content.Diagnostics[0]
+	if diagnostic.StartRow != 3 || diagnostic.StartCol != 13 || diagnostic.EndCol != 17 {
+		t.Fatalf("unexpected diagnostic %+v", diagnostic)
+	}
+
+	view := editor.View()
+	if view[3].Sign.Text != "W" || view[3].Sign.Class != "diagnostic.warning" {
+		t.Fatalf("expected a warning sign, got %+v", view[3].Sign)
+	}
+	if !strings.HasSuffix(cellText(view[3].Cells), "  ■ todo here") {
+		t.Fatalf("expected virtual text, got %q", cellText(view[3].Cells))
+	}
+
+	// an edit after the emoji on the line and one that joins lines, both
+	// have to reach the server intact for the warning to move right
+	editor.Content.replace([]rune("😀"), editor.Content.lineStart(3)+7, editor.Content.lineStart(3)+7)
+	editor.Content.replace([]rune{}, 12, 14)
+	expectContent(t, editor, "package mainfunc main() {\n\ts := \"😀😀\" // TODO\n}")
+	waitFor(t, editor, "moved diagnostics", func() bool {
+		diagnostics := editor.Content.Diagnostics
+		return len(diagnostics) == 1 && diagnostics[0].StartRow == 1 && diagnostics[0].StartCol == 14
+	})
+
+	typeKeys(t, editor, "]d")
+	if editor.Cursor.Row != 1 || editor.Cursor.Col != 14 || editor.Message != "todo here" {
+		t.Fatalf("expected ]d to jump to the warning, got %+v %q", editor.Cursor, editor.Message)
+	}
+
+	// the server's copy of the line matches
+	typeKeys(t, editor, "K")
+	waitFor(t, editor, "hover", func() bool { return editor.Popup != nil })
+	if got := editor.Popup.Lines; len(got) != 2 || got[0] != "TODO" || got[1] != "    s := \"😀😀\" // TODO" {
+		t.Fatalf("unexpected hover %q", got)
+	}
+	typeKeys(t, editor, "l")
+	if editor.Popup != nil {
+		t.Fatal("expected the next key to close the hover")
+	}
+}
+
+func cellText(cells []ViewCell) string {
+	text := []rune{}
+	for _, cell := range cells {
+		text = append(text, cell.Rune)
+	}
+	return string(text)
+}
+
+func TestLSPClose(t *testing.T) {
+	editor := newLSPTestEditor(t, "package main\n")
+	doc := editor.Content.lsp
+	uri := doc.uri
+	listeners := len(editor.Content.listeners)
+
+	editor.Content.Close()
+	if editor.Content.lsp != nil || openDocument(uri) != nil || len(editor.Content.listeners) != listeners-1 {
+		t.Fatal("expected the document to be closed")
+	}
+	version := doc.version
+	editor.Content.replace([]rune("// done\n"), 0, 0)
+	if doc.version != version || len(doc.changes) != 0 {
+		t.Fatal("expected edits to stay out of the closed document")
+	}
+}
+
+func TestLSPNavigationAndRename(t *testing.T) {
+	editor := newLSPTestEditor(t, "package main\n\nfunc greet() {}\n\nfunc main() {\n\tgreet()\n}")
+	editor.moveCursorTo(editor.Content.lineStart(5) + 2)
+
+	typeKeys(t, editor, "gd")
+	waitFor(t, editor, "definition", func() bool { return editor.Cursor.Row == 2 })
+	if editor.Cursor.Col != 5 {
+		t.Fatalf("expected the definition at 2:5, got %+v", editor.Cursor)
+	}
+
+	typeKeys(t, editor, "gr")
+	waitFor(t, editor, "references", func() bool { return editor.Message != "" })
+	if lines := strings.Split(editor.Message, "\n"); len(lines) != 2 ||
+		!strings.HasSuffix(lines[1], ":6:2: greet()") {
+		t.Fatalf("unexpected references %q", editor.Message)
+	}
+
+	if err := editor.ExecuteCommand("LspRename hello"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, editor, "rename", func() bool { return strings.HasPrefix(editor.Message, "renamed") })
+	expectContent(t, editor, "package main\n\nfunc hello() {}\n\nfunc main() {\n\thello()\n}")
+
+	// a reply about text that has changed since is dropped
+	if err := editor.ExecuteCommand("LspRename bye"); err != nil {
+		t.Fatal(err)
+	}
+	editor.Content.replace([]rune{}, 0, len("package main\n"))
+	waitFor(t, editor, "stale rename", func() bool { return editor.MessageLevel == MessageWarning })
+	expectContent(t, editor, "\nfunc hello() {}\n\nfunc main() {\n\thello()\n}")
+}
+
+func TestLSPRenameAcrossFiles(t *testing.T) {
+	editor := newLSPTestEditor(t, "package main\n\nfunc main() {\n\tgreet()\n}")
+	dir := filepath.Dir(editor.FilePath)
+	writeTestFile(t, filepath.Join(dir, "greet.go"), "package main\n\nfunc greet() {}\n")
+	writeTestFile(t, filepath.Join(dir, "notes.go"), "package main\n\n// greet\n")
+	writeTestFile(t, filepath.Join(dir, "disk.go"), "package main\n\nvar _ = greet\n")
+	writeTestFile(t, filepath.Join(dir, "late.go"), "package main\n\nvar _ = greet\n")
+	main := editor.FilePath
+
+	// notes.go is open with changes, but the server only knows it from disk
+	if err := editor.OpenFile(filepath.Join(dir, "notes.go")); err != nil {
+		t.Fatal(err)
+	}
+	editor.Content.lsp.close()
+	editor.Content.replace([]rune("// mine\n"), 0, 0)
+	notes := editor.Content
+	if err := editor.OpenFile(filepath.Join(dir, "greet.go")); err != nil {
+		t.Fatal(err)
+	}
+	greet := editor.Content
+	// late.go is followed by the server, but changed after the request
+	if err := editor.OpenFile(filepath.Join(dir, "late.go")); err != nil {
+		t.Fatal(err)
+	}
+	late := editor.Content
+	if err := editor.OpenFile(main); err != nil {
+		t.Fatal(err)
+	}
+
+	editor.moveCursorTo(editor.Content.lineStart(3) + 2)
+	if err := editor.ExecuteCommand("LspRename hello"); err != nil {
+		t.Fatal(err)
+	}
+	late.replace([]rune("// late\n"), 0, 0)
+	waitFor(t, editor, "rename", func() bool {
+		return strings.HasPrefix(editor.Message, "renamed") && string(greet.calculateContent()) != "package main\n\nfunc greet() {}"
+	})
+	waitFor(t, editor, "stale document", func() bool { return strings.HasSuffix(editor.Message, "late.go changed, rename not applied there") })
+
+	expectContent(t, editor, "package main\n\nfunc main() {\n\thello()\n}")
+	if got := string(greet.calculateContent()); got != "package main\n\nfunc hello() {}" {
+		t.Fatalf("expected the open document renamed, got %q", got)
+	}
+	if got := string(late.calculateContent()); got != "// late\npackage main\n\nvar _ = greet" {
+		t.Fatalf("expected the changed document left alone, got %q", got)
+	}
+	if got := string(notes.calculateContent()); got != "// mine\npackage main\n\n// greet" {
+		t.Fatalf("expected the modified buffer left alone, got %q", got)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(dir, "notes.go")); string(raw) != "package main\n\n// greet\n" {
+		t.Fatalf("expected the modified buffer's file left alone, got %q", raw)
+	}
+	if raw, _ := os.ReadFile(filepath.Join(dir, "disk.go")); string(raw) != "package main\n\nvar _ = hello\n" {
+		t.Fatalf("expected the closed file renamed on disk, got %q", raw)
+	}
+}
+
+func TestLSPCompletion(t *testing.T) {
+	editor := newLSPTestEditor(t, "package main\n\nfunc main() {\n\tfmt.Pr\n}")
+	editor.moveCursorTo(editor.Content.lineStart(3) + 7)
+	editor.Mode = Insert
+
+	typeKeys(t, editor, "<C-x><C-o>")
+	waitFor(t, editor, "completions", func() bool { return editor.Popup != nil })
+	if lines := editor.Popup.Lines; len(lines) != 2 || !strings.HasPrefix(lines[0], "Printf") {
+		t.Fatalf("expected sorted completions, got %q", lines)
+	}
+
+	typeKeys(t, editor, "<C-n><CR>")
+	expectContent(t, editor, "// added\npackage main\n\nfunc main() {\n\tfmt.Println\n}")
+	if editor.Cursor.Row != 4 || editor.Cursor.Col != 12 || editor.Popup != nil {
+		t.Fatalf("expected the cursor after the completion, got %+v", editor.Cursor)
+	}
+}
@@ -0,0 +1,318 @@
+package backend
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// outputPath is the name the output buffer is shown under. It is not a file.
+const outputPath = "[Output]"
+
+// job is a shell command started by :make or :!, running in the background.
+// Its output is added to the output buffer as it comes in.
+type job struct {
+	command string
+	cancel  context.CancelFunc
+	lines   []string
+	// :make replaces the quickfix list even when nothing is found, and
+	// without a bang goes to the first error
+	make bool
+	jump bool
+}
+
+// errorFormat is one entry of the errorformat option as a regular
+// expression, with the field each group holds.
+type errorFormat struct {
+	pattern *regexp.Regexp
+	fields  []rune
+}
+
+// parseErrorFormat reads a comma separated list of formats like vim's
+// errorformat: %f is the file, %l the line, %c the column, %m the message,
+// %t a letter for the type, %% a percent sign. Everything else is matched
+// as it is, after any leading whitespace.
+func parseErrorFormat(efm string) ([]errorFormat, error) {
+	formats := []errorFormat{}
+	for _, entry := range splitEscaped(efm, ',') {
+		if entry == "" {
+			continue
+		}
+
+		var pattern strings.Builder
+		pattern.WriteString(`^\s*`)
+		format := errorFormat{}
+		runes := []rune(entry)
+		for i := 0; i < len(runes); i += 1 {
+			if runes[i] != '%' {
+				pattern.WriteString(regexp.QuoteMeta(string(runes[i])))
+				continue
+			}
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("errorformat: %s ends in %%", entry)
+			}
+			i += 1
+			switch field := runes[i]; field {
+			case 'f':
+				pattern.WriteString(`(.+?)`)
+			case 'l', 'c':
+				pattern.WriteString(`(\d+)`)
+			case 'm':
+				pattern.WriteString(`(.*)`)
+			case 't':
+				pattern.WriteString(`([A-Za-z])`)
+			case '%':
+				pattern.WriteString("%")
+				continue
+			default:
+				return nil, fmt.Errorf("errorformat: unknown %%%c in %s", field, entry)
+			}
+			format.fields = append(format.fields, runes[i])
+		}
+		if !strings.ContainsRune(string(format.fields), 'f') {
+			return nil, fmt.Errorf("errorformat: %s has no %%f", entry)
+		}
+		pattern.WriteString(`$`)
+
+		var err error
+		if format.pattern, err = regexp.Compile(pattern.String()); err != nil {
+			return nil, err
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// errorTypes names the letters %t matches, as diagnostics are named.
+var errorTypes = map[rune]string{'e': "error", 'w': "warning", 'i': "info", 'n': "hint"}
+
+// parseErrors turns the lines of output that match one of the formats into
+// quickfix items. Lines that match none are left out.
+func parseErrors(formats []errorFormat, lines []string) []QuickfixItem {
+	items := []QuickfixItem{}
+	for _, line := range lines {
+		for _, format := range formats {
+			match := format.pattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			item := QuickfixItem{Type: "error"}
+			for i, field := range format.fields {
+				value := match[i+1]
+				switch field {
+				case 'f':
+					item.Path = value
+				case 'l':
+					row, _ := strconv.Atoi(value)
+					item.Row = max(0, row-1)
+				case 'c':
+					col, _ := strconv.Atoi(value)
+					item.Col = max(0, col-1)
+				case 'm':
+					item.Text = value
+				case 't':
+					if name, ok := errorTypes[unicode.ToLower(rune(value[0]))]; ok {
+						item.Type = name
+					}
+				}
+			}
+			items = append(items, item)
+			break
+		}
+	}
+	return items
+}
+
+// outputContent is the buffer jobs write to, made the first time it is
+// needed. It belongs to the editor whatever file is shown, so that the
+// editor's loop is the one that runs the job's updates.
+func (editor *Editor) outputContent() *Content {
+	if editor.output == nil {
+		editor.output = &Content{
+			Original: []rune{}, Add: []rune{}, SavedVersion: -1, setUp: true, scratch: true,
+		}
+		editor.output.async()
+		if editor.wake != nil {
+			editor.output.SetWake(editor.wake)
+		}
+	}
+	return editor.output
+}
+
+// showOutput switches to the output buffer.
+func (editor *Editor) showOutput() {
+	if editor.Content != editor.outputContent() {
+		editor.SwitchContent(editor.output, outputPath)
+	}
+}
+
+// startJob runs command with the shell, stopping the job before it if that
+// is still going. The command runs on its own goroutine and hands its output
+// to the editor's loop through the output buffer's queue.
+func (editor *Editor) startJob(command string, isMake bool, jump bool) error {
+	if editor.job != nil {
+		editor.job.cancel()
+	}
+
+	output := editor.outputContent()
+	output.replace([]rune("$ "+command+"\n"), 0, output.Length)
+	if editor.Content == output {
+		editor.moveCursorTo(0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	// stdout and stderr share a pipe so that they stay in order
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	cmd.Stdout, cmd.Stderr = writer, writer
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		cancel()
+		return err
+	}
+
+	current := &job{command: command, cancel: cancel, make: isMake, jump: jump}
+	editor.job = current
+	editor.inform("running: " + command)
+
+	queue := output.async()
+	go func() {
+		buffered := bufio.NewReader(reader)
+		lines := []string{}
+		for {
+			line, err := buffered.ReadString('\n')
+			if line != "" {
+				lines = append(lines, strings.TrimRight(line, "\r\n"))
+			}
+			// whatever has come in so far goes over in one go
+			if len(lines) > 0 && (err != nil || buffered.Buffered() == 0) {
+				batch := lines
+				queue.post(func() { editor.jobOutput(current, batch) })
+				lines = []string{}
+			}
+			if err != nil {
+				break
+			}
+		}
+		reader.Close()
+
+		err := cmd.Wait()
+		queue.post(func() { editor.jobDone(current, err) })
+	}()
+	return nil
+}
+
+// jobOutput adds lines from a job to the output buffer. Lines from a job
+// that has been replaced are dropped.
+func (editor *Editor) jobOutput(current *job, lines []string) {
+	if editor.job != current {
+		return
+	}
+	current.lines = append(current.lines, lines...)
+
+	output := editor.outputContent()
+	output.replace([]rune(strings.Join(lines, "\n")+"\n"), output.Length, output.Length)
+	// an output buffer being watched follows along
+	if editor.Content == output {
+		editor.moveCursorTo(output.Length)
+	}
+}
+
+// jobDone parses the finished job's output into the quickfix list.
+func (editor *Editor) jobDone(current *job, err error) {
+	if editor.job != current {
+		return
+	}
+	editor.job = nil
+
+	status := "done"
+	if err != nil {
+		status = err.Error()
+	}
+	output := editor.outputContent()
+	output.replace([]rune("["+status+"]\n"), output.Length, output.Length)
+
+	formats, efmErr := parseErrorFormat(editor.OptionString("errorformat"))
+	if efmErr != nil {
+		editor.ReportError(efmErr)
+		return
+	}
+	items := parseErrors(formats, current.lines)
+	if current.make || len(items) > 0 {
+		editor.setQuickfix(current.command, items)
+		editor.quickfix.signs = true
+	}
+
+	// jumping away while someone is typing would lose their place
+	if len(items) > 0 && current.jump && editor.Mode == Normal {
+		if err := editor.jumpQuickfix(0); err != nil {
+			editor.ReportError(err)
+		}
+		return
+	}
+	editor.inform(fmt.Sprintf("%s: %s, %d errors", current.command, status, len(items)))
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "makeprg", Short: "mp", Kind: StringOption, Scope: GlobalScope,
+		Default: OptionValue{String: "make"}, Secure: true,
+	})
+	registerOption(OptionDef{
+		Name: "errorformat", Short: "efm", Kind: StringOption, Scope: GlobalScope,
+		Default: OptionValue{String: "%f:%l:%c: %m,%f:%l: %m"}, Secure: true,
+		validate: func(value OptionValue) error {
+			_, err := parseErrorFormat(value.String)
+			return err
+		},
+	})
+
+	registerSignSource(func(editor *Editor) map[int]Sign {
+		signs := map[int]Sign{}
+		if !editor.quickfix.signs {
+			return signs
+		}
+		for _, item := range editor.quickfix.items {
+			if item.Type != "" && samePath(item.Path, editor.FilePath) {
+				signs[item.Row] = Sign{
+					Text:  strings.ToUpper(item.Type[:1]),
+					Class: "diagnostic." + item.Type,
+					// below the language server's own diagnostics
+					Priority: 5,
+				}
+			}
+		}
+		return signs
+	})
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		command := editor.OptionString("makeprg")
+		if args != "" {
+			command += " " + args
+		}
+		return editor.startJob(command, true, !bang)
+	}, "make", "mak")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if args == "" {
+			return fmt.Errorf("usage: !command")
+		}
+		return editor.startJob(args, false, false)
+	}, "!")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		editor.showOutput()
+		return nil
+	}, "Output")
+}
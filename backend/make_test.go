@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	formats, err := parseErrorFormat(`%f:%l:%c: %m,%f:%l: %t: %m,%f(%l): 100%%\, %m`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := parseErrors(formats, []string{
+		"# example.com/pkg",
+		"./main.go:10:5: undefined: x",
+		"    main_test.go:12: w: got 1",
+		"lib.c(3): 100%, sure",
+		"ok  	example.com/pkg	0.1s",
+	})
+	expected := []QuickfixItem{
+		{Path: "./main.go", Row: 9, Col: 4, Text: "undefined: x", Type: "error"},
+		{Path: "main_test.go", Row: 11, Col: 0, Text: "got 1", Type: "warning"},
+		{Path: "lib.c", Row: 2, Col: 0, Text: "sure", Type: "error"},
+	}
+	if !slices.Equal(items, expected) {
+		t.Fatalf("\nGot: %+v\nExpected: %+v", items, expected)
+	}
+
+	if _, err := parseErrorFormat("%l: %m"); err == nil {
+		t.Fatal("expected a format without a file to be refused")
+	}
+	if _, err := parseErrorFormat("%f:%l:%*d: %m"); err == nil {
+		t.Fatal("expected an unknown field to be refused")
+	}
+	editor := newTestEditor("")
+	if err := editor.ExecuteCommand("set efm=%q"); err == nil {
+		t.Fatal("expected :set to check the errorformat")
+	}
+}
+
+func TestMake(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	writeTestFile(t, path, "package main\n\nfunc main() {\n\tx\n}\n")
+
+	editor := InitializeEditor(path, 24, 80)
+	editor.ExecuteCommand(`set makeprg=printf\ '%s:4:2:\ undefined:\ x\\n'`)
+	if err := editor.ExecuteCommand("make " + path + "; exit 1"); err != nil {
+		t.Fatal(err)
+	}
+	// the editor is free while the job runs
+	typeKeys(t, &editor, "j")
+	waitFor(t, &editor, "make", func() bool { return editor.job == nil })
+
+	if editor.Cursor.Row != 3 || editor.Cursor.Col != 1 || editor.Message != "(1 of 1): undefined: x" {
+		t.Fatalf("expected to jump to the error, got %+v %q", editor.Cursor, editor.Message)
+	}
+	if sign := editor.signs()[3]; sign.Text != "E" || sign.Class != "diagnostic.error" {
+		t.Fatalf("expected an error sign, got %+v", sign)
+	}
+
+	editor.ExecuteCommand("Output")
+	output := string(editor.Content.calculateContent())
+	if editor.FileName != outputPath || !strings.HasSuffix(output, path+":4:2: undefined: x\n[exit status 1]\n") {
+		t.Fatalf("unexpected output buffer %s: %q", editor.FileName, output)
+	}
+	if err := editor.ExecuteCommand("w"); err == nil {
+		t.Fatal("expected the output buffer not to be saved")
+	}
+
+	// :! leaves the quickfix list alone when nothing in the output parses
+	if err := editor.ExecuteCommand("!echo one; echo two >&2"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, &editor, "!", func() bool { return editor.job == nil })
+	expectContent(t, &editor, "$ echo one; echo two >&2\none\ntwo\n[done]\n")
+	if len(editor.quickfix.items) != 1 || editor.Message != "echo one; echo two >&2: done, 0 errors" {
+		t.Fatalf("unexpected quickfix list %+v and message %q", editor.quickfix.items, editor.Message)
+	}
+
+	// a clean build clears the errors
+	editor.ExecuteCommand("set makeprg=true")
+	editor.ExecuteCommand("make")
+	waitFor(t, &editor, "clean make", func() bool { return editor.job == nil })
+	if len(editor.quickfix.items) != 0 {
+		t.Fatalf("expected an empty quickfix list, got %+v", editor.quickfix.items)
+	}
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Mark is a named position in a file, kept in place as the file is edited.
+type Mark struct {
+	Name  rune
+	Path  string
+	Index int
+	Row   int
+	Col   int
+}
+
+// setMark puts mark name at the cursor.
+func (editor *Editor) setMark(name rune) {
+	content := editor.Content
+	if content.marks == nil {
+		content.marks = map[rune]*anchor{}
+	}
+	if old, ok := content.marks[name]; ok {
+		content.dropAnchors(old)
+	}
+	content.marks[name] = content.newAnchor(editor.Cursor.Index, false)
+}
+
+// jumpMark moves to mark name, to the first non blank of its line unless
+// exact is set.
+func (editor *Editor) jumpMark(name rune, exact bool) error {
+	anchor, ok := editor.Content.marks[name]
+	if !ok {
+		return fmt.Errorf("mark not set: %c", name)
+	}
+
+	editor.moveCursorTo(anchor.Index)
+	if !exact {
+		line, start := editor.currentLine()
+		editor.moveCursorTo(start + len(leadingWhitespace(line)))
+	}
+	return nil
+}
+
+// Marks lists the marks in every file the editor has open, by file and then
+// by name.
+func (editor *Editor) Marks() []Mark {
+	marks := []Mark{}
+	paths := editor.BufferPaths()
+	for i, content := range editor.ownedContents() {
+		names := []rune{}
+		for name := range content.marks {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			index := content.marks[name].Index
+			row, col := content.position(index)
+			marks = append(marks, Mark{Name: name, Path: paths[i], Index: index, Row: row, Col: col})
+		}
+	}
+	return marks
+}
+
+func init() {
+	registerAction("mark_set", func(editor *Editor, key KeyStroke) {
+		editor.setMark(key.Rune)
+	})
+	registerAction("mark_jump_line", func(editor *Editor, key KeyStroke) {
+		if err := editor.jumpMark(key.Rune, false); err != nil {
+			editor.ReportError(err)
+		}
+	})
+	registerAction("mark_jump", func(editor *Editor, key KeyStroke) {
+		if err := editor.jumpMark(key.Rune, true); err != nil {
+			editor.ReportError(err)
+		}
+	})
+
+	for name := 'a'; name <= 'z'; name += 1 {
+		bindDefault(Normal, "m"+string(name), "mark_set")
+		bindDefault(Normal, "'"+string(name), "mark_jump_line")
+		bindDefault(Normal, "`"+string(name), "mark_jump")
+	}
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// MessageLevel is how serious a message is, which decides how it is drawn.
+type MessageLevel int
+
+const (
+	MessageInfo MessageLevel = iota
+	MessageWarning
+	MessageError
+)
+
+// Message is something the editor told the user, kept for :messages.
+type Message struct {
+	Level MessageLevel
+	Text  string
+}
+
+// how many messages :messages remembers
+const messageHistory = 200
+
+// the last line of a message too long for the command line
+const pressEnter = "Press ENTER or type command to continue"
+
+var messageClasses = map[MessageLevel]string{
+	MessageInfo:    "message",
+	MessageWarning: "message.warning",
+	MessageError:   "message.error",
+}
+
+// Notify shows text under the status bar and keeps it for :messages.
+func (editor *Editor) Notify(level MessageLevel, text string) {
+	editor.Message, editor.MessageLevel = text, level
+	editor.messages = append(editor.messages, Message{Level: level, Text: text})
+	if over := len(editor.messages) - messageHistory; over > 0 {
+		editor.messages = editor.messages[over:]
+	}
+}
+
+// inform shows a message about something that went as asked.
+func (editor *Editor) inform(text string) {
+	editor.Notify(MessageInfo, text)
+}
+
+// warn shows a message about something that went only partly as asked.
+func (editor *Editor) warn(text string) {
+	editor.Notify(MessageWarning, text)
+}
+
+// ReportError shows a failure.
+func (editor *Editor) ReportError(err error) {
+	editor.Notify(MessageError, err.Error())
+}
+
+// showListing shows what a command listed, a line each. Listings are not
+// kept for :messages.
+func (editor *Editor) showListing(lines []string) {
+	editor.Message, editor.MessageLevel = strings.Join(lines, "\n"), MessageInfo
+}
+
+func (editor *Editor) clearMessage() {
+	editor.Message, editor.MessageLevel = "", MessageInfo
+}
+
+// MessageClass is the theme class the message is drawn with.
+func (editor *Editor) MessageClass() string {
+	return messageClasses[editor.MessageLevel]
+}
+
+// MessageLines is the message wrapped to the screen. A message that takes
+// more than one line covers the bottom of the text and ends with a line
+// asking for enter, see HandleKey.
+func (editor *Editor) MessageLines() []string {
+	if editor.Message == "" {
+		return nil
+	}
+	width := max(1, editor.ScreenWidth)
+	lines := []string{}
+	for _, line := range strings.Split(editor.Message, "\n") {
+		text := []rune(line)
+		for len(text) > width {
+			lines = append(lines, string(text[:width]))
+			text = text[width:]
+		}
+		lines = append(lines, string(text))
+	}
+	if len(lines) > 1 {
+		lines = append(lines, pressEnter)
+	}
+	return lines
+}
+
+// MorePending reports whether a long message is waiting for a key.
+func (editor *Editor) MorePending() bool {
+	return len(editor.MessageLines()) > 1
+}
+
+// dismissMessage takes down a long message on the next key. Enter, space
+// and escape only do that, any other key goes on to do what it does.
+func (editor *Editor) dismissMessage(key KeyStroke) bool {
+	if !editor.MorePending() {
+		return false
+	}
+	editor.clearMessage()
+	return key == KeyStroke{Key: tcell.KeyEnter} ||
+		key == KeyStroke{Key: tcell.KeyEscape} ||
+		key == KeyStroke{Key: tcell.KeyRune, Rune: ' '}
+}
+
+func messagesCommand(editor *Editor, bang bool, args string) error {
+	switch strings.TrimSpace(args) {
+	case "":
+	case "clear":
+		editor.messages = nil
+		editor.clearMessage()
+		return nil
+	default:
+		return fmt.Errorf("trailing characters: %s", args)
+	}
+
+	lines := []string{}
+	for _, message := range editor.messages {
+		lines = append(lines, message.Text)
+	}
+	editor.showListing(lines)
+	return nil
+}
+
+func init() {
+	registerExCommand(messagesCommand, "messages", "mes")
+}
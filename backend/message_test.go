@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	dir := t.TempDir()
+	path := filepath.Join(dir, "new.txt")
+
+	// a file that is not there yet opens empty and is made on write
+	editor := InitializeEditor(path, 24, 30)
+	if editor.Message != `"new.txt" [New]` || editor.MessageLevel != MessageInfo {
+		t.Fatalf("unexpected message %q", editor.Message)
+	}
+	typeKeys(t, &editor, "ihello<Esc>:w<CR>")
+	if text, err := os.ReadFile(path); err != nil || string(text) != "hello\n" {
+		t.Fatalf("expected the file to be written, got %q: %v", text, err)
+	}
+
+	// failing to write is an error, not a crash
+	editor.FilePath = filepath.Join(dir, "missing", "new.txt")
+	typeKeys(t, &editor, ":w<CR>")
+	if editor.MessageLevel != MessageError || editor.MessageClass() != "message.error" {
+		t.Fatalf("expected an error, got %q", editor.Message)
+	}
+	typeKeys(t, &editor, ":wq<CR>")
+	if editor.Quit {
+		t.Fatal("expected :wq not to quit when the write failed")
+	}
+
+	// the history takes several lines, so it waits for a key
+	typeKeys(t, &editor, ":messages<CR>")
+	lines := editor.MessageLines()
+	if len(editor.messages) != 4 || lines[0] != `"new.txt" [New]` || lines[len(lines)-1] != pressEnter ||
+		!editor.MorePending() {
+		t.Fatalf("unexpected history %q", lines)
+	}
+	if slices.Contains(editor.messages, Message{Text: editor.Message}) {
+		t.Fatal("expected the listing not to be kept")
+	}
+
+	// enter only takes the listing down, other keys go on to do their thing
+	typeKeys(t, &editor, "<CR>")
+	if editor.Message != "" || editor.Mode != Normal || editor.Cursor.Row != 0 {
+		t.Fatalf("expected enter to dismiss the listing, got %q", editor.Message)
+	}
+	typeKeys(t, &editor, ":messages<CR>:messages clear<CR>")
+	if editor.Message != "" || len(editor.messages) != 0 {
+		t.Fatalf("expected the history to be cleared, got %q", editor.messages)
+	}
+
+	// long lines wrap and ask for enter too
+	editor.inform("a message that is too long to fit")
+	if lines := editor.MessageLines(); len(lines) != 3 || lines[1] != "fit" {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
@@ -0,0 +1,317 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// MouseEvent is the state of the mouse buttons and wheel with the mouse over
+// a screen cell.
+type MouseEvent struct {
+	Buttons tcell.ButtonMask
+	Col     int
+	Row     int
+}
+
+func NewMouseEvent(buttons tcell.ButtonMask, col int, row int) MouseEvent {
+	return MouseEvent{Buttons: buttons, Col: col, Row: row}
+}
+
+// how many lines a turn of the wheel scrolls
+const wheelLines = 3
+
+// mouseState is what the editor remembers between mouse events.
+type mouseState struct {
+	// the button is held, so movement drags
+	down bool
+	// the press was on the text, at anchor
+	inText bool
+	anchor int
+	// the last press, to tell a double click
+	lastClick time.Time
+	clickCol  int
+	clickRow  int
+}
+
+// HandleMouse clicks, drags and scrolls. A click focuses whatever it is on
+// and puts the cursor there, a drag over the text selects it in visual mode
+// and a double click selects a word, opens an entry of the explorer or jumps
+// to an item of the quickfix list. A click on the border of a window goes
+// over to the window on the other side.
+func (editor *Editor) HandleMouse(event MouseEvent) {
+	// the picker and the command line are worked with keys
+	if editor.Mode == Picking || editor.Mode == Command {
+		return
+	}
+	// rows are counted from the top of the text, the tabline is above it
+	event.Row -= editor.TablineHeight()
+
+	switch {
+	case event.Buttons&tcell.WheelUp != 0:
+		editor.scrollWheel(event, -wheelLines)
+	case event.Buttons&tcell.WheelDown != 0:
+		editor.scrollWheel(event, wheelLines)
+	case event.Buttons&tcell.Button1 == 0:
+		editor.mouse.down = false
+		return
+	case editor.mouse.down:
+		editor.dragMouse(event)
+	default:
+		editor.mouse.down = true
+		editor.clickMouse(event)
+	}
+	editor.ScrollToCursor()
+}
+
+func (editor *Editor) clickMouse(event MouseEvent) {
+	mouse := &editor.mouse
+	double := time.Since(mouse.lastClick) < time.Duration(editor.OptionInt("mousetime"))*time.Millisecond &&
+		mouse.clickCol == event.Col && mouse.clickRow == event.Row
+	mouse.lastClick, mouse.clickCol, mouse.clickRow = time.Now(), event.Col, event.Row
+	if double {
+		// a third click is a click of its own
+		mouse.lastClick = time.Time{}
+	}
+	mouse.inText = false
+
+	if editor.MorePending() {
+		editor.clearMessage()
+		return
+	}
+
+	textHeight := editor.TextHeight()
+	sidebar := editor.SidebarWidth()
+	switch {
+	case event.Row < 0 || event.Row >= editor.ScreenHeight-2-editor.TablineHeight():
+		// the tabline, the status bar and the command line
+	case event.Row == textHeight:
+		// the quickfix window's title is its border with the text
+		if editor.Mode == Quickfixing {
+			editor.Mode = Normal
+		} else {
+			editor.Mode = Quickfixing
+		}
+	case event.Row > textHeight:
+		editor.clickQuickfix(event.Row-textHeight-1, double)
+	case event.Col == sidebar-1:
+		if editor.Mode == Exploring {
+			editor.Mode = Normal
+		} else {
+			editor.Mode = Exploring
+		}
+	case event.Col < sidebar:
+		editor.clickExplorer(event.Row, double)
+	default:
+		editor.clickText(event.Col, event.Row, double)
+	}
+}
+
+// clickExplorer selects the entry on row of the explorer.
+func (editor *Editor) clickExplorer(row int, double bool) {
+	explorer := editor.Explorer
+	editor.Mode = Exploring
+	if row >= len(explorer.Lines) {
+		return
+	}
+	explorer.Selected = explorer.Top + row
+	editor.refreshExplorer()
+	if double {
+		if err := editor.activateExplorer(); err != nil {
+			editor.ReportError(err)
+		}
+	}
+}
+
+// clickQuickfix selects the item on row of the quickfix window.
+func (editor *Editor) clickQuickfix(row int, double bool) {
+	window := editor.QuickfixWindow
+	editor.Mode = Quickfixing
+	if row >= len(window.Lines) {
+		return
+	}
+	window.Selected = window.Top + row
+	editor.refreshQuickfix()
+	if double {
+		editor.Mode = Normal
+		if err := editor.jumpQuickfix(window.Selected); err != nil {
+			editor.ReportError(err)
+		}
+	}
+}
+
+// clickText puts the cursor where the text was clicked. Insert mode stays
+// in insert mode, anything else goes back to normal mode.
+func (editor *Editor) clickText(col int, row int, double bool) {
+	if editor.Mode == OperatorPending {
+		editor.cancelOperator()
+	}
+	if editor.Mode != Insert {
+		editor.Mode = Normal
+	}
+
+	if view := editor.Diff; view != nil {
+		border := editor.SidebarWidth() + editor.DiffPaneWidth()
+		switch side := editor.diffSide(); {
+		case col == border:
+			editor.switchDiffSide()
+			return
+		case (col > border) != (side == 1):
+			editor.switchDiffSide()
+		}
+	}
+
+	index := editor.indexAt(col, row)
+	editor.moveCursorTo(index)
+	editor.mouse.inText, editor.mouse.anchor = true, index
+	if double {
+		editor.selectWord(index)
+	}
+}
+
+// dragMouse selects from where the button went down to where the mouse is.
+func (editor *Editor) dragMouse(event MouseEvent) {
+	mouse := &editor.mouse
+	if !mouse.inText {
+		return
+	}
+	index := editor.indexAt(event.Col, event.Row)
+	if editor.Mode != Visual {
+		if index == mouse.anchor {
+			return
+		}
+		editor.startVisual(mouse.anchor)
+	}
+	editor.moveCursorTo(index)
+}
+
+// scrollWheel scrolls whatever the mouse is over by lines.
+func (editor *Editor) scrollWheel(event MouseEvent, lines int) {
+	textHeight := editor.TextHeight()
+	switch {
+	case event.Row < 0 || event.Row >= editor.ScreenHeight-2-editor.TablineHeight():
+		editor.scrollView(lines)
+	case event.Row >= textHeight:
+		editor.QuickfixWindow.Selected += lines
+		editor.refreshQuickfix()
+	case event.Col < editor.SidebarWidth():
+		editor.moveExplorer(lines)
+	case editor.Diff != nil:
+		// both sides scroll with the cursor
+		editor.moveToRow(max(0, min(editor.Cursor.Row+lines, len(editor.Content.lines())-1)))
+	default:
+		editor.scrollView(lines)
+	}
+}
+
+// scrollView moves the top line of the window by lines, taking the cursor
+// along only as far as it has to go to stay scrolloff lines inside it.
+func (editor *Editor) scrollView(lines int) {
+	count := len(editor.Content.lines())
+	for ; lines > 0 && editor.nextLine(editor.TopLine) < count; lines -= 1 {
+		editor.TopLine = editor.nextLine(editor.TopLine)
+	}
+	for ; lines < 0 && editor.TopLine > 0; lines += 1 {
+		editor.TopLine = editor.prevLine(editor.TopLine)
+	}
+
+	view := editor.View()
+	if len(view) == 0 {
+		return
+	}
+	scrolloff := min(editor.OptionInt("scrolloff"), (editor.TextHeight()-1)/2)
+
+	first := editor.TopLine
+	for i := 0; i < scrolloff && first > 0 && editor.nextLine(first) < count; i += 1 {
+		first = editor.nextLine(first)
+	}
+	// the last line whose rows all fit
+	last := view[len(view)-1].Line
+	shown := 0
+	for _, line := range view {
+		if line.Line == last {
+			shown += 1
+		}
+	}
+	if shown < editor.screenRows(editor.Content.lines(), last) {
+		last = editor.prevLine(last)
+	}
+	for i := 0; i < scrolloff && editor.nextLine(last) < count; i += 1 {
+		last = editor.prevLine(last)
+	}
+
+	if cursor, _ := editor.lineRange(editor.Cursor.Row); cursor < first {
+		editor.moveToRow(first)
+	} else if cursor > last && last >= first {
+		editor.moveToRow(last)
+	}
+}
+
+// indexAt is the content index at a screen cell of the text, or the nearest
+// one for cells past the end of a line or below the last line.
+func (editor *Editor) indexAt(col int, row int) int {
+	if view := editor.Diff; view != nil {
+		side := editor.diffSide()
+		pane := view.Panes[side]
+		if len(pane) == 0 {
+			return editor.Cursor.Index
+		}
+		row = max(0, min(row, len(pane)-1))
+		line := pane[row]
+		if line.Line == -1 {
+			return editor.Content.lineStart(view.lineAt(side, view.Top+row))
+		}
+		left := editor.SidebarWidth() + side*(editor.DiffPaneWidth()+1) + editor.GutterWidth()
+		return editor.cellIndex(line.Line, line.Cells, col-left)
+	}
+
+	view := editor.View()
+	if len(view) == 0 {
+		return 0
+	}
+	line := view[max(0, min(row, len(view)-1))]
+	if line.Folded > 0 {
+		return editor.Content.lineStart(line.Line)
+	}
+	left := editor.SidebarWidth() + editor.BlameWidth() + editor.GutterWidth()
+	return editor.cellIndex(line.Line, line.Cells, col-left)
+}
+
+// cellIndex is the content index in column col of a screen row of the line
+// at row. Past the last cell it is the end of the line, where only insert
+// mode puts the cursor.
+func (editor *Editor) cellIndex(row int, cells []ViewCell, col int) int {
+	line := editor.Content.lines()[row]
+	end := editor.Content.lineStart(row) + len(line)
+	col = max(0, col)
+	if col < len(cells) && cells[col].Index >= 0 {
+		return cells[col].Index
+	}
+	if len(line) > 0 && editor.Mode != Insert {
+		return end - 1
+	}
+	return end
+}
+
+// selectWord selects the word at index in visual mode.
+func (editor *Editor) selectWord(index int) {
+	text := editor.Content.calculateContent()
+	start, end := index, index
+	if index < len(text) && isWordRune(text[index]) {
+		for start > 0 && isWordRune(text[start-1]) {
+			start -= 1
+		}
+		for end+1 < len(text) && isWordRune(text[end+1]) {
+			end += 1
+		}
+	}
+	editor.startVisual(start)
+	editor.moveCursorTo(end)
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "mousetime", Short: "mouset", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 500}, validate: positive,
+	})
+}
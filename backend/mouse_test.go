@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestMouse(t *testing.T) {
+	lines := []string{"hello world", "second"}
+	for i := 2; i < 40; i += 1 {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	editor := newTestEditor(strings.Join(lines, "\n"))
+	editor.ScreenHeight = 12
+
+	// the text starts after the line numbers
+	left := editor.GutterWidth()
+	click := func(col int, row int) {
+		editor.HandleMouse(NewMouseEvent(tcell.Button1, col, row))
+		editor.HandleMouse(NewMouseEvent(tcell.ButtonNone, col, row))
+	}
+
+	click(left+6, 0)
+	if editor.Cursor.Index != 6 || editor.Mode != Normal {
+		t.Fatalf("expected the cursor on world, got %+v", editor.Cursor)
+	}
+	click(left+6, 0)
+	typeKeys(t, editor, "y")
+	if string(editor.register) != "world" {
+		t.Fatalf("expected a double click to select the word, got %q", string(editor.register))
+	}
+
+	// past the end of a line is its last rune, except in insert mode
+	editor.mouse.lastClick = editor.mouse.lastClick.AddDate(0, 0, -1)
+	click(left+20, 1)
+	if editor.Cursor.Row != 1 || editor.Cursor.Col != 5 {
+		t.Fatalf("expected the end of the line, got %+v", editor.Cursor)
+	}
+	typeKeys(t, editor, "i")
+	click(left+20, 0)
+	if editor.Mode != Insert || editor.Cursor.Col != 11 {
+		t.Fatalf("expected to stay in insert mode after the line, got %+v", editor.Cursor)
+	}
+	typeKeys(t, editor, "<Esc>")
+
+	// dragging selects from the press to the mouse
+	editor.HandleMouse(NewMouseEvent(tcell.Button1, left+2, 1))
+	editor.HandleMouse(NewMouseEvent(tcell.Button1, left+3, 2))
+	editor.HandleMouse(NewMouseEvent(tcell.ButtonNone, left+3, 2))
+	if editor.Mode != Visual {
+		t.Fatal("expected a drag to start visual mode")
+	}
+	typeKeys(t, editor, "d")
+	if line := editor.Content.lines()[1]; string(line) != "se 2" {
+		t.Fatalf("expected the dragged text deleted, got %q", string(line))
+	}
+
+	// the wheel scrolls and only moves the cursor to keep it on screen
+	editor.HandleMouse(NewMouseEvent(tcell.WheelDown, left, 0))
+	editor.HandleMouse(NewMouseEvent(tcell.WheelDown, left, 0))
+	if editor.TopLine != 6 || editor.Cursor.Row != 6 {
+		t.Fatalf("expected to scroll down 6 lines, got top %d and %+v", editor.TopLine, editor.Cursor)
+	}
+	editor.HandleMouse(NewMouseEvent(tcell.WheelUp, left, 0))
+	if editor.TopLine != 3 || editor.Cursor.Row != 6 {
+		t.Fatalf("expected to scroll up 3 lines, got top %d and %+v", editor.TopLine, editor.Cursor)
+	}
+
+	// clicks on the command line do nothing
+	click(left, editor.ScreenHeight-1)
+	if editor.Cursor.Row != 6 {
+		t.Fatalf("expected the cursor to stay, got %+v", editor.Cursor)
+	}
+}
@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+)
+
+func (editor *Editor) startOperator(operator string) {
+	editor.operator = operator
+	editor.Mode = OperatorPending
+}
+
+func (editor *Editor) cancelOperator() {
+	editor.operator = ""
+	editor.Mode = Normal
+}
+
+// applyOperator runs the pending operator over [start, end) and leaves
+// operator pending mode.
+func (editor *Editor) applyOperator(start int, end int, linewise bool) {
+	operator := editor.operator
+	editor.cancelOperator()
+
+	switch operator {
+	case "zf":
+		first, _ := editor.Content.position(start)
+		last, _ := editor.Content.position(max(start, end-1))
+		editor.createFold(first, last)
+		return
+	case "!":
+		// like vim, the lines are put on the command line for the filter
+		// command to be typed after them
+		first, _ := editor.Content.position(start)
+		last, _ := editor.Content.position(max(start, end-1))
+		editor.moveCursorTo(editor.Content.lineStart(first))
+		editor.ToCommand()
+		editor.CommandLine = []rune(".!")
+		if last > first {
+			editor.CommandLine = []rune(fmt.Sprintf(".,.+%d!", last-first))
+		}
+		return
+	}
+
+	text := editor.Content.calculateContent()
+	editor.register = slices.Clone(text[start:end])
+	editor.registerLinewise = linewise
+
+	switch operator {
+	case "d", "c":
+		editor.Content.replace([]rune{}, start, end)
+	}
+
+	editor.moveCursorTo(start)
+	if operator == "c" {
+		editor.Mode = Insert
+	}
+}
+
+// operateLine is the doubled operator, dd, cc and yy. A closed fold counts as
+// one line.
+func (editor *Editor) operateLine(key KeyStroke) {
+	if string(key.Rune) != editor.operator {
+		editor.cancelOperator()
+		return
+	}
+	editor.operateRows(editor.lineRange(editor.Cursor.Row))
+}
+
+// operateLines applies the pending operator to the current line and the one
+// above or below it.
+func (editor *Editor) operateLines(down bool) {
+	first, last := editor.lineRange(editor.Cursor.Row)
+	if down {
+		next := editor.nextLine(editor.Cursor.Row)
+		if next >= len(editor.Content.lines()) {
+			editor.cancelOperator()
+			return
+		}
+		_, last = editor.lineRange(next)
+	} else {
+		if first == 0 {
+			editor.cancelOperator()
+			return
+		}
+		first, _ = editor.lineRange(first - 1)
+	}
+	editor.operateRows(first, last)
+}
+
+// operateRows applies the pending operator to whole rows.
+func (editor *Editor) operateRows(first int, last int) {
+	operator := editor.operator
+	lines := editor.Content.lines()
+	start := editor.Content.lineStart(first)
+	end := editor.Content.lineStart(last) + len(lines[last])
+
+	switch operator {
+	case "c":
+		// keep the indent, only the text goes
+		editor.applyOperator(start+len(leadingWhitespace(lines[first])), end, false)
+		return
+	case "zf", "!":
+		editor.applyOperator(start, end, true)
+		return
+	}
+
+	editor.cancelOperator()
+	text := editor.Content.calculateContent()
+	editor.register = append(slices.Clone(text[start:end]), '\n')
+	editor.registerLinewise = true
+	if operator == "y" {
+		if first < editor.Cursor.Row {
+			editor.moveToRow(first)
+		}
+		return
+	}
+
+	// the last line has no newline of its own, take the one before it
+	if end < editor.Content.Length {
+		end += 1
+	} else if start > 0 {
+		start -= 1
+	}
+
+	editor.Content.replace([]rune{}, start, end)
+	row := min(first, len(editor.Content.lines())-1)
+	editor.moveCursorTo(editor.Content.lineStart(row))
+}
+
+// put inserts the unnamed register after the cursor, or below the current
+// line when it holds whole lines.
+func (editor *Editor) put(before bool) {
+	if len(editor.register) == 0 {
+		return
+	}
+
+	text := slices.Clone(editor.register)
+	line, start := editor.currentLine()
+	index := editor.Cursor.Index
+	if !before && index-start < len(line) {
+		index += 1
+	}
+	cursor := index + len(text) - 1
+
+	if editor.registerLinewise {
+		index, cursor = start, start
+		if !before {
+			index = start + len(line)
+			if index == editor.Content.Length {
+				// no newline to insert after, so bring one along
+				text = append([]rune{'\n'}, text[:len(text)-1]...)
+				cursor = index + 1
+			} else {
+				index += 1
+				cursor = index
+			}
+		}
+	}
+
+	editor.Content.replace(text, index, index)
+	editor.moveCursorTo(cursor)
+}
+
+func init() {
+	for _, operator := range []string{"d", "c", "y"} {
+		registerAction("operator_"+operatorNames[operator], func(editor *Editor, key KeyStroke) {
+			editor.startOperator(operator)
+		})
+		bindDefault(Normal, operator, "operator_"+operatorNames[operator])
+		bindDefault(OperatorPending, operator, "operator_line")
+	}
+
+	registerAction("operator_line", func(editor *Editor, key KeyStroke) {
+		editor.operateLine(key)
+	})
+	registerAction("operator_down", func(editor *Editor, key KeyStroke) {
+		editor.operateLines(true)
+	})
+	registerAction("operator_up", func(editor *Editor, key KeyStroke) {
+		editor.operateLines(false)
+	})
+	registerAction("operator_cancel", func(editor *Editor, key KeyStroke) {
+		editor.cancelOperator()
+	})
+	registerAction("put_after", func(editor *Editor, key KeyStroke) {
+		editor.put(false)
+	})
+	registerAction("put_before", func(editor *Editor, key KeyStroke) {
+		editor.put(true)
+	})
+
+	bindDefault(OperatorPending, "j", "operator_down")
+	bindDefault(OperatorPending, "k", "operator_up")
+	bindDefault(OperatorPending, "<Down>", "operator_down")
+	bindDefault(OperatorPending, "<Up>", "operator_up")
+	bindDefault(OperatorPending, "<Esc>", "operator_cancel")
+	bindDefault(Normal, "p", "put_after")
+	bindDefault(Normal, "P", "put_before")
+}
+
+var operatorNames = map[string]string{"d": "delete", "c": "change", "y": "yank"}
@@ -0,0 +1,28 @@
+package backend
+
+import "testing"
+
+func TestLineOperators(t *testing.T) {
+	editor := newTestEditor("one\ntwo\nthree")
+	typeKeys(t, editor, "jddp")
+	expectContent(t, editor, "one\nthree\ntwo")
+
+	typeKeys(t, editor, "ddP")
+	expectContent(t, editor, "one\ntwo\nthree")
+	if editor.Cursor.Row != 1 {
+		t.Fatalf("expected the cursor on the put line, got row %d", editor.Cursor.Row)
+	}
+
+	typeKeys(t, editor, "yykp")
+	expectContent(t, editor, "one\ntwo\ntwo\nthree")
+
+	typeKeys(t, editor, "ccsix<Esc>")
+	expectContent(t, editor, "one\nsix\ntwo\nthree")
+
+	// an unknown object cancels the operator without touching the text
+	typeKeys(t, editor, "dz")
+	expectContent(t, editor, "one\nsix\ntwo\nthree")
+	if editor.Mode != Normal {
+		t.Fatalf("expected normal mode, got %v", editor.Mode)
+	}
+}
@@ -0,0 +1,513 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type OptionKind int
+
+const (
+	BoolOption   OptionKind = iota
+	NumberOption            = iota
+	StringOption            = iota
+)
+
+type OptionScope int
+
+const (
+	GlobalScope OptionScope = iota
+	BufferScope             = iota
+	WindowScope             = iota
+)
+
+// OptionValue holds the value of an option, which field is used depends on
+// the option's kind.
+type OptionValue struct {
+	Bool   bool   `json:",omitempty"`
+	Number int    `json:",omitempty"`
+	String string `json:",omitempty"`
+}
+
+type OptionValues map[string]OptionValue
+
+type OptionDef struct {
+	Name    string
+	Short   string
+	Kind    OptionKind
+	Scope   OptionScope
+	Default OptionValue
+	// Secure options can run programs, so modelines may not set them
+	Secure bool
+
+	// optional check run before a value is stored
+	validate func(value OptionValue) error
+}
+
+func (def *OptionDef) format(value OptionValue) string {
+	switch def.Kind {
+	case BoolOption:
+		if value.Bool {
+			return "  " + def.Name
+		}
+		return "no" + def.Name
+	case NumberOption:
+		return def.Name + "=" + strconv.Itoa(value.Number)
+	}
+	return def.Name + "=" + value.String
+}
+
+func (def *OptionDef) parse(raw string) (OptionValue, error) {
+	switch def.Kind {
+	case NumberOption:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return OptionValue{}, fmt.Errorf("number required after =: %s=%s", def.Name, raw)
+		}
+		return OptionValue{Number: number}, nil
+	case StringOption:
+		return OptionValue{String: raw}, nil
+	}
+	return OptionValue{}, fmt.Errorf("invalid argument: %s=%s", def.Name, raw)
+}
+
+var optionDefs = map[string]*OptionDef{}
+var optionShortNames = map[string]string{}
+
+func registerOption(def OptionDef) {
+	optionDefs[def.Name] = &def
+	if def.Short != "" {
+		optionShortNames[def.Short] = def.Name
+	}
+}
+
+func lookupOption(name string) (*OptionDef, bool) {
+	if long, ok := optionShortNames[name]; ok {
+		name = long
+	}
+	def, ok := optionDefs[name]
+	return def, ok
+}
+
+func nonNegative(value OptionValue) error {
+	if value.Number < 0 {
+		return fmt.Errorf("argument must be positive")
+	}
+	return nil
+}
+
+func positive(value OptionValue) error {
+	if value.Number <= 0 {
+		return fmt.Errorf("argument must be positive")
+	}
+	return nil
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "tabstop", Short: "ts", Kind: NumberOption, Scope: BufferScope,
+		Default: OptionValue{Number: 8}, validate: positive,
+	})
+	registerOption(OptionDef{
+		Name: "shiftwidth", Short: "sw", Kind: NumberOption, Scope: BufferScope,
+		Default: OptionValue{Number: 8}, validate: nonNegative,
+	})
+	registerOption(OptionDef{
+		Name: "expandtab", Short: "et", Kind: BoolOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "filetype", Short: "ft", Kind: StringOption, Scope: BufferScope,
+	})
+	registerOption(OptionDef{
+		Name: "modeline", Short: "ml", Kind: BoolOption, Scope: BufferScope,
+		Default: OptionValue{Bool: true},
+	})
+	registerOption(OptionDef{
+		Name: "modelines", Short: "mls", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 5}, validate: nonNegative,
+	})
+	registerOption(OptionDef{
+		Name: "number", Short: "nu", Kind: BoolOption, Scope: WindowScope,
+		Default: OptionValue{Bool: true},
+	})
+	registerOption(OptionDef{
+		Name: "relativenumber", Short: "rnu", Kind: BoolOption, Scope: WindowScope,
+	})
+	registerOption(OptionDef{
+		Name: "numberwidth", Short: "nuw", Kind: NumberOption, Scope: WindowScope,
+		Default: OptionValue{Number: 4}, validate: positive,
+	})
+	registerOption(OptionDef{
+		Name: "signcolumn", Short: "scl", Kind: StringOption, Scope: WindowScope,
+		Default: OptionValue{String: "auto"}, validate: oneOf("auto", "yes", "no"),
+	})
+	registerOption(OptionDef{
+		Name: "wrap", Kind: BoolOption, Scope: WindowScope,
+		Default: OptionValue{Bool: true},
+	})
+	registerOption(OptionDef{
+		Name: "scrolloff", Short: "so", Kind: NumberOption, Scope: GlobalScope,
+		validate: nonNegative,
+	})
+	registerOption(OptionDef{
+		Name: "timeoutlen", Short: "tm", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 1000}, validate: nonNegative,
+	})
+
+	registerExCommand(setCommand(setBoth), "set", "se")
+	registerExCommand(setCommand(setLocal), "setlocal", "setl")
+	registerExCommand(setCommand(setGlobal), "setglobal", "setg")
+}
+
+// local values for the scope an option lives in, nil for global options
+func (editor *Editor) localOptions(scope OptionScope) OptionValues {
+	switch scope {
+	case BufferScope:
+		if editor.Content.Options == nil {
+			editor.Content.Options = OptionValues{}
+		}
+		return editor.Content.Options
+	case WindowScope:
+		if editor.WindowOptions == nil {
+			editor.WindowOptions = OptionValues{}
+		}
+		return editor.WindowOptions
+	}
+	return nil
+}
+
+func (editor *Editor) globalOptions() OptionValues {
+	if editor.GlobalOptions == nil {
+		editor.GlobalOptions = OptionValues{}
+	}
+	return editor.GlobalOptions
+}
+
+// Option resolves an option to its local value, falling back to the global
+// value and then the default.
+func (editor *Editor) Option(name string) OptionValue {
+	def, ok := lookupOption(name)
+	if !ok {
+		return OptionValue{}
+	}
+
+	var local OptionValues
+	switch def.Scope {
+	case BufferScope:
+		local = editor.Content.Options
+	case WindowScope:
+		local = editor.WindowOptions
+	}
+
+	if value, ok := local[def.Name]; ok {
+		return value
+	}
+	if value, ok := editor.GlobalOptions[def.Name]; ok {
+		return value
+	}
+	return def.Default
+}
+
+func (editor *Editor) OptionBool(name string) bool {
+	return editor.Option(name).Bool
+}
+
+func (editor *Editor) OptionInt(name string) int {
+	return editor.Option(name).Number
+}
+
+func (editor *Editor) OptionString(name string) string {
+	return editor.Option(name).String
+}
+
+type setHow int
+
+const (
+	setBoth   setHow = iota
+	setLocal         = iota
+	setGlobal        = iota
+	// like setLocal, for options that come from the file itself
+	setModeline = iota
+)
+
+// SetOption stores a value. :set writes both the local and global value for
+// local options, :setlocal and :setglobal only write one of them.
+func (editor *Editor) SetOption(name string, value OptionValue, how setHow) error {
+	def, ok := lookupOption(name)
+	if !ok {
+		return fmt.Errorf("unknown option: %s", name)
+	}
+
+	if def.validate != nil {
+		if err := def.validate(value); err != nil {
+			return fmt.Errorf("%s: %w", def.Name, err)
+		}
+	}
+
+	local := editor.localOptions(def.Scope)
+	if local == nil || how != setLocal {
+		editor.globalOptions()[def.Name] = value
+	}
+	if local != nil && how != setGlobal {
+		local[def.Name] = value
+	}
+
+	return nil
+}
+
+func (editor *Editor) resetOption(def *OptionDef, how setHow) {
+	local := editor.localOptions(def.Scope)
+	if local == nil || how != setLocal {
+		delete(editor.globalOptions(), def.Name)
+	}
+	if local != nil && how != setGlobal {
+		delete(local, def.Name)
+	}
+}
+
+var setArgPattern = regexp.MustCompile(`^([a-z]+)(\?|!|&|[-+^]?[=:])?(.*)$`)
+
+// SetOptions runs the arguments of a :set command.
+func (editor *Editor) SetOptions(args string, how setHow) error {
+	if args == "" || args == "all" {
+		editor.showListing(editor.listOptions(args == "all"))
+		return nil
+	}
+
+	modeline := how == setModeline
+	if modeline {
+		how = setLocal
+	}
+	shown := []string{}
+
+	for _, arg := range splitSetArgs(args) {
+		match := setArgPattern.FindStringSubmatch(arg)
+		if match == nil {
+			return fmt.Errorf("invalid argument: %s", arg)
+		}
+		name, op, raw := match[1], match[2], match[3]
+
+		def, ok := lookupOption(name)
+		prefix := ""
+		if !ok {
+			for _, p := range []string{"no", "inv"} {
+				if strings.HasPrefix(name, p) {
+					if d, found := lookupOption(name[len(p):]); found && d.Kind == BoolOption {
+						def, ok, prefix = d, true, p
+					}
+				}
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown option: %s", name)
+		}
+		// a file may only change how it is shown and edited itself
+		if modeline && (def.Secure || def.Scope == GlobalScope) {
+			return fmt.Errorf("%s cannot be set from a modeline", def.Name)
+		}
+		if raw != "" && op != "=" && op != ":" && !strings.HasSuffix(op, "=") {
+			return fmt.Errorf("trailing characters: %s", arg)
+		}
+
+		current := editor.Option(def.Name)
+
+		switch {
+		case op == "?":
+			shown = append(shown, def.format(current))
+		case op == "&":
+			editor.resetOption(def, how)
+		case op == "!" || prefix == "inv":
+			if def.Kind != BoolOption {
+				return fmt.Errorf("invalid argument: %s", arg)
+			}
+			if err := editor.SetOption(def.Name, OptionValue{Bool: !current.Bool}, how); err != nil {
+				return err
+			}
+		case op == "":
+			if def.Kind != BoolOption {
+				shown = append(shown, def.format(current))
+				continue
+			}
+			if err := editor.SetOption(def.Name, OptionValue{Bool: prefix == ""}, how); err != nil {
+				return err
+			}
+		default:
+			value, err := def.parse(raw)
+			if err != nil {
+				return err
+			}
+			value = combineOption(def, current, value, op[0])
+			if err := editor.SetOption(def.Name, value, how); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(shown) > 0 {
+		editor.showListing(shown)
+	}
+
+	return nil
+}
+
+// combineOption applies the += -= and ^= forms of :set.
+func combineOption(def *OptionDef, current OptionValue, value OptionValue, op byte) OptionValue {
+	switch {
+	case def.Kind == NumberOption && op == '+':
+		value.Number = current.Number + value.Number
+	case def.Kind == NumberOption && op == '-':
+		value.Number = current.Number - value.Number
+	case def.Kind == NumberOption && op == '^':
+		value.Number = current.Number * value.Number
+	case def.Kind == StringOption && op == '+':
+		value.String = joinOptionList(current.String, value.String)
+	case def.Kind == StringOption && op == '^':
+		value.String = joinOptionList(value.String, current.String)
+	case def.Kind == StringOption && op == '-':
+		items := []string{}
+		for _, item := range strings.Split(current.String, ",") {
+			if item != value.String {
+				items = append(items, item)
+			}
+		}
+		value.String = strings.Join(items, ",")
+	}
+	return value
+}
+
+func joinOptionList(a string, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
+// splitSetArgs splits on spaces that are not escaped with a backslash.
+func splitSetArgs(args string) []string {
+	parts := []string{}
+	var current strings.Builder
+
+	escaped := false
+	for _, r := range args {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ' ' || r == '\t':
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	return parts
+}
+
+func (editor *Editor) listOptions(all bool) []string {
+	names := []string{}
+	for name := range optionDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{}
+	for _, name := range names {
+		def := optionDefs[name]
+		value := editor.Option(name)
+		if all || value != def.Default {
+			lines = append(lines, def.format(value))
+		}
+	}
+	return lines
+}
+
+func setCommand(how setHow) ExCommand {
+	return func(editor *Editor, bang bool, args string) error {
+		return editor.SetOptions(args, how)
+	}
+}
+
+// applyOptionConfig sets the values from the [options] section of the config.
+func (editor *Editor) applyOptionConfig(config *Config) error {
+	for name, value := range config.Section("options") {
+		def, ok := lookupOption(name)
+		if !ok {
+			return fmt.Errorf("options: unknown option %s", name)
+		}
+
+		var err error
+		switch value := value.(type) {
+		case bool:
+			err = editor.SetOption(def.Name, OptionValue{Bool: value}, setBoth)
+		default:
+			var parsed OptionValue
+			parsed, err = def.parse(fmt.Sprint(value))
+			if err == nil {
+				err = editor.SetOption(def.Name, parsed, setBoth)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("options: %w", err)
+		}
+	}
+
+	return nil
+}
+
+var modelinePattern = regexp.MustCompile(`(?:^|\s)(?:vim?|ex):\s*(set?\s+([^:]*):|(.*))`)
+
+// applyModelines looks for vim style modelines in the first and last lines of
+// the buffer and applies them with :setlocal.
+func (editor *Editor) applyModelines() error {
+	if !editor.OptionBool("modeline") {
+		return nil
+	}
+
+	count := editor.OptionInt("modelines")
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(editor.GetContent())))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	checked := map[int]bool{}
+	for i := 0; i < count && i < len(lines); i += 1 {
+		checked[i] = true
+		checked[len(lines)-1-i] = true
+	}
+
+	indexes := []int{}
+	for i := range checked {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		match := modelinePattern.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+
+		args := match[2]
+		if match[2] == "" && match[3] != "" {
+			args = strings.ReplaceAll(match[3], ":", " ")
+		}
+		if err := editor.SetOptions(strings.TrimSpace(args), setModeline); err != nil {
+			return fmt.Errorf("modeline on line %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package backend
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetOptions(t *testing.T) {
+	editor := newTestEditor("hey")
+
+	if editor.OptionInt("tabstop") != 8 {
+		t.Fatalf("expected default tabstop of 8, got %d", editor.OptionInt("tabstop"))
+	}
+
+	for _, command := range []string{"set ts=4", "set et", "set nonumber", "set sw+=2"} {
+		if err := editor.ExecuteCommand(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+
+	if editor.OptionInt("tabstop") != 4 || !editor.OptionBool("expandtab") ||
+		editor.OptionBool("number") || editor.OptionInt("shiftwidth") != 10 {
+		t.Fatalf("unexpected options %+v %+v", editor.Content.Options, editor.WindowOptions)
+	}
+
+	editor.ExecuteCommand("set ts?")
+	if editor.Message != "tabstop=4" {
+		t.Fatalf("expected tabstop=4, got %q", editor.Message)
+	}
+
+	editor.ExecuteCommand("set ts& number!")
+	if editor.OptionInt("tabstop") != 8 || !editor.OptionBool("number") {
+		t.Fatal("expected tabstop reset and number toggled back on")
+	}
+
+	if err := editor.ExecuteCommand("set ts=0"); err == nil {
+		t.Fatal("expected tabstop=0 to be rejected")
+	}
+	if err := editor.ExecuteCommand("set nosuchoption"); err == nil {
+		t.Fatal("expected unknown option error")
+	}
+}
+
+func TestSetLocal(t *testing.T) {
+	editor := newTestEditor("hey")
+
+	editor.ExecuteCommand("setlocal ts=2")
+	if editor.OptionInt("tabstop") != 2 {
+		t.Fatal("expected local tabstop of 2")
+	}
+	if _, ok := editor.GlobalOptions["tabstop"]; ok {
+		t.Fatal(":setlocal should not change the global value")
+	}
+
+	editor.ExecuteCommand("setglobal ts=3")
+	if editor.OptionInt("tabstop") != 2 {
+		t.Fatal(":setglobal should not change the local value")
+	}
+
+	other := newTestEditor("there")
+	other.GlobalOptions = editor.GlobalOptions
+	if other.OptionInt("tabstop") != 3 {
+		t.Fatal("expected other buffers to see the global value")
+	}
+}
+
+func TestSharedContentKeepsLocalOptions(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	path := filepath.Join(t.TempDir(), "notes.md")
+	writeTestFile(t, path, "# Notes\n\n<!-- vim: set ts=4: -->\n")
+
+	first := InitializeEditor(path, 24, 80)
+	first.ExecuteCommand("setlocal ts=2")
+
+	// a second client on the file gets the buffer as the first left it
+	second := InitializeEditorWithContent(first.Content, path, 24, 80)
+	if second.OptionInt("tabstop") != 2 || second.OptionString("filetype") != "markdown" {
+		t.Fatalf("expected the local options to be kept, got %+v", second.Content.Options)
+	}
+}
+
+func TestModelines(t *testing.T) {
+	editor := newTestEditor(strings.Join([]string{
+		"package main",
+		"",
+		"// vim: set ts=4 sw=4 et:",
+	}, "\n"))
+
+	if err := editor.applyModelines(); err != nil {
+		t.Fatal(err)
+	}
+	if editor.OptionInt("tabstop") != 4 || !editor.OptionBool("expandtab") {
+		t.Fatalf("modeline not applied: %+v", editor.Content.Options)
+	}
+
+	editor = newTestEditor("# vi: noet ts=3\nhey")
+	editor.applyModelines()
+	if editor.OptionInt("tabstop") != 3 {
+		t.Fatalf("modeline not applied: %+v", editor.Content.Options)
+	}
+}
+
+func TestModelinesCannotRunPrograms(t *testing.T) {
+	for _, modeline := range []string{
+		`# vim: set makeprg=touch\ PWNED :`,
+		"# vim: set efm=%f:%m :",
+		"# vim: set ts=4 nolsp :",
+	} {
+		editor := newTestEditor("hey\n" + modeline)
+		if err := editor.applyModelines(); err == nil {
+			t.Fatalf("expected %q to be rejected", modeline)
+		}
+		if editor.OptionString("makeprg") != "make" || !editor.OptionBool("lsp") {
+			t.Fatalf("%q changed global options: %+v", modeline, editor.GlobalOptions)
+		}
+	}
+}
+
+func TestScrolloff(t *testing.T) {
+	lines := []string{}
+	for range 50 {
+		lines = append(lines, "line")
+	}
+	editor := newTestEditor(strings.Join(lines, "\n"))
+	editor.ScreenHeight = 12
+	editor.ExecuteCommand("set scrolloff=3")
+
+	typeKeys(t, editor, strings.Repeat("j", 20))
+	if editor.TopLine != 20+3-editor.TextHeight()+1 {
+		t.Fatalf("expected top line %d, got %d", 20+3-editor.TextHeight()+1, editor.TopLine)
+	}
+
+	typeKeys(t, editor, strings.Repeat("k", 10))
+	if editor.TopLine != 10-3 {
+		t.Fatalf("expected top line %d, got %d", 10-3, editor.TopLine)
+	}
+
+	view := editor.View()
+	if len(view) != editor.TextHeight() || view[0].Line != editor.TopLine {
+		t.Fatalf("unexpected view %+v", view)
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	editor := newTestEditor(strings.Repeat("line\n", 149) + "last")
+	editor.ScreenHeight = 7
+	editor.ExecuteCommand("set nowrap")
+	editor.ExecuteCommand("150")
+	editor.ScrollToCursor()
+
+	numbers := func() []int {
+		numbers := []int{}
+		for _, line := range editor.View() {
+			numbers = append(numbers, line.Number)
+		}
+		return numbers
+	}
+	if got := numbers(); !slices.Equal(got, []int{146, 147, 148, 149, 150}) || editor.GutterWidth() != 5 {
+		t.Fatalf("expected buffer lines and a gutter for 3 digits, got %v and %d", got, editor.GutterWidth())
+	}
+
+	typeKeys(t, editor, "kk")
+	editor.ExecuteCommand("set relativenumber")
+	if got := numbers(); !slices.Equal(got, []int{2, 1, 148, 1, 2}) {
+		t.Fatalf("expected hybrid numbers, got %v", got)
+	}
+	editor.ExecuteCommand("set nonumber")
+	if got := numbers(); !slices.Equal(got, []int{2, 1, 0, 1, 2}) {
+		t.Fatalf("expected relative numbers, got %v", got)
+	}
+	editor.ExecuteCommand("set norelativenumber")
+	if editor.GutterWidth() != 0 {
+		t.Fatalf("expected no gutter, got %d", editor.GutterWidth())
+	}
+
+	editor.ExecuteCommand("set signcolumn=yes numberwidth=6")
+	if editor.GutterWidth() != signWidth {
+		t.Fatalf("expected just the sign column, got %d", editor.GutterWidth())
+	}
+	editor.ExecuteCommand("set number")
+	if editor.GutterWidth() != signWidth+6 {
+		t.Fatalf("expected numberwidth to widen the gutter, got %d", editor.GutterWidth())
+	}
+	if err := editor.ExecuteCommand("set scl=maybe"); err == nil {
+		t.Fatal("expected signcolumn to be checked")
+	}
+}
@@ -0,0 +1,56 @@
+package backend
+
+import "strings"
+
+// Paste puts in text the terminal pasted, as a single edit. In insert mode
+// it goes in at the cursor just as it was copied, without the autoindent,
+// completion and other things typing it would set off. Normal mode puts it
+// before the cursor like P, and visual mode puts it in place of the
+// selection. The command line and the picker only take its first line.
+func (editor *Editor) Paste(text string) {
+	// terminals send line breaks as carriage returns
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	if editor.MorePending() {
+		editor.clearMessage()
+	}
+	if editor.Mode == OperatorPending {
+		editor.cancelOperator()
+	}
+	firstLine, _, _ := strings.Cut(text, "\n")
+
+	switch editor.Mode {
+	case Command:
+		editor.CommandLine = append(editor.CommandLine, []rune(firstLine)...)
+		return
+	case Picking:
+		if editor.Picker != nil {
+			editor.Picker.Query = append(editor.Picker.Query, []rune(firstLine)...)
+			editor.filterPicker()
+		}
+		return
+	case Insert, Normal, Visual:
+	default:
+		return
+	}
+	if text == "" {
+		return
+	}
+
+	editor.closeCompletion()
+	editor.Popup = nil
+	start, end := editor.Cursor.Index, editor.Cursor.Index
+	if editor.Mode == Visual {
+		start, end = editor.visualRange()
+		editor.Mode = Normal
+	}
+	runes := []rune(text)
+	editor.Content.replace(runes, start, end)
+
+	if editor.Mode == Insert {
+		editor.moveCursorTo(start + len(runes))
+	} else {
+		editor.moveCursorTo(start + len(runes) - 1)
+	}
+	editor.ScrollToCursor()
+}
@@ -0,0 +1,38 @@
+package backend
+
+import "testing"
+
+func TestPaste(t *testing.T) {
+	editor := newTestEditor("\tfunc main() {\n}")
+	if err := editor.ExecuteCommand("set autoindent"); err != nil {
+		t.Fatal(err)
+	}
+
+	// pasted lines keep their own indent, typed ones would get the line's
+	typeKeys(t, editor, "ji")
+	version := editor.Content.Version
+	editor.Paste("\tx := 1\r\n\ty := 2\r\n")
+	expectContent(t, editor, "\tfunc main() {\n\tx := 1\n\ty := 2\n}")
+	if editor.Content.Version != version+1 || editor.Mode != Insert || editor.Cursor.Row != 3 {
+		t.Fatalf("expected one edit with the cursor after it, got %+v", editor.Cursor)
+	}
+
+	// normal mode puts it before the cursor, visual mode over the selection
+	typeKeys(t, editor, "<Esc>")
+	editor.Paste("// end")
+	expectContent(t, editor, "\tfunc main() {\n\tx := 1\n\ty := 2\n// end}")
+	if editor.Mode != Normal || editor.Cursor.Col != 5 {
+		t.Fatalf("expected the cursor on the last pasted rune, got %+v", editor.Cursor)
+	}
+	typeKeys(t, editor, "vhhhhh")
+	editor.Paste("/*")
+	expectContent(t, editor, "\tfunc main() {\n\tx := 1\n\ty := 2\n/*}")
+
+	// the command line takes the first line
+	typeKeys(t, editor, ":")
+	editor.Paste("set ts=2\nset ts=3")
+	typeKeys(t, editor, "<CR>")
+	if editor.OptionInt("tabstop") != 2 {
+		t.Fatalf("expected the first line to run, got tabstop %d", editor.OptionInt("tabstop"))
+	}
+}
@@ -0,0 +1,385 @@
+package backend
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Picker is the overlay for choosing one of a list of items by typing part
+// of it. Only the matching items that fit on screen are kept in Lines, from
+// Top, so the whole list is not sent to remote clients on every key.
+type Picker struct {
+	Title string
+	Query []rune
+
+	Lines []string
+	// rune positions in each line that matched the query
+	Matches  [][]int
+	Top      int
+	Selected int
+	// matching items and all items
+	Count int
+	Total int
+
+	Preview []string
+
+	items []PickerItem
+	shown []pickerMatch
+}
+
+// PickerItem is one choice in the picker.
+type PickerItem struct {
+	Label string
+	// preview is worked out for the selected item only
+	preview func() string
+	accept  func(editor *Editor) error
+}
+
+type pickerMatch struct {
+	item      int
+	score     int
+	positions []int
+}
+
+// PickerLayout is where the picker is drawn: a prompt row and then the list,
+// with the preview to the right of the list when there is room for it.
+type PickerLayout struct {
+	Row       int
+	Col       int
+	Width     int
+	Height    int
+	ListWidth int
+}
+
+// the picker shows a preview beside the list once it is this wide
+const minPreviewPickerWidth = 60
+
+func (editor *Editor) PickerLayout() PickerLayout {
+	width := max(20, editor.ScreenWidth*9/10)
+	height := max(3, (editor.ScreenHeight-2)*8/10)
+	layout := PickerLayout{
+		Row:       max(0, (editor.ScreenHeight-2-height)/2),
+		Col:       max(0, (editor.ScreenWidth-width)/2),
+		Width:     width,
+		Height:    height,
+		ListWidth: width,
+	}
+	if width >= minPreviewPickerWidth {
+		layout.ListWidth = width / 2
+	}
+	return layout
+}
+
+// openPicker shows items in the picker and switches to typing the query.
+func (editor *Editor) openPicker(title string, items []PickerItem) {
+	editor.closeCompletion()
+	editor.Popup = nil
+	editor.Picker = &Picker{Title: title, items: items, Total: len(items)}
+	editor.Mode = Picking
+	editor.filterPicker()
+}
+
+// filterPicker matches the query against every item, best match first.
+func (editor *Editor) filterPicker() {
+	picker := editor.Picker
+	query := string(picker.Query)
+
+	picker.shown = []pickerMatch{}
+	for i, item := range picker.items {
+		if score, positions, ok := fuzzyMatch(query, item.Label); ok {
+			picker.shown = append(picker.shown, pickerMatch{item: i, score: score, positions: positions})
+		}
+	}
+	if query != "" {
+		slices.SortStableFunc(picker.shown, func(a, b pickerMatch) int {
+			return cmp.Or(
+				cmp.Compare(b.score, a.score),
+				cmp.Compare(len(picker.items[a.item].Label), len(picker.items[b.item].Label)),
+			)
+		})
+	}
+
+	picker.Count = len(picker.shown)
+	picker.Selected, picker.Top = 0, 0
+	editor.refreshPicker()
+}
+
+// refreshPicker fills in the visible lines and the preview.
+func (editor *Editor) refreshPicker() {
+	picker := editor.Picker
+	height := editor.PickerLayout().Height - 1
+
+	picker.Selected = max(0, min(picker.Selected, len(picker.shown)-1))
+	if picker.Selected < picker.Top {
+		picker.Top = picker.Selected
+	}
+	if picker.Selected >= picker.Top+height {
+		picker.Top = picker.Selected - height + 1
+	}
+
+	picker.Lines, picker.Matches, picker.Preview = []string{}, [][]int{}, nil
+	for _, match := range picker.shown[picker.Top:min(len(picker.shown), picker.Top+height)] {
+		picker.Lines = append(picker.Lines, picker.items[match.item].Label)
+		picker.Matches = append(picker.Matches, match.positions)
+	}
+
+	if len(picker.shown) == 0 {
+		return
+	}
+	if preview := picker.items[picker.shown[picker.Selected].item].preview; preview != nil {
+		text := strings.TrimSuffix(preview(), "\n")
+		lines := strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n")
+		picker.Preview = lines[:min(len(lines), height)]
+	}
+}
+
+func (editor *Editor) movePicker(offset int) {
+	editor.Picker.Selected += offset
+	editor.refreshPicker()
+}
+
+func (editor *Editor) typePicker(r rune) {
+	editor.Picker.Query = append(editor.Picker.Query, r)
+	editor.filterPicker()
+}
+
+func (editor *Editor) closePicker() {
+	editor.Picker = nil
+	editor.Mode = Normal
+}
+
+// acceptPicker closes the picker and acts on the selected item.
+func (editor *Editor) acceptPicker() {
+	picker := editor.Picker
+	editor.closePicker()
+	if len(picker.shown) == 0 {
+		return
+	}
+
+	item := picker.items[picker.shown[picker.Selected].item]
+	if err := item.accept(editor); err != nil {
+		editor.ReportError(err)
+	}
+}
+
+// linesAround is the text from a little above row, for previews that should
+// show a position in context.
+func linesAround(text []rune, row int) string {
+	lines := strings.Split(string(text), "\n")
+	start := max(0, min(row-3, len(lines)-1))
+	return strings.Join(lines[start:min(len(lines), start+maxPreviewLines)], "\n")
+}
+
+// previews never need more lines than a screen has
+const maxPreviewLines = 200
+
+// displayPath shortens paths under the working directory to relative ones.
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+func fileItem(label string, path string) PickerItem {
+	return PickerItem{
+		Label: label,
+		preview: func() string {
+			return pathPreview(path, maxPreviewLines)
+		},
+		accept: func(editor *Editor) error {
+			return editor.OpenFile(path)
+		},
+	}
+}
+
+// pickFile lists the files under the working directory. On the server that
+// is the server's directory, since this runs there.
+func (editor *Editor) pickFile() error {
+	files, err := projectFiles(".")
+	if err != nil {
+		return err
+	}
+
+	items := []PickerItem{}
+	for _, file := range files {
+		items = append(items, fileItem(file, filepath.FromSlash(file)))
+	}
+	editor.openPicker("Files", items)
+	return nil
+}
+
+func (editor *Editor) pickBuffer() {
+	contents := editor.ownedContents()
+	items := []PickerItem{}
+	for i, path := range editor.BufferPaths() {
+		item := fileItem(path, path)
+		// unsaved changes only show in the content
+		if i < len(contents) {
+			content, row := contents[i], editor.Cursor.Row
+			if i > 0 {
+				row, _ = content.position(editor.hidden[i-1].cursor)
+			}
+			item.preview = func() string {
+				return linesAround(content.calculateContent(), row)
+			}
+		}
+		items = append(items, item)
+	}
+	editor.openPicker("Buffers", items)
+}
+
+func (editor *Editor) pickRecentFile() {
+	items := []PickerItem{}
+	for _, path := range RecentFiles() {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			items = append(items, fileItem(displayPath(path), path))
+		}
+	}
+	editor.openPicker("Recent", items)
+}
+
+func (editor *Editor) pickMark() {
+	contents := map[string]*Content{}
+	for i, content := range editor.ownedContents() {
+		contents[editor.BufferPaths()[i]] = content
+	}
+
+	items := []PickerItem{}
+	for _, mark := range editor.Marks() {
+		content := contents[mark.Path]
+		line := content.lines()[mark.Row]
+		items = append(items, PickerItem{
+			Label: fmt.Sprintf("%c  %s:%d:%d  %s", mark.Name, mark.Path, mark.Row+1, mark.Col+1,
+				strings.TrimSpace(string(line))),
+			preview: func() string {
+				return linesAround(content.calculateContent(), mark.Row)
+			},
+			accept: func(editor *Editor) error {
+				if err := editor.OpenFile(mark.Path); err != nil {
+					return err
+				}
+				return editor.jumpMark(mark.Name, true)
+			},
+		})
+	}
+	editor.openPicker("Marks", items)
+}
+
+func (editor *Editor) pickCommand() {
+	items := []PickerItem{}
+	for _, line := range slices.Backward(editor.history) {
+		items = append(items, PickerItem{
+			Label: line,
+			accept: func(editor *Editor) error {
+				editor.remember(line)
+				return editor.ExecuteCommand(line)
+			},
+		})
+	}
+	editor.openPicker("History", items)
+}
+
+// pickerAction makes an action that does nothing unless the picker is open,
+// in case one is mapped outside of it.
+func pickerAction(fn func(editor *Editor)) Action {
+	return func(editor *Editor, key KeyStroke) {
+		if editor.Picker != nil {
+			fn(editor)
+		}
+	}
+}
+
+func init() {
+	registerAction("picker_files", func(editor *Editor, key KeyStroke) {
+		if err := editor.pickFile(); err != nil {
+			editor.ReportError(err)
+		}
+	})
+	registerAction("picker_buffers", func(editor *Editor, key KeyStroke) {
+		editor.pickBuffer()
+	})
+	registerAction("picker_recent", func(editor *Editor, key KeyStroke) {
+		editor.pickRecentFile()
+	})
+	registerAction("picker_marks", func(editor *Editor, key KeyStroke) {
+		editor.pickMark()
+	})
+	registerAction("picker_history", func(editor *Editor, key KeyStroke) {
+		editor.pickCommand()
+	})
+
+	registerAction("picker_accept", pickerAction((*Editor).acceptPicker))
+	registerAction("picker_cancel", pickerAction((*Editor).closePicker))
+	registerAction("picker_next", pickerAction(func(editor *Editor) {
+		editor.movePicker(1)
+	}))
+	registerAction("picker_prev", pickerAction(func(editor *Editor) {
+		editor.movePicker(-1)
+	}))
+	registerAction("picker_backspace", pickerAction(func(editor *Editor) {
+		if query := editor.Picker.Query; len(query) > 0 {
+			editor.Picker.Query = query[:len(query)-1]
+			editor.filterPicker()
+		}
+	}))
+	registerAction("picker_clear", pickerAction(func(editor *Editor) {
+		editor.Picker.Query = []rune{}
+		editor.filterPicker()
+	}))
+
+	bindDefault(Normal, "<Space>f", "picker_files")
+	bindDefault(Normal, "<Space>b", "picker_buffers")
+	bindDefault(Normal, "<Space>r", "picker_recent")
+	bindDefault(Normal, "<Space>m", "picker_marks")
+	bindDefault(Normal, "<Space>:", "picker_history")
+
+	bindDefault(Picking, "<CR>", "picker_accept")
+	bindDefault(Picking, "<Esc>", "picker_cancel")
+	bindDefault(Picking, "<C-c>", "picker_cancel")
+	bindDefault(Picking, "<C-n>", "picker_next")
+	bindDefault(Picking, "<Down>", "picker_next")
+	bindDefault(Picking, "<Tab>", "picker_next")
+	bindDefault(Picking, "<C-p>", "picker_prev")
+	bindDefault(Picking, "<Up>", "picker_prev")
+	bindDefault(Picking, "<S-Tab>", "picker_prev")
+	bindDefault(Picking, "<BS>", "picker_backspace")
+	bindDefault(Picking, "<C-h>", "picker_backspace")
+	bindDefault(Picking, "<C-u>", "picker_clear")
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.pickFile()
+	}, "Files")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		editor.pickBuffer()
+		return nil
+	}, "Buffers")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		// :History: is the command line history, as in fzf.vim
+		if args == ":" {
+			editor.pickCommand()
+		} else {
+			editor.pickRecentFile()
+		}
+		return nil
+	}, "History")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		editor.pickMark()
+		return nil
+	}, "Marks")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if args == "" {
+			if editor.Content.Modified() && !bang {
+				return fmt.Errorf("%s has unsaved changes, :e! to throw them away", editor.FileName)
+			}
+			return editor.reload()
+		}
+		return editor.OpenFile(args)
+	}, "e", "edit")
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestProjectFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{
+		"main.go",
+		"notes.log",
+		"keep.log",
+		"build/out.bin",
+		"src/app.go",
+		"src/gen/code.go",
+		"src/gen/keep.go",
+		"docs/a/b/c.md",
+		".git/HEAD",
+	} {
+		writeTestFile(t, filepath.Join(dir, filepath.FromSlash(file)), "")
+	}
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "# build output\n*.log\n!keep.log\nbuild/\n/docs/**/c.md\n")
+	writeTestFile(t, filepath.Join(dir, "src", ".gitignore"), "gen/*\n!gen/keep.go\n")
+
+	files, err := projectFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{".gitignore", "keep.log", "main.go", "src/.gitignore", "src/app.go", "src/gen/keep.go"}
+	if !slices.Equal(files, expected) {
+		t.Fatalf("\nGot: %q\nExpected: %q", files, expected)
+	}
+}
+
+func TestPickerFilterAndOpen(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.txt"), filepath.Join(dir, "second.txt")
+	writeTestFile(t, first, "one\n")
+	writeTestFile(t, second, "two\nlines\n")
+
+	editor := InitializeEditor(first, 24, 80)
+	editor.openPicker("Files", []PickerItem{
+		fileItem("first.txt", first),
+		fileItem("second.txt", second),
+	})
+	typeKeys(t, &editor, "sec")
+	if editor.Mode != Picking || !slices.Equal(editor.Picker.Lines, []string{"second.txt"}) {
+		t.Fatalf("expected only second.txt to match, got %q", editor.Picker.Lines)
+	}
+	if !slices.Equal(editor.Picker.Preview, []string{"two", "lines"}) {
+		t.Fatalf("unexpected preview %q", editor.Picker.Preview)
+	}
+
+	typeKeys(t, &editor, "<CR>")
+	if editor.Mode != Normal || editor.Picker != nil || editor.FilePath != second {
+		t.Fatalf("expected second.txt open in normal mode, got %s", editor.FilePath)
+	}
+	expectContent(t, &editor, "two\nlines")
+
+	// the first file keeps its unsaved edits and cursor while hidden
+	typeKeys(t, &editor, "jdd")
+	if err := editor.OpenFile(first); err != nil {
+		t.Fatal(err)
+	}
+	typeKeys(t, &editor, "ia<Esc>")
+	if err := editor.OpenFile(second); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, &editor, "two")
+	if !slices.Equal(editor.BufferPaths(), []string{second, first}) {
+		t.Fatalf("unexpected buffers %q", editor.BufferPaths())
+	}
+
+	editor.ExecuteCommand("wa")
+	for path, expected := range map[string]string{first: "aone\n", second: "two\n"} {
+		raw, _ := os.ReadFile(path)
+		if string(raw) != expected {
+			t.Fatalf("%s: saved %q, expected %q", path, string(raw), expected)
+		}
+	}
+	if editor.FilePath != second {
+		t.Fatalf("expected :wa to keep showing %s, got %s", second, editor.FilePath)
+	}
+
+	recent := RecentFiles()
+	if len(recent) < 2 || recent[0] != second || recent[1] != first {
+		t.Fatalf("expected both files at the front of the recent files, got %q", recent)
+	}
+}
+
+func TestMarks(t *testing.T) {
+	editor := newTestEditor("one\n  two\nthree")
+
+	typeKeys(t, editor, "jllmaj")
+	// an edit above the mark moves it with its text
+	editor.Content.replace([]rune("zero\n"), 0, 0)
+
+	typeKeys(t, editor, "`a")
+	if editor.Cursor.Row != 2 || editor.Cursor.Col != 2 {
+		t.Fatalf("expected the exact mark position, got %+v", editor.Cursor)
+	}
+	typeKeys(t, editor, "j'a")
+	if editor.Cursor.Row != 2 || editor.Cursor.Col != 2 {
+		t.Fatalf("expected the first non blank of the mark's line, got %+v", editor.Cursor)
+	}
+
+	marks := editor.Marks()
+	if len(marks) != 1 || marks[0].Name != 'a' || marks[0].Row != 2 {
+		t.Fatalf("unexpected marks %+v", marks)
+	}
+
+	typeKeys(t, editor, "`b")
+	if editor.Message == "" {
+		t.Fatal("expected a message for an unset mark")
+	}
+}
+
+func TestCommandHistoryPicker(t *testing.T) {
+	editor := newTestEditor("one")
+
+	typeKeys(t, editor, ":set ts=2<CR>:set ts=3<CR>:set ts=2<CR>")
+	if !slices.Equal(editor.history, []string{"set ts=3", "set ts=2"}) {
+		t.Fatalf("unexpected history %q", editor.history)
+	}
+
+	editor.ExecuteCommand("History:")
+	if !slices.Equal(editor.Picker.Lines, []string{"set ts=2", "set ts=3"}) {
+		t.Fatalf("expected the latest command first, got %q", editor.Picker.Lines)
+	}
+	typeKeys(t, editor, "<C-n><CR>")
+	if editor.OptionInt("tabstop") != 3 || editor.history[len(editor.history)-1] != "set ts=3" {
+		t.Fatalf("expected :set ts=3 to run again, got history %q", editor.history)
+	}
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// the most lines a popup shows at once, and the widest a preview gets
+const (
+	maxPopupHeight  = 10
+	maxPreviewWidth = 60
+)
+
+// Popup is a small window drawn over the text next to the cursor, for hover
+// text and menus. Row and Col are the screen position of its top left corner
+// and Top is the first of Lines that is shown. A menu can have a preview of
+// the selected line drawn beside it, starting at PreviewCol on the same row.
+type Popup struct {
+	Lines    []string
+	Row      int
+	Col      int
+	Width    int
+	Height   int
+	Top      int
+	Selected int
+
+	Preview      []string
+	PreviewCol   int
+	PreviewWidth int
+}
+
+// showPopup opens a popup below the cursor, or above it when there is no room
+// below. selected is the highlighted line of a menu, -1 for plain text.
+func (editor *Editor) showPopup(lines []string, selected int) {
+	if len(lines) == 0 {
+		editor.Popup = nil
+		return
+	}
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+	width = min(width+2, editor.ScreenWidth)
+
+	col, row := editor.CursorScreenPosition()
+	top := editor.TablineHeight()
+	bottom := top + editor.TextHeight()
+	height := min(len(lines), maxPopupHeight, max(row-top, bottom-row-1))
+
+	popup := &Popup{
+		Lines:    lines,
+		Row:      row + 1,
+		Col:      max(0, min(col, editor.ScreenWidth-width)),
+		Width:    width,
+		Height:   max(1, height),
+		Selected: selected,
+	}
+	if popup.Row+popup.Height > bottom {
+		popup.Row = max(top, row-popup.Height)
+	}
+
+	editor.Popup = popup
+	editor.selectPopupLine(selected)
+}
+
+// selectPopupLine highlights a line of a menu, scrolling it into view.
+func (editor *Editor) selectPopupLine(selected int) {
+	popup := editor.Popup
+	if popup == nil || selected < 0 {
+		return
+	}
+
+	popup.Selected = (selected + len(popup.Lines)) % len(popup.Lines)
+	if popup.Selected < popup.Top {
+		popup.Top = popup.Selected
+	}
+	if popup.Selected >= popup.Top+popup.Height {
+		popup.Top = popup.Selected - popup.Height + 1
+	}
+}
+
+// VisibleLines is the part of the popup that fits on screen.
+func (popup *Popup) VisibleLines() []string {
+	return popup.Lines[popup.Top:min(len(popup.Lines), popup.Top+popup.Height)]
+}
+
+// setPopupPreview shows text beside the popup, on the right when it fits
+// and otherwise on the left.
+func (editor *Editor) setPopupPreview(text string) {
+	popup := editor.Popup
+	popup.Preview = nil
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n")
+	lines = lines[:min(len(lines), maxPopupHeight, max(0, editor.TablineHeight()+editor.TextHeight()-popup.Row))]
+	width := 0
+	for _, line := range lines {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+	width = min(width+2, maxPreviewWidth)
+
+	switch {
+	case popup.Col+popup.Width+width <= editor.ScreenWidth:
+		popup.PreviewCol = popup.Col + popup.Width
+	case popup.Col >= width:
+		popup.PreviewCol = popup.Col - width
+	default:
+		return
+	}
+	popup.Preview, popup.PreviewWidth = lines, width
+}
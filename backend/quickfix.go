@@ -0,0 +1,322 @@
+package backend
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// QuickfixItem is a position in a file, with the text of its line. Row and
+// Col count from 0. Errors from :make have a Type, named like a diagnostic's
+// severity.
+type QuickfixItem struct {
+	Path string
+	Row  int
+	Col  int
+	Text string
+	Type string
+}
+
+// quickfixList is what the last :grep or :make found, and the item :cnext
+// and :cprev go on from.
+type quickfixList struct {
+	title   string
+	items   []QuickfixItem
+	current int
+	// whether the items are errors to mark in the sign column
+	signs bool
+}
+
+// QuickfixWindow shows the quickfix list across the bottom of the screen,
+// under the text. Height counts its title row. Like the picker only the rows
+// that fit are kept in Lines, from Top.
+type QuickfixWindow struct {
+	Title    string
+	Lines    []string
+	Top      int
+	Selected int
+	Current  int
+	Height   int
+}
+
+// the height :copen uses without a count
+const defaultQuickfixHeight = 10
+
+// setQuickfix replaces the quickfix list.
+func (editor *Editor) setQuickfix(title string, items []QuickfixItem) {
+	editor.quickfix = quickfixList{title: title, items: items}
+	if editor.QuickfixWindow != nil {
+		editor.QuickfixWindow.Selected = 0
+		editor.refreshQuickfix()
+	}
+}
+
+// describe is how an item is listed: path|line col column type| text.
+func (item QuickfixItem) describe() string {
+	position := fmt.Sprintf("%d col %d", item.Row+1, item.Col+1)
+	if item.Type != "" {
+		position += " " + item.Type
+	}
+	return fmt.Sprintf("%s|%s| %s", item.Path, position, strings.TrimSpace(item.Text))
+}
+
+// jumpQuickfix goes to item i of the quickfix list.
+func (editor *Editor) jumpQuickfix(i int) error {
+	items := editor.quickfix.items
+	if len(items) == 0 {
+		return fmt.Errorf("no quickfix list")
+	}
+	if i < 0 || i >= len(items) {
+		return fmt.Errorf("no more items")
+	}
+
+	editor.quickfix.current = i
+	if window := editor.QuickfixWindow; window != nil {
+		window.Selected = i
+		editor.refreshQuickfix()
+	}
+
+	item := items[i]
+	if err := editor.openAt(item.Path, item.Row, item.Col); err != nil {
+		return err
+	}
+	editor.inform(fmt.Sprintf("(%d of %d): %s", i+1, len(items), strings.TrimSpace(item.Text)))
+	return nil
+}
+
+// openQuickfix shows the quickfix window and moves into it.
+func (editor *Editor) openQuickfix(height int) {
+	window := editor.QuickfixWindow
+	if window == nil {
+		window = &QuickfixWindow{Selected: editor.quickfix.current}
+		editor.QuickfixWindow = window
+	}
+	// the text keeps at least one row
+	window.Height = max(2, min(height, editor.ScreenHeight-3))
+	editor.Mode = Quickfixing
+	editor.refreshQuickfix()
+	editor.ScrollToCursor()
+}
+
+func (editor *Editor) closeQuickfix() {
+	editor.QuickfixWindow = nil
+	if editor.Mode == Quickfixing {
+		editor.Mode = Normal
+	}
+}
+
+// refreshQuickfix fills in the window's title and visible lines.
+func (editor *Editor) refreshQuickfix() {
+	window, list := editor.QuickfixWindow, editor.quickfix
+	height := window.Height - 1
+
+	window.Title = "Quickfix"
+	if list.title != "" {
+		window.Title += ": " + list.title
+	}
+	window.Current = list.current
+	window.Selected = max(0, min(window.Selected, len(list.items)-1))
+	if window.Selected < window.Top {
+		window.Top = window.Selected
+	}
+	if window.Selected >= window.Top+height {
+		window.Top = window.Selected - height + 1
+	}
+	window.Top = max(0, min(window.Top, len(list.items)-height))
+
+	window.Lines = []string{}
+	for _, item := range list.items[window.Top:min(len(list.items), window.Top+height)] {
+		window.Lines = append(window.Lines, item.describe())
+	}
+}
+
+// quickfixDo runs command at every item of the quickfix list, or once in
+// each file when perFile is set. The files it changes are left open and
+// unsaved, :wa writes them.
+func (editor *Editor) quickfixDo(command string, perFile bool) error {
+	items := editor.quickfix.items
+	if len(items) == 0 {
+		return fmt.Errorf("no quickfix list")
+	}
+	if command == "" {
+		return fmt.Errorf("no command given")
+	}
+
+	// behind an opener files are switched to later, by the frontend, so
+	// every item has to be in this file before any of them is changed
+	if editor.opener != nil {
+		for _, item := range items {
+			if !samePath(item.Path, editor.FilePath) {
+				return fmt.Errorf("%s is not open here, remote editors can only change their own file", item.Path)
+			}
+		}
+	}
+
+	done := map[string]bool{}
+	for i, item := range items {
+		file, _ := filepath.Abs(item.Path)
+		if perFile && done[file] {
+			continue
+		}
+		done[file] = true
+
+		if err := editor.jumpQuickfix(i); err != nil {
+			return err
+		}
+		if err := editor.ExecuteCommand(command); err != nil {
+			return fmt.Errorf("%s: %w", item.Path, err)
+		}
+	}
+	return nil
+}
+
+// quickfixCommand makes a command that moves through the quickfix list.
+// next picks the item to go to from the current one and the count given.
+func quickfixCommand(next func(current int, count int, items int) int) ExCommand {
+	return func(editor *Editor, bang bool, args string) error {
+		count := 0
+		if args != "" {
+			var err error
+			if count, err = strconv.Atoi(args); err != nil {
+				return fmt.Errorf("invalid count: %s", args)
+			}
+		}
+		list := editor.quickfix
+		return editor.jumpQuickfix(next(list.current, count, len(list.items)))
+	}
+}
+
+func init() {
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		words, err := splitArgs(args)
+		if err != nil {
+			return err
+		}
+		if len(words) == 0 {
+			return fmt.Errorf("usage: grep pattern [path ...]")
+		}
+		paths := words[1:]
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+
+		// what was found is kept even when some paths could not be searched
+		items, grepErr := grep(words[0], paths)
+		if grepErr != nil && len(items) == 0 {
+			return grepErr
+		}
+		editor.setQuickfix("grep "+args, items)
+		if len(items) == 0 {
+			return fmt.Errorf("no matches: %s", words[0])
+		}
+		// like vim, :grep! stays put
+		if !bang {
+			if err := editor.jumpQuickfix(0); err != nil {
+				return err
+			}
+		}
+		switch {
+		case grepErr != nil:
+			editor.warn(fmt.Sprintf("%d matches, but %s", len(items), grepErr))
+		case bang:
+			editor.inform(fmt.Sprintf("%d matches", len(items)))
+		}
+		return nil
+	}, "grep", "gr")
+
+	registerExCommand(quickfixCommand(func(current int, count int, items int) int {
+		return current + max(1, count)
+	}), "cnext", "cn")
+	registerExCommand(quickfixCommand(func(current int, count int, items int) int {
+		return current - max(1, count)
+	}), "cprevious", "cprev", "cp", "cNext", "cN")
+	registerExCommand(quickfixCommand(func(current int, count int, items int) int {
+		if count > 0 {
+			return count - 1
+		}
+		return current
+	}), "cc")
+	registerExCommand(quickfixCommand(func(current int, count int, items int) int {
+		return max(1, count) - 1
+	}), "cfirst", "cfir", "crewind", "cr")
+	registerExCommand(quickfixCommand(func(current int, count int, items int) int {
+		if count > 0 {
+			return count - 1
+		}
+		return items - 1
+	}), "clast", "cla")
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		height := defaultQuickfixHeight
+		if args != "" {
+			var err error
+			if height, err = strconv.Atoi(args); err != nil || height < 1 {
+				return fmt.Errorf("invalid height: %s", args)
+			}
+		}
+		editor.openQuickfix(height)
+		return nil
+	}, "copen", "cope")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		editor.closeQuickfix()
+		return nil
+	}, "cclose", "ccl")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.quickfixDo(args, false)
+	}, "cdo")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.quickfixDo(args, true)
+	}, "cfdo")
+
+	quickfixWindowAction := func(fn func(editor *Editor) error) Action {
+		return func(editor *Editor, key KeyStroke) {
+			if editor.QuickfixWindow == nil {
+				return
+			}
+			if err := fn(editor); err != nil {
+				editor.ReportError(err)
+			}
+		}
+	}
+	registerAction("quickfix_next", func(editor *Editor, key KeyStroke) {
+		if err := editor.ExecuteCommand("cnext"); err != nil {
+			editor.ReportError(err)
+		}
+	})
+	registerAction("quickfix_prev", func(editor *Editor, key KeyStroke) {
+		if err := editor.ExecuteCommand("cprev"); err != nil {
+			editor.ReportError(err)
+		}
+	})
+	registerAction("quickfix_down", quickfixWindowAction(func(editor *Editor) error {
+		editor.QuickfixWindow.Selected += 1
+		editor.refreshQuickfix()
+		return nil
+	}))
+	registerAction("quickfix_up", quickfixWindowAction(func(editor *Editor) error {
+		editor.QuickfixWindow.Selected -= 1
+		editor.refreshQuickfix()
+		return nil
+	}))
+	registerAction("quickfix_jump", quickfixWindowAction(func(editor *Editor) error {
+		editor.Mode = Normal
+		return editor.jumpQuickfix(editor.QuickfixWindow.Selected)
+	}))
+	registerAction("quickfix_close", func(editor *Editor, key KeyStroke) {
+		editor.closeQuickfix()
+	})
+	registerAction("quickfix_leave", func(editor *Editor, key KeyStroke) {
+		editor.Mode = Normal
+	})
+
+	bindDefault(Normal, "]q", "quickfix_next")
+	bindDefault(Normal, "[q", "quickfix_prev")
+	bindDefault(Quickfixing, "j", "quickfix_down")
+	bindDefault(Quickfixing, "<Down>", "quickfix_down")
+	bindDefault(Quickfixing, "k", "quickfix_up")
+	bindDefault(Quickfixing, "<Up>", "quickfix_up")
+	bindDefault(Quickfixing, "<CR>", "quickfix_jump")
+	bindDefault(Quickfixing, "q", "quickfix_close")
+	bindDefault(Quickfixing, "<Esc>", "quickfix_leave")
+}
@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	editor := newTestEditor("foo foo\nbar Foo\nfoo\nbaz")
+
+	editor.ExecuteCommand("s/foo/x/")
+	expectContent(t, editor, "x foo\nbar Foo\nfoo\nbaz")
+
+	editor.ExecuteCommand("%s/foo/<&>/gi")
+	expectContent(t, editor, "x <foo>\nbar <Foo>\n<foo>\nbaz")
+	if editor.Cursor.Row != 2 || editor.Message != "3 substitutions on 3 lines" {
+		t.Fatalf("expected the cursor on the last change and a count, got %d %q", editor.Cursor.Row, editor.Message)
+	}
+
+	// groups, other delimiters, and a newline in the replacement
+	editor.ExecuteCommand(`2,$s#<(\w+)>#\1\n#`)
+	expectContent(t, editor, "x <foo>\nbar Foo\n\nfoo\n\nbaz")
+	if editor.Cursor.Row != 4 {
+		t.Fatalf("expected the cursor below the added lines, got row %d", editor.Cursor.Row)
+	}
+
+	editor.moveCursorTo(0)
+	typeKeys(t, editor, "jma")
+	if err := editor.ExecuteCommand("'a,.+1s/o/0/g"); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, editor, "x <foo>\nbar F00\n\nfoo\n\nbaz")
+
+	if err := editor.ExecuteCommand("s/nothing/x/"); err == nil {
+		t.Fatal("expected an error when nothing matches")
+	}
+	if err := editor.ExecuteCommand("s/nothing/x/e"); err != nil {
+		t.Fatalf("expected the e flag to hide the error, got %v", err)
+	}
+	if err := editor.ExecuteCommand("%w"); err == nil {
+		t.Fatal("expected commands without ranges to refuse them")
+	}
+
+	editor.ExecuteCommand("4")
+	if editor.Cursor.Row != 3 {
+		t.Fatalf("expected a line number to go to the line, got row %d", editor.Cursor.Row)
+	}
+}
+
+func TestGrepAndQuickfix(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "one oldName\ntwo\n  three oldName oldName\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.txt"), "oldName()\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "skip.log"), "oldName\n")
+	writeTestFile(t, filepath.Join(dir, "binary"), "oldName\x00")
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "*.log\n")
+
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub", "b.txt")
+	expected := []QuickfixItem{
+		{Path: a, Row: 0, Col: 4, Text: "one oldName"},
+		{Path: a, Row: 2, Col: 8, Text: "  three oldName oldName"},
+		{Path: b, Row: 0, Col: 0, Text: "oldName()"},
+	}
+	items, err := grepFiles("old[A-Z]", []string{dir})
+	if err != nil || !slices.Equal(items, expected) {
+		t.Fatalf("\nGot: %+v\nExpected: %+v", items, expected)
+	}
+	if rg, err := exec.LookPath("rg"); err == nil {
+		if items, err := ripgrep(rg, "old[A-Z]", []string{dir}); err != nil || !slices.Equal(items, expected) {
+			t.Fatalf("ripgrep differs\nGot: %+v\nExpected: %+v", items, expected)
+		}
+	}
+
+	// a path that cannot be searched does not lose the matches elsewhere
+	missing := filepath.Join(dir, "missing")
+	if items, err := grepFiles("old[A-Z]", []string{dir, missing}); err == nil || !slices.Equal(items, expected) {
+		t.Fatalf("expected the matches and an error, got %+v %v", items, err)
+	}
+	rg := filepath.Join(t.TempDir(), "rg")
+	writeTestFile(t, rg, "#!/bin/sh\nprintf 'a.txt\\0001:5:one oldName\\n'\necho 'missing: No such file' >&2\nexit 2\n")
+	os.Chmod(rg, 0755)
+	items, err = ripgrep(rg, "old[A-Z]", []string{dir, missing})
+	if err == nil || !strings.Contains(err.Error(), "missing") || len(items) != 1 || items[0].Col != 4 {
+		t.Fatalf("expected rg's matches and its complaint, got %+v %v", items, err)
+	}
+
+	editor := InitializeEditor(b, 24, 80)
+	if err := editor.ExecuteCommand("grep! 'old[A-Z]' " + dir + " " + missing); err != nil {
+		t.Fatal(err)
+	}
+	if editor.MessageLevel != MessageWarning || !strings.HasPrefix(editor.Message, "3 matches, but ") {
+		t.Fatalf("expected the matches with a warning, got %q", editor.Message)
+	}
+	if err := editor.ExecuteCommand("grep 'old[A-Z]' " + dir); err != nil {
+		t.Fatal(err)
+	}
+	if editor.FilePath != a || editor.Cursor.Row != 0 || editor.Cursor.Col != 4 {
+		t.Fatalf("expected to jump to the first match, got %s %+v", editor.FilePath, editor.Cursor)
+	}
+
+	editor.ExecuteCommand("cn")
+	if editor.Cursor.Row != 2 || editor.Cursor.Col != 8 || editor.Message != "(2 of 3): three oldName oldName" {
+		t.Fatalf("unexpected position %+v and message %q", editor.Cursor, editor.Message)
+	}
+	typeKeys(t, &editor, "]q")
+	if editor.FilePath != b {
+		t.Fatalf("expected ]q to open the next file, got %s", editor.FilePath)
+	}
+	if err := editor.ExecuteCommand("cn"); err == nil {
+		t.Fatal("expected an error past the last item")
+	}
+
+	// the tabline takes a row as well, with both files open
+	editor.ExecuteCommand("copen 5")
+	window := editor.QuickfixWindow
+	if editor.Mode != Quickfixing || editor.TextHeight() != 16 || len(window.Lines) != 3 || window.Selected != 2 {
+		t.Fatalf("unexpected window %+v", window)
+	}
+	if window.Lines[1] != a+"|3 col 9| three oldName oldName" {
+		t.Fatalf("unexpected line %q", window.Lines[1])
+	}
+	typeKeys(t, &editor, "kk<CR>")
+	if editor.Mode != Normal || editor.FilePath != a || editor.Cursor.Row != 0 || window.Current != 0 {
+		t.Fatalf("expected the window to jump to the first item, got %s %+v", editor.FilePath, editor.Cursor)
+	}
+
+	// :cdo visits every match, the e flag skips ones an earlier run changed
+	if err := editor.ExecuteCommand("cdo s/oldName/newName/e"); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, &editor, "newName()")
+	editor.ExecuteCommand("cfirst")
+	expectContent(t, &editor, "one newName\ntwo\n  three newName oldName")
+
+	if err := editor.ExecuteCommand("cfdo %s/Name/Id/g"); err != nil {
+		t.Fatal(err)
+	}
+	editor.ExecuteCommand("wa")
+	for path, text := range map[string]string{a: "one newId\ntwo\n  three newId oldId\n", b: "newId()\n"} {
+		raw, _ := os.ReadFile(path)
+		if string(raw) != text {
+			t.Fatalf("%s: saved %q, expected %q", path, string(raw), text)
+		}
+	}
+
+	editor.ExecuteCommand("cclose")
+	if editor.QuickfixWindow != nil || editor.TextHeight() != 21 {
+		t.Fatal("expected :cclose to give the rows back")
+	}
+
+	// a remote editor only changes anything when every item is in its file
+	editor.ExecuteCommand("cfirst")
+	editor.SetOpener(func(path string) {})
+	if err := editor.ExecuteCommand("cdo s/newId/lostId/"); err == nil {
+		t.Fatal("expected :cdo to refuse items in other files")
+	}
+	expectContent(t, &editor, "one newId\ntwo\n  three newId oldId")
+}
+
+func TestSplitArgs(t *testing.T) {
+	words, err := splitArgs(`"two words" it\'s \bword\b  'single "quoted"'`)
+	expected := []string{"two words", "it's", `\bword\b`, `single "quoted"`}
+	if err != nil || !slices.Equal(words, expected) {
+		t.Fatalf("\nGot: %q\nExpected: %q", words, expected)
+	}
+	if _, err := splitArgs(`"open`); err == nil {
+		t.Fatal("expected an error for a missing quote")
+	}
+}
@@ -0,0 +1,826 @@
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Snippet is a template expanded in insert mode when one of its prefixes is
+// typed before the trigger key. Body uses VS Code's snippet syntax.
+type Snippet struct {
+	Name        string
+	Prefixes    []string
+	Body        string
+	Description string
+
+	// filetypes a .code-snippets file limits the snippet to, empty for all
+	scopes []string
+}
+
+// snippetDir is where snippets are loaded from: <filetype>.json files in VS
+// Code's format, and .code-snippets files whose snippets carry a scope.
+func snippetDir() string {
+	config := DefaultConfigPath()
+	if config == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(config), "snippets")
+}
+
+type snippetFile struct {
+	modTime  time.Time
+	snippets []Snippet
+}
+
+var (
+	snippetFilesMu sync.Mutex
+	snippetFiles   = map[string]snippetFile{}
+)
+
+// snippetsFor lists the snippets for a filetype, sorted by name.
+func snippetsFor(filetype string) ([]Snippet, error) {
+	dir := snippetDir()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) || dir == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snippets := []Snippet{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name != filetype+".json" && filepath.Ext(name) != ".code-snippets" {
+			continue
+		}
+
+		loaded, err := loadSnippetFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, snippet := range loaded {
+			if len(snippet.scopes) == 0 || slices.Contains(snippet.scopes, filetype) {
+				snippets = append(snippets, snippet)
+			}
+		}
+	}
+
+	slices.SortStableFunc(snippets, func(a, b Snippet) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return snippets, nil
+}
+
+// loadSnippetFile parses a snippet file, reusing the last parse until the
+// file changes.
+func loadSnippetFile(path string) ([]Snippet, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snippetFilesMu.Lock()
+	defer snippetFilesMu.Unlock()
+
+	if cached, ok := snippetFiles[path]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.snippets, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snippets, err := parseSnippetFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	snippetFiles[path] = snippetFile{modTime: info.ModTime(), snippets: snippets}
+	return snippets, nil
+}
+
+// stringList is a JSON string or array of strings.
+type stringList []string
+
+func (list *stringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*list = stringList{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(list))
+}
+
+func parseSnippetFile(data []byte) ([]Snippet, error) {
+	entries := map[string]struct {
+		Prefix      stringList `json:"prefix"`
+		Body        stringList `json:"body"`
+		Description string     `json:"description"`
+		Scope       string     `json:"scope"`
+	}{}
+	if err := json.Unmarshal(stripJSONComments(data), &entries); err != nil {
+		return nil, err
+	}
+
+	snippets := []Snippet{}
+	for name, entry := range entries {
+		snippet := Snippet{
+			Name:        name,
+			Prefixes:    entry.Prefix,
+			Body:        strings.Join(entry.Body, "\n"),
+			Description: entry.Description,
+		}
+		for _, scope := range strings.Split(entry.Scope, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				snippet.scopes = append(snippet.scopes, scope)
+			}
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, nil
+}
+
+// stripJSONComments blanks out the // and /* */ comments VS Code allows in
+// snippet files.
+func stripJSONComments(data []byte) []byte {
+	data = bytes.Clone(data)
+	inString := false
+	for i := 0; i < len(data); i += 1 {
+		switch {
+		case inString && data[i] == '\\':
+			i += 1
+		case data[i] == '"':
+			inString = !inString
+		case inString:
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			for ; i < len(data) && data[i] != '\n'; i += 1 {
+				data[i] = ' '
+			}
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := len(data)
+			if close := bytes.Index(data[i+2:], []byte("*/")); close != -1 {
+				end = i + 2 + close + 2
+			}
+			for ; i < end; i += 1 {
+				if data[i] != '\n' {
+					data[i] = ' '
+				}
+			}
+			i -= 1
+		}
+	}
+	return data
+}
+
+// snippetNode is a piece of a parsed snippet body: literal text, a tabstop
+// or placeholder, or a variable.
+type snippetNode struct {
+	text     string
+	stop     int
+	variable string
+	// a placeholder's or variable's default
+	children []snippetNode
+	choices  []string
+}
+
+const notStop = -1
+
+// parseSnippet parses VS Code snippet syntax. Anything that does not parse
+// as a tabstop or variable is kept as text.
+func parseSnippet(body string) []snippetNode {
+	parser := snippetParser{runes: []rune(body)}
+	return parser.nodes(false)
+}
+
+type snippetParser struct {
+	runes []rune
+	pos   int
+}
+
+func (parser *snippetParser) peek(offset int) rune {
+	if parser.pos+offset < len(parser.runes) {
+		return parser.runes[parser.pos+offset]
+	}
+	return 0
+}
+
+// nodes parses up to the end of the body, or the } closing a placeholder
+// when nested is set.
+func (parser *snippetParser) nodes(nested bool) []snippetNode {
+	nodes := []snippetNode{}
+	text := []rune{}
+	flush := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, snippetNode{text: string(text), stop: notStop})
+			text = []rune{}
+		}
+	}
+
+	for parser.pos < len(parser.runes) {
+		r := parser.runes[parser.pos]
+		switch {
+		case r == '\\' && strings.ContainsRune(`$}\`, parser.peek(1)):
+			text = append(text, parser.peek(1))
+			parser.pos += 2
+		case r == '}' && nested:
+			flush()
+			return nodes
+		case r == '$':
+			start := parser.pos
+			if node, ok := parser.dollar(); ok {
+				flush()
+				nodes = append(nodes, node)
+			} else {
+				parser.pos = start + 1
+				text = append(text, '$')
+			}
+		default:
+			text = append(text, r)
+			parser.pos += 1
+		}
+	}
+	flush()
+	return nodes
+}
+
+func (parser *snippetParser) number() (int, bool) {
+	start := parser.pos
+	for unicode.IsDigit(parser.peek(0)) {
+		parser.pos += 1
+	}
+	n, err := strconv.Atoi(string(parser.runes[start:parser.pos]))
+	return n, err == nil
+}
+
+func (parser *snippetParser) name() string {
+	start := parser.pos
+	for r := parser.peek(0); r == '_' || unicode.IsLetter(r) ||
+		parser.pos > start && unicode.IsDigit(r); r = parser.peek(0) {
+		parser.pos += 1
+	}
+	return string(parser.runes[start:parser.pos])
+}
+
+// dollar parses what follows a $, leaving pos anywhere when it fails.
+func (parser *snippetParser) dollar() (snippetNode, bool) {
+	parser.pos += 1
+	if stop, ok := parser.number(); ok {
+		return snippetNode{stop: stop}, true
+	}
+	if name := parser.name(); name != "" {
+		return snippetNode{stop: notStop, variable: name}, true
+	}
+	if parser.peek(0) != '{' {
+		return snippetNode{}, false
+	}
+	parser.pos += 1
+
+	node := snippetNode{stop: notStop}
+	if stop, ok := parser.number(); ok {
+		node.stop = stop
+	} else if node.variable = parser.name(); node.variable == "" {
+		return snippetNode{}, false
+	}
+
+	switch parser.peek(0) {
+	case '}':
+		parser.pos += 1
+		return node, true
+	case ':':
+		parser.pos += 1
+		node.children = parser.nodes(true)
+		if parser.peek(0) != '}' {
+			return snippetNode{}, false
+		}
+		parser.pos += 1
+		return node, true
+	case '|':
+		if node.stop == notStop {
+			return snippetNode{}, false
+		}
+		parser.pos += 1
+		choice := []rune{}
+		for parser.pos < len(parser.runes) {
+			r := parser.runes[parser.pos]
+			switch {
+			case r == '\\' && strings.ContainsRune(`,|\`, parser.peek(1)):
+				choice = append(choice, parser.peek(1))
+				parser.pos += 2
+				continue
+			case r == ',' || r == '|':
+				node.choices = append(node.choices, string(choice))
+				choice = []rune{}
+			default:
+				choice = append(choice, r)
+			}
+			parser.pos += 1
+			if r == '|' {
+				if parser.peek(0) != '}' {
+					return snippetNode{}, false
+				}
+				parser.pos += 1
+				return node, true
+			}
+		}
+	}
+	return snippetNode{}, false
+}
+
+// snippetExpansion is a rendered snippet. Offsets are runes from the start
+// of text, the first range of each stop is the one typed into.
+type snippetExpansion struct {
+	text    []rune
+	stops   map[int][][2]int
+	choices map[int][]string
+}
+
+// expandSnippetBody renders body with the variables filled in, indenting
+// every line after the first like the cursor's line and turning the body's
+// leading tabs into the buffer's indentation.
+func (editor *Editor) expandSnippetBody(body string) snippetExpansion {
+	nodes := parseSnippet(body)
+	line, _ := editor.currentLine()
+	indent := slices.Clone(leadingWhitespace(line))
+	unit := editor.whitespaceFill(0, editor.shiftWidth())
+
+	// every occurrence of a stop shows the first placeholder's text
+	primary := map[int]*snippetNode{}
+	var find func(nodes []snippetNode)
+	find = func(nodes []snippetNode) {
+		for i := range nodes {
+			node := &nodes[i]
+			if node.stop != notStop {
+				if current, ok := primary[node.stop]; !ok ||
+					len(current.children) == 0 && len(current.choices) == 0 &&
+						(len(node.children) > 0 || len(node.choices) > 0) {
+					primary[node.stop] = node
+				}
+			}
+			find(node.children)
+		}
+	}
+	find(nodes)
+
+	expansion := snippetExpansion{stops: map[int][][2]int{}, choices: map[int][]string{}}
+	lineStart := false
+	var render func(nodes []snippetNode, mirror bool)
+	render = func(nodes []snippetNode, mirror bool) {
+		for i := range nodes {
+			node := &nodes[i]
+			switch {
+			case node.variable != "":
+				if value, ok := editor.snippetVariable(node.variable); ok && value != "" {
+					render([]snippetNode{{text: value, stop: notStop}}, true)
+				} else if len(node.children) > 0 {
+					render(node.children, mirror)
+				} else if !ok {
+					render([]snippetNode{{text: node.variable, stop: notStop}}, true)
+				}
+
+			case node.stop != notStop:
+				start := len(expansion.text)
+				source := primary[node.stop]
+				if len(source.choices) > 0 {
+					render([]snippetNode{{text: source.choices[0], stop: notStop}}, true)
+				} else {
+					render(source.children, mirror || source != node)
+				}
+				if mirror {
+					continue
+				}
+
+				span := [2]int{start, len(expansion.text)}
+				if source == node {
+					expansion.stops[node.stop] = append([][2]int{span}, expansion.stops[node.stop]...)
+					expansion.choices[node.stop] = node.choices
+				} else {
+					expansion.stops[node.stop] = append(expansion.stops[node.stop], span)
+				}
+
+			default:
+				for _, r := range node.text {
+					switch {
+					case r == '\n':
+						expansion.text = append(append(expansion.text, '\n'), indent...)
+						lineStart = true
+					case r == '\t' && lineStart:
+						expansion.text = append(expansion.text, unit...)
+					default:
+						expansion.text = append(expansion.text, r)
+						lineStart = lineStart && r == ' '
+					}
+				}
+			}
+		}
+	}
+	render(nodes, false)
+	return expansion
+}
+
+// snippetVariable resolves the variables VS Code defines. Unknown names
+// report false.
+func (editor *Editor) snippetVariable(name string) (string, bool) {
+	now := time.Now()
+	line, start := editor.currentLine()
+
+	switch name {
+	case "TM_FILENAME":
+		return editor.FileName, true
+	case "TM_FILENAME_BASE":
+		return strings.TrimSuffix(editor.FileName, filepath.Ext(editor.FileName)), true
+	case "TM_FILEPATH":
+		if path, err := filepath.Abs(editor.FilePath); err == nil {
+			return path, true
+		}
+		return editor.FilePath, true
+	case "TM_DIRECTORY":
+		if path, err := filepath.Abs(editor.FilePath); err == nil {
+			return filepath.Dir(path), true
+		}
+		return filepath.Dir(editor.FilePath), true
+	case "TM_LINE_INDEX":
+		return strconv.Itoa(editor.Cursor.Row), true
+	case "TM_LINE_NUMBER":
+		return strconv.Itoa(editor.Cursor.Row + 1), true
+	case "TM_CURRENT_LINE":
+		return string(line), true
+	case "TM_CURRENT_WORD":
+		col := editor.Cursor.Index - start
+		return string(line[wordStart(line, col):col]), true
+	case "TM_SELECTED_TEXT":
+		return "", true
+	case "CLIPBOARD":
+		return string(editor.register), true
+	case "CURRENT_YEAR":
+		return now.Format("2006"), true
+	case "CURRENT_YEAR_SHORT":
+		return now.Format("06"), true
+	case "CURRENT_MONTH":
+		return now.Format("01"), true
+	case "CURRENT_MONTH_NAME":
+		return now.Format("January"), true
+	case "CURRENT_MONTH_NAME_SHORT":
+		return now.Format("Jan"), true
+	case "CURRENT_DATE":
+		return now.Format("02"), true
+	case "CURRENT_DAY_NAME":
+		return now.Format("Monday"), true
+	case "CURRENT_DAY_NAME_SHORT":
+		return now.Format("Mon"), true
+	case "CURRENT_HOUR":
+		return now.Format("15"), true
+	case "CURRENT_MINUTE":
+		return now.Format("04"), true
+	case "CURRENT_SECOND":
+		return now.Format("05"), true
+	case "CURRENT_SECONDS_UNIX":
+		return strconv.FormatInt(now.Unix(), 10), true
+	case "RANDOM":
+		return fmt.Sprintf("%06d", rand.Intn(1000000)), true
+	case "RANDOM_HEX":
+		return fmt.Sprintf("%06x", rand.Intn(1<<24)), true
+	}
+	return "", false
+}
+
+// snippetStop is one tabstop of an expanded snippet. The first range is
+// typed into, the others mirror it.
+type snippetStop struct {
+	number  int
+	ranges  [][2]*anchor
+	choices []string
+}
+
+// snippetSession is the snippet being filled in. Its positions are anchors
+// so edits anywhere in the content keep them in place.
+type snippetSession struct {
+	start   *anchor
+	end     *anchor
+	stops   []snippetStop
+	current int
+
+	// the placeholder's text is selected, typing replaces it
+	selected        bool
+	selectedVersion int
+}
+
+func (session *snippetSession) anchors() []*anchor {
+	anchors := []*anchor{session.start, session.end}
+	for _, stop := range session.stops {
+		for _, span := range stop.ranges {
+			anchors = append(anchors, span[0], span[1])
+		}
+	}
+	return anchors
+}
+
+// Placeholder is a range of the snippet placeholder being filled in, drawn
+// highlighted.
+type Placeholder struct {
+	Start    int
+	End      int
+	Selected bool
+}
+
+// snippetAt finds the snippet whose prefix is the word before the cursor,
+// returning where the prefix starts.
+func (editor *Editor) snippetAt() (Snippet, int, bool) {
+	snippets, err := snippetsFor(editor.OptionString("filetype"))
+	if err != nil {
+		editor.ReportError(err)
+		return Snippet{}, 0, false
+	}
+
+	line, lineStart := editor.currentLine()
+	col := editor.Cursor.Index - lineStart
+	// prefixes can hold punctuation, take the longest one that fits
+	best, bestLen := Snippet{}, 0
+	for _, snippet := range snippets {
+		for _, prefix := range snippet.Prefixes {
+			runes := []rune(prefix)
+			if len(runes) <= bestLen || len(runes) > col ||
+				string(line[col-len(runes):col]) != prefix {
+				continue
+			}
+			// a word prefix has to be the whole word
+			if start := col - len(runes); start > 0 && isWordRune(runes[0]) && isWordRune(line[start-1]) {
+				continue
+			}
+			best, bestLen = snippet, len(runes)
+		}
+	}
+	return best, editor.Cursor.Index - bestLen, bestLen > 0
+}
+
+// expandSnippet replaces the snippet prefix before the cursor with the
+// snippet and moves to its first tabstop.
+func (editor *Editor) expandSnippet() bool {
+	snippet, start, ok := editor.snippetAt()
+	if !ok {
+		return false
+	}
+	editor.insertSnippet(snippet.Body, start, editor.Cursor.Index)
+	return true
+}
+
+// insertSnippet puts a snippet body in place of [start, end).
+func (editor *Editor) insertSnippet(body string, start int, end int) {
+	editor.endSnippet()
+	editor.closeCompletion()
+
+	expansion := editor.expandSnippetBody(body)
+	content := editor.Content
+	content.replace(expansion.text, start, end)
+
+	session := &snippetSession{
+		start: content.newAnchor(start, false),
+		end:   content.newAnchor(start+len(expansion.text), true),
+	}
+	// $0 is where the cursor ends up, after every other stop
+	numbers := []int{}
+	for number := range expansion.stops {
+		if number != 0 {
+			numbers = append(numbers, number)
+		}
+	}
+	slices.Sort(numbers)
+	numbers = append(numbers, 0)
+	if _, ok := expansion.stops[0]; !ok {
+		expansion.stops[0] = [][2]int{{len(expansion.text), len(expansion.text)}}
+	}
+
+	for _, number := range numbers {
+		stop := snippetStop{number: number, choices: expansion.choices[number]}
+		for _, span := range expansion.stops[number] {
+			stop.ranges = append(stop.ranges, [2]*anchor{
+				content.newAnchor(start+span[0], false),
+				content.newAnchor(start+span[1], true),
+			})
+		}
+		session.stops = append(session.stops, stop)
+	}
+
+	editor.snippet = session
+	editor.moveToStop(0)
+}
+
+// moveToStop makes stop i the one being filled in. The cursor goes to the
+// start of its placeholder with the placeholder's text selected, once the
+// last stop is reached the snippet is done.
+func (editor *Editor) moveToStop(i int) {
+	session := editor.snippet
+	session.current = i
+	stop := session.stops[i]
+	primary := stop.ranges[0]
+
+	// text typed at the edges of this stop's ranges belongs to them, not to
+	// the stops on either side
+	anchors := session.anchors()
+	for _, span := range stop.ranges {
+		for _, anchor := range anchors {
+			switch anchor.Index {
+			case span[1].Index:
+				anchor.after = true
+			case span[0].Index:
+				anchor.after = false
+			}
+		}
+		span[0].after, span[1].after = false, true
+	}
+
+	editor.moveCursorTo(primary[0].Index)
+	session.selected = primary[1].Index > primary[0].Index
+	session.selectedVersion = editor.Content.Version
+
+	if stop.number == 0 {
+		editor.endSnippet()
+		return
+	}
+	editor.updatePlaceholders()
+
+	if len(stop.choices) > 0 {
+		editor.showSnippetChoices(stop)
+	}
+}
+
+// jumpSnippet moves offset stops through the snippet, reporting false when
+// there is no snippet to move through.
+func (editor *Editor) jumpSnippet(offset int) bool {
+	session := editor.snippet
+	if session == nil {
+		return false
+	}
+	editor.closeCompletion()
+	editor.moveToStop(max(0, min(session.current+offset, len(session.stops)-1)))
+	return true
+}
+
+func (editor *Editor) endSnippet() {
+	if editor.snippet != nil {
+		editor.Content.dropAnchors(editor.snippet.anchors()...)
+		editor.snippet = nil
+	}
+	editor.Placeholders = nil
+}
+
+// replaceSelectedPlaceholder deletes the selected placeholder text before
+// the first key typed into it.
+func (editor *Editor) replaceSelectedPlaceholder() bool {
+	session := editor.snippet
+	if session == nil || !session.selected {
+		return false
+	}
+	session.selected = false
+
+	primary := session.stops[session.current].ranges[0]
+	if editor.Cursor.Index != primary[0].Index {
+		return false
+	}
+	editor.Content.replace([]rune{}, primary[0].Index, primary[1].Index)
+	editor.moveCursorTo(primary[0].Index)
+	return true
+}
+
+// updateSnippet copies the placeholder being typed into to its mirrors. The
+// snippet ends once insert mode is left or the cursor moves out of it.
+func (editor *Editor) updateSnippet() {
+	session := editor.snippet
+	if session == nil {
+		return
+	}
+
+	cursor := editor.Cursor.Index
+	if editor.Mode != Insert || cursor < session.start.Index || cursor > session.end.Index {
+		editor.endSnippet()
+		return
+	}
+
+	stop := session.stops[session.current]
+	primary := stop.ranges[0]
+	if session.selected && (editor.Content.Version != session.selectedVersion || cursor != primary[0].Index) {
+		session.selected = false
+	}
+
+	text := editor.Content.calculateContent()
+	typed := slices.Clone(text[primary[0].Index:primary[1].Index])
+	tracked := editor.Content.newAnchor(cursor, true)
+	for _, span := range stop.ranges[1:] {
+		if !slices.Equal(editor.Content.calculateContent()[span[0].Index:span[1].Index], typed) {
+			editor.Content.replace(typed, span[0].Index, span[1].Index)
+		}
+	}
+	editor.moveCursorTo(tracked.Index)
+	editor.Content.dropAnchors(tracked)
+
+	editor.updatePlaceholders()
+}
+
+// updatePlaceholders records the current stop's ranges for drawing.
+func (editor *Editor) updatePlaceholders() {
+	session := editor.snippet
+	editor.Placeholders = nil
+	for i, span := range session.stops[session.current].ranges {
+		editor.Placeholders = append(editor.Placeholders, Placeholder{
+			Start:    span[0].Index,
+			End:      span[1].Index,
+			Selected: i == 0 && session.selected,
+		})
+	}
+}
+
+// classifyPlaceholders marks the cells of the placeholder being filled in.
+func (editor *Editor) classifyPlaceholders(cells []ViewCell) {
+	for _, placeholder := range editor.Placeholders {
+		class := "snippet"
+		if placeholder.Selected {
+			class = "snippet.selected"
+		}
+		for i := range cells {
+			if placeholder.Start <= cells[i].Index && cells[i].Index < placeholder.End {
+				cells[i].Class = class
+			}
+		}
+	}
+}
+
+// showSnippetChoices offers a choice placeholder's options in the
+// completion menu.
+func (editor *Editor) showSnippetChoices(stop snippetStop) {
+	primary := stop.ranges[0]
+	menu := &completionMenu{row: editor.Cursor.Row}
+	for _, choice := range stop.choices {
+		menu.items = append(menu.items, CompletionItem{
+			Label: choice,
+			start: primary[0].Index,
+			apply: func(editor *Editor, start int) {
+				editor.Content.replace([]rune(choice), primary[0].Index, primary[1].Index)
+				editor.moveCursorTo(primary[1].Index)
+			},
+		})
+	}
+	editor.completion = menu
+	editor.Popup = nil
+	editor.showCompletion()
+}
+
+// snippetSource completes snippet prefixes, expanding the chosen snippet.
+type snippetSource struct{}
+
+func (snippetSource) Start(line []rune, col int) int {
+	return wordStart(line, col)
+}
+
+func (snippetSource) Complete(editor *Editor, prefix string, deliver func(items []CompletionItem)) {
+	snippets, err := snippetsFor(editor.OptionString("filetype"))
+	if err != nil {
+		editor.ReportError(err)
+	}
+
+	items := []CompletionItem{}
+	for _, snippet := range snippets {
+		for _, label := range snippet.Prefixes {
+			body := snippet.Body
+			items = append(items, CompletionItem{
+				Label:  label,
+				Detail: cmp.Or(snippet.Description, snippet.Name),
+				preview: func() string {
+					return string(editor.expandSnippetBody(body).text)
+				},
+				apply: func(editor *Editor, start int) {
+					editor.insertSnippet(body, start, editor.Cursor.Index)
+				},
+			})
+		}
+	}
+	deliver(items)
+}
+
+func init() {
+	RegisterCompletionSource("snippet", snippetSource{})
+
+	registerAction("snippet_expand", func(editor *Editor, key KeyStroke) {
+		if !editor.expandSnippet() {
+			editor.inform("no snippet")
+		}
+	})
+	registerAction("snippet_next", func(editor *Editor, key KeyStroke) {
+		editor.jumpSnippet(1)
+	})
+	registerAction("snippet_prev", func(editor *Editor, key KeyStroke) {
+		editor.jumpSnippet(-1)
+	})
+
+	bindDefault(Insert, "<S-Tab>", "snippet_prev")
+}
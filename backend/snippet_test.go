@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newSnippetTestEditor writes snippets as go.json in a snippet directory
+// next to an empty config.
+func newSnippetTestEditor(t *testing.T, text string, snippets string) *Editor {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(dir, "config.toml"))
+	os.Mkdir(filepath.Join(dir, "snippets"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "snippets", "go.json"), []byte(snippets), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	editor := newTestEditor(text)
+	editor.FileName = "main.go"
+	editor.SetOption("filetype", OptionValue{String: "go"}, setLocal)
+	editor.moveCursorTo(editor.Content.Length)
+	return editor
+}
+
+func TestSnippetTabstopsAndMirrors(t *testing.T) {
+	editor := newSnippetTestEditor(t, "func main() {\n\t", `{
+		// comments are allowed
+		"for loop": {
+			"prefix": ["for", "fori"],
+			"body": ["for ${1:i} := 0; $1 < ${2:n}; $1++ {", "\t$0", "}"],
+			"description": "counting loop"
+		}
+	}`)
+
+	typeKeys(t, editor, "ifor<Tab>")
+	expectContent(t, editor, "func main() {\n\tfor i := 0; i < n; i++ {\n\t\t\n\t}")
+	if len(editor.Placeholders) != 3 || !editor.Placeholders[0].Selected {
+		t.Fatalf("expected the first placeholder selected with two mirrors, got %+v", editor.Placeholders)
+	}
+
+	// typing replaces the selected text and the mirrors follow
+	typeKeys(t, editor, "idx")
+	expectContent(t, editor, "func main() {\n\tfor idx := 0; idx < n; idx++ {\n\t\t\n\t}")
+
+	// an edit before the snippet moves every tabstop along with the text
+	editor.Content.replace([]rune("// loop\n"), 0, 0)
+	editor.moveCursorTo(editor.Cursor.Index + len("// loop\n"))
+	typeKeys(t, editor, "<Tab>len(s)")
+	expectContent(t, editor, "// loop\nfunc main() {\n\tfor idx := 0; idx < len(s); idx++ {\n\t\t\n\t}")
+
+	// $0 ends the snippet, after that tab is a tab again
+	typeKeys(t, editor, "<Tab>")
+	if editor.Cursor.Row != 3 || editor.Cursor.Col != 2 || editor.snippet != nil {
+		t.Fatalf("expected the cursor at $0 with the snippet done, got %+v", editor.Cursor)
+	}
+	if len(editor.Content.anchors) != 0 {
+		t.Fatalf("expected the anchors dropped, got %d", len(editor.Content.anchors))
+	}
+	typeKeys(t, editor, "<Tab>")
+	expectContent(t, editor, "// loop\nfunc main() {\n\tfor idx := 0; idx < len(s); idx++ {\n\t\t\t\n\t}")
+}
+
+func TestSnippetVariablesAndNavigation(t *testing.T) {
+	editor := newSnippetTestEditor(t, "", `{
+		"header": {
+			"prefix": "hdr",
+			"body": "// $TM_FILENAME (c) $CURRENT_YEAR ${1:author} \\$1 ${UNSET:none} ${2|a,b|}"
+		}
+	}`)
+
+	typeKeys(t, editor, "ihdr<Tab>")
+	year := strconv.Itoa(time.Now().Year())
+	expectContent(t, editor, "// main.go (c) "+year+" author $1 none a")
+
+	// moving back keeps what was typed, leaving insert mode ends the snippet
+	typeKeys(t, editor, "<Tab>")
+	if editor.Popup == nil || len(editor.Popup.Lines) != 2 {
+		t.Fatalf("expected the choices in a menu, got %+v", editor.Popup)
+	}
+	typeKeys(t, editor, "<C-n><C-y><S-Tab>me")
+	expectContent(t, editor, "// main.go (c) "+year+" me $1 none b")
+	typeKeys(t, editor, "<Esc>")
+	if editor.snippet != nil || editor.Placeholders != nil {
+		t.Fatal("expected the snippet to end with insert mode")
+	}
+}
+
+func TestAnchors(t *testing.T) {
+	editor := newTestEditor("abcdef")
+	content := editor.Content
+	before := content.newAnchor(3, false)
+	after := content.newAnchor(3, true)
+
+	content.replace([]rune("xy"), 3, 3)
+	if before.Index != 3 || after.Index != 5 {
+		t.Fatalf("expected 3 and 5 after inserting at the anchors, got %d %d", before.Index, after.Index)
+	}
+	content.replace([]rune("12"), 0, 1)
+	if before.Index != 4 || after.Index != 6 {
+		t.Fatalf("expected both to shift by one, got %d %d", before.Index, after.Index)
+	}
+	content.replace([]rune{}, 3, 8)
+	if before.Index != 3 || after.Index != 3 {
+		t.Fatalf("expected both at the deletion, got %d %d", before.Index, after.Index)
+	}
+
+	content.dropAnchors(before)
+	if len(content.anchors) != 1 {
+		t.Fatalf("expected one anchor left, got %d", len(content.anchors))
+	}
+}
@@ -0,0 +1,273 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bhivam/text-editor/lsp"
+)
+
+// the status line when the statusline option is not set
+const defaultStatusline = " %{mode} | %f%<%( %m%)%=%( %{branch}%)%( %{diagnostics}%)%( %{collaborators}%)%( %y%) %l:%c %p%% "
+
+// the tabline when the tabline option is not set
+const defaultTabline = " [%f%( %m%)]%<%( %{hidden}%)"
+
+var modeNames = map[EditorMode]string{
+	Normal:          "NORMAL",
+	OperatorPending: "NORMAL",
+	Insert:          "INSERT",
+	Command:         "COMMAND",
+	Picking:         "PICKER",
+	Exploring:       "EXPLORER",
+	Quickfixing:     "QUICKFIX",
+	Visual:          "VISUAL",
+}
+
+// Modified reports whether the buffer has changed since it was read or last
+// written. Buffers that are not files never are.
+func (content *Content) Modified() bool {
+	return content.SavedVersion != -1 && content.Version != content.SavedVersion
+}
+
+// statuslineItem is what an item of the statusline option shows, and false
+// for items there are none of.
+func (editor *Editor) statuslineItem(name string) (string, bool) {
+	lines := max(1, len(editor.Content.lines()))
+	switch name {
+	case "f":
+		return editor.FileName, true
+	case "F":
+		return editor.FilePath, true
+	case "m":
+		if editor.Content.Modified() {
+			return "[+]", true
+		}
+		return "", true
+	case "y":
+		if filetype := editor.OptionString("filetype"); filetype != "" {
+			return "[" + filetype + "]", true
+		}
+		return "", true
+	case "l":
+		return strconv.Itoa(editor.Cursor.Row + 1), true
+	case "c":
+		return strconv.Itoa(editor.Cursor.Col + 1), true
+	case "L":
+		return strconv.Itoa(lines), true
+	case "p":
+		return strconv.Itoa((editor.Cursor.Row + 1) * 100 / lines), true
+	case "mode":
+		return modeNames[editor.Mode], true
+	case "filetype":
+		return editor.OptionString("filetype"), true
+	case "encoding":
+		return editor.OptionString("fileencoding"), true
+	case "fileformat":
+		return editor.OptionString("fileformat"), true
+	case "branch":
+		return editor.Content.GitBranch, true
+	case "diagnostics":
+		counts := map[lsp.DiagnosticSeverity]int{}
+		for _, diagnostic := range editor.Content.Diagnostics {
+			counts[lsp.DiagnosticSeverity(diagnostic.rank())] += 1
+		}
+		parts := []string{}
+		for _, severity := range []lsp.DiagnosticSeverity{
+			lsp.SeverityError, lsp.SeverityWarning, lsp.SeverityInformation, lsp.SeverityHint,
+		} {
+			if counts[severity] > 0 {
+				parts = append(parts, fmt.Sprintf("%c:%d", strings.ToUpper(severityNames[severity])[0], counts[severity]))
+			}
+		}
+		return strings.Join(parts, " "), true
+	case "hidden":
+		return strings.Join(editor.Tabs, "  "), true
+	case "collaborators":
+		switch editor.Collaborators {
+		case 0:
+			return "", true
+		case 1:
+			return "1 other", true
+		}
+		return fmt.Sprintf("%d others", editor.Collaborators), true
+	}
+	return "", false
+}
+
+// statuslineSide is the text of one side of a status line, and where in it
+// to cut when it does not fit, -1 for nowhere in particular.
+type statuslineSide struct {
+	text []rune
+	cut  int
+}
+
+// formatStatusline fills in a statusline format. %x is a one letter item and
+// %{name} a named one, %( and %) group items so the group is left out when
+// they all come out empty, %= splits the left side from the right, %< is
+// where to cut when the line does not fit, and %% is a percent sign.
+func (editor *Editor) formatStatusline(text string) ([2]statuslineSide, error) {
+	format := []rune(text)
+	sides := [2]statuslineSide{{cut: -1}, {cut: -1}}
+	side := 0
+	// the text of each open group and whether any of its items had text
+	type group struct {
+		start  int
+		filled bool
+	}
+	groups := []group{}
+
+	for i := 0; i < len(format); i += 1 {
+		if format[i] != '%' {
+			sides[side].text = append(sides[side].text, format[i])
+			continue
+		}
+		i += 1
+		if i == len(format) {
+			return sides, fmt.Errorf("statusline ends with %%")
+		}
+
+		name := string(format[i])
+		switch name {
+		case "%":
+			sides[side].text = append(sides[side].text, '%')
+			continue
+		case "=":
+			if side == 1 || len(groups) > 0 {
+				return sides, fmt.Errorf("statusline has %%= twice or inside a group")
+			}
+			side = 1
+			continue
+		case "<":
+			sides[side].cut = len(sides[side].text)
+			continue
+		case "(":
+			groups = append(groups, group{start: len(sides[side].text)})
+			continue
+		case ")":
+			if len(groups) == 0 {
+				return sides, fmt.Errorf("statusline has %%) without %%(")
+			}
+			closed := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			if !closed.filled {
+				sides[side].text = sides[side].text[:closed.start]
+			} else if len(groups) > 0 {
+				groups[len(groups)-1].filled = true
+			}
+			continue
+		case "{":
+			end := slices.Index(format[i:], '}')
+			if end == -1 {
+				return sides, fmt.Errorf("statusline has %%{ without }")
+			}
+			name = string(format[i+1 : i+end])
+			i += end
+		}
+
+		value, ok := editor.statuslineItem(name)
+		if !ok {
+			return sides, fmt.Errorf("unknown statusline item %s", name)
+		}
+		if value != "" && len(groups) > 0 {
+			groups[len(groups)-1].filled = true
+		}
+		sides[side].text = append(sides[side].text, []rune(value)...)
+	}
+	if len(groups) > 0 {
+		return sides, fmt.Errorf("statusline has %%( without %%)")
+	}
+	return sides, nil
+}
+
+// truncate cuts the side down to width, taking text away at its cut, or
+// from the start, and marking the place with <.
+func (side statuslineSide) truncate(width int) []rune {
+	if len(side.text) <= width {
+		return side.text
+	}
+	if width <= 0 {
+		return []rune{}
+	}
+	cut := max(0, side.cut)
+	over := len(side.text) - width + 1
+	end := min(len(side.text), cut+over)
+	text := append(append(append([]rune{}, side.text[:cut]...), '<'), side.text[end:]...)
+	return text[len(text)-width:]
+}
+
+// GetStatusBar lays out the statusline option across the screen.
+func (editor *Editor) GetStatusBar() []rune {
+	return editor.layoutStatusline(editor.OptionString("statusline"), defaultStatusline)
+}
+
+// GetTabline lays out the tabline option across the screen, for the row
+// above the text.
+func (editor *Editor) GetTabline() []rune {
+	return editor.layoutStatusline(editor.OptionString("tabline"), defaultTabline)
+}
+
+// TablineHeight is the number of rows the tabline takes above the text. With
+// showtabline at 1 it is only shown while other files are open.
+func (editor *Editor) TablineHeight() int {
+	switch editor.OptionInt("showtabline") {
+	case 1:
+		if len(editor.Tabs) > 0 {
+			return 1
+		}
+	case 2:
+		return 1
+	}
+	return 0
+}
+
+// layoutStatusline fills in format, or fallback when it is empty, across the
+// screen. When it is too wide the right side is kept and the left side cut
+// down, see truncate.
+func (editor *Editor) layoutStatusline(format string, fallback string) []rune {
+	if format == "" {
+		format = fallback
+	}
+	sides, err := editor.formatStatusline(format)
+	if err != nil {
+		sides, _ = editor.formatStatusline(fallback)
+	}
+
+	width := max(0, editor.ScreenWidth)
+	right := sides[1].truncate(width)
+	left := sides[0].truncate(width - len(right))
+
+	statusLine := append([]rune{}, left...)
+	for range width - len(left) - len(right) {
+		statusLine = append(statusLine, ' ')
+	}
+	return append(statusLine, right...)
+}
+
+func validStatusline(value OptionValue) error {
+	_, err := (&Editor{Content: &Content{}, Cursor: &Cursor{}}).formatStatusline(value.String)
+	return err
+}
+
+func init() {
+	registerOption(OptionDef{
+		Name: "statusline", Short: "stl", Kind: StringOption, Scope: WindowScope,
+		validate: validStatusline,
+	})
+	registerOption(OptionDef{
+		Name: "tabline", Short: "tal", Kind: StringOption, Scope: GlobalScope,
+		validate: validStatusline,
+	})
+	registerOption(OptionDef{
+		Name: "showtabline", Short: "stal", Kind: NumberOption, Scope: GlobalScope,
+		Default: OptionValue{Number: 1},
+		validate: func(value OptionValue) error {
+			if value.Number < 0 || value.Number > 2 {
+				return fmt.Errorf("argument must be 0, 1 or 2")
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestStatusline(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	path := filepath.Join(t.TempDir(), "notes.md")
+	writeTestFile(t, path, "one\ntwo\nthree\nfour\n")
+
+	editor := InitializeEditor(path, 24, 40)
+	expectStatus := func(expected string) {
+		t.Helper()
+		if status := string(editor.GetStatusBar()); status != expected {
+			t.Fatalf("\nGot:      %q\nExpected: %q", status, expected)
+		}
+	}
+	expectStatus(" NORMAL | notes.md   [markdown] 1:1 25% ")
+
+	typeKeys(t, &editor, "jix<Esc>")
+	editor.Collaborators = 2
+	editor.Content.Diagnostics = []Diagnostic{{Severity: 1}, {Severity: 2}, {}}
+	expectStatus("md< E:2 W:1 2 others [markdown] 2:1 50% ")
+	editor.ScreenWidth = 60
+	expectStatus(" NORMAL | notes.md [+]  E:2 W:1 2 others [markdown] 2:1 50% ")
+	// the file name is the last of the left side to go
+	editor.ScreenWidth = 57
+	expectStatus(" NORMAL | notes.md<] E:2 W:1 2 others [markdown] 2:1 50% ")
+	editor.ScreenWidth = 40
+	editor.SaveContent()
+	editor.Collaborators = 0
+	editor.Content.Diagnostics = nil
+
+	if err := editor.ExecuteCommand(`set stl=%{mode}\ %<%F%=%{fileformat}/%{encoding}\ %l/%L`); err != nil {
+		t.Fatal(err)
+	}
+	status := []rune(string(editor.GetStatusBar()))
+	if len(status) != 40 || string(status[:8]) != "NORMAL <" || string(status[len(status)-14:]) != "unix/utf-8 2/4" {
+		t.Fatalf("expected the path to be cut, got %q", string(status))
+	}
+
+	// the right side goes last
+	editor.ScreenWidth = 10
+	expectStatus("<utf-8 2/4")
+}
+
+func TestTabline(t *testing.T) {
+	t.Setenv("TEXT_EDITOR_CONFIG", filepath.Join(t.TempDir(), "none.toml"))
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "notes.md"), "one\ntwo\n")
+	writeTestFile(t, filepath.Join(dir, "todo.txt"), "first\nsecond\nthird\n")
+
+	editor := InitializeEditor(filepath.Join(dir, "notes.md"), 24, 40)
+	expectTabline := func(expected string) {
+		t.Helper()
+		if tabline := strings.TrimRight(string(editor.GetTabline()), " "); tabline != expected {
+			t.Fatalf("\nGot:      %q\nExpected: %q", tabline, expected)
+		}
+	}
+
+	// only shown once another file is open
+	if editor.TablineHeight() != 0 || editor.TextHeight() != 22 {
+		t.Fatal("expected no tabline for a single file")
+	}
+	editor.ExecuteCommand("set showtabline=2")
+	if editor.TablineHeight() != 1 || editor.TextHeight() != 21 {
+		t.Fatal("expected showtabline=2 to always show the tabline")
+	}
+	expectTabline(" [notes.md]")
+	editor.ExecuteCommand("set showtabline=1")
+
+	if err := editor.OpenFile(filepath.Join(dir, "todo.txt")); err != nil {
+		t.Fatal(err)
+	}
+	typeKeys(t, &editor, "i-<Esc>")
+	if editor.TablineHeight() != 1 {
+		t.Fatal("expected the tabline with two files open")
+	}
+	expectTabline(" [todo.txt [+]] notes.md")
+	editor.ScreenWidth = 20
+	expectTabline(" [todo.txt [+]]<s.md")
+
+	// the text starts below it, for the cursor and the mouse alike
+	if _, row := editor.CursorScreenPosition(); row != 1 {
+		t.Fatalf("expected the cursor below the tabline, got row %d", row)
+	}
+	left := editor.GutterWidth()
+	editor.HandleMouse(NewMouseEvent(tcell.Button1, left+2, 0))
+	editor.HandleMouse(NewMouseEvent(tcell.ButtonNone, left+2, 0))
+	if editor.Cursor.Row != 0 || editor.Cursor.Col != 0 {
+		t.Fatalf("expected a click on the tabline to leave the cursor, got %+v", editor.Cursor)
+	}
+	editor.HandleMouse(NewMouseEvent(tcell.Button1, left+2, 3))
+	editor.HandleMouse(NewMouseEvent(tcell.ButtonNone, left+2, 3))
+	if editor.Cursor.Row != 2 || editor.Cursor.Col != 2 {
+		t.Fatalf("expected the click on the third line, got %+v", editor.Cursor)
+	}
+
+	editor.ScreenWidth = 40
+	if err := editor.ExecuteCommand(`set tabline=%{hidden}%=%f`); err != nil {
+		t.Fatal(err)
+	}
+	if tabline := string(editor.GetTabline()); !strings.HasPrefix(tabline, "notes.md ") || !strings.HasSuffix(tabline, "todo.txt") {
+		t.Fatalf("expected the tabline option to be used, got %q", tabline)
+	}
+	if err := editor.ExecuteCommand("set showtabline=3"); err == nil {
+		t.Fatal("expected showtabline past 2 to be refused")
+	}
+}
@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long the buffer has to sit unchanged before it is parsed again for
+// things that can live with slightly stale structure, like folds
+const structureDebounce = 300 * time.Millisecond
+
+type TextObject int
+
+const (
+	FunctionObject TextObject = iota
+	ClassObject               = iota
+	ArgumentObject            = iota
+)
+
+// FoldRange covers the rows StartRow through EndRow, inclusive.
+type FoldRange struct {
+	StartRow int
+	EndRow   int
+}
+
+// Symbol is one entry of the outline. Depth is 0 for top level declarations
+// and grows for things declared inside them.
+type Symbol struct {
+	Name  string
+	Kind  string
+	Index int
+	Row   int
+	Depth int
+}
+
+// StructureProvider understands the syntax of one language well enough to
+// find text objects, fold ranges and an outline. Positions are content
+// indexes and rows, as in Content.
+type StructureProvider interface {
+	// Parse replaces the provider's view of the buffer. A provider should
+	// keep whatever it could make sense of when the text has errors.
+	Parse(text []rune) error
+	// TextObject returns the range of the object around index, inner leaves
+	// out delimiters and surrounding whitespace. linewise is set when the
+	// range covers whole lines.
+	TextObject(object TextObject, index int, inner bool) (start int, end int, linewise bool, ok bool)
+	Folds() []FoldRange
+	Outline() []Symbol
+}
+
+var structureProviders = map[string]func() StructureProvider{}
+var structureProvidersMu sync.RWMutex
+
+// RegisterStructureProvider makes a provider available for a filetype.
+func RegisterStructureProvider(filetype string, provider func() StructureProvider) {
+	structureProvidersMu.Lock()
+	defer structureProvidersMu.Unlock()
+	structureProviders[filetype] = provider
+}
+
+func structureProviderFor(filetype string) func() StructureProvider {
+	structureProvidersMu.RLock()
+	defer structureProvidersMu.RUnlock()
+	return structureProviders[filetype]
+}
+
+// structureCache holds a content's provider and when it was last parsed.
+type structureCache struct {
+	filetype string
+	provider StructureProvider
+	// content version the provider last parsed, -1 before the first parse
+	version  int
+	editedAt time.Time
+}
+
+// Structure returns the provider for the buffer's filetype, or nil if there
+// is none. With force the buffer is parsed if it changed at all, otherwise
+// parsing waits until edits have settled for structureDebounce.
+func (editor *Editor) Structure(force bool) StructureProvider {
+	filetype := editor.OptionString("filetype")
+	newProvider := structureProviderFor(filetype)
+	if newProvider == nil {
+		return nil
+	}
+
+	content := editor.Content
+	if content.structure == nil {
+		content.structure = &structureCache{}
+		content.OnEdit(func(edit Edit) {
+			content.structure.editedAt = time.Now()
+		})
+	}
+
+	cache := content.structure
+	if cache.provider == nil || cache.filetype != filetype {
+		cache.filetype = filetype
+		cache.provider = newProvider()
+		cache.version = -1
+	}
+
+	if cache.version != content.Version &&
+		(force || cache.version == -1 || time.Since(cache.editedAt) >= structureDebounce) {
+		// errors still leave a partial parse which is the best we have
+		cache.provider.Parse(content.calculateContent())
+		cache.version = content.Version
+	}
+
+	return cache.provider
+}
+
+// selectTextObject applies the pending operator to a text object around the
+// cursor.
+func (editor *Editor) selectTextObject(object TextObject, inner bool) {
+	structure := editor.Structure(true)
+	if structure == nil {
+		editor.cancelOperator()
+		editor.warn("no structure for filetype " + editor.OptionString("filetype"))
+		return
+	}
+
+	start, end, linewise, ok := structure.TextObject(object, editor.Cursor.Index, inner)
+	if !ok {
+		editor.cancelOperator()
+		return
+	}
+	editor.applyOperator(start, end, linewise)
+}
+
+// outline lists the buffer's symbols. With a name it jumps to the first
+// symbol containing it instead.
+func (editor *Editor) outline(name string) error {
+	structure := editor.Structure(true)
+	if structure == nil {
+		return fmt.Errorf("no structure for filetype %q", editor.OptionString("filetype"))
+	}
+
+	symbols := structure.Outline()
+	if name != "" {
+		for _, symbol := range symbols {
+			if strings.Contains(strings.ToLower(symbol.Name), strings.ToLower(name)) {
+				editor.moveCursorTo(symbol.Index)
+				return nil
+			}
+		}
+		return fmt.Errorf("no symbol matching %q", name)
+	}
+
+	if len(symbols) == 0 {
+		editor.inform("no symbols")
+		return nil
+	}
+
+	lines := []string{}
+	for _, symbol := range symbols {
+		lines = append(lines, fmt.Sprintf(
+			"%4d %s%s %s",
+			symbol.Row+1, strings.Repeat("  ", symbol.Depth), symbol.Kind, symbol.Name,
+		))
+	}
+	editor.showListing(lines)
+	return nil
+}
+
+func init() {
+	objects := []struct {
+		name   string
+		key    string
+		object TextObject
+	}{
+		{"function", "f", FunctionObject},
+		{"class", "c", ClassObject},
+		{"argument", "a", ArgumentObject},
+	}
+
+	for _, o := range objects {
+		registerAction("inner_"+o.name, func(editor *Editor, key KeyStroke) {
+			editor.selectTextObject(o.object, true)
+		})
+		registerAction("around_"+o.name, func(editor *Editor, key KeyStroke) {
+			editor.selectTextObject(o.object, false)
+		})
+		bindDefault(OperatorPending, "i"+o.key, "inner_"+o.name)
+		bindDefault(OperatorPending, "a"+o.key, "around_"+o.name)
+	}
+
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.outline(strings.TrimSpace(args))
+	}, "outline", "ol")
+}
@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const structureTestSource = `package main
+
+type point struct {
+	x int
+	y int
+}
+
+// add sums two points.
+func add(a point, b point) point {
+	sum := point{a.x + b.x, a.y + b.y}
+	return sum
+}
+
+func main() {
+	print(add(point{}, point{1, 2}), "done")
+}
+`
+
+func newGoTestEditor(t *testing.T) *Editor {
+	t.Helper()
+
+	editor := newTestEditor(structureTestSource)
+	editor.SetOption("filetype", OptionValue{String: "go"}, setLocal)
+	return editor
+}
+
+func cursorAt(t *testing.T, editor *Editor, text string) {
+	t.Helper()
+
+	index := strings.Index(string(editor.GetContent()), text)
+	if index == -1 {
+		t.Fatalf("%q not found", text)
+	}
+	editor.moveCursorTo(len([]rune(string(editor.GetContent())[:index])))
+}
+
+func TestGoTextObjects(t *testing.T) {
+	editor := newGoTestEditor(t)
+	cursorAt(t, editor, "return sum")
+	typeKeys(t, editor, "dif")
+	if !strings.Contains(string(editor.GetContent()), "point {\n}\n") {
+		t.Fatalf("expected an empty body, got\n%s", string(editor.GetContent()))
+	}
+
+	editor = newGoTestEditor(t)
+	cursorAt(t, editor, "sum :=")
+	typeKeys(t, editor, "daf")
+	if strings.Contains(string(editor.GetContent()), "add sums") ||
+		!strings.Contains(string(editor.GetContent()), "}\n\n\nfunc main") {
+		t.Fatalf("expected add and its doc comment to be gone, got\n%s", string(editor.GetContent()))
+	}
+
+	editor = newGoTestEditor(t)
+	cursorAt(t, editor, "y int")
+	typeKeys(t, editor, "yic")
+	if string(editor.register) != "\tx int\n\ty int\n" || !editor.registerLinewise {
+		t.Fatalf("unexpected register %q", string(editor.register))
+	}
+
+	editor = newGoTestEditor(t)
+	cursorAt(t, editor, "b point)")
+	typeKeys(t, editor, "daa")
+	if !strings.Contains(string(editor.GetContent()), "func add(a point) point") {
+		t.Fatalf("expected the last parameter to be gone, got\n%s", string(editor.GetContent()))
+	}
+
+	editor = newGoTestEditor(t)
+	cursorAt(t, editor, "point{1, 2}")
+	typeKeys(t, editor, "cianew<Esc>")
+	if !strings.Contains(string(editor.GetContent()), "add(point{}, new)") {
+		t.Fatalf("expected the argument to be changed, got\n%s", string(editor.GetContent()))
+	}
+
+	cursorAt(t, editor, `"done"`)
+	typeKeys(t, editor, "daa")
+	if !strings.Contains(string(editor.GetContent()), "print(add(point{}, new))") {
+		t.Fatalf("expected the outer argument to be gone, got\n%s", string(editor.GetContent()))
+	}
+}
+
+func TestGoFoldsAndOutline(t *testing.T) {
+	editor := newGoTestEditor(t)
+	structure := editor.Structure(true)
+
+	folds := structure.Folds()
+	expected := []FoldRange{{2, 5}, {8, 11}, {13, 15}}
+	for _, fold := range expected {
+		found := false
+		for _, f := range folds {
+			found = found || f == fold
+		}
+		if !found {
+			t.Errorf("expected fold %v in %v", fold, folds)
+		}
+	}
+
+	if err := editor.ExecuteCommand("outline"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(editor.Message, "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], "struct point") ||
+		!strings.Contains(lines[1], "  field x") || !strings.Contains(lines[4], "func main") {
+		t.Fatalf("unexpected outline\n%s", editor.Message)
+	}
+
+	if err := editor.ExecuteCommand("outline mai"); err != nil {
+		t.Fatal(err)
+	}
+	if editor.Cursor.Row != 13 {
+		t.Fatalf("expected to jump to main, got row %d", editor.Cursor.Row)
+	}
+}
+
+func TestStructureDebounce(t *testing.T) {
+	editor := newGoTestEditor(t)
+	outline := func(force bool) int {
+		return len(editor.Structure(force).Outline())
+	}
+
+	if outline(false) != 5 {
+		t.Fatal("expected the first request to parse")
+	}
+
+	editor.Content.replace([]rune("var z int\n"), editor.Content.Length, editor.Content.Length)
+	if outline(false) != 5 {
+		t.Fatal("expected a parse right after an edit to wait")
+	}
+	if outline(true) != 6 {
+		t.Fatal("expected a forced parse to see the edit")
+	}
+
+	editor.Content.replace([]rune("var w int\n"), editor.Content.Length, editor.Content.Length)
+	editor.Content.structure.editedAt = time.Now().Add(-structureDebounce)
+	if outline(false) != 7 {
+		t.Fatal("expected a parse once edits settled")
+	}
+}
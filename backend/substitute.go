@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// substitution is a parsed :s command. Patterns are Go regular expressions.
+type substitution struct {
+	pattern  *regexp.Regexp
+	template string
+	global   bool
+	// no error when nothing matches, for running over many places
+	quiet bool
+}
+
+// parseSubstitution reads /pattern/replacement/flags. Any punctuation can
+// stand in for the slashes.
+func parseSubstitution(args string) (substitution, error) {
+	if args == "" {
+		return substitution{}, fmt.Errorf("usage: s/pattern/replacement/flags")
+	}
+	delimiter, _ := firstRune(args)
+	if unicode.IsLetter(delimiter) || unicode.IsDigit(delimiter) || delimiter == '\\' || delimiter == '"' {
+		return substitution{}, fmt.Errorf("invalid delimiter: %c", delimiter)
+	}
+
+	parts := splitEscaped(args[len(string(delimiter)):], delimiter)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if parts[0] == "" {
+		return substitution{}, fmt.Errorf("empty pattern")
+	}
+
+	sub := substitution{template: replacementTemplate(parts[1])}
+	pattern := parts[0]
+	for _, flag := range parts[2] {
+		switch flag {
+		case 'g':
+			sub.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		case 'I':
+			// case sensitive, as it is anyway
+		case 'e':
+			sub.quiet = true
+		default:
+			return substitution{}, fmt.Errorf("unknown flag: %c", flag)
+		}
+	}
+
+	var err error
+	if sub.pattern, err = regexp.Compile(pattern); err != nil {
+		return substitution{}, err
+	}
+	return sub, nil
+}
+
+func firstRune(s string) (rune, bool) {
+	for _, r := range s {
+		return r, true
+	}
+	return 0, false
+}
+
+// splitEscaped splits s on delimiter, where a backslash before the delimiter
+// keeps it. Other backslashes are left for the pattern or the replacement.
+func splitEscaped(s string, delimiter rune) []string {
+	parts, current := []string{}, []rune{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); i += 1 {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == delimiter:
+			current = append(current, delimiter)
+			i += 1
+		case runes[i] == '\\' && i+1 < len(runes):
+			current = append(current, runes[i], runes[i+1])
+			i += 1
+		case runes[i] == delimiter:
+			parts = append(parts, string(current))
+			current = []rune{}
+		default:
+			current = append(current, runes[i])
+		}
+	}
+	return append(parts, string(current))
+}
+
+// replacementTemplate turns vim's replacement syntax, & for the match and \1
+// for a group, into a template for regexp.Expand.
+func replacementTemplate(replacement string) string {
+	var template strings.Builder
+	runes := []rune(replacement)
+	for i := 0; i < len(runes); i += 1 {
+		switch r := runes[i]; {
+		case r == '&':
+			template.WriteString("${0}")
+		case r == '$':
+			template.WriteString("$$")
+		case r == '\\' && i+1 < len(runes):
+			i += 1
+			switch next := runes[i]; {
+			case unicode.IsDigit(next):
+				template.WriteString("${" + string(next) + "}")
+			case next == 'n' || next == 'r':
+				template.WriteRune('\n')
+			case next == 't':
+				template.WriteRune('\t')
+			case next == '$':
+				template.WriteString("$$")
+			default:
+				template.WriteRune(next)
+			}
+		default:
+			template.WriteRune(r)
+		}
+	}
+	return template.String()
+}
+
+// replaceLine applies the substitution to one line, returning the new line
+// and how many replacements were made.
+func (sub substitution) replaceLine(line string) (string, int) {
+	matches := sub.pattern.FindAllStringSubmatchIndex(line, -1)
+	if !sub.global {
+		matches = matches[:min(1, len(matches))]
+	}
+	if len(matches) == 0 {
+		return line, 0
+	}
+
+	result, end := []byte{}, 0
+	for _, match := range matches {
+		result = append(result, line[end:match[0]]...)
+		result = sub.pattern.ExpandString(result, sub.template, line, match)
+		end = match[1]
+	}
+	return string(append(result, line[end:]...)), len(matches)
+}
+
+// substitute runs a substitution over lines first to last, leaving the
+// cursor on the last line that changed.
+func (editor *Editor) substitute(first int, last int, args string) error {
+	sub, err := parseSubstitution(args)
+	if err != nil {
+		return err
+	}
+
+	count, changedLines, lastChanged, added := 0, 0, -1, 0
+	// bottom up, so that a replacement with a newline leaves the lines
+	// still to do where they were
+	for row := last; row >= first; row -= 1 {
+		line := editor.Content.lines()[row]
+		replaced, n := sub.replaceLine(string(line))
+		if n == 0 {
+			continue
+		}
+
+		start := editor.Content.lineStart(row)
+		editor.Content.replace([]rune(replaced), start, start+len(line))
+		count, changedLines = count+n, changedLines+1
+		added += strings.Count(replaced, "\n")
+		if lastChanged == -1 {
+			lastChanged = row
+		}
+	}
+
+	if count == 0 {
+		if sub.quiet {
+			return nil
+		}
+		return fmt.Errorf("pattern not found: %s", sub.pattern)
+	}
+
+	// the last change moved down by every line the changes added
+	lastChanged += added
+	start := editor.Content.lineStart(lastChanged)
+	editor.moveCursorTo(start + len(leadingWhitespace(editor.Content.lines()[lastChanged])))
+	if changedLines > 1 {
+		editor.inform(fmt.Sprintf("%d substitutions on %d lines", count, changedLines))
+	}
+	return nil
+}
+
+func init() {
+	registerRangeCommand(func(editor *Editor, first int, last int, bang bool, args string) error {
+		return editor.substitute(first, last, args)
+	}, "s", "substitute")
+}
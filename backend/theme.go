@@ -0,0 +1,432 @@
+package backend
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Highlight is how a highlight group is drawn. Colors are names tcell knows,
+// like "red" or "darkslategray", or "#rrggbb". A group without a color of
+// its own takes the one of what it is drawn over.
+type Highlight struct {
+	Fg        string `json:",omitempty"`
+	Bg        string `json:",omitempty"`
+	Bold      bool   `json:",omitempty"`
+	Italic    bool   `json:",omitempty"`
+	Underline bool   `json:",omitempty"`
+	Reverse   bool   `json:",omitempty"`
+}
+
+// Theme maps highlight groups to how they are drawn. Groups are the token
+// classes, dotted, "string.escape" falls back to "string" when the theme has
+// no entry for it, and a few for the rest of the screen: "normal" for text,
+// "linenr", "statusline", "visual", "search" and "cursorline".
+type Theme map[string]Highlight
+
+var DefaultTheme = Theme{
+	"normal":     {},
+	"linenr":     {Fg: "dimgray"},
+	"statusline": {Fg: "black", Bg: "floralwhite", Bold: true},
+	"visual":     {Bg: "lightsteelblue", Fg: "black"},
+	"search":     {Bg: "yellow", Fg: "black"},
+	"cursorline": {Bg: "#303030"},
+
+	"comment":       {Fg: "gray", Italic: true},
+	"string":        {Fg: "green"},
+	"string.escape": {Fg: "olive"},
+	"keyword":       {Fg: "purple", Bold: true},
+	"type":          {Fg: "teal"},
+	"constant":      {Fg: "orange"},
+	"number":        {Fg: "orange"},
+	"function":      {Fg: "blue"},
+	"special":       {Fg: "olive"},
+	"heading":       {Fg: "blue", Bold: true},
+	"emphasis":      {Italic: true},
+	"strong":        {Bold: true},
+	"code":          {Fg: "green"},
+	"link":          {Fg: "blue", Underline: true},
+	"fold":          {Fg: "teal"},
+
+	"diagnostic.error":   {Fg: "red"},
+	"diagnostic.warning": {Fg: "yellow"},
+	"diagnostic.info":    {Fg: "blue"},
+	"diagnostic.hint":    {Fg: "gray"},
+
+	"popup":          {Fg: "white", Bg: "darkslategray"},
+	"popup.selected": {Fg: "black", Bg: "lightsteelblue"},
+
+	"picker.match": {Fg: "yellow", Bold: true},
+
+	"explorer.directory": {Fg: "blue", Bold: true},
+	"explorer.ignored":   {Fg: "gray"},
+	"explorer.current":   {Underline: true},
+	"explorer.selected":  {Fg: "black", Bg: "lightsteelblue"},
+
+	"quickfix.current":  {Bold: true},
+	"quickfix.selected": {Fg: "black", Bg: "lightsteelblue"},
+
+	"git.modified":   {Fg: "olive"},
+	"git.added":      {Fg: "green"},
+	"git.deleted":    {Fg: "red"},
+	"git.untracked":  {Fg: "teal"},
+	"git.conflicted": {Fg: "red", Bold: true},
+
+	"diff.added":   {Bg: "darkgreen"},
+	"diff.changed": {Bg: "darkslateblue"},
+	"diff.text":    {Bg: "darkred", Bold: true},
+	"diff.filler":  {Fg: "gray"},
+
+	"snippet":          {Underline: true},
+	"snippet.selected": {Fg: "black", Bg: "lightsteelblue"},
+
+	"message.error":   {Fg: "red", Bold: true},
+	"message.warning": {Fg: "yellow"},
+	"message.more":    {Fg: "green", Bold: true},
+}
+
+// groupNames are vim's names for the groups that have one, so :hi and
+// colorscheme files can use either.
+var groupNames = map[string]string{
+	"Normal":          "normal",
+	"LineNr":          "linenr",
+	"StatusLine":      "statusline",
+	"TabLine":         "tabline",
+	"Visual":          "visual",
+	"Search":          "search",
+	"CursorLine":      "cursorline",
+	"Comment":         "comment",
+	"String":          "string",
+	"Keyword":         "keyword",
+	"Type":            "type",
+	"Constant":        "constant",
+	"Number":          "number",
+	"Function":        "function",
+	"Special":         "special",
+	"Folded":          "fold",
+	"Pmenu":           "popup",
+	"PmenuSel":        "popup.selected",
+	"DiagnosticError": "diagnostic.error",
+	"DiagnosticWarn":  "diagnostic.warning",
+	"DiagnosticInfo":  "diagnostic.info",
+	"DiagnosticHint":  "diagnostic.hint",
+	"DiffAdd":         "diff.added",
+	"DiffChange":      "diff.changed",
+	"DiffText":        "diff.text",
+	"MsgArea":         "message",
+	"ErrorMsg":        "message.error",
+	"WarningMsg":      "message.warning",
+	"MoreMsg":         "message.more",
+}
+
+// groupClass is the class a group name stands for.
+func groupClass(name string) string {
+	if class, ok := groupNames[name]; ok {
+		return class
+	}
+	return strings.ToLower(name)
+}
+
+// colorDepth is how many colors the terminal shows, see SetColors.
+var colorDepth = 1 << 24
+
+// SetColors tells the theme how many colors the terminal can show, as
+// tcell's Screen.Colors reports it. Colors a theme asks for that the
+// terminal does not have are drawn as the closest one it does, and with no
+// colors at all only the attributes are left.
+func SetColors(colors int) {
+	colorDepth = colors
+}
+
+// adaptColor is color as the terminal can show it.
+func adaptColor(color tcell.Color) tcell.Color {
+	if color == tcell.ColorDefault || color == tcell.ColorReset || colorDepth >= 1<<24 {
+		return color
+	}
+	if colorDepth < 8 {
+		return tcell.ColorDefault
+	}
+
+	size := min(colorDepth, 256)
+	if color&tcell.ColorIsRGB == 0 && int(color-tcell.ColorValid) < size {
+		return color
+	}
+	palette := []tcell.Color{}
+	for i := range size {
+		palette = append(palette, tcell.PaletteColor(i))
+	}
+	return tcell.FindColor(color, palette)
+}
+
+// parseColor reads a color of a Highlight, empty when there is none.
+func parseColor(name string) (tcell.Color, error) {
+	if name == "" || name == "none" {
+		return tcell.ColorDefault, nil
+	}
+	color := tcell.GetColor(name)
+	if color == tcell.ColorDefault {
+		return color, fmt.Errorf("unknown color %s", name)
+	}
+	return color, nil
+}
+
+// Style returns the style for a class drawn over fallback. Colors the class
+// does not set are the fallback's, so highlighted text sits on the same
+// background as everything else.
+func (theme Theme) Style(class string, fallback tcell.Style) tcell.Style {
+	for class != "" {
+		if highlight, ok := theme[class]; ok {
+			fg, bg, _ := fallback.Decompose()
+			if color, _ := parseColor(highlight.Fg); color != tcell.ColorDefault {
+				fg = adaptColor(color)
+			}
+			if color, _ := parseColor(highlight.Bg); color != tcell.ColorDefault {
+				bg = adaptColor(color)
+			}
+			return tcell.StyleDefault.Foreground(fg).Background(bg).
+				Bold(highlight.Bold).Italic(highlight.Italic).
+				Underline(highlight.Underline).Reverse(highlight.Reverse)
+		}
+
+		dot := strings.LastIndexByte(class, '.')
+		if dot == -1 {
+			break
+		}
+		class = class[:dot]
+	}
+
+	return fallback
+}
+
+// describe writes a group the way :hi takes it.
+func (highlight Highlight) describe() string {
+	parts := []string{}
+	if highlight.Fg != "" {
+		parts = append(parts, "fg="+highlight.Fg)
+	}
+	if highlight.Bg != "" {
+		parts = append(parts, "bg="+highlight.Bg)
+	}
+	attributes := []string{}
+	for _, attribute := range []struct {
+		name string
+		set  bool
+	}{
+		{"bold", highlight.Bold}, {"italic", highlight.Italic},
+		{"underline", highlight.Underline}, {"reverse", highlight.Reverse},
+	} {
+		if attribute.set {
+			attributes = append(attributes, attribute.name)
+		}
+	}
+	if len(attributes) > 0 {
+		parts = append(parts, "gui="+strings.Join(attributes, ","))
+	}
+	if len(parts) == 0 {
+		return "cleared"
+	}
+	return strings.Join(parts, " ")
+}
+
+// set changes one setting of a group: fg or bg to a color, gui to a
+// comma separated list of attributes or NONE, or one attribute on or off.
+func (highlight *Highlight) set(key string, value any) error {
+	switch key {
+	case "fg", "guifg", "bg", "guibg":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a color", key)
+		}
+		name = strings.ToLower(name)
+		if _, err := parseColor(name); err != nil {
+			return err
+		}
+		if name == "none" {
+			name = ""
+		}
+		if strings.HasSuffix(key, "fg") {
+			highlight.Fg = name
+		} else {
+			highlight.Bg = name
+		}
+	case "gui", "attr":
+		name, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a list of attributes", key)
+		}
+		highlight.Bold, highlight.Italic, highlight.Underline, highlight.Reverse = false, false, false, false
+		for _, attribute := range strings.Split(strings.ToLower(name), ",") {
+			if attribute == "none" {
+				continue
+			}
+			if err := highlight.set(attribute, true); err != nil {
+				return err
+			}
+		}
+	case "bold", "italic", "underline", "reverse":
+		on, ok := value.(bool)
+		if text, isText := value.(string); isText && (text == "true" || text == "false") {
+			on, ok = text == "true", true
+		}
+		if !ok {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		switch key {
+		case "bold":
+			highlight.Bold = on
+		case "italic":
+			highlight.Italic = on
+		case "underline":
+			highlight.Underline = on
+		case "reverse":
+			highlight.Reverse = on
+		}
+	default:
+		return fmt.Errorf("unknown highlight key %s", key)
+	}
+	return nil
+}
+
+// applyHighlights sets groups from config sections, a section per group
+// under prefix.
+func (theme Theme) applyHighlights(config *Config, prefix string) error {
+	for section, values := range config.Sections {
+		name, ok := strings.CutPrefix(section, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		class := groupClass(name)
+		highlight := theme[class]
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			if err := highlight.set(key, values[key]); err != nil {
+				return fmt.Errorf("%s: %w", section, err)
+			}
+		}
+		theme[class] = highlight
+	}
+	return nil
+}
+
+// colorschemePath is where the colorscheme called name is read from, next
+// to the config file.
+func colorschemePath(name string) string {
+	return filepath.Join(filepath.Dir(DefaultConfigPath()), "colors", name+".toml")
+}
+
+// loadColorscheme reads a colorscheme: a file with a section for each group
+// it sets, on top of the default theme. "default" is the default theme.
+func loadColorscheme(name string) (Theme, error) {
+	theme := maps.Clone(DefaultTheme)
+	if name == "default" {
+		return theme, nil
+	}
+	if strings.ContainsAny(name, `/\`) || name == "" {
+		return nil, fmt.Errorf("bad colorscheme name %q", name)
+	}
+
+	path := colorschemePath(name)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no colorscheme %s", name)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := theme.applyHighlights(config, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return theme, nil
+}
+
+// setColorscheme switches to the colorscheme called name. Groups changed
+// with :hi or the config go back to what the colorscheme says.
+func (editor *Editor) setColorscheme(name string) error {
+	theme, err := loadColorscheme(name)
+	if err != nil {
+		return err
+	}
+	editor.Theme, editor.colorscheme = theme, name
+	return nil
+}
+
+// applyThemeConfig picks the colorscheme from [highlight] in the config and
+// then applies the groups in [highlight.<group>] over it.
+func (editor *Editor) applyThemeConfig(config *Config) error {
+	name, ok := config.String("highlight", "colorscheme")
+	if !ok {
+		name = "default"
+	}
+	if err := editor.setColorscheme(name); err != nil {
+		return fmt.Errorf("highlight: %w", err)
+	}
+	return editor.Theme.applyHighlights(config, "highlight.")
+}
+
+// highlightCommand is :hi. On its own it lists every group, with a group it
+// shows that one, and with settings it changes them. :hi clear goes back to
+// the colorscheme.
+func (editor *Editor) highlightCommand(args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		lines := []string{}
+		for _, class := range slices.Sorted(maps.Keys(editor.Theme)) {
+			lines = append(lines, fmt.Sprintf("%-20s %s", class, editor.Theme[class].describe()))
+		}
+		editor.showListing(lines)
+		return nil
+	}
+	if fields[0] == "clear" {
+		if len(fields) == 2 {
+			// one group back to how the colorscheme has it
+			scheme, err := loadColorscheme(editor.colorscheme)
+			if err != nil {
+				return err
+			}
+			editor.Theme[groupClass(fields[1])] = scheme[groupClass(fields[1])]
+			return nil
+		}
+		return editor.setColorscheme(editor.colorscheme)
+	}
+
+	class := groupClass(fields[0])
+	highlight, ok := editor.Theme[class]
+	if len(fields) == 1 {
+		if !ok {
+			return fmt.Errorf("no highlight group %s", fields[0])
+		}
+		editor.showListing([]string{fmt.Sprintf("%s %s", class, highlight.describe())})
+		return nil
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %s", field)
+		}
+		if err := highlight.set(strings.ToLower(key), value); err != nil {
+			return err
+		}
+	}
+	if editor.Theme == nil {
+		editor.Theme = Theme{}
+	}
+	editor.Theme[class] = highlight
+	return nil
+}
+
+func init() {
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		if args == "" {
+			editor.showListing([]string{editor.colorscheme})
+			return nil
+		}
+		return editor.setColorscheme(args)
+	}, "colorscheme", "colo")
+	registerExCommand(func(editor *Editor, bang bool, args string) error {
+		return editor.highlightCommand(args)
+	}, "highlight", "hi")
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestColorscheme(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.toml")
+	t.Setenv("TEXT_EDITOR_CONFIG", config)
+	writeTestFile(t, config, "[highlight]\ncolorscheme = \"dusk\"\n[highlight.LineNr]\nfg = \"#808080\"\n")
+	writeTestFile(t, colorschemePath("dusk"),
+		"[Normal]\nfg = \"#d0d0d0\"\nbg = \"#1c1c1c\"\n[comment]\nfg = \"green\"\nitalic = false\n")
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	writeTestFile(t, path, "text\n")
+	editor := InitializeEditor(path, 24, 80)
+	if editor.Message != "" {
+		t.Fatal(editor.Message)
+	}
+
+	normal := editor.Theme.Style("normal", tcell.StyleDefault)
+	if fg, bg, _ := normal.Decompose(); fg != tcell.NewHexColor(0xd0d0d0) || bg != tcell.NewHexColor(0x1c1c1c) {
+		t.Fatalf("expected the colorscheme's Normal, got %v on %v", fg, bg)
+	}
+	// groups drawn over Normal keep its background
+	if fg, bg, attrs := editor.Theme.Style("comment.line", normal).Decompose(); fg != tcell.ColorGreen ||
+		bg != tcell.NewHexColor(0x1c1c1c) || attrs&tcell.AttrItalic != 0 {
+		t.Fatalf("unexpected comment style %v on %v, %v", fg, bg, attrs)
+	}
+	if editor.Theme["linenr"].Fg != "#808080" || editor.Theme["keyword"] != DefaultTheme["keyword"] {
+		t.Fatalf("expected the config over the colorscheme over the default, got %+v", editor.Theme)
+	}
+
+	if err := editor.ExecuteCommand("hi Comment guifg=Red gui=bold,underline"); err != nil {
+		t.Fatal(err)
+	}
+	editor.ExecuteCommand("hi comment")
+	if editor.Message != "comment fg=red gui=bold,underline" {
+		t.Fatalf("unexpected group %q", editor.Message)
+	}
+	for _, command := range []string{"hi Comment fg=notacolor", "hi Comment size=2", "colorscheme nosuch"} {
+		if err := editor.ExecuteCommand(command); err == nil {
+			t.Fatalf("expected %s to fail", command)
+		}
+	}
+
+	editor.ExecuteCommand("hi clear Comment")
+	if editor.Theme["comment"].Fg != "green" {
+		t.Fatalf("expected the colorscheme's comment back, got %+v", editor.Theme["comment"])
+	}
+	editor.ExecuteCommand("colorscheme default")
+	editor.ExecuteCommand("colorscheme")
+	if editor.Message != "default" || editor.Theme["normal"] != DefaultTheme["normal"] {
+		t.Fatalf("expected the default theme, got %q %+v", editor.Message, editor.Theme["normal"])
+	}
+}
+
+func TestColorDepth(t *testing.T) {
+	defer SetColors(colorDepth)
+
+	theme := Theme{"x": {Fg: "#ff0101", Bg: "darkslategray"}}
+	SetColors(256)
+	fg, bg, _ := theme.Style("x", tcell.StyleDefault).Decompose()
+	if fg != tcell.ColorRed && fg != tcell.Color196 || bg&tcell.ColorIsRGB != 0 || bg-tcell.ColorValid >= 256 {
+		t.Fatalf("expected colors from the 256 color palette, got %v and %v", fg, bg)
+	}
+
+	SetColors(16)
+	if fg, _, _ := theme.Style("x", tcell.StyleDefault).Decompose(); fg != tcell.ColorRed {
+		t.Fatalf("expected red, got %v", fg)
+	}
+
+	SetColors(0)
+	if fg, bg, _ := theme.Style("x", tcell.StyleDefault).Decompose(); fg != tcell.ColorDefault || bg != tcell.ColorDefault {
+		t.Fatalf("expected no colors, got %v and %v", fg, bg)
+	}
+}
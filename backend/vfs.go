@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem is what the explorer reads and changes files through, so that
+// it can run against something other than the disk. Names are slash
+// separated and relative to the file system's root, which is ".".
+type FileSystem interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Create makes an empty file, failing if name exists.
+	Create(name string) error
+	// Mkdir makes a directory and any missing parents.
+	Mkdir(name string) error
+	Rename(from string, to string) error
+	// Remove deletes a file, or a directory and everything in it.
+	Remove(name string) error
+}
+
+// dirFileSystem is the directory tree under a directory on disk.
+type dirFileSystem string
+
+func (dir dirFileSystem) path(name string) string {
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+func (dir dirFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(dir.path(name))
+}
+
+func (dir dirFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(dir.path(name))
+}
+
+func (dir dirFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(dir.path(name))
+}
+
+func (dir dirFileSystem) Create(name string) error {
+	f, err := os.OpenFile(dir.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (dir dirFileSystem) Mkdir(name string) error {
+	return os.MkdirAll(dir.path(name), 0755)
+}
+
+func (dir dirFileSystem) Rename(from string, to string) error {
+	return os.Rename(dir.path(from), dir.path(to))
+}
+
+func (dir dirFileSystem) Remove(name string) error {
+	return os.RemoveAll(dir.path(name))
+}
+
+// exists reports whether name is in fsys, treating errors other than a
+// missing file as existing so nothing gets overwritten by mistake.
+func exists(fsys FileSystem, name string) bool {
+	_, err := fsys.Stat(name)
+	return !errors.Is(err, fs.ErrNotExist)
+}
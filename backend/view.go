@@ -0,0 +1,437 @@
+package backend
+
+import "strconv"
+
+// ViewCell is one screen cell of text. Index is the content index the cell
+// shows, a tab expands to several cells that share an index. Class is the
+// syntax class of the rune, empty for plain text.
+type ViewCell struct {
+	Rune  rune
+	Index int
+	Class string
+}
+
+// ViewLine is one screen row of the text area. Continuation is set on the
+// extra rows of a line that wrapped, Folded is the number of lines a closed
+// fold drawn on the row hides. Number is what the gutter shows for the line,
+// see numberLines.
+type ViewLine struct {
+	Line         int
+	Number       int
+	Continuation bool
+	Folded       int
+	Sign         Sign
+	Cells        []ViewCell
+}
+
+// Sign is drawn in the sign column next to a line. When several sources mark
+// the same line the highest Priority wins.
+type Sign struct {
+	Text     string
+	Class    string
+	Priority int
+}
+
+// the width of the sign column when it is shown
+const signWidth = 2
+
+var signSources []func(editor *Editor) map[int]Sign
+
+// registerSignSource adds a source of signs, a map from row to sign.
+func registerSignSource(source func(editor *Editor) map[int]Sign) {
+	signSources = append(signSources, source)
+}
+
+// signs collects the sign for every marked row.
+func (editor *Editor) signs() map[int]Sign {
+	signs := map[int]Sign{}
+	for _, source := range signSources {
+		for row, sign := range source(editor) {
+			if current, ok := signs[row]; !ok || sign.Priority > current.Priority {
+				signs[row] = sign
+			}
+		}
+	}
+	return signs
+}
+
+// SignWidth is the width of the sign column. With signcolumn=auto it only
+// shows up when some line has a sign.
+func (editor *Editor) SignWidth() int {
+	switch editor.OptionString("signcolumn") {
+	case "yes":
+		return signWidth
+	case "no":
+		return 0
+	}
+	for _, source := range signSources {
+		if len(source(editor)) > 0 {
+			return signWidth
+		}
+	}
+	return 0
+}
+
+// GutterWidth is the sign column and the line numbers, which have a space
+// on either side.
+func (editor *Editor) GutterWidth() int {
+	if !editor.OptionBool("number") && !editor.OptionBool("relativenumber") {
+		return editor.SignWidth()
+	}
+	return editor.SignWidth() + editor.numberDigits() + 2
+}
+
+// numberDigits is how many digits the line numbers get: enough for the last
+// line, and at least numberwidth columns with the spaces around them.
+func (editor *Editor) numberDigits() int {
+	count := len(editor.Content.lines())
+	if editor.Diff != nil {
+		// both sides of a diff have the same gutter
+		count = max(len(editor.Diff.lineRows[0]), len(editor.Diff.lineRows[1]))
+	}
+	return max(len(strconv.Itoa(count)), editor.OptionInt("numberwidth")-2)
+}
+
+// numberLines fills in the number the gutter shows for each row: the line
+// number with number, how many lines away from the cursor the line is with
+// relativenumber, and with both the line number on the cursor's line only.
+func (editor *Editor) numberLines(view []ViewLine) {
+	absolute, relative := editor.OptionBool("number"), editor.OptionBool("relativenumber")
+	if !relative {
+		for i := range view {
+			view[i].Number = view[i].Line + 1
+		}
+		return
+	}
+
+	// lines are counted the way j and k move, a closed fold is one line
+	cursor, _ := editor.lineRange(editor.Cursor.Row)
+	distance := 0
+	for line := editor.TopLine; line < cursor; line = editor.nextLine(line) {
+		distance -= 1
+	}
+	for line := editor.TopLine; line > cursor; line = editor.prevLine(line) {
+		distance += 1
+	}
+
+	for i := range view {
+		if i > 0 && view[i].Line != view[i-1].Line {
+			distance += 1
+		}
+		view[i].Number = max(distance, -distance)
+		if distance == 0 && absolute {
+			view[i].Number = view[i].Line + 1
+		}
+	}
+}
+
+// TextWidth is the number of columns available for file content.
+func (editor *Editor) TextWidth() int {
+	if editor.Diff != nil {
+		return max(1, editor.DiffPaneWidth()-editor.GutterWidth())
+	}
+	return max(1, editor.ScreenWidth-editor.SidebarWidth()-editor.BlameWidth()-editor.GutterWidth())
+}
+
+// displayCells lays a line out on screen, start is the content index of the
+// first rune in the line.
+func (editor *Editor) displayCells(line []rune, start int) []ViewCell {
+	tabstop := max(1, editor.OptionInt("tabstop"))
+	cells := []ViewCell{}
+
+	for i, r := range line {
+		if r != '\t' {
+			cells = append(cells, ViewCell{Rune: r, Index: start + i})
+			continue
+		}
+
+		for width := tabstop - len(cells)%tabstop; width > 0; width -= 1 {
+			cells = append(cells, ViewCell{Rune: ' ', Index: start + i})
+		}
+	}
+
+	return cells
+}
+
+// classifyCells copies span classes onto the cells of a line starting at
+// content index start.
+func classifyCells(cells []ViewCell, spans []Span, start int) {
+	span := 0
+	for i := range cells {
+		col := cells[i].Index - start
+		for span < len(spans) && spans[span].End <= col {
+			span += 1
+		}
+		if span < len(spans) && spans[span].Start <= col {
+			cells[i].Class = spans[span].Class
+		}
+	}
+}
+
+// displayCol is the screen column, before wrapping and scrolling, of column
+// col in line.
+func (editor *Editor) displayCol(line []rune, col int) int {
+	return len(editor.displayCells(line[:min(col, len(line))], 0))
+}
+
+// lineRows is how many screen rows a line of the given display width takes.
+func (editor *Editor) lineRows(width int) int {
+	if !editor.OptionBool("wrap") {
+		return 1
+	}
+	textWidth := editor.TextWidth()
+	return max(1, (width+textWidth-1)/textWidth)
+}
+
+// View lays out the visible part of the content, one entry per screen row.
+func (editor *Editor) View() []ViewLine {
+	lines := editor.Content.lines()
+	height := editor.TextHeight()
+	width := editor.TextWidth()
+	wrap := editor.OptionBool("wrap")
+
+	start := 0
+	for row := 0; row < editor.TopLine && row < len(lines); row += 1 {
+		start += len(lines[row]) + 1
+	}
+
+	// every visible line takes at least one row
+	lastLine := editor.TopLine
+	for i := 1; i < height && editor.nextLine(lastLine) < len(lines); i += 1 {
+		lastLine = editor.nextLine(lastLine)
+	}
+	lastLine = min(lastLine, len(lines)-1)
+	spans := editor.lineSpans(lines, editor.TopLine, lastLine)
+	signs := editor.signs()
+	virtualText := editor.virtualText()
+
+	view := []ViewLine{}
+	for row := editor.TopLine; row < len(lines) && len(view) < height; row = editor.nextLine(row) {
+		if fold, ok := editor.closedFold(row); ok {
+			view = append(view, editor.foldLine(fold, lines[row], start, width))
+			view[len(view)-1].Sign = signs[row]
+			for skipped := row; skipped <= fold.EndRow && skipped < len(lines); skipped += 1 {
+				start += len(lines[skipped]) + 1
+			}
+			continue
+		}
+
+		cells := editor.displayCells(lines[row], start)
+		if row-editor.TopLine < len(spans) {
+			classifyCells(cells, spans[row-editor.TopLine], start)
+		}
+		editor.classifyPlaceholders(cells)
+		editor.classifySelection(cells)
+		start += len(lines[row]) + 1
+
+		if !wrap {
+			if editor.LeftCol < len(cells) {
+				cells = cells[editor.LeftCol:]
+			} else {
+				cells = []ViewCell{}
+			}
+			view = append(view, ViewLine{Line: row, Sign: signs[row], Cells: cells[:min(width, len(cells))]})
+			appendVirtualText(&view[len(view)-1], virtualText[row], width)
+			continue
+		}
+
+		for offset := 0; offset == 0 || offset < len(cells); offset += width {
+			if len(view) == height {
+				break
+			}
+			view = append(view, ViewLine{
+				Line:         row,
+				Continuation: offset > 0,
+				Cells:        cells[offset:min(offset+width, len(cells))],
+			})
+		}
+		first := len(view) - 1
+		for first > 0 && view[first].Continuation {
+			first -= 1
+		}
+		view[first].Sign = signs[row]
+		appendVirtualText(&view[len(view)-1], virtualText[row], width)
+	}
+
+	editor.numberLines(view)
+	return view
+}
+
+// appendVirtualText adds text that is not part of the content after the
+// cells of a row, as much of it as fits. Virtual cells have Index -1.
+func appendVirtualText(line *ViewLine, text []ViewCell, width int) {
+	if len(text) == 0 || len(line.Cells)+2 >= width {
+		return
+	}
+
+	cells := append([]ViewCell{}, line.Cells...)
+	for _, cell := range append([]ViewCell{{Rune: ' ', Index: -1}, {Rune: ' ', Index: -1}}, text...) {
+		if len(cells) == width {
+			break
+		}
+		cells = append(cells, cell)
+	}
+	line.Cells = cells
+}
+
+var virtualTextSources []func(editor *Editor) map[int][]ViewCell
+
+// registerVirtualText adds a source of text drawn after the end of lines.
+func registerVirtualText(source func(editor *Editor) map[int][]ViewCell) {
+	virtualTextSources = append(virtualTextSources, source)
+}
+
+func (editor *Editor) virtualText() map[int][]ViewCell {
+	text := map[int][]ViewCell{}
+	for _, source := range virtualTextSources {
+		for row, cells := range source(editor) {
+			if _, ok := text[row]; !ok {
+				text[row] = cells
+			}
+		}
+	}
+	return text
+}
+
+// foldLine is the single row drawn for a closed fold, the summary padded out
+// to the width of the window.
+func (editor *Editor) foldLine(fold Fold, line []rune, start int, width int) ViewLine {
+	cells := []ViewCell{}
+	for _, r := range editor.foldSummary(fold, line) {
+		cells = append(cells, ViewCell{Rune: r, Index: start, Class: "fold"})
+	}
+	for len(cells) < width {
+		cells = append(cells, ViewCell{Rune: '-', Index: start, Class: "fold"})
+	}
+
+	return ViewLine{
+		Line:   fold.StartRow,
+		Folded: fold.EndRow - fold.StartRow + 1,
+		Cells:  cells[:width],
+	}
+}
+
+// screenRows is how many screen rows the line at row takes up.
+func (editor *Editor) screenRows(lines [][]rune, row int) int {
+	if _, ok := editor.closedFold(row); ok {
+		return 1
+	}
+	return editor.lineRows(len(editor.displayCells(lines[row], 0)))
+}
+
+// CursorScreenPosition is where the cursor should be drawn, in screen
+// coordinates including the gutter and the tabline.
+func (editor *Editor) CursorScreenPosition() (int, int) {
+	col, row := editor.cursorWindowPosition()
+	return col, row + editor.TablineHeight()
+}
+
+// cursorWindowPosition is where the cursor is with rows counted from the top
+// of the text.
+func (editor *Editor) cursorWindowPosition() (int, int) {
+	if editor.Mode == Exploring && editor.Explorer != nil {
+		return editor.Explorer.cursorPosition()
+	}
+	if window := editor.QuickfixWindow; editor.Mode == Quickfixing && window != nil {
+		return 0, editor.TextHeight() + 1 + window.Selected - window.Top
+	}
+
+	lines := editor.Content.lines()
+	width := editor.TextWidth()
+
+	// both sides are laid out by scrollDiff, without folds or wrapping
+	if editor.Diff != nil {
+		col := editor.displayCol(lines[editor.Cursor.Row], editor.Cursor.Col) - editor.LeftCol
+		left := editor.SidebarWidth() + editor.diffSide()*(editor.DiffPaneWidth()+1)
+		return left + editor.GutterWidth() + col, editor.Diff.CursorRow
+	}
+
+	row := 0
+	for line := editor.TopLine; line < editor.Cursor.Row && line < len(lines); line = editor.nextLine(line) {
+		row += editor.screenRows(lines, line)
+	}
+
+	// a closed fold is drawn without scrolling and the cursor sits at its start
+	if _, folded := editor.closedFold(editor.Cursor.Row); folded {
+		return editor.SidebarWidth() + editor.BlameWidth() + editor.GutterWidth(), row
+	}
+
+	col := 0
+	if editor.Cursor.Row < len(lines) {
+		col = editor.displayCol(lines[editor.Cursor.Row], editor.Cursor.Col)
+	}
+
+	if editor.OptionBool("wrap") {
+		row += col / width
+		col = col % width
+	} else {
+		col -= editor.LeftCol
+	}
+
+	return editor.SidebarWidth() + editor.BlameWidth() + editor.GutterWidth() + col, row
+}
+
+// ScrollToCursor moves the viewport so the cursor is visible, keeping
+// scrolloff lines of context above and below it when possible.
+func (editor *Editor) ScrollToCursor() {
+	editor.updateSnippet()
+	editor.publishBuffer()
+	editor.updateFolds()
+	editor.updateGitSigns()
+	editor.updateBlame()
+	editor.revealCursor()
+	if editor.Diff != nil {
+		editor.scrollDiff()
+		return
+	}
+
+	height := editor.TextHeight()
+	if height <= 0 {
+		return
+	}
+
+	lines := editor.Content.lines()
+	cursorRow := min(editor.Cursor.Row, len(lines)-1)
+	scrolloff := min(editor.OptionInt("scrolloff"), (height-1)/2)
+
+	// the top line can not be hidden in a fold
+	editor.TopLine, _ = editor.lineRange(min(editor.TopLine, len(lines)-1))
+
+	above := cursorRow
+	for i := 0; i < scrolloff && above > 0; i += 1 {
+		above = editor.prevLine(above)
+	}
+	if above < editor.TopLine {
+		editor.TopLine = max(0, above)
+	}
+
+	below := cursorRow
+	for i := 0; i < scrolloff && editor.nextLine(below) < len(lines); i += 1 {
+		below = editor.nextLine(below)
+	}
+
+	for editor.TopLine < cursorRow {
+		used := 0
+		for line := editor.TopLine; line <= below && line < len(lines); line = editor.nextLine(line) {
+			used += editor.screenRows(lines, line)
+		}
+		if used <= height {
+			break
+		}
+		editor.TopLine = editor.nextLine(editor.TopLine)
+	}
+
+	if editor.OptionBool("wrap") {
+		editor.LeftCol = 0
+		return
+	}
+
+	width := editor.TextWidth()
+	col := editor.displayCol(lines[cursorRow], editor.Cursor.Col)
+	if col < editor.LeftCol {
+		editor.LeftCol = col
+	}
+	if col >= editor.LeftCol+width {
+		editor.LeftCol = col - width + 1
+	}
+}
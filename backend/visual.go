@@ -0,0 +1,88 @@
+package backend
+
+import "fmt"
+
+// startVisual selects from the content index anchor to the cursor.
+func (editor *Editor) startVisual(anchor int) {
+	editor.VisualStart = anchor
+	editor.Mode = Visual
+}
+
+// visualRange is the selection as [start, end). Both the anchor and the
+// cursor are part of it, whichever comes first.
+func (editor *Editor) visualRange() (int, int) {
+	start, end := editor.VisualStart, editor.Cursor.Index
+	if start > end {
+		start, end = end, start
+	}
+	return start, min(end+1, editor.Content.Length)
+}
+
+// operateVisual runs an operator over the selection, which ends visual
+// mode.
+func (editor *Editor) operateVisual(operator string) {
+	start, end := editor.visualRange()
+	editor.operator = operator
+	editor.applyOperator(start, end, false)
+}
+
+// classifySelection marks the selected cells of a row.
+func (editor *Editor) classifySelection(cells []ViewCell) {
+	if editor.Mode != Visual {
+		return
+	}
+	start, end := editor.visualRange()
+	for i := range cells {
+		if start <= cells[i].Index && cells[i].Index < end {
+			cells[i].Class = "visual"
+		}
+	}
+}
+
+func init() {
+	registerAction("visual_mode", func(editor *Editor, key KeyStroke) {
+		editor.startVisual(editor.Cursor.Index)
+	})
+	registerAction("visual_exit", func(editor *Editor, key KeyStroke) {
+		editor.Mode = Normal
+	})
+	registerAction("visual_other_end", func(editor *Editor, key KeyStroke) {
+		anchor := editor.VisualStart
+		editor.VisualStart = editor.Cursor.Index
+		editor.moveCursorTo(anchor)
+	})
+	registerAction("visual_command", func(editor *Editor, key KeyStroke) {
+		// like the filter operator, the selected lines are put on the
+		// command line as a range
+		start, end := editor.visualRange()
+		first, _ := editor.Content.position(start)
+		last, _ := editor.Content.position(max(start, end-1))
+		editor.moveCursorTo(editor.Content.lineStart(first))
+		editor.ToCommand()
+		if last > first {
+			editor.CommandLine = []rune(fmt.Sprintf(".,.+%d", last-first))
+		}
+	})
+	for operator, keys := range map[string][]string{"d": {"d", "x"}, "c": {"c", "s"}, "y": {"y"}} {
+		registerAction("visual_"+operatorNames[operator], func(editor *Editor, key KeyStroke) {
+			editor.operateVisual(operator)
+		})
+		for _, key := range keys {
+			bindDefault(Visual, key, "visual_"+operatorNames[operator])
+		}
+	}
+
+	bindDefault(Normal, "v", "visual_mode")
+	bindDefault(Visual, "v", "visual_exit")
+	bindDefault(Visual, "<Esc>", "visual_exit")
+	bindDefault(Visual, "o", "visual_other_end")
+	bindDefault(Visual, ":", "visual_command")
+	bindDefault(Visual, "j", "cursor_down")
+	bindDefault(Visual, "k", "cursor_up")
+	bindDefault(Visual, "h", "cursor_left")
+	bindDefault(Visual, "l", "cursor_right")
+	bindDefault(Visual, "<Down>", "cursor_down")
+	bindDefault(Visual, "<Up>", "cursor_up")
+	bindDefault(Visual, "<Left>", "cursor_left")
+	bindDefault(Visual, "<Right>", "cursor_right")
+}
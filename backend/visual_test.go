@@ -0,0 +1,27 @@
+package backend
+
+import "testing"
+
+func TestVisualMode(t *testing.T) {
+	editor := newTestEditor("one two\nthree\nfour")
+
+	typeKeys(t, editor, "lvlly")
+	if string(editor.register) != "ne " || editor.Mode != Normal || editor.Cursor.Index != 1 {
+		t.Fatalf("expected the selection yanked, got %q at %d", string(editor.register), editor.Cursor.Index)
+	}
+
+	// the selection goes either way from where it started
+	typeKeys(t, editor, "jvkd")
+	expectContent(t, editor, "oree\nfour")
+
+	typeKeys(t, editor, "vjohcX<Esc>")
+	expectContent(t, editor, "Xur")
+	if editor.Mode != Normal {
+		t.Fatalf("expected c to insert, got mode %d", editor.Mode)
+	}
+
+	typeKeys(t, editor, "v<Esc>")
+	if editor.Mode != Normal {
+		t.Fatal("expected escape to leave visual mode")
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -26,46 +29,92 @@ type EditorEvent struct {
 	IsExit       bool
 	Key          tcell.Key
 	Rune         rune
+	Mod          tcell.ModMask
 	Width        int
 	Height       int
+	// a mouse event has the buttons held and the cell the mouse is over
+	IsMouse bool
+	Buttons tcell.ButtonMask
+	X       int
+	Y       int
+	// a paste has all of the pasted text
+	IsPaste bool
+	Text    string
+}
+
+// ServerFrame is what the server sends: the client's editor to draw, or a
+// message for when something went wrong outside of it.
+type ServerFrame struct {
+	Editor  *backend.Editor  `json:",omitempty"`
+	Message *backend.Message `json:",omitempty"`
+}
+
+// pasteText collects the keys of a bracketed paste, which the terminal sends
+// between two paste events, so that the paste goes in as one.
+type pasteText struct {
+	active bool
+	text   []rune
+}
+
+// add takes a key that arrived during a paste, and reports whether it did.
+func (paste *pasteText) add(event *tcell.EventKey) bool {
+	if !paste.active {
+		return false
+	}
+	switch event.Key() {
+	case tcell.KeyRune:
+		paste.text = append(paste.text, event.Rune())
+	case tcell.KeyEnter, tcell.KeyLF:
+		paste.text = append(paste.text, '\n')
+	case tcell.KeyTab:
+		paste.text = append(paste.text, '\t')
+	}
+	return true
 }
 
 func printLineNum(
 	screen tcell.Screen,
-	row *int,
+	row int,
 	col *int,
+	lineNum int,
+	continuation bool,
 	numDigits int, lineNumStyle tcell.Style,
 ) {
-	nums := []rune(strconv.FormatInt(int64(*row), 10))
+	nums := []rune(strconv.FormatInt(int64(lineNum), 10))
+	if continuation {
+		nums = []rune{}
+	}
 	if len(nums) < numDigits {
-		for i := 0; i < numDigits-len(nums); i += 1 {
+		for i := len(nums); i < numDigits; i += 1 {
 			nums = append([]rune(" "), nums...)
 		}
 	}
-	screen.SetContent(*col, *row, rune(' '), nil, lineNumStyle)
+	screen.SetContent(*col, row, rune(' '), nil, lineNumStyle)
 	*col += 1
 	for i := range numDigits {
-		screen.SetContent(*col, *row, nums[i], nil, lineNumStyle)
+		screen.SetContent(*col, row, nums[i], nil, lineNumStyle)
 		*col += 1
 	}
-	screen.SetContent(*col, *row, rune(' '), nil, lineNumStyle)
+	screen.SetContent(*col, row, rune(' '), nil, lineNumStyle)
 	*col += 1
 }
 
-func tcpFileEdit(remoteHost string, fileName string) {
+// tcpFileEdit edits fileName on the server at remoteHost. It returns why the
+// session ended when it was not the user quitting.
+func tcpFileEdit(remoteHost string, fileName string) error {
 	conn, err := net.Dial("tcp", remoteHost)
 	if err != nil {
-		return
+		return err
 	}
 	defer conn.Close()
 
 	screen, err := tcell.NewScreen()
 	if err != nil {
-		log.Fatalf("%+v", err)
+		return err
 	}
 
 	if err := screen.Init(); err != nil {
-		log.Fatalf("%+v", err)
+		return err
 	}
 	defer screen.Fini()
 
@@ -82,84 +131,104 @@ func tcpFileEdit(remoteHost string, fileName string) {
 
 	err = enc.Encode(initArgs)
 	if err != nil {
-		return
+		return err
 	}
 
-	defStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorReset.TrueColor()).
-		Background(tcell.ColorReset.TrueColor())
-
-	lineNumStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorDimGray.TrueColor()).
-		Background(tcell.ColorReset.TrueColor())
-
-	statusBarStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorBlack.TrueColor()).
-		Background(tcell.ColorFloralWhite).
-		Bold(true)
-
-	screen.SetStyle(defStyle)
-	screen.EnableMouse()
+	backend.SetColors(screen.Colors())
+	// motion only matters with a button held, which keeps it off the wire
+	screen.EnableMouse(tcell.MouseButtonEvents | tcell.MouseDragEvents)
 	screen.EnablePaste()
 	screen.Clear()
 
 	editor := backend.Editor{}
+	// why the server ended the session, the screen is gone by the time it
+	// can be shown
+	failed := make(chan error, 1)
+	fail := func(err error) {
+		failed <- err
+		screen.PostEvent(tcell.NewEventInterrupt(nil))
+	}
 
 	go func() {
+		started := false
 		for {
-			if err := dec.Decode(&editor); err != nil {
+			frame := ServerFrame{}
+			if err := dec.Decode(&frame); err != nil {
+				fail(fmt.Errorf("lost the connection to the server: %w", err))
+				return
+			}
+			if frame.Editor != nil {
+				editor, started = *frame.Editor, true
+			}
+			if message := frame.Message; message != nil {
+				if !started {
+					fail(errors.New(message.Text))
+					return
+				}
+				editor.Notify(message.Level, message.Text)
+			}
+			renderEditor(screen, editor)
+
+			// quitting is decided by the server's keymap, we just close up
+			if editor.Quit {
+				screen.PostEvent(tcell.NewEventInterrupt(nil))
 				return
 			}
-			renderEditor(screen, editor, defStyle, lineNumStyle, statusBarStyle)
 		}
 	}()
 
+	paste := pasteText{}
 	for {
 		event := screen.PollEvent()
 
 		editorEvent := EditorEvent{}
 		editorEvent.DispatchTime = time.Now().UnixMilli()
 		switch event := event.(type) {
+		case *tcell.EventPaste:
+			if event.Start() {
+				paste = pasteText{active: true}
+				continue
+			}
+			editorEvent.IsPaste = true
+			editorEvent.Text = string(paste.text)
+			paste = pasteText{}
 		case *tcell.EventKey:
+			if paste.add(event) {
+				continue
+			}
 			editorEvent.IsKey = true
 			editorEvent.Key = event.Key()
 			editorEvent.Rune = event.Rune()
+			editorEvent.Mod = event.Modifiers()
 		case *tcell.EventResize:
 			editorEvent.IsKey = false
 			editorEvent.Width, editorEvent.Height = event.Size()
+		case *tcell.EventMouse:
+			editorEvent.IsMouse = true
+			editorEvent.Buttons = event.Buttons()
+			editorEvent.X, editorEvent.Y = event.Position()
+		case *tcell.EventInterrupt:
+			select {
+			case err := <-failed:
+				return err
+			default:
+			}
+			// Send exit message to server
+			exitEvent := EditorEvent{IsExit: true}
+			enc.Encode(exitEvent)
+			return nil
+		default:
+			continue
 		}
 
 		err := enc.Encode(editorEvent)
 		if err != nil {
-			return
-		}
-
-		// Handle quit locally since we need to close connection
-		if event, ok := event.(*tcell.EventKey); ok {
-			if editor.Mode == backend.Normal && event.Key() == tcell.KeyRune && event.Rune() == 'q' {
-				// Send exit message to server
-				exitEvent := EditorEvent{IsExit: true}
-				enc.Encode(exitEvent)
-				return
-			}
+			return err
 		}
 	}
 }
 
-func localFileEdit(fileName string) {
-	defStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorReset.TrueColor()).
-		Background(tcell.ColorReset.TrueColor())
-
-	lineNumStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorDimGray.TrueColor()).
-		Background(tcell.ColorReset.TrueColor())
-
-	statusBarStyle := tcell.StyleDefault.
-		Foreground(tcell.ColorBlack.TrueColor()).
-		Background(tcell.ColorFloralWhite).
-		Bold(true)
-
+func localFileEdit(fileName string, diffWith string) {
 	screen, err := tcell.NewScreen()
 	if err != nil {
 		log.Fatalf("%+v", err)
@@ -168,13 +237,25 @@ func localFileEdit(fileName string) {
 		log.Fatalf("%+v", err)
 	}
 
-	screen.SetStyle(defStyle)
-	screen.EnableMouse()
+	backend.SetColors(screen.Colors())
+	// motion only matters with a button held, which keeps it off the wire
+	screen.EnableMouse(tcell.MouseButtonEvents | tcell.MouseDragEvents)
 	screen.EnablePaste()
 	screen.Clear()
 
 	initScreenWidth, initScreenHeight := screen.Size()
 	editor := backend.InitializeEditor(fileName, initScreenHeight, initScreenWidth)
+	if diffWith != "" {
+		if err := editor.ExecuteCommand("diffsplit " + diffWith); err != nil {
+			editor.ReportError(err)
+		}
+	}
+
+	// language server replies arrive on other goroutines, they interrupt the
+	// poll so the loop can run them
+	editor.SetWake(func() {
+		screen.PostEvent(tcell.NewEventInterrupt(nil))
+	})
 
 	quit := func() {
 		maybePanic := recover()
@@ -183,126 +264,403 @@ func localFileEdit(fileName string) {
 			panic(maybePanic)
 		}
 
-		// save content to file
-		editor.SaveContent()
+		// save every open file, the screen is gone so errors go to the
+		// terminal
+		err := editor.SaveAll()
+		backend.ShutdownLanguageServers()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
 	defer quit()
 
+	paste := pasteText{}
 	for {
-		renderEditor(
-			screen,
-			editor,
-			defStyle,
-			lineNumStyle,
-			statusBarStyle,
-		)
+		// the screen waits for the end of a paste
+		if !paste.active {
+			renderEditor(screen, editor)
+		}
 
 		// poll for new event
 		event := screen.PollEvent()
 
 		// update state based on new event
 		switch event := event.(type) {
+		case *tcell.EventPaste:
+			if event.Start() {
+				paste = pasteText{active: true}
+				continue
+			}
+			editor.Paste(string(paste.text))
+			paste = pasteText{}
 		case *tcell.EventKey:
+			if paste.add(event) {
+				continue
+			}
+			editor.HandleKey(backend.NewKeyStroke(
+				event.Key(),
+				event.Rune(),
+				event.Modifiers(),
+			))
+			scheduleKeyTimeout(screen, &editor)
+		case *tcell.EventInterrupt:
+			editor.FlushPendingKeys()
+			editor.RunPending()
+		case *tcell.EventResize:
+			editor.Resize(event.Size())
+		case *tcell.EventMouse:
+			x, y := event.Position()
+			editor.HandleMouse(backend.NewMouseEvent(event.Buttons(), x, y))
+		}
 
-			key := event.Key()
-
-			switch editor.Mode {
-			case backend.Insert:
-				switch key {
-				case tcell.KeyEscape:
-					editor.ToNormal()
-				case tcell.KeyEnter:
-					editor.InsertRune('\n')
-				case tcell.KeyRight:
-					editor.ShiftCursor(0, 1, false, false)
-				case tcell.KeyLeft:
-					editor.ShiftCursor(0, -1, false, false)
-				case tcell.KeyUp:
-					editor.ShiftCursor(-1, 0, false, false)
-				case tcell.KeyDown:
-					editor.ShiftCursor(1, 0, false, false)
-				case tcell.KeyRune:
-					editor.InsertRune(event.Rune())
-				case tcell.KeyBackspace2:
-					editor.Backspace()
-				}
-			case backend.Normal:
-				switch key {
-				case tcell.KeyRune:
-					keyVal := event.Rune()
-					switch keyVal {
-					case rune('q'):
-						return
-
-						// switch mode
-					case rune('a'):
-						editor.ToInsert(true)
-					case rune('i'):
-						editor.ToInsert(false)
-
-						// basic movement keys
-					case rune('j'):
-						editor.ShiftCursor(1, 0, false, false)
-					case rune('k'):
-						editor.ShiftCursor(-1, 0, false, false)
-					case rune('h'):
-						editor.ShiftCursor(0, -1, false, false)
-					case rune('l'):
-						editor.ShiftCursor(0, 1, false, false)
-					}
-				case tcell.KeyRight:
-					editor.ShiftCursor(0, 1, false, false)
-				case tcell.KeyLeft:
-					editor.ShiftCursor(0, -1, false, false)
-				case tcell.KeyUp:
-					editor.ShiftCursor(-1, 0, false, false)
-				case tcell.KeyDown:
-					editor.ShiftCursor(1, 0, false, false)
+		if editor.Quit {
+			return
+		}
+	}
+}
+
+// scheduleKeyTimeout wakes the event loop once timeoutlen passes so that a
+// partially typed mapping is resolved without waiting for another key.
+func scheduleKeyTimeout(screen tcell.Screen, editor *backend.Editor) {
+	if timeout, ok := editor.PendingKeyTimeout(); ok {
+		time.AfterFunc(timeout, func() {
+			screen.PostEvent(tcell.NewEventInterrupt(nil))
+		})
+	}
+}
+
+// drawPopupLine fills width cells with a space and then as much of line as
+// fits.
+func drawPopupLine(screen tcell.Screen, col int, row int, width int, line string, style tcell.Style) {
+	text := []rune(" " + line)
+	for i := range width {
+		r := ' '
+		if i < len(text) {
+			r = text[i]
+		}
+		screen.SetContent(col+i, row, r, nil, style)
+	}
+}
+
+// drawPicker draws the picker over the text: the prompt, the matching items
+// with the matched runes picked out, and the selected item's preview. It
+// returns where the cursor goes in the prompt.
+func drawPicker(screen tcell.Screen, editor backend.Editor, defStyle tcell.Style) (int, int) {
+	picker, layout := editor.Picker, editor.PickerLayout()
+	style := editor.Theme.Style("popup", defStyle)
+	selectedStyle := editor.Theme.Style("popup.selected", defStyle)
+	previewStyle := editor.Theme.Style("popup.preview", defStyle)
+
+	prompt := picker.Title + "> " + string(picker.Query)
+	drawPopupLine(screen, layout.Col, layout.Row, layout.Width, prompt, style)
+	count := fmt.Sprintf("%d/%d ", picker.Count, picker.Total)
+	for i, r := range []rune(count) {
+		screen.SetContent(layout.Col+layout.Width-len(count)+i, layout.Row, r, nil, style)
+	}
+
+	for i := range layout.Height - 1 {
+		row := layout.Row + 1 + i
+
+		line, lineStyle := "", style
+		if i < len(picker.Lines) {
+			line = picker.Lines[i]
+			if picker.Top+i == picker.Selected {
+				lineStyle = selectedStyle
+			}
+		}
+		drawPopupLine(screen, layout.Col, row, layout.ListWidth, line, lineStyle)
+		if i < len(picker.Matches) {
+			matchStyle := editor.Theme.Style("picker.match", lineStyle)
+			runes := []rune(line)
+			for _, pos := range picker.Matches[i] {
+				if col := layout.Col + 1 + pos; col < layout.Col+layout.ListWidth {
+					screen.SetContent(col, row, runes[pos], nil, matchStyle)
 				}
 			}
+		}
 
-		case *tcell.EventResize:
-			editor.ScreenWidth, editor.ScreenHeight = event.Size()
+		if layout.ListWidth < layout.Width {
+			preview := ""
+			if i < len(picker.Preview) {
+				preview = picker.Preview[i]
+			}
+			screen.SetContent(layout.Col+layout.ListWidth, row, '│', nil, style)
+			drawPopupLine(screen, layout.Col+layout.ListWidth+1, row,
+				layout.Width-layout.ListWidth-1, preview, previewStyle)
 		}
 	}
+
+	return layout.Col + 1 + len([]rune(prompt)), layout.Row
 }
 
-func renderEditor(
-	screen tcell.Screen,
-	editor backend.Editor,
-	defStyle tcell.Style,
-	lineNumStyle tcell.Style,
-	statusBarStyle tcell.Style,
-) {
+// drawExplorer draws the file tree down the left of the screen, below top,
+// with each entry's git status at the right edge of its row.
+func drawExplorer(screen tcell.Screen, editor backend.Editor, top int, defStyle tcell.Style) {
+	explorer, width := editor.Explorer, editor.SidebarWidth()-1
+	borderStyle := editor.Theme.Style("explorer.border", defStyle)
+
+	for i := range editor.TextHeight() {
+		row := top + i
+		screen.SetContent(width, row, '│', nil, borderStyle)
+		if i >= len(explorer.Lines) {
+			continue
+		}
+		line := explorer.Lines[i]
+
+		class := "explorer"
+		switch {
+		case line.Ignored:
+			class = "explorer.ignored"
+		case line.Dir:
+			class = "explorer.directory"
+		}
+		style := editor.Theme.Style(class, defStyle)
+		if line.Current {
+			style = editor.Theme.Style("explorer.current", style)
+		}
+		if explorer.Top+i == explorer.Selected && editor.Mode == backend.Exploring {
+			style = editor.Theme.Style("explorer.selected", style)
+		}
+
+		icon, name := "  ", line.Name
+		if line.Dir {
+			icon, name = "▸ ", name+"/"
+			if line.Open {
+				icon = "▾ "
+			}
+		}
+		text := []rune(strings.Repeat("  ", line.Depth) + icon + name)
+		for col := range width {
+			r := ' '
+			if col < len(text) {
+				r = text[col]
+			}
+			screen.SetContent(col, row, r, nil, style)
+		}
+
+		if line.Status != "" && width > 2 {
+			statusStyle := editor.Theme.Style(gitStatusClass(line.Status), style)
+			screen.SetContent(width-2, row, ' ', nil, style)
+			screen.SetContent(width-1, row, []rune(line.Status)[0], nil, statusStyle)
+		}
+	}
+}
+
+// drawQuickfix draws the quickfix window between the text and the status
+// bar: a title row and then the list.
+func drawQuickfix(screen tcell.Screen, editor backend.Editor, defStyle tcell.Style, titleStyle tcell.Style) {
+	window, top := editor.QuickfixWindow, editor.TablineHeight()+editor.TextHeight()
+
+	drawPopupLine(screen, 0, top, editor.ScreenWidth, window.Title, titleStyle)
+	for i := range window.Height - 1 {
+		line, style := "", defStyle
+		if i < len(window.Lines) {
+			line = window.Lines[i]
+			if window.Top+i == window.Current {
+				style = editor.Theme.Style("quickfix.current", style)
+			}
+			if window.Top+i == window.Selected && editor.Mode == backend.Quickfixing {
+				style = editor.Theme.Style("quickfix.selected", style)
+			}
+		}
+		drawPopupLine(screen, 0, top+1+i, editor.ScreenWidth, line, style)
+	}
+}
+
+// gitStatusClass is the theme class for a git status letter.
+func gitStatusClass(status string) string {
+	switch status {
+	case "A":
+		return "git.added"
+	case "D":
+		return "git.deleted"
+	case "?":
+		return "git.untracked"
+	case "U":
+		return "git.conflicted"
+	}
+	return "git.modified"
+}
+
+// drawDiff draws the two sides of diff mode next to each other below top,
+// each with its own line numbers, split by a border.
+func drawDiff(screen tcell.Screen, editor backend.Editor, top int, defStyle tcell.Style, lineNumStyle tcell.Style) {
+	signWidth := editor.SignWidth()
+	numDigits := editor.GutterWidth() - signWidth - 2
+	paneWidth := editor.DiffPaneWidth()
+
+	for side, pane := range editor.Diff.Panes {
+		left := editor.SidebarWidth() + side*(paneWidth+1)
+		for i, line := range pane {
+			row := top + i
+			lineStyle := defStyle
+			if line.Kind != "" {
+				lineStyle = editor.Theme.Style("diff."+line.Kind, defStyle)
+			}
+			for col := left; col < left+paneWidth; col += 1 {
+				screen.SetContent(col, row, ' ', nil, lineStyle)
+			}
+
+			col := left + signWidth
+			if numDigits > 0 && line.Line != -1 {
+				printLineNum(screen, row, &col, line.Line+1, false, numDigits, lineNumStyle)
+			} else {
+				col += numDigits + 2
+			}
+			for _, cell := range line.Cells {
+				style := lineStyle
+				if cell.Index >= line.TextStart && cell.Index < line.TextEnd {
+					style = editor.Theme.Style("diff.text", style)
+				}
+				screen.SetContent(col, row, cell.Rune, nil, editor.Theme.Style(cell.Class, style))
+				col += 1
+			}
+		}
+	}
+
+	border := editor.SidebarWidth() + paneWidth
+	for row := range editor.TextHeight() {
+		screen.SetContent(border, top+row, '│', nil, lineNumStyle)
+	}
+}
+
+func renderEditor(screen tcell.Screen, editor backend.Editor) {
+	defStyle := editor.Theme.Style("normal", tcell.StyleDefault)
+	lineNumStyle := editor.Theme.Style("linenr", defStyle)
+	statusBarStyle := editor.Theme.Style("statusline", defStyle)
+
+	screen.SetStyle(defStyle)
 	screen.Clear()
 
-	maxRowDigits := 2
-	i, row, col := 0, 0, 0
-	editorContent := editor.GetContent()
-	printLineNum(screen, &row, &col, 2, lineNumStyle)
-	for i < editor.Content.Length && row < editor.ScreenHeight-1 {
-		r := editorContent[i]
-		if r == '\n' {
-			row = row + 1
-			col = 0
-
-			printLineNum(screen, &row, &col, maxRowDigits, lineNumStyle)
-		} else {
-			screen.SetContent(col, row, r, nil, defStyle)
+	// the tabline takes the top row, the windows start below it
+	top := editor.TablineHeight()
+	if top > 0 {
+		tablineStyle := editor.Theme.Style("tabline", statusBarStyle)
+		for col, r := range editor.GetTabline() {
+			screen.SetContent(col, 0, r, nil, tablineStyle)
+		}
+	}
+
+	sidebarWidth := editor.SidebarWidth()
+	if editor.Explorer != nil {
+		drawExplorer(screen, editor, top, defStyle)
+	}
+
+	signWidth := editor.SignWidth()
+	numDigits := editor.GutterWidth() - signWidth - 2
+	if editor.Diff != nil {
+		drawDiff(screen, editor, top, defStyle, lineNumStyle)
+	}
+	blameWidth := editor.BlameWidth()
+	for i, line := range editor.View() {
+		if editor.Diff != nil {
+			break
+		}
+		row := top + i
+		col := sidebarWidth
+		if blameWidth > 0 {
+			text := ""
+			if !line.Continuation && line.Line < len(editor.Blame.Lines) {
+				text = editor.Blame.Lines[line.Line]
+			}
+			drawPopupLine(screen, col, row, blameWidth, text, lineNumStyle)
+			col += blameWidth
+		}
+		if signWidth > 0 {
+			signStyle := editor.Theme.Style(line.Sign.Class, lineNumStyle)
+			for i, r := range []rune(fmt.Sprintf("%-*s", signWidth, line.Sign.Text))[:signWidth] {
+				screen.SetContent(col+i, row, r, nil, signStyle)
+			}
+			col += signWidth
+		}
+		if numDigits > 0 {
+			printLineNum(
+				screen,
+				row,
+				&col,
+				line.Number,
+				line.Continuation,
+				numDigits,
+				lineNumStyle,
+			)
+		}
+		for _, cell := range line.Cells {
+			style := editor.Theme.Style(cell.Class, defStyle)
+			screen.SetContent(col, row, cell.Rune, nil, style)
 			col += 1
 		}
-		i += 1
+	}
+
+	if editor.QuickfixWindow != nil {
+		drawQuickfix(screen, editor, defStyle, statusBarStyle)
+	}
+
+	if popup := editor.Popup; popup != nil {
+		for i, line := range popup.VisibleLines() {
+			class := "popup"
+			if popup.Top+i == popup.Selected {
+				class = "popup.selected"
+			}
+			drawPopupLine(screen, popup.Col, popup.Row+i, popup.Width, line,
+				editor.Theme.Style(class, defStyle))
+		}
+		for i, line := range popup.Preview {
+			drawPopupLine(screen, popup.PreviewCol, popup.Row+i, popup.PreviewWidth, line,
+				editor.Theme.Style("popup.preview", defStyle))
+		}
+	}
+
+	cursorCol, cursorRow := editor.CursorScreenPosition()
+	if editor.Picker != nil {
+		cursorCol, cursorRow = drawPicker(screen, editor, defStyle)
 	}
 
 	statusBar := editor.GetStatusBar()
-	row = editor.ScreenHeight - 1
+	row := editor.ScreenHeight - 2
 	for col, r := range statusBar {
 		screen.SetContent(col, row, r, nil, statusBarStyle)
 	}
 
-	screen.ShowCursor(maxRowDigits+2+editor.Cursor.Col, editor.Cursor.Row)
+	row = editor.ScreenHeight - 1
+	if editor.Mode == backend.Command {
+		prompt := ":"
+		if editor.Prompt != "" {
+			prompt = editor.Prompt
+		}
+		commandLine := append([]rune(prompt), editor.CommandLine...)
+		for col, r := range commandLine {
+			screen.SetContent(col, row, r, nil, defStyle)
+		}
+		screen.ShowCursor(len(commandLine), row)
+	} else {
+		// long messages cover the bottom of the text until the next key
+		messageLines := editor.MessageLines()
+		messageStyle := editor.Theme.Style(editor.MessageClass(), defStyle)
+		for i, message := range messageLines {
+			messageRow := row - len(messageLines) + 1 + i
+			if messageRow < 0 {
+				continue
+			}
+			style := messageStyle
+			if i == len(messageLines)-1 && editor.MorePending() {
+				style = editor.Theme.Style("message.more", defStyle)
+			}
+			for col := range editor.ScreenWidth {
+				screen.SetContent(col, messageRow, ' ', nil, defStyle)
+			}
+			for col, r := range []rune(message) {
+				screen.SetContent(col, messageRow, r, nil, style)
+			}
+		}
+		if editor.MorePending() {
+			screen.ShowCursor(len([]rune(messageLines[len(messageLines)-1])), row)
+		} else {
+			screen.ShowCursor(cursorCol, cursorRow)
+		}
+	}
 
 	// show new buffer
 	screen.Show()
@@ -312,6 +670,7 @@ func main() {
 	var fileName string
 
 	isRemote := flag.Bool("R", false, "Specify remote host and port")
+	isDiff := flag.Bool("d", false, "Show two files side by side in diff mode")
 
 	flag.Parse()
 
@@ -326,7 +685,17 @@ func main() {
 		log.Println("remoteHost: ", remoteHost)
 		log.Println("fileName: ", fileName)
 
-		tcpFileEdit(remoteHost, fileName)
+		if err := tcpFileEdit(remoteHost, fileName); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	} else if *isDiff {
+		if len(flag.Args()) < 2 {
+			log.Fatal("Please specify two file names after -d")
+			os.Exit(1)
+		}
+
+		localFileEdit(flag.Arg(0), flag.Arg(1))
 		os.Exit(0)
 	} else {
 		if len(flag.Args()) < 1 {
@@ -335,7 +704,7 @@ func main() {
 		}
 		fileName = flag.Arg(0)
 
-		localFileEdit(fileName)
+		localFileEdit(fileName, "")
 		os.Exit(0)
 	}
 }
@@ -0,0 +1,308 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Handlers receive what the server sends without being asked. They run on the
+// connection's read goroutine.
+type Handlers struct {
+	// Ready is called once the initialize handshake is done
+	Ready       func()
+	Diagnostics func(params PublishDiagnosticsParams)
+}
+
+// Client is a running language server.
+type Client struct {
+	conn     *Conn
+	cmd      *exec.Cmd
+	handlers Handlers
+
+	mu           sync.Mutex
+	initialized  bool
+	capabilities ServerCapabilities
+	// messages held back until the server has answered initialize
+	queued []func()
+	err    error
+}
+
+// Start runs command with rootDir as the workspace and begins the initialize
+// handshake. Requests made before the handshake finishes are held until it
+// does.
+func Start(command []string, rootDir string, handlers Handlers) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("lsp: empty command")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = rootDir
+	cmd.Stderr = io.Discard
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	client := &Client{cmd: cmd, handlers: handlers}
+	client.conn = NewConn(stdout, stdin, client.handle)
+	go func() {
+		<-client.conn.Done()
+		cmd.Wait()
+	}()
+
+	params := InitializeParams{
+		ProcessID:    os.Getpid(),
+		RootURI:      URIFromPath(rootDir),
+		Capabilities: json.RawMessage(clientCapabilities),
+	}
+	params.ClientInfo.Name = "text-editor"
+
+	client.conn.Call("initialize", params, func(raw json.RawMessage, err error) {
+		result := InitializeResult{}
+		if err == nil {
+			err = json.Unmarshal(raw, &result)
+		}
+
+		client.mu.Lock()
+		client.err = err
+		client.capabilities = result.Capabilities
+		client.initialized = true
+		queued := client.queued
+		client.queued = nil
+		client.mu.Unlock()
+
+		if err == nil {
+			client.conn.Notify("initialized", struct{}{})
+		}
+		for _, send := range queued {
+			send()
+		}
+		if handlers.Ready != nil {
+			handlers.Ready()
+		}
+	})
+
+	return client, nil
+}
+
+// handle answers the requests servers commonly make of clients.
+func (client *Client) handle(method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		diagnostics := PublishDiagnosticsParams{}
+		if err := json.Unmarshal(params, &diagnostics); err == nil && client.handlers.Diagnostics != nil {
+			client.handlers.Diagnostics(diagnostics)
+		}
+	case "workspace/configuration":
+		var request struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &request)
+		return make([]any, len(request.Items)), nil
+	case "client/registerCapability", "client/unregisterCapability",
+		"window/workDoneProgress/create", "window/showMessageRequest":
+		return nil, nil
+	case "window/showMessage", "window/logMessage", "$/progress", "$/logTrace", "telemetry/event":
+	default:
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method}
+	}
+	return nil, nil
+}
+
+// whenReady runs send once the handshake is done, right away if it is.
+func (client *Client) whenReady(send func()) {
+	client.mu.Lock()
+	if !client.initialized {
+		client.queued = append(client.queued, send)
+		client.mu.Unlock()
+		return
+	}
+	client.mu.Unlock()
+	send()
+}
+
+// Ready reports whether the initialize handshake succeeded.
+func (client *Client) Ready() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.initialized && client.err == nil
+}
+
+// Capabilities is what the server said it supports, empty until the
+// handshake finishes.
+func (client *Client) Capabilities() ServerCapabilities {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.capabilities
+}
+
+// Done is closed when the server exits.
+func (client *Client) Done() <-chan struct{} {
+	return client.conn.Done()
+}
+
+// Notify sends a notification once the server is ready.
+func (client *Client) Notify(method string, params any) {
+	client.whenReady(func() {
+		client.conn.Notify(method, params)
+	})
+}
+
+// call makes a request once the server is ready and decodes the result into
+// a new T.
+func call[T any](client *Client, method string, params any, callback func(result T, err error)) {
+	client.whenReady(func() {
+		client.mu.Lock()
+		err := client.err
+		client.mu.Unlock()
+
+		var result T
+		if err != nil {
+			callback(result, err)
+			return
+		}
+
+		client.conn.Call(method, params, func(raw json.RawMessage, err error) {
+			if err == nil && len(raw) > 0 && string(raw) != "null" {
+				err = json.Unmarshal(raw, &result)
+			}
+			callback(result, err)
+		})
+	})
+}
+
+var ErrUnsupported = errors.New("lsp: not supported by the server")
+
+// supports checks a capability once the server is ready.
+func (client *Client) supports(capability func(ServerCapabilities) json.RawMessage) bool {
+	return provides(capability(client.Capabilities()))
+}
+
+func (client *Client) Hover(params TextDocumentPositionParams, callback func(*Hover, error)) {
+	client.whenReady(func() {
+		if !client.supports(func(c ServerCapabilities) json.RawMessage { return c.HoverProvider }) {
+			callback(nil, ErrUnsupported)
+			return
+		}
+		call(client, "textDocument/hover", params, callback)
+	})
+}
+
+// Definition returns every location the server gives, whichever of the three
+// forms it uses.
+func (client *Client) Definition(params TextDocumentPositionParams, callback func([]Location, error)) {
+	client.whenReady(func() {
+		if !client.supports(func(c ServerCapabilities) json.RawMessage { return c.DefinitionProvider }) {
+			callback(nil, ErrUnsupported)
+			return
+		}
+		call(client, "textDocument/definition", params, func(raw json.RawMessage, err error) {
+			if err != nil || len(raw) == 0 || string(raw) == "null" {
+				callback(nil, err)
+				return
+			}
+			callback(decodeLocations(raw))
+		})
+	})
+}
+
+func decodeLocations(raw json.RawMessage) ([]Location, error) {
+	location := Location{}
+	if json.Unmarshal(raw, &location) == nil && location.URI != "" {
+		return []Location{location}, nil
+	}
+
+	locations := []Location{}
+	if err := json.Unmarshal(raw, &locations); err != nil {
+		return nil, err
+	}
+	if len(locations) > 0 && locations[0].URI == "" {
+		links := []locationLink{}
+		json.Unmarshal(raw, &links)
+		locations = locations[:0]
+		for _, link := range links {
+			locations = append(locations, Location{URI: link.TargetURI, Range: link.TargetSelectionRange})
+		}
+	}
+	return locations, nil
+}
+
+func (client *Client) References(params ReferenceParams, callback func([]Location, error)) {
+	client.whenReady(func() {
+		if !client.supports(func(c ServerCapabilities) json.RawMessage { return c.ReferencesProvider }) {
+			callback(nil, ErrUnsupported)
+			return
+		}
+		call(client, "textDocument/references", params, callback)
+	})
+}
+
+func (client *Client) Rename(params RenameParams, callback func(*WorkspaceEdit, error)) {
+	client.whenReady(func() {
+		if !client.supports(func(c ServerCapabilities) json.RawMessage { return c.RenameProvider }) {
+			callback(nil, ErrUnsupported)
+			return
+		}
+		call(client, "textDocument/rename", params, callback)
+	})
+}
+
+// Completion returns the items whether the server sends a list or a bare
+// array.
+func (client *Client) Completion(params TextDocumentPositionParams, callback func([]CompletionItem, error)) {
+	client.whenReady(func() {
+		if !client.supports(func(c ServerCapabilities) json.RawMessage { return c.CompletionProvider }) {
+			callback(nil, ErrUnsupported)
+			return
+		}
+		call(client, "textDocument/completion", params, func(raw json.RawMessage, err error) {
+			if err != nil || len(raw) == 0 || string(raw) == "null" {
+				callback(nil, err)
+				return
+			}
+
+			items := []CompletionItem{}
+			if json.Unmarshal(raw, &items) == nil {
+				callback(items, nil)
+				return
+			}
+			list := completionList{}
+			err = json.Unmarshal(raw, &list)
+			callback(list.Items, err)
+		})
+	})
+}
+
+// Shutdown asks the server to exit, killing it if it takes too long.
+func (client *Client) Shutdown() {
+	done := make(chan struct{})
+	client.whenReady(func() {
+		client.conn.Call("shutdown", nil, func(json.RawMessage, error) {
+			client.conn.Notify("exit", nil)
+			close(done)
+		})
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+
+	select {
+	case <-client.conn.Done():
+	case <-time.After(time.Second):
+		client.cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,234 @@
+// Package lsp speaks the Language Server Protocol, JSON-RPC 2.0 messages
+// framed with Content-Length headers.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes
+const (
+	CodeMethodNotFound = -32601
+	CodeInternalError  = -32603
+)
+
+var ErrClosed = errors.New("lsp: connection closed")
+
+// ResponseError is an error returned by the other end of a call.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *ResponseError) Error() string {
+	return fmt.Sprintf("lsp: %s (%d)", err.Message, err.Code)
+}
+
+// Handler answers requests and notifications from the other end. The result
+// is ignored for notifications. Handlers run on the connection's read
+// goroutine so they must not block.
+type Handler func(method string, params json.RawMessage) (any, error)
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// Conn is one end of a JSON-RPC connection. Calls are asynchronous, their
+// callbacks run on the read goroutine.
+type Conn struct {
+	writer  io.Writer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]func(result json.RawMessage, err error)
+	closed  bool
+
+	handler Handler
+	done    chan struct{}
+}
+
+// NewConn starts reading messages from r, passing requests and notifications
+// to handler.
+func NewConn(r io.Reader, w io.Writer, handler Handler) *Conn {
+	conn := &Conn{
+		writer:  w,
+		pending: map[int]func(json.RawMessage, error){},
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	go conn.read(bufio.NewReader(r))
+	return conn
+}
+
+// Done is closed once the other end goes away.
+func (conn *Conn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Call sends a request, callback gets the result or the error.
+func (conn *Conn) Call(
+	method string,
+	params any,
+	callback func(result json.RawMessage, err error),
+) {
+	conn.mu.Lock()
+	if conn.closed {
+		conn.mu.Unlock()
+		callback(nil, ErrClosed)
+		return
+	}
+	conn.nextID += 1
+	id := conn.nextID
+	conn.pending[id] = callback
+	conn.mu.Unlock()
+
+	rawID := json.RawMessage(strconv.Itoa(id))
+	if err := conn.send(message{ID: &rawID, Method: method}, params); err != nil {
+		// the read goroutine may have failed it already on closing
+		conn.mu.Lock()
+		_, ok := conn.pending[id]
+		delete(conn.pending, id)
+		conn.mu.Unlock()
+		if ok {
+			callback(nil, err)
+		}
+	}
+}
+
+// Notify sends a notification, which has no response.
+func (conn *Conn) Notify(method string, params any) error {
+	return conn.send(message{Method: method}, params)
+}
+
+func (conn *Conn) send(msg message, params any) error {
+	msg.JSONRPC = "2.0"
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = raw
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	_, err = fmt.Fprintf(conn.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (conn *Conn) reply(id *json.RawMessage, result any, err error) {
+	msg := message{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		responseErr, ok := err.(*ResponseError)
+		if !ok {
+			responseErr = &ResponseError{Code: CodeInternalError, Message: err.Error()}
+		}
+		msg.Error = responseErr
+	} else {
+		raw, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			raw = json.RawMessage("null")
+		}
+		msg.Result = raw
+	}
+
+	body, _ := json.Marshal(msg)
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	fmt.Fprintf(conn.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (conn *Conn) read(reader *bufio.Reader) {
+	headers := textproto.NewReader(reader)
+	for {
+		header, err := headers.ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+		if err != nil {
+			break
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			break
+		}
+
+		msg := message{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			continue
+		}
+		conn.dispatch(msg)
+	}
+
+	conn.mu.Lock()
+	conn.closed = true
+	pending := conn.pending
+	conn.pending = map[int]func(json.RawMessage, error){}
+	conn.mu.Unlock()
+
+	for _, callback := range pending {
+		callback(nil, ErrClosed)
+	}
+	close(conn.done)
+}
+
+func (conn *Conn) dispatch(msg message) {
+	// a response to one of our calls
+	if msg.Method == "" {
+		if msg.ID == nil {
+			return
+		}
+		id, err := strconv.Atoi(string(*msg.ID))
+		if err != nil {
+			return
+		}
+
+		conn.mu.Lock()
+		callback, ok := conn.pending[id]
+		delete(conn.pending, id)
+		conn.mu.Unlock()
+
+		if !ok {
+			return
+		}
+		if msg.Error != nil {
+			callback(nil, msg.Error)
+		} else {
+			callback(msg.Result, nil)
+		}
+		return
+	}
+
+	result, err := conn.handle(msg.Method, msg.Params)
+	if msg.ID != nil {
+		conn.reply(msg.ID, result, err)
+	}
+}
+
+func (conn *Conn) handle(method string, params json.RawMessage) (any, error) {
+	if conn.handler == nil {
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method}
+	}
+	return conn.handler(method, params)
+}
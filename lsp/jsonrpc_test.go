@@ -0,0 +1,59 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestConnRoundTrip(t *testing.T) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	notified := make(chan string, 1)
+	NewConn(serverReader, serverWriter, func(method string, params json.RawMessage) (any, error) {
+		switch method {
+		case "echo":
+			return params, nil
+		case "note":
+			notified <- string(params)
+			return nil, nil
+		}
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "no " + method}
+	})
+	client := NewConn(clientReader, clientWriter, nil)
+
+	type reply struct {
+		result json.RawMessage
+		err    error
+	}
+	replies := make(chan reply, 1)
+	callback := func(result json.RawMessage, err error) {
+		replies <- reply{result, err}
+	}
+
+	client.Call("echo", map[string]int{"a": 1}, callback)
+	if got := <-replies; got.err != nil || string(got.result) != `{"a":1}` {
+		t.Fatalf("unexpected echo %s %v", got.result, got.err)
+	}
+
+	client.Notify("note", "hi")
+	if got := <-notified; got != `"hi"` {
+		t.Fatalf("unexpected notification %s", got)
+	}
+
+	client.Call("missing", nil, callback)
+	responseErr := &ResponseError{}
+	if got := <-replies; !errors.As(got.err, &responseErr) || responseErr.Code != CodeMethodNotFound {
+		t.Fatalf("expected method not found, got %v", got.err)
+	}
+
+	// calls made after the other end goes away fail
+	serverWriter.Close()
+	<-client.Done()
+	client.Call("echo", nil, callback)
+	if got := <-replies; !errors.Is(got.err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", got.err)
+	}
+}
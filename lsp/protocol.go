@@ -0,0 +1,282 @@
+package lsp
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Position is zero based, Character counts UTF-16 code units.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type locationLink struct {
+	TargetURI            string `json:"targetUri"`
+	TargetSelectionRange Range  `json:"targetSelectionRange"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// TextDocumentContentChangeEvent replaces Range with Text, or the whole
+// document when Range is nil.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// text document sync kinds
+const (
+	SyncNone        = 0
+	SyncFull        = 1
+	SyncIncremental = 2
+)
+
+type DiagnosticSeverity int
+
+const (
+	SeverityError       DiagnosticSeverity = 1
+	SeverityWarning     DiagnosticSeverity = 2
+	SeverityInformation DiagnosticSeverity = 3
+	SeverityHint        DiagnosticSeverity = 4
+)
+
+type Diagnostic struct {
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	Message  string             `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type TextDocumentEdit struct {
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                      `json:"edits"`
+}
+
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []json.RawMessage     `json:"documentChanges,omitempty"`
+}
+
+// Edits flattens both forms of a workspace edit into edits per document.
+// File operations in documentChanges are skipped.
+func (edit *WorkspaceEdit) Edits() map[string][]TextEdit {
+	edits := map[string][]TextEdit{}
+	for uri, changes := range edit.Changes {
+		edits[uri] = append(edits[uri], changes...)
+	}
+	for _, raw := range edit.DocumentChanges {
+		change := TextDocumentEdit{}
+		if err := json.Unmarshal(raw, &change); err != nil || change.TextDocument.URI == "" {
+			continue
+		}
+		edits[change.TextDocument.URI] = append(edits[change.TextDocument.URI], change.Edits...)
+	}
+	return edits
+}
+
+type RenameParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	NewName      string                 `json:"newName"`
+}
+
+type ReferenceParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type CompletionItem struct {
+	Label               string     `json:"label"`
+	Kind                int        `json:"kind,omitempty"`
+	Detail              string     `json:"detail,omitempty"`
+	Documentation       Markup     `json:"documentation,omitempty"`
+	SortText            string     `json:"sortText,omitempty"`
+	FilterText          string     `json:"filterText,omitempty"`
+	InsertText          string     `json:"insertText,omitempty"`
+	InsertTextFormat    int        `json:"insertTextFormat,omitempty"`
+	TextEdit            *TextEdit  `json:"textEdit,omitempty"`
+	AdditionalTextEdits []TextEdit `json:"additionalTextEdits,omitempty"`
+}
+
+type completionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// Markup is documentation in any of the forms servers send it, a plain
+// string, MarkupContent, a MarkedString or a list of MarkedStrings.
+type Markup string
+
+func (markup *Markup) UnmarshalJSON(raw []byte) error {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		*markup = Markup(text)
+		return nil
+	}
+
+	var content struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(raw, &content) == nil && content.Value != "" {
+		*markup = Markup(content.Value)
+		return nil
+	}
+
+	var list []Markup
+	if json.Unmarshal(raw, &list) == nil {
+		parts := []string{}
+		for _, part := range list {
+			parts = append(parts, string(part))
+		}
+		*markup = Markup(strings.Join(parts, "\n\n"))
+	}
+	return nil
+}
+
+type Hover struct {
+	Contents Markup `json:"contents"`
+	Range    *Range `json:"range,omitempty"`
+}
+
+// ServerCapabilities holds the parts of the server's capabilities the editor
+// looks at.
+type ServerCapabilities struct {
+	TextDocumentSync   json.RawMessage `json:"textDocumentSync,omitempty"`
+	HoverProvider      json.RawMessage `json:"hoverProvider,omitempty"`
+	DefinitionProvider json.RawMessage `json:"definitionProvider,omitempty"`
+	ReferencesProvider json.RawMessage `json:"referencesProvider,omitempty"`
+	RenameProvider     json.RawMessage `json:"renameProvider,omitempty"`
+	CompletionProvider json.RawMessage `json:"completionProvider,omitempty"`
+}
+
+// SyncKind is how the server wants document changes sent.
+func (capabilities ServerCapabilities) SyncKind() int {
+	var kind int
+	if json.Unmarshal(capabilities.TextDocumentSync, &kind) == nil {
+		return kind
+	}
+
+	var options struct {
+		Change int `json:"change"`
+	}
+	if json.Unmarshal(capabilities.TextDocumentSync, &options) == nil {
+		return options.Change
+	}
+	return SyncNone
+}
+
+// provides reads capabilities that are either a bool or an options object.
+func provides(raw json.RawMessage) bool {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false
+	}
+	return string(raw) != "false"
+}
+
+type InitializeParams struct {
+	ProcessID    int             `json:"processId"`
+	RootURI      string          `json:"rootUri"`
+	Capabilities json.RawMessage `json:"capabilities"`
+	ClientInfo   struct {
+		Name string `json:"name"`
+	} `json:"clientInfo"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// what the editor tells servers it can do
+const clientCapabilities = `{
+	"textDocument": {
+		"synchronization": {"didSave": true},
+		"hover": {"contentFormat": ["plaintext", "markdown"]},
+		"definition": {},
+		"references": {},
+		"rename": {},
+		"completion": {"completionItem": {"snippetSupport": false}},
+		"publishDiagnostics": {}
+	},
+	"workspace": {"workspaceEdit": {"documentChanges": false}, "configuration": true},
+	"general": {"positionEncodings": ["utf-16"]}
+}`
+
+// URIFromPath turns a file path into a file:// URI.
+func URIFromPath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// PathFromURI is the file path of a file:// URI, empty for other schemes.
+func PathFromURI(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(parsed.Path)
+}
@@ -7,8 +7,8 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"net"
-	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/bhivam/text-editor/backend"
 )
@@ -25,186 +25,426 @@ type EditorEvent struct {
 	IsExit       bool
 	Key          tcell.Key
 	Rune         rune
+	Mod          tcell.ModMask
 	Width        int
 	Height       int
+	// a mouse event has the buttons held and the cell the mouse is over
+	IsMouse bool
+	Buttons tcell.ButtonMask
+	X       int
+	Y       int
+	// a paste has all of the pasted text
+	IsPaste bool
+	Text    string
+}
+
+// ServerFrame is what the server sends: the client's editor to draw, or a
+// message for when something went wrong outside of it.
+type ServerFrame struct {
+	Editor  *backend.Editor  `json:",omitempty"`
+	Message *backend.Message `json:",omitempty"`
 }
 
 type ClientEditorEvent struct {
 	clientID string
 	event    EditorEvent
+
+	// set when a pending key sequence for this client has timed out
+	isKeyTimeout bool
+	// set when background work, like a language server reply, is waiting
+	// for the session's editors
+	isWake bool
+	// set when a client that opened this session's file moves over to it,
+	// or subscribes to it while other clients have it open
+	join *IndividualEditorState
+	// set when a client moved this session's file, from and to
+	rename []string
+	// set when the client has disconnected
+	leave bool
 }
 
 type IndividualEditorState struct {
 	editor *backend.Editor
 	enc    *json.Encoder
+
+	// the session the client's events go to, guarded by sessionsMu
+	session *FileEditSession
+	// the client has disconnected, guarded by sessionsMu
+	left bool
+	// a file the editor asked to open, see moveClient
+	opening string
+	// files the editor moved, from and to, see renameSessions
+	renames [][]string
+	// what the client asked for until its session builds its editor, see
+	// editorSubscribe
+	initArgs *InitArgs
 }
 
 type FileEditSession struct {
+	path          string
 	content       *backend.Content
 	editorStates  map[string]*IndividualEditorState
 	clientEventCh chan ClientEditorEvent
-	mu            sync.RWMutex
+	// closed once processClientEvents has stopped, so nothing waits to send
+	// to it
+	done chan struct{}
+	mu   sync.RWMutex
 }
 
 var fileEditSessions map[string]*FileEditSession = make(map[string]*FileEditSession)
 var sessionsMu sync.RWMutex
 
 func processClientEvents(fileEditSession *FileEditSession) {
+	defer endSession(fileEditSession)
+
 	for {
 		clientEvent := <-fileEditSession.clientEventCh
 
 		currClientID := clientEvent.clientID
 		event := clientEvent.event
 
-		fileEditSession.mu.RLock()
+		if state := clientEvent.join; state != nil {
+			// the client went away while it was on its way here
+			sessionsMu.RLock()
+			left := state.left
+			sessionsMu.RUnlock()
+			if left {
+				continue
+			}
 
-		editor := fileEditSession.editorStates[currClientID].editor
+			if initArgs := state.initArgs; initArgs != nil {
+				editor := backend.InitializeEditorWithContent(
+					fileEditSession.content,
+					fileEditSession.path,
+					initArgs.ScreenHeight,
+					initArgs.ScreenWidth,
+				)
+				attachEditor(state, &editor)
+				state.initArgs = nil
+			} else {
+				state.editor.SwitchContent(fileEditSession.content, fileEditSession.path)
+			}
 
-		if event.IsKey {
-			key := event.Key
+			fileEditSession.mu.Lock()
+			fileEditSession.editorStates[currClientID] = state
+			fileEditSession.mu.Unlock()
+
+			// a wake for the editor's own work may have gone to the session
+			// it just left
+			state.editor.RunPending()
+			fileEditSession.mu.RLock()
+			ok := broadcast(fileEditSession)
+			fileEditSession.mu.RUnlock()
+			if !ok {
+				return
+			}
+			continue
+		}
 
-			if key == tcell.KeyRune {
-				fmt.Printf("Client %s sent '%c'\n", currClientID, event.Rune)
+		if clientEvent.leave {
+			fileEditSession.mu.Lock()
+			editorState, ok := fileEditSession.editorStates[currClientID]
+			delete(fileEditSession.editorStates, currClientID)
+			empty := len(fileEditSession.editorStates) == 0
+			fileEditSession.mu.Unlock()
+
+			if ok {
+				editorState.editor.LeaveContent()
+			}
+			// the session stays for the next client, but other sessions'
+			// completion no longer offers its words
+			if empty {
+				fileEditSession.content.Unpublish()
 			}
+			continue
+		}
 
-			switch editor.Mode {
-			case backend.Insert:
-				switch key {
-				case tcell.KeyEscape:
-					editor.ToNormal()
-				case tcell.KeyEnter:
-					editor.InsertRune('\n')
-				case tcell.KeyRight:
-					editor.ShiftCursor(0, 1, false, false)
-				case tcell.KeyLeft:
-					editor.ShiftCursor(0, -1, false, false)
-				case tcell.KeyUp:
-					editor.ShiftCursor(-1, 0, false, false)
-				case tcell.KeyDown:
-					editor.ShiftCursor(1, 0, false, false)
-				case tcell.KeyRune:
-					editor.InsertRune(event.Rune)
-				case tcell.KeyBackspace2:
-					editor.Backspace()
-				}
-			case backend.Normal:
-				switch key {
-				case tcell.KeyRune:
-					keyVal := event.Rune
-					switch keyVal {
-					case rune('q'):
-						return
-
-					case rune('a'):
-						editor.ToInsert(true)
-					case rune('i'):
-						editor.ToInsert(false)
-
-					case rune('j'):
-						editor.ShiftCursor(1, 0, false, false)
-					case rune('k'):
-						editor.ShiftCursor(-1, 0, false, false)
-					case rune('h'):
-						editor.ShiftCursor(0, -1, false, false)
-					case rune('l'):
-						editor.ShiftCursor(0, 1, false, false)
-					}
-				case tcell.KeyRight:
-					editor.ShiftCursor(0, 1, false, false)
-				case tcell.KeyLeft:
-					editor.ShiftCursor(0, -1, false, false)
-				case tcell.KeyUp:
-					editor.ShiftCursor(-1, 0, false, false)
-				case tcell.KeyDown:
-					editor.ShiftCursor(1, 0, false, false)
-				}
+		if rename := clientEvent.rename; rename != nil {
+			fileEditSession.mu.RLock()
+			fileEditSession.path, _ = backend.RenamedPath(fileEditSession.path, rename[0], rename[1])
+			for _, editorState := range fileEditSession.editorStates {
+				editorState.editor.RenameBuffers(rename[0], rename[1])
 			}
-		} else {
-			editor.ScreenWidth, editor.ScreenHeight = event.Width, event.Height
+			ok := broadcast(fileEditSession)
+			fileEditSession.mu.RUnlock()
+			if !ok {
+				return
+			}
+			continue
 		}
 
-		for _, editorState := range fileEditSession.editorStates {
-			if err := editorState.enc.Encode(editorState.editor); err != nil {
-				log.Printf("Error encoding in goroutine: %v", err)
+		fileEditSession.mu.RLock()
+
+		if clientEvent.isWake {
+			for _, editorState := range fileEditSession.editorStates {
+				editorState.editor.RunPending()
+			}
+			if !broadcast(fileEditSession) {
+				fileEditSession.mu.RUnlock()
 				return
 			}
-			log.Printf("Sent new editor state")
+			fileEditSession.mu.RUnlock()
+			continue
+		}
+
+		editorState, ok := fileEditSession.editorStates[currClientID]
+		if !ok {
+			fileEditSession.mu.RUnlock()
+			continue
+		}
+		editor := editorState.editor
+
+		if clientEvent.isKeyTimeout {
+			editor.FlushPendingKeys()
+		} else if event.IsKey {
+			if event.Key == tcell.KeyRune {
+				fmt.Printf("Client %s sent '%c'\n", currClientID, event.Rune)
+			}
+
+			editor.HandleKey(backend.NewKeyStroke(event.Key, event.Rune, event.Mod))
+			scheduleKeyTimeout(fileEditSession, currClientID, editor)
+		} else if event.IsPaste {
+			editor.Paste(event.Text)
+		} else if event.IsMouse {
+			editor.HandleMouse(backend.NewMouseEvent(event.Buttons, event.X, event.Y))
+		} else {
+			editor.Resize(event.Width, event.Height)
+		}
+
+		if !broadcast(fileEditSession) {
+			fileEditSession.mu.RUnlock()
+			return
 		}
 		fileEditSession.mu.RUnlock()
 
+		for _, rename := range editorState.renames {
+			renameSessions(rename[0], rename[1])
+		}
+		editorState.renames = nil
+
+		if editorState.opening != "" {
+			path := editorState.opening
+			editorState.opening = ""
+			moveClient(fileEditSession, currClientID, editorState, path)
+		}
+
 		log.Printf("Client Event Received: %+v", clientEvent)
 	}
 }
 
-func editorSubscribe(initArgs InitArgs, enc *json.Encoder) (*FileEditSession, string, *IndividualEditorState) {
-	clientID := uuid.New().String()
+// endSession forgets a session whose goroutine has stopped and lets go of
+// anything waiting to send to it.
+func endSession(fileEditSession *FileEditSession) {
+	sessionsMu.Lock()
+	for path, other := range fileEditSessions {
+		if other == fileEditSession {
+			delete(fileEditSessions, path)
+		}
+	}
+	sessionsMu.Unlock()
+
+	close(fileEditSession.done)
+	fileEditSession.content.Close()
+	log.Printf("Session %s ended", fileEditSession.path)
+}
+
+// sendEvent hands an event to a session, unless the session has ended. It
+// reports whether the session took it.
+func sendEvent(fileEditSession *FileEditSession, clientEvent ClientEditorEvent) bool {
+	select {
+	case fileEditSession.clientEventCh <- clientEvent:
+		return true
+	case <-fileEditSession.done:
+		return false
+	}
+}
 
+// moveClient hands a client whose editor opened another file over to the
+// session for that file, starting one if nobody has it open. Contents are
+// only touched by their session's goroutine, so the switch itself happens
+// there.
+func moveClient(
+	from *FileEditSession,
+	clientID string,
+	editorState *IndividualEditorState,
+	path string,
+) {
+	sessionsMu.Lock()
+	to, ok := fileEditSessions[path]
+	if !ok {
+		content, err := backend.LoadContent(path)
+		if err != nil {
+			sessionsMu.Unlock()
+			editorState.editor.ReportError(err)
+			editorState.enc.Encode(ServerFrame{Editor: editorState.editor})
+			return
+		}
+		to = newSession(path, content)
+	}
+	editorState.session = to
+	sessionsMu.Unlock()
+
+	from.mu.Lock()
+	delete(from.editorStates, clientID)
+	empty := len(from.editorStates) == 0
+	from.mu.Unlock()
+	editorState.editor.LeaveContent()
+	if empty {
+		from.content.Unpublish()
+	}
+
+	log.Printf("Client %s moved from %s to %s", clientID, from.path, path)
+
+	go sendEvent(to, ClientEditorEvent{clientID: clientID, join: editorState})
+}
+
+// renameSessions moves the sessions for files under from, after a client
+// moved it to to. Each session updates its own editors.
+func renameSessions(from string, to string) {
 	sessionsMu.Lock()
 	defer sessionsMu.Unlock()
 
-	if fileEditSession, ok := fileEditSessions[initArgs.FilePath]; ok {
-		individualEditorState := &IndividualEditorState{
-			editor: &backend.Editor{
-				Content:      fileEditSession.content,
-				Cursor:       &backend.Cursor{Index: 0, Row: 0, Col: 0},
-				FilePath:     initArgs.FilePath,
-				FileName:     filepath.Base(initArgs.FilePath),
-				ScreenHeight: initArgs.ScreenHeight,
-				ScreenWidth:  initArgs.ScreenWidth,
-				Mode:         backend.Normal,
-			},
-			enc: enc,
+	for path, fileEditSession := range fileEditSessions {
+		renamed, ok := backend.RenamedPath(path, from, to)
+		if !ok {
+			continue
 		}
+		delete(fileEditSessions, path)
+		fileEditSessions[renamed] = fileEditSession
+		log.Printf("Session %s moved to %s", path, renamed)
+
+		go sendEvent(fileEditSession, ClientEditorEvent{rename: []string{from, to}})
+	}
+}
 
-		fileEditSession.mu.Lock()
-		fileEditSession.editorStates[clientID] = individualEditorState
-		fileEditSession.mu.Unlock()
+// newSession starts the goroutine for a file's session. The caller holds
+// sessionsMu.
+func newSession(path string, content *backend.Content) *FileEditSession {
+	fileEditSession := &FileEditSession{
+		path:          path,
+		content:       content,
+		editorStates:  make(map[string]*IndividualEditorState),
+		clientEventCh: make(chan ClientEditorEvent, 10),
+		done:          make(chan struct{}),
+	}
 
-		log.Printf("Client %s subscribed to %s", clientID, initArgs.FilePath)
-		return fileEditSession, clientID, individualEditorState
-	} else {
-		editor := backend.InitializeEditor(
-			initArgs.FilePath,
-			initArgs.ScreenHeight,
-			initArgs.ScreenWidth,
-		)
-
-		individualEditorState := &IndividualEditorState{
-			editor: &editor,
-			enc:    enc,
-		}
+	// the wake comes from a language server goroutine, so it must not
+	// block on a full channel
+	content.SetWake(func() {
+		go sendEvent(fileEditSession, ClientEditorEvent{isWake: true})
+	})
 
-		fileEditSession := &FileEditSession{
-			content:       editor.Content,
-			editorStates:  make(map[string]*IndividualEditorState),
-			clientEventCh: make(chan ClientEditorEvent, 10),
+	fileEditSessions[path] = fileEditSession
+	go processClientEvents(fileEditSession)
+	return fileEditSession
+}
+
+// broadcast sends every client its editor's state.
+func broadcast(fileEditSession *FileEditSession) bool {
+	for _, editorState := range fileEditSession.editorStates {
+		editorState.editor.Collaborators = len(fileEditSession.editorStates) - 1
+		if err := editorState.enc.Encode(ServerFrame{Editor: editorState.editor}); err != nil {
+			log.Printf("Error encoding in goroutine: %v", err)
+			return false
 		}
-		fileEditSession.editorStates[clientID] = individualEditorState
+		log.Printf("Sent new editor state")
+	}
+	return true
+}
+
+// scheduleKeyTimeout queues a timeout event for a client that is partway
+// through a mapped key sequence.
+func scheduleKeyTimeout(
+	fileEditSession *FileEditSession,
+	clientID string,
+	editor *backend.Editor,
+) {
+	if timeout, ok := editor.PendingKeyTimeout(); ok {
+		time.AfterFunc(timeout, func() {
+			sendEvent(fileEditSession, ClientEditorEvent{
+				clientID:     clientID,
+				isKeyTimeout: true,
+			})
+		})
+	}
+}
 
-		fileEditSessions[initArgs.FilePath] = fileEditSession
+// editorSubscribe sets up a new client. The first client on a file starts
+// its session. Later ones share the session's content, which only its
+// goroutine may touch, so their editors are built there.
+func editorSubscribe(initArgs InitArgs, enc *json.Encoder) (string, *IndividualEditorState) {
+	clientID := uuid.New().String()
+
+	sessionsMu.Lock()
 
-		log.Printf("Client %s subscribed to %s (new session)", clientID, initArgs.FilePath)
+	individualEditorState := &IndividualEditorState{enc: enc}
 
-		go processClientEvents(fileEditSession)
+	fileEditSession, ok := fileEditSessions[initArgs.FilePath]
+	if ok {
+		individualEditorState.initArgs = &initArgs
+		individualEditorState.session = fileEditSession
+		sessionsMu.Unlock()
 
-		return fileEditSession, clientID, individualEditorState
+		// the join goes ahead of the client's first event
+		sendEvent(fileEditSession, ClientEditorEvent{
+			clientID: clientID,
+			join:     individualEditorState,
+		})
+		log.Printf("Client %s subscribed to %s", clientID, initArgs.FilePath)
+		return clientID, individualEditorState
 	}
+
+	editor := backend.InitializeEditor(
+		initArgs.FilePath,
+		initArgs.ScreenHeight,
+		initArgs.ScreenWidth,
+	)
+	fileEditSession = newSession(initArgs.FilePath, editor.Content)
+	individualEditorState.session = fileEditSession
+	attachEditor(individualEditorState, &editor)
+	log.Printf("Client %s subscribed to %s (new session)", clientID, initArgs.FilePath)
+
+	fileEditSession.mu.Lock()
+	fileEditSession.editorStates[clientID] = individualEditorState
+	fileEditSession.mu.Unlock()
+	sessionsMu.Unlock()
+
+	return clientID, individualEditorState
 }
 
-func editorUnsubscribe(filePath string, clientID string) {
-	sessionsMu.RLock()
-	defer sessionsMu.RUnlock()
+// attachEditor gives a client its editor, with the hooks that let the
+// editor open files and run work in the background behind the server.
+func attachEditor(individualEditorState *IndividualEditorState, editor *backend.Editor) {
+	individualEditorState.editor = editor
+
+	// files picked on the server are opened by moving to their session
+	editor.SetOpener(func(path string) {
+		individualEditorState.opening = path
+	})
+	editor.SetRenamer(func(from string, to string) {
+		individualEditorState.renames = append(individualEditorState.renames, []string{from, to})
+	})
+	// output from :make goes to whichever session the client is in by then.
+	// The session's file keeps the wake newSession gave it.
+	editor.SetWake(func() {
+		sessionsMu.RLock()
+		session := individualEditorState.session
+		sessionsMu.RUnlock()
+		go sendEvent(session, ClientEditorEvent{isWake: true})
+	})
+}
 
-	fileEditSession, ok := fileEditSessions[filePath]
+// editorUnsubscribe takes a client out of its session. The editor has hooks
+// in the session's content, so the session's goroutine does it.
+func editorUnsubscribe(clientID string, editorState *IndividualEditorState) {
+	sessionsMu.Lock()
+	fileEditSession := editorState.session
+	editorState.left = true
+	sessionsMu.Unlock()
 
-	if !ok {
-		return
-	}
-	fileEditSession.mu.RLock()
-	delete(fileEditSession.editorStates, clientID)
-	fileEditSession.mu.RUnlock()
+	sendEvent(fileEditSession, ClientEditorEvent{clientID: clientID, leave: true})
 
-	log.Printf("Client %s unsubscribed from %s", clientID, filePath)
+	log.Printf("Client %s unsubscribed from %s", clientID, fileEditSession.path)
 }
 
 func handleConnection(conn net.Conn) {
@@ -217,13 +457,20 @@ func handleConnection(conn net.Conn) {
 
 	initArgs := InitArgs{}
 	err := dec.Decode(&initArgs)
+	if err == nil && initArgs.FilePath == "" {
+		err = fmt.Errorf("no file name")
+	}
 	if err != nil {
 		log.Println("Initialization failed!")
+		enc.Encode(ServerFrame{Message: &backend.Message{
+			Level: backend.MessageError,
+			Text:  "could not start editing: " + err.Error(),
+		}})
 		return
 	}
 
-	fileEditSession, currClientID, _ := editorSubscribe(initArgs, enc)
-	defer editorUnsubscribe(initArgs.FilePath, currClientID)
+	currClientID, editorState := editorSubscribe(initArgs, enc)
+	defer editorUnsubscribe(currClientID, editorState)
 
 	for {
 		event := EditorEvent{}
@@ -238,9 +485,16 @@ func handleConnection(conn net.Conn) {
 			return
 		}
 
-		fileEditSession.clientEventCh <- ClientEditorEvent{
+		sessionsMu.RLock()
+		fileEditSession := editorState.session
+		sessionsMu.RUnlock()
+
+		if !sendEvent(fileEditSession, ClientEditorEvent{
 			clientID: currClientID,
 			event:    event,
+		}) {
+			log.Printf("Client %s: session for %s has ended", currClientID, fileEditSession.path)
+			return
 		}
 	}
 }